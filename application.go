@@ -15,3 +15,12 @@ type Application interface {
 	// Respond defines how the application reponds to requests that are successful.
 	Respond(rw http.ResponseWriter, req *http.Request, value any)
 }
+
+func requestApplication(req *http.Request) Application {
+	state := requestStateFrom(req)
+	if state == nil {
+		return nil
+	}
+
+	return state.app
+}
@@ -0,0 +1,333 @@
+package luci
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxFormMemory = 32 << 20
+)
+
+var (
+	bindTags = []string{"path", "query", "header", "cookie", "form"}
+
+	durationType        = reflect.TypeFor[time.Duration]()
+	timeType            = reflect.TypeFor[time.Time]()
+	textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+)
+
+// BindFieldError describes a struct field that could not be bound from a request.
+type BindFieldError struct {
+	// Field is the name of the struct field.
+	Field string
+	// Source is the struct tag the value was bound from, e.g. path, query, or header.
+	Source string
+	// Key is the name of the value in the source.
+	Key string
+	// Err is the reason the value could not be bound.
+	Err error
+}
+
+// Error returns the source, key, and reason the field could not be bound.
+func (err BindFieldError) Error() string {
+	return fmt.Sprintf(`%s "%s": %s`, err.Source, err.Key, err.Err)
+}
+
+// Unwrap returns the reason the field could not be bound.
+func (err BindFieldError) Unwrap() error {
+	return err.Err
+}
+
+// BindError is returned from Bind when one or more fields could not be bound.
+type BindError struct {
+	// Fields contains every field that could not be bound, in struct field order.
+	Fields []BindFieldError
+}
+
+// Error returns a description of every field that could not be bound.
+func (err *BindError) Error() string {
+	fields := make([]string, len(err.Fields))
+	for idx, field := range err.Fields {
+		fields[idx] = field.Error()
+	}
+
+	return "luci: bind: " + strings.Join(fields, "; ")
+}
+
+// Unwrap returns the errors for every field that could not be bound.
+func (err *BindError) Unwrap() []error {
+	errs := make([]error, len(err.Fields))
+	for idx, field := range err.Fields {
+		errs[idx] = field
+	}
+
+	return errs
+}
+
+// StatusCode returns 400 Bad Request, the status to respond with for bind errors.
+func (err *BindError) StatusCode() int {
+	return http.StatusBadRequest
+}
+
+type binder struct {
+	req   *http.Request
+	tags  []string
+	vars  map[string]string
+	query url.Values
+	form  url.Values
+	errs  []BindFieldError
+}
+
+// Bind populates the struct pointed to by dst from the request using struct tags to determine
+// where each field's value comes from. The supported tags are:
+//   - path: the request variable defined by the routes pattern
+//   - query: the URL query parameter
+//   - header: the request header
+//   - cookie: the request cookie
+//   - form: the url encoded or multipart form body value
+//
+// Fields may be strings, booleans, integers, floats, time.Duration, time.Time, types implementing
+// encoding.TextUnmarshaler, pointers to those types, or slices of those types. time.Time values are
+// parsed as RFC 3339 or HTTP dates. Slice fields receive every value for multi valued sources.
+// Fields in embedded structs are bound as well. Fields without a value in the request are left
+// unchanged.
+//
+// If any fields fail to bind a *BindError is returned describing every failing field.
+func Bind(req *http.Request, dst any) error {
+	value := reflect.ValueOf(dst)
+	if value.Kind() != reflect.Pointer || value.IsNil() || value.Elem().Kind() != reflect.Struct {
+		return errors.New("luci: bind destination must be a non-nil pointer to a struct")
+	}
+
+	return (&binder{req: req, tags: bindTags, vars: Vars(req)}).bind(value.Elem())
+}
+
+func bindForm(values url.Values, dst any) error {
+	value := reflect.ValueOf(dst)
+	if value.Kind() != reflect.Pointer || value.IsNil() || value.Elem().Kind() != reflect.Struct {
+		return errors.New("luci: bind destination must be a non-nil pointer to a struct")
+	}
+
+	return (&binder{tags: []string{"form"}, form: values}).bind(value.Elem())
+}
+
+func (binder *binder) bind(value reflect.Value) error {
+	err := binder.bindStruct(value)
+	if err != nil {
+		return err
+	}
+
+	if len(binder.errs) > 0 {
+		return &BindError{Fields: binder.errs}
+	}
+
+	return nil
+}
+
+func (binder *binder) bindStruct(value reflect.Value) error {
+	valueType := value.Type()
+
+	for idx := range valueType.NumField() {
+		field := valueType.Field(idx)
+
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			err := binder.bindStruct(value.Field(idx))
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if !field.IsExported() {
+			continue
+		}
+
+		for _, tag := range binder.tags {
+			key, ok := field.Tag.Lookup(tag)
+			if !ok || key == "" || key == "-" {
+				continue
+			}
+
+			raw, err := binder.lookup(tag, key)
+			if err != nil {
+				return err
+			}
+
+			if len(raw) == 0 {
+				continue
+			}
+
+			err = setValues(value.Field(idx), raw)
+			if err != nil {
+				binder.errs = append(binder.errs, BindFieldError{
+					Field:  field.Name,
+					Source: tag,
+					Key:    key,
+					Err:    err,
+				})
+			}
+		}
+	}
+
+	return nil
+}
+
+func (binder *binder) lookup(tag, key string) ([]string, error) {
+	switch tag {
+	case "path":
+		value, ok := binder.vars[key]
+		if !ok {
+			return nil, nil
+		}
+
+		return []string{value}, nil
+	case "query":
+		if binder.query == nil {
+			binder.query = binder.req.URL.Query()
+		}
+
+		return binder.query[key], nil
+	case "header":
+		return binder.req.Header.Values(key), nil
+	case "cookie":
+		cookie, err := binder.req.Cookie(key)
+		if errors.Is(err, http.ErrNoCookie) {
+			return nil, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("luci: bind cookie: %w", err)
+		}
+
+		return []string{cookie.Value}, nil
+	case "form":
+		if binder.form == nil {
+			err := binder.req.ParseMultipartForm(maxFormMemory)
+			if err != nil && !errors.Is(err, http.ErrNotMultipart) {
+				return nil, fmt.Errorf("luci: bind form: %w", err)
+			}
+
+			binder.form = binder.req.PostForm
+			if binder.form == nil {
+				binder.form = url.Values{}
+			}
+		}
+
+		return binder.form[key], nil
+	default:
+		return nil, nil
+	}
+}
+
+func setValues(value reflect.Value, raw []string) error {
+	if value.Kind() == reflect.Slice && !isScalarType(value.Type()) {
+		slice := reflect.MakeSlice(value.Type(), len(raw), len(raw))
+		for idx, item := range raw {
+			err := setValue(slice.Index(idx), item)
+			if err != nil {
+				return err
+			}
+		}
+
+		value.Set(slice)
+
+		return nil
+	}
+
+	return setValue(value, raw[0])
+}
+
+func isScalarType(valueType reflect.Type) bool {
+	return reflect.PointerTo(valueType).Implements(textUnmarshalerType)
+}
+
+func setValue(value reflect.Value, raw string) error {
+	if value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			value.Set(reflect.New(value.Type().Elem()))
+		}
+
+		value = value.Elem()
+	}
+
+	if isScalarType(value.Type()) && value.Type() != timeType {
+		unmarshaler, _ := value.Addr().Interface().(encoding.TextUnmarshaler)
+
+		err := unmarshaler.UnmarshalText([]byte(raw))
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", value.Type(), err)
+		}
+
+		return nil
+	}
+
+	switch value.Type() {
+	case durationType:
+		parsed, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("invalid duration: %w", err)
+		}
+
+		value.SetInt(int64(parsed))
+
+		return nil
+	case timeType:
+		parsed, err := time.Parse(time.RFC3339Nano, raw)
+		if err != nil {
+			var httpErr error
+
+			parsed, httpErr = http.ParseTime(raw)
+			if httpErr != nil {
+				return fmt.Errorf("invalid time: %w", err)
+			}
+		}
+
+		value.Set(reflect.ValueOf(parsed))
+
+		return nil
+	}
+
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(raw)
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid bool: %w", err)
+		}
+
+		value.SetBool(parsed)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		parsed, err := strconv.ParseInt(raw, 10, value.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid integer: %w", err)
+		}
+
+		value.SetInt(parsed)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		parsed, err := strconv.ParseUint(raw, 10, value.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid unsigned integer: %w", err)
+		}
+
+		value.SetUint(parsed)
+	case reflect.Float32, reflect.Float64:
+		parsed, err := strconv.ParseFloat(raw, value.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid float: %w", err)
+		}
+
+		value.SetFloat(parsed)
+	default:
+		return fmt.Errorf("unsupported type %s", value.Type())
+	}
+
+	return nil
+}
@@ -0,0 +1,137 @@
+package luci
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type BindPage struct {
+	Page  int     `query:"page"`
+	Limit *uint16 `query:"limit"`
+}
+
+func TestBind(t *testing.T) {
+	t.Parallel()
+
+	t.Run("binds path, query, header, cookie, and form values", func(t *testing.T) {
+		t.Parallel()
+
+		type input struct {
+			BindPage
+
+			Key      string        `path:"key"`
+			Ratio    float64       `query:"ratio"`
+			Verbose  bool          `query:"verbose"`
+			Tags     []string      `query:"tag"`
+			Match    string        `header:"If-Match"`
+			Since    time.Time     `header:"If-Modified-Since"`
+			Timeout  time.Duration `header:"Timeout"`
+			Session  string        `cookie:"session"`
+			Name     string        `form:"name"`
+			Birthday time.Time     `form:"birthday"`
+			Address  net.IP        `form:"address"`
+			Ignored  string        `query:"-"`
+			Missing  string        `query:"missing"`
+		}
+
+		form := url.Values{
+			"name":     []string{"luci"},
+			"birthday": []string{"2020-01-02T03:04:05Z"},
+			"address":  []string{"127.0.0.1"},
+		}
+
+		request := httptest.NewRequestWithContext(
+			t.Context(),
+			http.MethodPost,
+			"/user/abc?page=2&limit=10&ratio=0.5&verbose=true&tag=a&tag=b&-=x",
+			strings.NewReader(form.Encode()),
+		)
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.Header.Set("If-Match", `"etag"`)
+		request.Header.Set("If-Modified-Since", "Wed, 21 Oct 2015 07:28:00 GMT")
+		request.Header.Set("Timeout", "5s")
+		request.AddCookie(&http.Cookie{Name: "session", Value: "secret"})
+		request = withState(request, &requestState{vars: map[string]string{"key": "abc"}})
+
+		in := input{Missing: "default"}
+
+		err := Bind(request, &in)
+		assert.NoError(t, err)
+
+		limit := uint16(10)
+		assert.Equal(t, input{
+			BindPage: BindPage{Page: 2, Limit: &limit},
+			Key:      "abc",
+			Ratio:    0.5,
+			Verbose:  true,
+			Tags:     []string{"a", "b"},
+			Match:    `"etag"`,
+			Since:    time.Date(2015, time.October, 21, 7, 28, 0, 0, time.UTC),
+			Timeout:  5 * time.Second,
+			Session:  "secret",
+			Name:     "luci",
+			Birthday: time.Date(2020, time.January, 2, 3, 4, 5, 0, time.UTC),
+			Address:  net.ParseIP("127.0.0.1"),
+			Missing:  "default",
+		}, in)
+	})
+
+	t.Run("returns bind error describing every field that failed", func(t *testing.T) {
+		t.Parallel()
+
+		type input struct {
+			Page    int           `query:"page"`
+			Verbose bool          `query:"verbose"`
+			Timeout time.Duration `header:"Timeout"`
+			Name    string        `query:"name"`
+		}
+
+		request := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/user?page=abc&verbose=maybe&name=luci", nil)
+		request.Header.Set("Timeout", "soon")
+
+		var in input
+
+		err := Bind(request, &in)
+		assert.EqualError(
+			t,
+			err,
+			`luci: bind: query "page": invalid integer: strconv.ParseInt: parsing "abc": invalid syntax; `+
+				`query "verbose": invalid bool: strconv.ParseBool: parsing "maybe": invalid syntax; `+
+				`header "Timeout": invalid duration: time: invalid duration "soon"`,
+		)
+
+		var bindErr *BindError
+		assert.True(t, errors.As(err, &bindErr))
+		assert.Equal(t, http.StatusBadRequest, bindErr.StatusCode())
+		assert.Len(t, bindErr.Fields, 3)
+		assert.Equal(t, "Page", bindErr.Fields[0].Field)
+		assert.Equal(t, "query", bindErr.Fields[0].Source)
+		assert.Equal(t, "page", bindErr.Fields[0].Key)
+		assert.Equal(t, "Verbose", bindErr.Fields[1].Field)
+		assert.Equal(t, "Timeout", bindErr.Fields[2].Field)
+		assert.Equal(t, "header", bindErr.Fields[2].Source)
+		assert.Equal(t, "luci", in.Name)
+	})
+
+	t.Run("returns error if destination is not a pointer to a struct", func(t *testing.T) {
+		t.Parallel()
+
+		request := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/user", nil)
+
+		err := Bind(request, struct{}{})
+		assert.EqualError(t, err, "luci: bind destination must be a non-nil pointer to a struct")
+
+		var value string
+
+		err = Bind(request, &value)
+		assert.EqualError(t, err, "luci: bind destination must be a non-nil pointer to a struct")
+	})
+}
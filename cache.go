@@ -0,0 +1,541 @@
+package luci
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// DefaultCacheConfig is the base configuration that's used when creating a cache middleware.
+	DefaultCacheConfig = CacheConfig{
+		MaxEntries:        1024,
+		MaxLength:         1 << 20,
+		TTL:               time.Minute,
+		RevalidateTimeout: 30 * time.Second,
+	}
+)
+
+// CacheConfig defines how the cache middleware caches responses.
+// See DefaultCacheConfig for configuration defaults.
+type CacheConfig struct {
+	// Store defines where cached responses are stored. If not set a MemoryCacheStore is
+	// created using MaxEntries.
+	Store CacheStore
+	// MaxEntries defines the maximum number of responses the default MemoryCacheStore holds.
+	MaxEntries int
+	// MaxLength defines the maximum response body length to cache, longer responses aren't cached.
+	MaxLength int
+	// TTL defines how long responses are fresh for if neither the route nor the response
+	// Cache-Control header defines it.
+	TTL time.Duration
+	// StaleWhileRevalidate defines how long stale responses may be served while they're
+	// revalidated in the background, if neither the route nor the response Cache-Control
+	// header defines it.
+	StaleWhileRevalidate time.Duration
+	// RevalidateTimeout defines the timeout for background revalidation requests.
+	RevalidateTimeout time.Duration
+}
+
+// RouteCache opts a route into response caching by the cache middleware.
+type RouteCache struct {
+	// TTL defines how long responses are fresh for, if not set the cache middlewares TTL is used.
+	TTL time.Duration
+	// StaleWhileRevalidate defines how long stale responses may be served while they're revalidated
+	// in the background, if not set the cache middlewares StaleWhileRevalidate is used.
+	StaleWhileRevalidate time.Duration
+}
+
+// CachedResponse is a response stored by a CacheStore.
+type CachedResponse struct {
+	// Status is the status code of the response.
+	Status int
+	// Header contains the headers set by the handler.
+	Header http.Header
+	// Body is the response body.
+	Body []byte
+	// Vary contains the request headers that select the response. Responses with Vary headers are
+	// stored as an entry with only Vary set, and a variant entry for each combination of values.
+	Vary []string
+	// Stored is when the response was stored.
+	Stored time.Time
+	// Fresh is when the response becomes stale.
+	Fresh time.Time
+	// Expires is when the response may no longer be served while it's revalidated, once
+	// expired stores may discard the response.
+	Expires time.Time
+}
+
+// CacheStore stores cached responses by key.
+type CacheStore interface {
+	// Get returns the response stored with the key, and whether one was found.
+	Get(ctx context.Context, key string) (CachedResponse, bool, error)
+	// Set stores the response with the key, replacing any existing response.
+	Set(ctx context.Context, key string, response CachedResponse) error
+	// Delete removes the response stored with the key.
+	Delete(ctx context.Context, key string) error
+}
+
+type memoryCacheEntry struct {
+	key      string
+	response CachedResponse
+}
+
+// MemoryCacheStore is a CacheStore that keeps responses in memory, evicting the least
+// recently used responses once the maximum number of entries has been reached.
+type MemoryCacheStore struct {
+	maxEntries int
+	entries    map[string]*list.Element
+	order      *list.List
+	mu         sync.Mutex
+}
+
+// discardWriter is a ResponseWriter for background requests that have no client.
+type discardWriter struct {
+	header http.Header
+}
+
+// NewMemoryCacheStore creates a memory cache store that holds up to maxEntries responses.
+// If maxEntries is zero or less the number of entries isn't bounded.
+func NewMemoryCacheStore(maxEntries int) *MemoryCacheStore {
+	return &MemoryCacheStore{
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+// Get returns the response stored with the key, and whether one was found. Expired responses are discarded.
+func (store *MemoryCacheStore) Get(_ context.Context, key string) (CachedResponse, bool, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	element, ok := store.entries[key]
+	if !ok {
+		return CachedResponse{}, false, nil
+	}
+
+	entry, _ := element.Value.(*memoryCacheEntry)
+	if !entry.response.Expires.IsZero() && time.Now().After(entry.response.Expires) {
+		store.remove(element)
+		return CachedResponse{}, false, nil
+	}
+
+	store.order.MoveToFront(element)
+
+	return entry.response, true, nil
+}
+
+// Set stores the response with the key, evicting the least recently used response if the store is full.
+func (store *MemoryCacheStore) Set(_ context.Context, key string, response CachedResponse) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	element, ok := store.entries[key]
+	if ok {
+		entry, _ := element.Value.(*memoryCacheEntry)
+		entry.response = response
+		store.order.MoveToFront(element)
+
+		return nil
+	}
+
+	store.entries[key] = store.order.PushFront(&memoryCacheEntry{key: key, response: response})
+
+	for store.maxEntries > 0 && store.order.Len() > store.maxEntries {
+		store.remove(store.order.Back())
+	}
+
+	return nil
+}
+
+// Delete removes the response stored with the key.
+func (store *MemoryCacheStore) Delete(_ context.Context, key string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	element, ok := store.entries[key]
+	if ok {
+		store.remove(element)
+	}
+
+	return nil
+}
+
+// Len returns the number of responses in the store.
+func (store *MemoryCacheStore) Len() int {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	return store.order.Len()
+}
+
+func (store *MemoryCacheStore) remove(element *list.Element) {
+	entry, _ := store.order.Remove(element).(*memoryCacheEntry)
+	delete(store.entries, entry.key)
+}
+
+// Cache is a middleware that caches responses for GET requests to routes that opt in using
+// Route.Cache. Responses are keyed by the route name, variables, query, and the request headers
+// listed in the response's Vary header.
+//
+// The response's Cache-Control header takes precedence over the configured lifetimes, s-maxage and
+// max-age define the TTL and stale-while-revalidate defines how long stale responses are served while
+// they're revalidated in the background. Responses with no-store, no-cache, or private directives,
+// a Set-Cookie header, or Vary: * aren't cached, and requests with an Authorization header bypass
+// the cache. Request Cache-Control directives are ignored so clients can't bypass the cache.
+// Server shutdown cancels and waits for background revalidation, and panics while revalidating
+// are logged.
+//
+// Cache should come after Compress and Conditional so cached responses are stored uncompressed
+// and conditional requests are evaluated against them.
+func Cache(config CacheConfig) Middleware {
+	config = buildCacheConfig(config)
+
+	var (
+		mu           sync.Mutex
+		revalidating = make(map[string]struct{})
+	)
+
+	return func(next http.Handler) http.Handler {
+		// revalidate runs the handler in the background to replace a stale response.
+		revalidate := func(req *http.Request, key, variantKey string, route RouteCache) {
+			mu.Lock()
+			_, ok := revalidating[variantKey]
+			if !ok {
+				revalidating[variantKey] = struct{}{}
+			}
+			mu.Unlock()
+
+			if ok {
+				return
+			}
+
+			run := func(ctx context.Context) {
+				defer func() {
+					mu.Lock()
+					delete(revalidating, variantKey)
+					mu.Unlock()
+				}()
+
+				ctx, cancel := context.WithTimeout(ctx, config.RevalidateTimeout)
+				defer cancel()
+
+				newReq := req.WithContext(ctx)
+				rw := &discardWriter{header: make(http.Header)}
+
+				// Background requests aren't handled by the recover middleware, a panic would crash the server.
+				defer func() {
+					val := recover()
+					if val == nil {
+						return
+					}
+
+					err := recoveredError(val)
+					if !errors.Is(err, http.ErrAbortHandler) {
+						Logger(newReq).With(slog.Any("error", err)).Error("recovered from panic revalidating cached response")
+					}
+				}()
+
+				err := serveCached(config, route, next, rw, newReq, key)
+				if err != nil {
+					Logger(newReq).With(slog.Any("error", err)).Error("failed to revalidate cached response")
+				}
+			}
+
+			ctx := context.WithoutCancel(req.Context())
+
+			state := requestStateFrom(req)
+			if state != nil && state.inFlight != nil {
+				state.inFlight.background(ctx, run)
+				return
+			}
+
+			go run(ctx)
+		}
+
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			route := RequestRoute(req).Cache
+			if route == nil || req.Method != http.MethodGet || req.Header.Get("Authorization") != "" {
+				next.ServeHTTP(rw, req)
+				return
+			}
+
+			key := cacheKey(req)
+
+			cached, variantKey, ok, err := lookupCached(req, config.Store, key)
+			if err != nil {
+				Logger(req).With(slog.Any("error", err)).Error("failed to get cached response")
+			}
+
+			if ok {
+				now := time.Now()
+
+				status := "hit"
+				if now.After(cached.Fresh) {
+					status = "stale"
+					revalidate(req, key, variantKey, *route)
+				}
+
+				writeCached(rw, cached, now)
+
+				resWriter := findResponseWriter(rw)
+				if resWriter != nil {
+					resWriter.cached(status)
+				}
+
+				return
+			}
+
+			resWriter := findResponseWriter(rw)
+			if resWriter != nil {
+				resWriter.cached("miss")
+			}
+
+			err = serveCached(config, *route, next, rw, req, key)
+			if err != nil {
+				Logger(req).With(slog.Any("error", err)).Error("failed to store cached response")
+			}
+		})
+	}
+}
+
+func (rw *discardWriter) Header() http.Header {
+	return rw.header
+}
+
+func (rw *discardWriter) WriteHeader(int) {}
+
+func (rw *discardWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+// serveCached calls the handler and stores the response if it's cacheable.
+func serveCached(
+	config CacheConfig,
+	route RouteCache,
+	next http.Handler,
+	rw http.ResponseWriter,
+	req *http.Request,
+	key string,
+) error {
+	recorder := newRecordWriter(rw, config.MaxLength)
+
+	next.ServeHTTP(recorder, req)
+
+	if !recorder.wroteHeader || !recorder.recorded || !cacheableStatus(recorder.status) {
+		return nil
+	}
+
+	header := recorder.handlerHeader()
+
+	now := time.Now()
+	response := CachedResponse{
+		Status: recorder.status,
+		Header: header,
+		Body:   recorder.body,
+		Stored: now,
+	}
+
+	ttl, stale, ok := cacheLifetime(config, route, header)
+	if !ok {
+		return nil
+	}
+
+	response.Fresh = now.Add(ttl)
+	response.Expires = response.Fresh.Add(stale)
+
+	vary := cacheVary(header)
+	if slices.Contains(vary, "*") {
+		return nil
+	}
+
+	if len(vary) != 0 {
+		err := config.Store.Set(req.Context(), key, CachedResponse{Vary: vary, Stored: now, Expires: response.Expires})
+		if err != nil {
+			return fmt.Errorf("luci: cache: %w", err)
+		}
+
+		key = cacheVariantKey(req, key, vary)
+	}
+
+	response.Vary = vary
+
+	err := config.Store.Set(req.Context(), key, response)
+	if err != nil {
+		return fmt.Errorf("luci: cache: %w", err)
+	}
+
+	return nil
+}
+
+// lookupCached returns the cached response for the request and the key it's stored with.
+func lookupCached(req *http.Request, store CacheStore, key string) (CachedResponse, string, bool, error) {
+	cached, ok, err := store.Get(req.Context(), key)
+	if err != nil {
+		return CachedResponse{}, "", false, fmt.Errorf("luci: cache: %w", err)
+	}
+
+	if !ok || len(cached.Vary) == 0 {
+		return cached, key, ok, nil
+	}
+
+	variantKey := cacheVariantKey(req, key, cached.Vary)
+
+	cached, ok, err = store.Get(req.Context(), variantKey)
+	if err != nil {
+		return CachedResponse{}, "", false, fmt.Errorf("luci: cache: %w", err)
+	}
+
+	return cached, variantKey, ok, nil
+}
+
+func writeCached(rw http.ResponseWriter, cached CachedResponse, now time.Time) {
+	rw.Header().Set("Age", strconv.Itoa(int(now.Sub(cached.Stored).Seconds())))
+	writeRecorded(rw, cached.Status, cached.Header, cached.Body)
+}
+
+// cacheLifetime returns how long the response is fresh for and how long it may be served stale
+// while revalidating, or false if the response must not be cached.
+func cacheLifetime(config CacheConfig, route RouteCache, header http.Header) (time.Duration, time.Duration, bool) {
+	if header.Get("Set-Cookie") != "" {
+		return 0, 0, false
+	}
+
+	ttl := config.TTL
+	if route.TTL != 0 {
+		ttl = route.TTL
+	}
+
+	stale := config.StaleWhileRevalidate
+	if route.StaleWhileRevalidate != 0 {
+		stale = route.StaleWhileRevalidate
+	}
+
+	directives := parseCacheControl(strings.Join(header.Values("Cache-Control"), ","))
+
+	for _, directive := range []string{"no-store", "no-cache", "private"} {
+		if _, ok := directives[directive]; ok {
+			return 0, 0, false
+		}
+	}
+
+	for _, directive := range []string{"max-age", "s-maxage"} {
+		seconds, err := strconv.Atoi(directives[directive])
+		if err == nil && seconds >= 0 {
+			ttl = time.Duration(seconds) * time.Second
+		}
+	}
+
+	seconds, err := strconv.Atoi(directives["stale-while-revalidate"])
+	if err == nil && seconds >= 0 {
+		stale = time.Duration(seconds) * time.Second
+	}
+
+	return ttl, stale, ttl > 0
+}
+
+func buildCacheConfig(config CacheConfig) CacheConfig {
+	built := DefaultCacheConfig
+
+	if config.MaxEntries != 0 {
+		built.MaxEntries = config.MaxEntries
+	}
+
+	if config.MaxLength != 0 {
+		built.MaxLength = config.MaxLength
+	}
+
+	if config.TTL != 0 {
+		built.TTL = config.TTL
+	}
+
+	if config.StaleWhileRevalidate != 0 {
+		built.StaleWhileRevalidate = config.StaleWhileRevalidate
+	}
+
+	if config.RevalidateTimeout != 0 {
+		built.RevalidateTimeout = config.RevalidateTimeout
+	}
+
+	built.Store = config.Store
+	if built.Store == nil {
+		built.Store = NewMemoryCacheStore(built.MaxEntries)
+	}
+
+	return built
+}
+
+// cacheKey returns the key for the request from the route name, variables, and query.
+func cacheKey(req *http.Request) string {
+	vars := make(url.Values)
+	for key, value := range Vars(req) {
+		vars.Set(key, value)
+	}
+
+	return RequestRoute(req).Name + " " + vars.Encode() + " " + req.URL.Query().Encode()
+}
+
+// cacheVariantKey returns the key for the request's values of the vary headers.
+func cacheVariantKey(req *http.Request, key string, vary []string) string {
+	values := make(url.Values, len(vary))
+	for _, name := range vary {
+		values[name] = req.Header.Values(name)
+	}
+
+	return key + "\n" + values.Encode()
+}
+
+// cacheVary returns the sorted names listed in the Vary header.
+func cacheVary(header http.Header) []string {
+	var vary []string
+
+	for _, value := range header.Values("Vary") {
+		for name := range strings.SplitSeq(value, ",") {
+			name = strings.TrimSpace(name)
+			if name != "" {
+				vary = append(vary, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+
+	slices.Sort(vary)
+
+	return slices.Compact(vary)
+}
+
+// parseCacheControl parses the Cache-Control directives, directive names are lowercased.
+func parseCacheControl(value string) map[string]string {
+	directives := make(map[string]string)
+
+	for directive := range strings.SplitSeq(value, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		if name == "" {
+			continue
+		}
+
+		directives[strings.ToLower(name)] = strings.Trim(arg, `"`)
+	}
+
+	return directives
+}
+
+func cacheableStatus(status int) bool {
+	switch status {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusNoContent, http.StatusMultipleChoices,
+		http.StatusMovedPermanently, http.StatusPermanentRedirect, http.StatusNotFound, http.StatusMethodNotAllowed,
+		http.StatusGone, http.StatusRequestURITooLong, http.StatusNotImplemented:
+		return true
+	default:
+		return false
+	}
+}
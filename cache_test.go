@@ -0,0 +1,368 @@
+package luci
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func countingHandler(calls *atomic.Int64, header http.Header) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		count := calls.Add(1)
+
+		for key, values := range header {
+			rw.Header()[key] = values
+		}
+
+		rw.Header().Set("Content-Type", "text/plain")
+		_, _ = io.WriteString(rw, strconv.FormatInt(count, 10)+" "+req.Header.Get("Accept-Language"))
+	}
+}
+
+func TestMemoryCacheStore(t *testing.T) {
+	t.Parallel()
+
+	t.Run("gets, sets, and deletes responses", func(t *testing.T) {
+		t.Parallel()
+
+		store := NewMemoryCacheStore(0)
+
+		_, ok, err := store.Get(t.Context(), "a")
+		assert.NoError(t, err)
+		assert.False(t, ok)
+
+		assert.NoError(t, store.Set(t.Context(), "a", CachedResponse{Status: http.StatusOK}))
+		assert.NoError(t, store.Set(t.Context(), "a", CachedResponse{Status: http.StatusNotFound}))
+
+		response, ok, err := store.Get(t.Context(), "a")
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, http.StatusNotFound, response.Status)
+		assert.Equal(t, 1, store.Len())
+
+		assert.NoError(t, store.Delete(t.Context(), "a"))
+		assert.NoError(t, store.Delete(t.Context(), "b"))
+		assert.Equal(t, 0, store.Len())
+	})
+
+	t.Run("evicts the least recently used responses", func(t *testing.T) {
+		t.Parallel()
+
+		store := NewMemoryCacheStore(2)
+
+		assert.NoError(t, store.Set(t.Context(), "a", CachedResponse{}))
+		assert.NoError(t, store.Set(t.Context(), "b", CachedResponse{}))
+
+		_, ok, _ := store.Get(t.Context(), "a")
+		assert.True(t, ok)
+
+		assert.NoError(t, store.Set(t.Context(), "c", CachedResponse{}))
+		assert.Equal(t, 2, store.Len())
+
+		_, ok, _ = store.Get(t.Context(), "b")
+		assert.False(t, ok)
+
+		_, ok, _ = store.Get(t.Context(), "a")
+		assert.True(t, ok)
+	})
+
+	t.Run("discards expired responses", func(t *testing.T) {
+		t.Parallel()
+
+		store := NewMemoryCacheStore(0)
+
+		assert.NoError(t, store.Set(t.Context(), "a", CachedResponse{Expires: time.Now().Add(-time.Second)}))
+
+		_, ok, err := store.Get(t.Context(), "a")
+		assert.NoError(t, err)
+		assert.False(t, ok)
+		assert.Equal(t, 0, store.Len())
+	})
+}
+
+func TestCache(t *testing.T) {
+	t.Parallel()
+
+	route := Route{Name: "show_user", Cache: &RouteCache{}}
+
+	serve := func(t *testing.T, handler http.Handler, route Route, target string, header http.Header) (*httptest.ResponseRecorder, *responseWriter) {
+		return serveTestRequest(handler, testRequest(t.Context(), http.MethodGet, target, nil, header, &requestState{route: &route, vars: map[string]string{"key": "abc"}, logger: noopLogger}))
+	}
+
+	t.Run("responds with cached responses", func(t *testing.T) {
+		t.Parallel()
+
+		var calls atomic.Int64
+
+		middleware := Cache(CacheConfig{})
+		handler := countingHandler(&calls, http.Header{"Etag": []string{`"abc"`}})
+
+		recorder, wrw := serve(t, middleware(handler), route, "/user/abc?page=1", nil)
+		assert.Equal(t, "1 ", recorder.Body.String())
+		assert.Empty(t, recorder.Header().Get("Age"))
+		assert.Equal(t, "miss", wrw.stats().cache)
+
+		recorder, wrw = serve(t, middleware(handler), route, "/user/abc?page=1", nil)
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "1 ", recorder.Body.String())
+		assert.Equal(t, `"abc"`, recorder.Header().Get("ETag"))
+		assert.Equal(t, "text/plain", recorder.Header().Get("Content-Type"))
+		assert.Equal(t, "0", recorder.Header().Get("Age"))
+		assert.Equal(t, "hit", wrw.stats().cache)
+
+		recorder, _ = serve(t, middleware(handler), route, "/user/abc?page=2", nil)
+		assert.Equal(t, "2 ", recorder.Body.String())
+
+		recorder, _ = serve(t, middleware(handler), Route{Name: "list_users", Cache: &RouteCache{}}, "/user/abc?page=2", nil)
+		assert.Equal(t, "3 ", recorder.Body.String())
+	})
+
+	t.Run("caches responses by the vary headers", func(t *testing.T) {
+		t.Parallel()
+
+		var calls atomic.Int64
+
+		middleware := Cache(CacheConfig{})
+		handler := countingHandler(&calls, http.Header{"Vary": []string{"accept-language"}})
+		english := http.Header{"Accept-Language": []string{"en"}}
+		french := http.Header{"Accept-Language": []string{"fr"}}
+
+		recorder, _ := serve(t, middleware(handler), route, "/user/abc", english)
+		assert.Equal(t, "1 en", recorder.Body.String())
+
+		recorder, _ = serve(t, middleware(handler), route, "/user/abc", french)
+		assert.Equal(t, "2 fr", recorder.Body.String())
+
+		recorder, _ = serve(t, middleware(handler), route, "/user/abc", english)
+		assert.Equal(t, "1 en", recorder.Body.String())
+
+		recorder, _ = serve(t, middleware(handler), route, "/user/abc", french)
+		assert.Equal(t, "2 fr", recorder.Body.String())
+	})
+
+	t.Run("doesn't cache responses that aren't allowed", func(t *testing.T) {
+		t.Parallel()
+
+		tests := []http.Header{
+			{"Cache-Control": []string{"no-store"}},
+			{"Cache-Control": []string{"no-cache"}},
+			{"Cache-Control": []string{"Private"}},
+			{"Cache-Control": []string{"max-age=0"}},
+			{"Set-Cookie": []string{"session=abc"}},
+			{"Vary": []string{"*"}},
+		}
+
+		for _, header := range tests {
+			var calls atomic.Int64
+
+			middleware := Cache(CacheConfig{})
+			handler := countingHandler(&calls, header)
+
+			serve(t, middleware(handler), route, "/user/abc", nil)
+			recorder, _ := serve(t, middleware(handler), route, "/user/abc", nil)
+
+			assert.Equal(t, "2 ", recorder.Body.String(), "%v", header)
+		}
+	})
+
+	t.Run("doesn't cache long or unsuccessful responses", func(t *testing.T) {
+		t.Parallel()
+
+		var calls atomic.Int64
+
+		middleware := Cache(CacheConfig{MaxLength: 1})
+		handler := countingHandler(&calls, nil)
+
+		serve(t, middleware(handler), route, "/user/abc", nil)
+		recorder, _ := serve(t, middleware(handler), route, "/user/abc", nil)
+		assert.Equal(t, "2 ", recorder.Body.String())
+
+		middleware = Cache(CacheConfig{})
+		handler = func(rw http.ResponseWriter, _ *http.Request) {
+			calls.Add(1)
+			rw.WriteHeader(http.StatusInternalServerError)
+		}
+
+		serve(t, middleware(handler), route, "/user/abc", nil)
+		serve(t, middleware(handler), route, "/user/abc", nil)
+		assert.Equal(t, int64(4), calls.Load())
+	})
+
+	t.Run("bypasses the cache", func(t *testing.T) {
+		t.Parallel()
+
+		var calls atomic.Int64
+
+		middleware := Cache(CacheConfig{})
+		handler := countingHandler(&calls, nil)
+		authorization := http.Header{"Authorization": []string{"Bearer abc"}}
+
+		serve(t, middleware(handler), Route{Name: "show_user"}, "/user/abc", nil)
+		recorder, wrw := serve(t, middleware(handler), Route{Name: "show_user"}, "/user/abc", nil)
+		assert.Equal(t, "2 ", recorder.Body.String())
+		assert.Empty(t, wrw.stats().cache)
+
+		serve(t, middleware(handler), route, "/user/abc", authorization)
+		recorder, _ = serve(t, middleware(handler), route, "/user/abc", authorization)
+		assert.Equal(t, "4 ", recorder.Body.String())
+
+		recorder = httptest.NewRecorder()
+		request := httptest.NewRequestWithContext(t.Context(), http.MethodPost, "/user/abc", nil)
+		request = withState(request, &requestState{route: &route})
+
+		middleware(handler).ServeHTTP(recorder, request)
+		assert.Equal(t, "5 ", recorder.Body.String())
+	})
+
+	t.Run("uses the response's lifetimes", func(t *testing.T) {
+		t.Parallel()
+
+		store := NewMemoryCacheStore(0)
+		middleware := Cache(CacheConfig{Store: store, TTL: time.Hour})
+
+		var calls atomic.Int64
+
+		handler := countingHandler(&calls, http.Header{"Cache-Control": []string{"max-age=60, s-maxage=120, stale-while-revalidate=30"}})
+		serve(t, middleware(handler), route, "/user/abc", nil)
+
+		response, ok, err := store.Get(t.Context(), "show_user key=abc ")
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, 120*time.Second, response.Fresh.Sub(response.Stored))
+		assert.Equal(t, 30*time.Second, response.Expires.Sub(response.Fresh))
+	})
+
+	t.Run("doesn't cache headers from earlier middlewares", func(t *testing.T) {
+		t.Parallel()
+
+		store := NewMemoryCacheStore(0)
+		middlewares := Middlewares{
+			func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+					rw.Header().Set("X-Request-Id", "abc")
+					next.ServeHTTP(rw, req)
+				})
+			},
+			Cache(CacheConfig{Store: store}),
+		}
+
+		var calls atomic.Int64
+
+		serve(t, middlewares.Handler(countingHandler(&calls, nil)), route, "/user/abc", nil)
+
+		response, ok, _ := store.Get(t.Context(), "show_user key=abc ")
+		assert.True(t, ok)
+		assert.Equal(t, http.Header{"Content-Type": []string{"text/plain"}}, response.Header)
+	})
+
+	t.Run("revalidates stale responses in the background", func(t *testing.T) {
+		t.Parallel()
+
+		store := NewMemoryCacheStore(0)
+		middleware := Cache(CacheConfig{Store: store, StaleWhileRevalidate: time.Minute})
+
+		var calls atomic.Int64
+
+		handler := countingHandler(&calls, nil)
+		key := "show_user key=abc "
+
+		serve(t, middleware(handler), route, "/user/abc", nil)
+
+		response, _, _ := store.Get(t.Context(), key)
+		response.Stored = time.Now().Add(-2 * time.Minute)
+		response.Fresh = time.Now().Add(-time.Second)
+		assert.NoError(t, store.Set(t.Context(), key, response))
+
+		recorder, wrw := serve(t, middleware(handler), route, "/user/abc", nil)
+		assert.Equal(t, "1 ", recorder.Body.String())
+		assert.Equal(t, "120", recorder.Header().Get("Age"))
+		assert.Equal(t, "stale", wrw.stats().cache)
+
+		assert.Eventually(t, func() bool {
+			response, _, _ := store.Get(t.Context(), key)
+			return string(response.Body) == "2 "
+		}, time.Second, time.Millisecond)
+
+		recorder, _ = serve(t, middleware(handler), route, "/user/abc", nil)
+		assert.Equal(t, "2 ", recorder.Body.String())
+	})
+
+	t.Run("recovers from panics while revalidating", func(t *testing.T) {
+		t.Parallel()
+
+		middleware := Cache(CacheConfig{TTL: time.Millisecond * 10, StaleWhileRevalidate: time.Minute})
+
+		var calls atomic.Int64
+
+		handler := http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+			if calls.Add(1) > 1 {
+				panic(errors.New("boom"))
+			}
+
+			_, _ = io.WriteString(rw, "abc")
+		})
+
+		serve(t, middleware(handler), route, "/user/abc", nil)
+
+		time.Sleep(time.Millisecond * 20)
+
+		recorder, _ := serve(t, middleware(handler), route, "/user/abc", nil)
+		assert.Equal(t, "abc", recorder.Body.String())
+
+		assert.Eventually(t, func() bool {
+			serve(t, middleware(handler), route, "/user/abc", nil)
+			return calls.Load() >= 3
+		}, time.Second, time.Millisecond*10)
+	})
+
+	t.Run("unwraps to the wrapped ResponseWriter", func(t *testing.T) {
+		t.Parallel()
+
+		handler := Cache(CacheConfig{})(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+			assert.IsType(t, new(recordWriter), rw)
+			assert.NotNil(t, findResponseWriter(rw))
+		}))
+
+		serve(t, handler, route, "/user/abc", nil)
+	})
+}
+
+func TestCacheVary(t *testing.T) {
+	t.Parallel()
+
+	header := http.Header{"Vary": []string{"accept-language, Accept", "Accept-Language,,"}}
+
+	assert.Equal(t, []string{"Accept", "Accept-Language"}, cacheVary(header))
+	assert.Empty(t, cacheVary(http.Header{}))
+}
+
+func TestParseCacheControl(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, map[string]string{
+		"public":                 "",
+		"max-age":                "60",
+		"stale-while-revalidate": "30",
+		"private":                "Set-Cookie",
+	}, parseCacheControl(`public, Max-Age=60,, stale-while-revalidate=30, private="Set-Cookie"`))
+}
+
+func TestBuildCacheConfig(t *testing.T) {
+	t.Parallel()
+
+	config := buildCacheConfig(CacheConfig{})
+	assert.IsType(t, new(MemoryCacheStore), config.Store)
+	assert.Equal(t, DefaultCacheConfig.TTL, config.TTL)
+
+	store := NewMemoryCacheStore(1)
+	config = buildCacheConfig(CacheConfig{Store: store, TTL: time.Second})
+	assert.Equal(t, store, config.Store)
+	assert.Equal(t, time.Second, config.TTL)
+}
@@ -0,0 +1,157 @@
+package luci
+
+import (
+	"net/http"
+	"slices"
+	"sync"
+)
+
+var (
+	// DefaultCoalesceConfig is the base configuration that's used when creating a coalesce middleware.
+	DefaultCoalesceConfig = CoalesceConfig{
+		Headers:   []string{"Accept"},
+		MaxLength: 1 << 20,
+	}
+)
+
+// CoalesceConfig defines how the coalesce middleware matches requests.
+// See DefaultCoalesceConfig for configuration defaults.
+type CoalesceConfig struct {
+	// Headers defines the request headers that must match for requests to be coalesced, in addition
+	// to the Authorization and Cookie headers which always must match.
+	Headers []string
+	// MaxLength defines the maximum response body length to share, if the response is longer
+	// waiting requests call the handler themselves.
+	MaxLength int
+}
+
+type coalesceCall struct {
+	done   chan struct{}
+	ok     bool
+	status int
+	header http.Header
+	body   []byte
+}
+
+type coalescer struct {
+	config CoalesceConfig
+	calls  map[string]*coalesceCall
+	mu     sync.Mutex
+}
+
+// Coalesce is a route middleware that coalesces concurrent identical GET and HEAD requests, so only one
+// request calls the handler and the others receive a copy of its response. Requests are identical if they
+// have the same method, route name, variables, query, and values for the configured headers.
+//
+// If the handler panics, the request's context is done before the handler returns, such as when the
+// route's timeout is reached, or the response can't be shared, waiting requests are released and call
+// the handler themselves, coalescing again with each other. Waiting requests still respond using
+// their own timeout.
+func Coalesce(config CoalesceConfig) Middleware {
+	return newCoalescer(buildCoalesceConfig(config)).middleware
+}
+
+func newCoalescer(config CoalesceConfig) *coalescer {
+	return &coalescer{
+		config: config,
+		calls:  make(map[string]*coalesceCall),
+	}
+}
+
+func (coalescer *coalescer) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			next.ServeHTTP(rw, req)
+			return
+		}
+
+		key := coalesceKey(req, coalescer.config.Headers)
+
+		for {
+			coalescer.mu.Lock()
+			call, ok := coalescer.calls[key]
+			if !ok {
+				call = &coalesceCall{done: make(chan struct{})}
+				coalescer.calls[key] = call
+			}
+			coalescer.mu.Unlock()
+
+			if !ok {
+				coalescer.lead(key, call, next, rw, req)
+				return
+			}
+
+			select {
+			case <-req.Context().Done():
+				return
+			case <-call.done:
+			}
+
+			if !call.ok {
+				continue
+			}
+
+			writeRecorded(rw, call.status, call.header, call.body)
+
+			resWriter := findResponseWriter(rw)
+			if resWriter != nil {
+				resWriter.coalesce()
+			}
+
+			return
+		}
+	})
+}
+
+// lead calls the handler and shares the response with the waiting requests. Waiting
+// requests are released even if the handler panics.
+func (coalescer *coalescer) lead(key string, call *coalesceCall, next http.Handler, rw http.ResponseWriter, req *http.Request) {
+	recorder := newRecordWriter(rw, coalescer.config.MaxLength)
+	completed := false
+
+	defer func() {
+		call.ok = completed && req.Context().Err() == nil && (!recorder.wroteHeader || recorder.recorded)
+
+		// Handlers that don't write a response implicitly respond with 200 OK.
+		if call.ok && !recorder.wroteHeader {
+			recorder.status = http.StatusOK
+			recorder.header = rw.Header().Clone()
+		}
+
+		if call.ok {
+			call.status = recorder.status
+			call.header = recorder.handlerHeader()
+			call.body = recorder.body
+		}
+
+		coalescer.mu.Lock()
+		delete(coalescer.calls, key)
+		coalescer.mu.Unlock()
+
+		close(call.done)
+	}()
+
+	next.ServeHTTP(recorder, req)
+	completed = true
+}
+
+func buildCoalesceConfig(config CoalesceConfig) CoalesceConfig {
+	built := DefaultCoalesceConfig
+
+	if len(config.Headers) != 0 {
+		built.Headers = config.Headers
+	}
+
+	if config.MaxLength != 0 {
+		built.MaxLength = config.MaxLength
+	}
+
+	built.Headers = append(slices.Clone(built.Headers), "Authorization", "Cookie")
+
+	return built
+}
+
+// coalesceKey returns the key for the request from the method, route name, variables, query, and headers.
+func coalesceKey(req *http.Request, headers []string) string {
+	return cacheVariantKey(req, req.Method+" "+cacheKey(req), headers)
+}
@@ -0,0 +1,261 @@
+package luci
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/synctest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// coalesceWaiters starts count requests that wait on the request that's currently being handled.
+// It must be called in a synctest bubble, and returns once every request is waiting.
+func coalesceWaiters(t *testing.T, handler http.Handler, count int) ([]*httptest.ResponseRecorder, []*responseWriter, *sync.WaitGroup) {
+	t.Helper()
+
+	var wg sync.WaitGroup
+
+	recorders := make([]*httptest.ResponseRecorder, count)
+	wrws := make([]*responseWriter, count)
+
+	for idx := range count {
+		wg.Go(func() {
+			recorders[idx], wrws[idx] = serveTestRequest(handler, testRequest(t.Context(), http.MethodGet, "/user/abc?page=1", nil, nil, nil))
+		})
+	}
+
+	synctest.Wait()
+
+	return recorders, wrws, &wg
+}
+
+func TestCoalesce(t *testing.T) {
+	t.Parallel()
+
+	t.Run("shares the response with waiting requests", func(t *testing.T) {
+		t.Parallel()
+
+		synctest.Test(t, func(t *testing.T) {
+			var calls atomic.Int64
+
+			started := make(chan struct{})
+			release := make(chan struct{})
+			handler := Coalesce(CoalesceConfig{})(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+				count := calls.Add(1)
+				close(started)
+				<-release
+
+				rw.Header().Set("Content-Type", "text/plain")
+				rw.WriteHeader(http.StatusAccepted)
+
+				_, err := io.WriteString(rw, strconv.FormatInt(count, 10))
+				assert.NoError(t, err)
+			}))
+
+			var (
+				leader    *httptest.ResponseRecorder
+				leaderWrw *responseWriter
+				leaderWg  sync.WaitGroup
+			)
+
+			leaderWg.Go(func() {
+				leader, leaderWrw = serveTestRequest(handler, testRequest(t.Context(), http.MethodGet, "/user/abc?page=1", nil, nil, nil))
+			})
+
+			<-started
+
+			recorders, wrws, wg := coalesceWaiters(t, handler, 3)
+
+			close(release)
+			leaderWg.Wait()
+			wg.Wait()
+
+			assert.Equal(t, int64(1), calls.Load())
+			assert.Equal(t, "1", leader.Body.String())
+			assert.False(t, leaderWrw.stats().coalesced)
+
+			for idx, recorder := range recorders {
+				assert.Equal(t, http.StatusAccepted, recorder.Code)
+				assert.Equal(t, "text/plain", recorder.Header().Get("Content-Type"))
+				assert.Equal(t, "1", recorder.Body.String())
+				assert.True(t, wrws[idx].stats().coalesced)
+			}
+		})
+	})
+
+	t.Run("releases waiting requests if the handler panics", func(t *testing.T) {
+		t.Parallel()
+
+		synctest.Test(t, func(t *testing.T) {
+			var calls atomic.Int64
+
+			started := make(chan struct{})
+			release := make(chan struct{})
+			handler := Coalesce(CoalesceConfig{})(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+				count := calls.Add(1)
+				if count == 1 {
+					close(started)
+					<-release
+					panic("luci")
+				}
+
+				_, err := io.WriteString(rw, strconv.FormatInt(count, 10))
+				assert.NoError(t, err)
+			}))
+
+			var leaderWg sync.WaitGroup
+
+			leaderWg.Go(func() {
+				assert.PanicsWithValue(t, "luci", func() {
+					serveTestRequest(handler, testRequest(t.Context(), http.MethodGet, "/user/abc?page=1", nil, nil, nil))
+				})
+			})
+
+			<-started
+
+			recorders, _, wg := coalesceWaiters(t, handler, 2)
+
+			close(release)
+			leaderWg.Wait()
+			wg.Wait()
+
+			// Released requests coalesce again, but may not overlap.
+			assert.GreaterOrEqual(t, calls.Load(), int64(2))
+
+			for _, recorder := range recorders {
+				assert.Equal(t, http.StatusOK, recorder.Code)
+				assert.Contains(t, []string{"2", "3"}, recorder.Body.String())
+			}
+		})
+	})
+
+	t.Run("releases waiting requests if the request times out", func(t *testing.T) {
+		t.Parallel()
+
+		synctest.Test(t, func(t *testing.T) {
+			var calls atomic.Int64
+
+			started := make(chan struct{})
+			handler := Coalesce(CoalesceConfig{})(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				count := calls.Add(1)
+				if count == 1 {
+					close(started)
+					<-req.Context().Done()
+					rw.WriteHeader(http.StatusServiceUnavailable)
+
+					return
+				}
+
+				_, err := io.WriteString(rw, strconv.FormatInt(count, 10))
+				assert.NoError(t, err)
+			}))
+
+			ctx, cancel := context.WithCancel(t.Context())
+
+			var (
+				leader   *httptest.ResponseRecorder
+				leaderWg sync.WaitGroup
+			)
+
+			leaderWg.Go(func() {
+				leader, _ = serveTestRequest(handler, testRequest(ctx, http.MethodGet, "/user/abc?page=1", nil, nil, nil))
+			})
+
+			<-started
+
+			recorders, _, wg := coalesceWaiters(t, handler, 2)
+
+			cancel()
+			leaderWg.Wait()
+			wg.Wait()
+
+			assert.Equal(t, http.StatusServiceUnavailable, leader.Code)
+			// Released requests coalesce again, but may not overlap.
+			assert.GreaterOrEqual(t, calls.Load(), int64(2))
+
+			for _, recorder := range recorders {
+				assert.Equal(t, http.StatusOK, recorder.Code)
+				assert.Contains(t, []string{"2", "3"}, recorder.Body.String())
+			}
+		})
+	})
+
+	t.Run("stops waiting once the waiting request is done", func(t *testing.T) {
+		t.Parallel()
+
+		started := make(chan struct{})
+		release := make(chan struct{})
+		handler := Coalesce(CoalesceConfig{})(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+			close(started)
+			<-release
+		}))
+
+		var leaderWg sync.WaitGroup
+
+		leaderWg.Go(func() {
+			serveTestRequest(handler, testRequest(t.Context(), http.MethodGet, "/user/abc?page=1", nil, nil, nil))
+		})
+
+		<-started
+
+		ctx, cancel := context.WithCancel(t.Context())
+		cancel()
+
+		_, wrw := serveTestRequest(handler, testRequest(ctx, http.MethodGet, "/user/abc?page=1", nil, nil, nil))
+		assert.False(t, wrw.stats().wroteHeader)
+
+		close(release)
+		leaderWg.Wait()
+	})
+
+	t.Run("doesn't coalesce unsafe requests", func(t *testing.T) {
+		t.Parallel()
+
+		handler := Coalesce(CoalesceConfig{})(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+			assert.IsType(t, new(responseWriter), rw)
+		}))
+
+		serveTestRequest(handler, testRequest(t.Context(), http.MethodPost, "/user/abc?page=1", nil, nil, nil))
+	})
+}
+
+func TestCoalesceKey(t *testing.T) {
+	t.Parallel()
+
+	headers := buildCoalesceConfig(CoalesceConfig{}).Headers
+
+	request := func(method, target string, header http.Header) string {
+		req := httptest.NewRequestWithContext(t.Context(), method, target, nil)
+		req.Header = header
+		req = withState(req, &requestState{route: &Route{Name: "show_user"}})
+
+		return coalesceKey(req, headers)
+	}
+
+	key := request(http.MethodGet, "/user?b=2&a=1", http.Header{"Accept": []string{"application/json"}})
+
+	assert.Equal(t, key, request(http.MethodGet, "/user?a=1&b=2", http.Header{"Accept": []string{"application/json"}, "X-Other": []string{"abc"}}))
+	assert.NotEqual(t, key, request(http.MethodHead, "/user?b=2&a=1", http.Header{"Accept": []string{"application/json"}}))
+	assert.NotEqual(t, key, request(http.MethodGet, "/user?b=2&a=2", http.Header{"Accept": []string{"application/json"}}))
+	assert.NotEqual(t, key, request(http.MethodGet, "/user?b=2&a=1", http.Header{"Accept": []string{"text/plain"}}))
+	assert.NotEqual(t, key, request(http.MethodGet, "/user?b=2&a=1", http.Header{
+		"Accept":        []string{"application/json"},
+		"Authorization": []string{"Bearer abc"},
+	}))
+}
+
+func TestBuildCoalesceConfig(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, CoalesceConfig{
+		Headers:   []string{"Accept", "Authorization", "Cookie"},
+		MaxLength: DefaultCoalesceConfig.MaxLength,
+	}, buildCoalesceConfig(CoalesceConfig{}))
+	assert.Equal(t, []string{"Accept"}, DefaultCoalesceConfig.Headers)
+}
@@ -0,0 +1,401 @@
+package luci
+
+import (
+	"encoding"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var (
+	// DefaultCodecs are the codecs used when a server has no codecs configured, in order of preference.
+	DefaultCodecs = Codecs{JSONCodec{}, XMLCodec{}, FormCodec{}, TextCodec{}}
+)
+
+// Codec encodes and decodes values for a media type.
+type Codec interface {
+	// ContentType returns the Content-Type header value for encoded values, e.g. application/json.
+	ContentType() string
+	// Encode writes the encoded value to the writer.
+	Encode(w io.Writer, value any) error
+	// Decode reads an encoded value from the reader into the value pointed to by dst.
+	Decode(r io.Reader, dst any) error
+}
+
+// Codecs is an ordered list of codecs, earlier codecs are preferred during content negotiation.
+type Codecs []Codec
+
+// Lookup returns the codec with the same media type as the given content type, ignoring parameters.
+func (codecs Codecs) Lookup(contentType string) (Codec, bool) {
+	mediaType := parseMediaType(contentType)
+
+	for _, codec := range codecs {
+		if parseMediaType(codec.ContentType()) == mediaType {
+			return codec, true
+		}
+	}
+
+	return nil, false
+}
+
+// Negotiate returns the codec that best matches the media ranges and q-values in the given Accept
+// header. If multiple codecs match equally well the earliest codec is used. An empty Accept header
+// matches the first codec.
+func (codecs Codecs) Negotiate(accept string) (Codec, bool) {
+	if len(codecs) == 0 {
+		return nil, false
+	}
+
+	ranges := parseAccept(accept)
+	if len(ranges) == 0 {
+		return codecs[0], true
+	}
+
+	var (
+		best        Codec
+		bestQuality float64
+	)
+
+	for _, codec := range codecs {
+		quality := acceptQuality(ranges, parseMediaType(codec.ContentType()))
+		if quality > bestQuality {
+			best = codec
+			bestQuality = quality
+		}
+	}
+
+	return best, best != nil
+}
+
+// Filter returns the codecs matching the given content types, in the order of the content types.
+func (codecs Codecs) Filter(contentTypes ...string) (Codecs, error) {
+	filtered := make(Codecs, 0, len(contentTypes))
+
+	for _, contentType := range contentTypes {
+		codec, ok := codecs.Lookup(contentType)
+		if !ok {
+			return nil, fmt.Errorf(`luci: no codec for content type "%s"`, contentType)
+		}
+
+		filtered = append(filtered, codec)
+	}
+
+	return filtered, nil
+}
+
+// JSONCodec encodes and decodes values as application/json using encoding/json.
+type JSONCodec struct{}
+
+// ContentType returns application/json.
+func (JSONCodec) ContentType() string {
+	return "application/json"
+}
+
+// Encode writes the value as JSON.
+func (JSONCodec) Encode(w io.Writer, value any) error {
+	err := json.NewEncoder(w).Encode(value)
+	if err != nil {
+		return fmt.Errorf("luci: json encode: %w", err)
+	}
+
+	return nil
+}
+
+// Decode reads JSON into dst.
+func (JSONCodec) Decode(r io.Reader, dst any) error {
+	err := json.NewDecoder(r).Decode(dst)
+	if err != nil {
+		return fmt.Errorf("luci: json decode: %w", err)
+	}
+
+	return nil
+}
+
+// XMLCodec encodes and decodes values as application/xml using encoding/xml.
+type XMLCodec struct{}
+
+// ContentType returns application/xml; charset=utf-8.
+func (XMLCodec) ContentType() string {
+	return "application/xml; charset=utf-8"
+}
+
+// Encode writes the value as XML.
+func (XMLCodec) Encode(w io.Writer, value any) error {
+	err := xml.NewEncoder(w).Encode(value)
+	if err != nil {
+		return fmt.Errorf("luci: xml encode: %w", err)
+	}
+
+	return nil
+}
+
+// Decode reads XML into dst.
+func (XMLCodec) Decode(r io.Reader, dst any) error {
+	err := xml.NewDecoder(r).Decode(dst)
+	if err != nil {
+		return fmt.Errorf("luci: xml decode: %w", err)
+	}
+
+	return nil
+}
+
+// FormCodec encodes and decodes values as application/x-www-form-urlencoded. Values may be
+// url.Values, map[string]string, or structs using the form struct tag as described by Bind.
+type FormCodec struct{}
+
+// ContentType returns application/x-www-form-urlencoded.
+func (FormCodec) ContentType() string {
+	return "application/x-www-form-urlencoded"
+}
+
+// Encode writes the value as a url encoded form.
+func (FormCodec) Encode(w io.Writer, value any) error {
+	var values url.Values
+
+	switch v := value.(type) {
+	case url.Values:
+		values = v
+	case map[string][]string:
+		values = v
+	case map[string]string:
+		values = make(url.Values, len(v))
+		for key, val := range v {
+			values.Set(key, val)
+		}
+	default:
+		var err error
+
+		values, err = formValues(value)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := io.WriteString(w, values.Encode())
+	if err != nil {
+		return fmt.Errorf("luci: form encode: %w", err)
+	}
+
+	return nil
+}
+
+// Decode reads a url encoded form into dst.
+func (FormCodec) Decode(r io.Reader, dst any) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("luci: form decode: %w", err)
+	}
+
+	values, err := url.ParseQuery(string(data))
+	if err != nil {
+		return fmt.Errorf("luci: form decode: %w", err)
+	}
+
+	switch v := dst.(type) {
+	case *url.Values:
+		*v = values
+	case *map[string]string:
+		*v = make(map[string]string, len(values))
+		for key := range values {
+			(*v)[key] = values.Get(key)
+		}
+	default:
+		return bindForm(values, dst)
+	}
+
+	return nil
+}
+
+// TextCodec encodes and decodes values as text/plain. Values are encoded using encoding.TextMarshaler
+// if implemented, otherwise using their default format. Values are decoded into strings, byte slices,
+// or types implementing encoding.TextUnmarshaler.
+type TextCodec struct{}
+
+// ContentType returns text/plain; charset=utf-8.
+func (TextCodec) ContentType() string {
+	return "text/plain; charset=utf-8"
+}
+
+// Encode writes the value as text.
+func (TextCodec) Encode(w io.Writer, value any) error {
+	var err error
+
+	switch v := value.(type) {
+	case []byte:
+		_, err = w.Write(v)
+	case encoding.TextMarshaler:
+		var data []byte
+
+		data, err = v.MarshalText()
+		if err == nil {
+			_, err = w.Write(data)
+		}
+	default:
+		_, err = fmt.Fprint(w, value)
+	}
+
+	if err != nil {
+		return fmt.Errorf("luci: text encode: %w", err)
+	}
+
+	return nil
+}
+
+// Decode reads text into dst.
+func (TextCodec) Decode(r io.Reader, dst any) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("luci: text decode: %w", err)
+	}
+
+	switch v := dst.(type) {
+	case *string:
+		*v = string(data)
+	case *[]byte:
+		*v = data
+	case encoding.TextUnmarshaler:
+		err = v.UnmarshalText(data)
+		if err != nil {
+			return fmt.Errorf("luci: text decode: %w", err)
+		}
+	default:
+		return fmt.Errorf("luci: text decode: unsupported type %T", dst)
+	}
+
+	return nil
+}
+
+// BinaryCodec encodes and decodes values implementing encoding.BinaryMarshaler and
+// encoding.BinaryUnmarshaler, which allows binary formats such as MessagePack or
+// Protocol Buffers to be supported by the types themselves.
+type BinaryCodec struct {
+	// Type is the Content-Type of the binary format, e.g. application/msgpack.
+	Type string
+}
+
+// ContentType returns the configured content type.
+func (codec BinaryCodec) ContentType() string {
+	return codec.Type
+}
+
+// Encode writes the value's binary encoding.
+func (codec BinaryCodec) Encode(w io.Writer, value any) error {
+	marshaler, ok := value.(encoding.BinaryMarshaler)
+	if !ok {
+		return fmt.Errorf("luci: binary encode: %T does not implement encoding.BinaryMarshaler", value)
+	}
+
+	data, err := marshaler.MarshalBinary()
+	if err != nil {
+		return fmt.Errorf("luci: binary encode: %w", err)
+	}
+
+	_, err = w.Write(data)
+	if err != nil {
+		return fmt.Errorf("luci: binary encode: %w", err)
+	}
+
+	return nil
+}
+
+// Decode reads a binary encoding into dst.
+func (codec BinaryCodec) Decode(r io.Reader, dst any) error {
+	unmarshaler, ok := dst.(encoding.BinaryUnmarshaler)
+	if !ok {
+		return fmt.Errorf("luci: binary decode: %T does not implement encoding.BinaryUnmarshaler", dst)
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("luci: binary decode: %w", err)
+	}
+
+	err = unmarshaler.UnmarshalBinary(data)
+	if err != nil {
+		return fmt.Errorf("luci: binary decode: %w", err)
+	}
+
+	return nil
+}
+
+func formValues(value any) (url.Values, error) {
+	reflectValue := reflect.ValueOf(value)
+	for reflectValue.Kind() == reflect.Pointer && !reflectValue.IsNil() {
+		reflectValue = reflectValue.Elem()
+	}
+
+	if reflectValue.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("luci: form encode: unsupported type %T", value)
+	}
+
+	values := make(url.Values)
+	formStructValues(reflectValue, values)
+
+	return values, nil
+}
+
+func formStructValues(value reflect.Value, values url.Values) {
+	valueType := value.Type()
+
+	for idx := range valueType.NumField() {
+		field := valueType.Field(idx)
+
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			formStructValues(value.Field(idx), values)
+			continue
+		}
+
+		key := field.Tag.Get("form")
+		if !field.IsExported() || key == "" || key == "-" {
+			continue
+		}
+
+		fieldValue := value.Field(idx)
+		if fieldValue.Kind() == reflect.Pointer {
+			if fieldValue.IsNil() {
+				continue
+			}
+
+			fieldValue = fieldValue.Elem()
+		}
+
+		if fieldValue.Kind() == reflect.Slice && !isScalarType(fieldValue.Type()) {
+			for _, item := range fieldValue.Seq2() {
+				values.Add(key, formatValue(item))
+			}
+
+			continue
+		}
+
+		values.Add(key, formatValue(fieldValue))
+	}
+}
+
+func formatValue(value reflect.Value) string {
+	switch v := value.Interface().(type) {
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case encoding.TextMarshaler:
+		data, err := v.MarshalText()
+		if err == nil {
+			return string(data)
+		}
+	}
+
+	return fmt.Sprint(value.Interface())
+}
+
+func parseMediaType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(contentType))
+	}
+
+	return mediaType
+}
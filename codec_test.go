@@ -0,0 +1,246 @@
+package luci
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type codecBinary struct {
+	value string
+}
+
+func (value codecBinary) MarshalBinary() ([]byte, error) {
+	return []byte(value.value), nil
+}
+
+func (value *codecBinary) UnmarshalBinary(data []byte) error {
+	value.value = string(data)
+	return nil
+}
+
+type codecForm struct {
+	Name    string    `form:"name"`
+	Tags    []string  `form:"tag"`
+	Created time.Time `form:"created"`
+	Address net.IP    `form:"address"`
+	Age     *int      `form:"age"`
+	Ignored string
+}
+
+func TestCodecsLookup(t *testing.T) {
+	t.Parallel()
+
+	codec, ok := DefaultCodecs.Lookup("application/json; charset=utf-8")
+	assert.True(t, ok)
+	assert.Equal(t, JSONCodec{}, codec)
+
+	codec, ok = DefaultCodecs.Lookup("TEXT/PLAIN")
+	assert.True(t, ok)
+	assert.Equal(t, TextCodec{}, codec)
+
+	_, ok = DefaultCodecs.Lookup("image/png")
+	assert.False(t, ok)
+}
+
+func TestCodecsNegotiate(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		accept   string
+		expected Codec
+	}{
+		{accept: "", expected: JSONCodec{}},
+		{accept: "*/*", expected: JSONCodec{}},
+		{accept: "application/xml", expected: XMLCodec{}},
+		{accept: "application/json;q=0.5, application/xml", expected: XMLCodec{}},
+		{accept: "text/*, application/json;q=0.9", expected: TextCodec{}},
+		{accept: "text/html, */*;q=0.1", expected: JSONCodec{}},
+		{accept: "application/*, application/json;q=0", expected: XMLCodec{}},
+		{accept: "image/png", expected: nil},
+		{accept: "*/*;q=0", expected: nil},
+	}
+
+	for _, test := range tests {
+		codec, ok := DefaultCodecs.Negotiate(test.accept)
+		assert.Equal(t, test.expected != nil, ok, test.accept)
+		assert.Equal(t, test.expected, codec, test.accept)
+	}
+
+	_, ok := Codecs{}.Negotiate("")
+	assert.False(t, ok)
+}
+
+func TestCodecsFilter(t *testing.T) {
+	t.Parallel()
+
+	codecs, err := DefaultCodecs.Filter("text/plain", "application/json")
+	assert.NoError(t, err)
+	assert.Equal(t, Codecs{TextCodec{}, JSONCodec{}}, codecs)
+
+	_, err = DefaultCodecs.Filter("image/png")
+	assert.EqualError(t, err, `luci: no codec for content type "image/png"`)
+}
+
+func TestJSONCodec(t *testing.T) {
+	t.Parallel()
+
+	var (
+		codec  JSONCodec
+		buffer bytes.Buffer
+		value  map[string]string
+	)
+
+	assert.NoError(t, codec.Encode(&buffer, map[string]string{"name": "luci"}))
+	assert.JSONEq(t, `{"name":"luci"}`, buffer.String())
+
+	assert.NoError(t, codec.Decode(&buffer, &value))
+	assert.Equal(t, map[string]string{"name": "luci"}, value)
+
+	assert.Error(t, codec.Decode(strings.NewReader("{"), &value))
+}
+
+func TestXMLCodec(t *testing.T) {
+	t.Parallel()
+
+	type user struct {
+		Name string `xml:"name"`
+	}
+
+	var (
+		codec  XMLCodec
+		buffer bytes.Buffer
+		value  user
+	)
+
+	assert.NoError(t, codec.Encode(&buffer, user{Name: "luci"}))
+	assert.Equal(t, "<user><name>luci</name></user>", buffer.String())
+
+	assert.NoError(t, codec.Decode(&buffer, &value))
+	assert.Equal(t, user{Name: "luci"}, value)
+}
+
+func TestFormCodec(t *testing.T) {
+	t.Parallel()
+
+	t.Run("encodes values", func(t *testing.T) {
+		t.Parallel()
+
+		var (
+			codec  FormCodec
+			buffer bytes.Buffer
+		)
+
+		assert.NoError(t, codec.Encode(&buffer, url.Values{"name": []string{"luci"}}))
+		assert.Equal(t, "name=luci", buffer.String())
+
+		buffer.Reset()
+		assert.NoError(t, codec.Encode(&buffer, map[string]string{"name": "luci"}))
+		assert.Equal(t, "name=luci", buffer.String())
+
+		buffer.Reset()
+
+		age := 5
+		assert.NoError(t, codec.Encode(&buffer, codecForm{
+			Name:    "luci",
+			Tags:    []string{"a", "b"},
+			Created: time.Date(2020, time.January, 2, 3, 4, 5, 0, time.UTC),
+			Address: net.ParseIP("127.0.0.1"),
+			Age:     &age,
+			Ignored: "ignored",
+		}))
+		assert.Equal(t, "address=127.0.0.1&age=5&created=2020-01-02T03%3A04%3A05Z&name=luci&tag=a&tag=b", buffer.String())
+
+		assert.EqualError(t, codec.Encode(&buffer, 5), "luci: form encode: unsupported type int")
+	})
+
+	t.Run("decodes values", func(t *testing.T) {
+		t.Parallel()
+
+		var (
+			codec  FormCodec
+			values url.Values
+			mapped map[string]string
+			form   codecForm
+		)
+
+		body := "address=127.0.0.1&age=5&created=2020-01-02T03%3A04%3A05Z&name=luci&tag=a&tag=b"
+
+		assert.NoError(t, codec.Decode(strings.NewReader(body), &values))
+		assert.Equal(t, "luci", values.Get("name"))
+
+		assert.NoError(t, codec.Decode(strings.NewReader(body), &mapped))
+		assert.Equal(t, "a", mapped["tag"])
+
+		assert.NoError(t, codec.Decode(strings.NewReader(body), &form))
+
+		age := 5
+		assert.Equal(t, codecForm{
+			Name:    "luci",
+			Tags:    []string{"a", "b"},
+			Created: time.Date(2020, time.January, 2, 3, 4, 5, 0, time.UTC),
+			Address: net.ParseIP("127.0.0.1"),
+			Age:     &age,
+		}, form)
+
+		var bindErr *BindError
+		assert.True(t, errors.As(codec.Decode(strings.NewReader("age=old"), &form), &bindErr))
+	})
+}
+
+func TestTextCodec(t *testing.T) {
+	t.Parallel()
+
+	var (
+		codec  TextCodec
+		buffer bytes.Buffer
+		str    string
+		data   []byte
+		ip     net.IP
+	)
+
+	assert.NoError(t, codec.Encode(&buffer, "luci"))
+	assert.NoError(t, codec.Encode(&buffer, []byte(" bytes ")))
+	assert.NoError(t, codec.Encode(&buffer, net.ParseIP("127.0.0.1")))
+	assert.NoError(t, codec.Encode(&buffer, 5))
+	assert.Equal(t, "luci bytes 127.0.0.15", buffer.String())
+
+	assert.NoError(t, codec.Decode(strings.NewReader("luci"), &str))
+	assert.Equal(t, "luci", str)
+
+	assert.NoError(t, codec.Decode(strings.NewReader("luci"), &data))
+	assert.Equal(t, []byte("luci"), data)
+
+	assert.NoError(t, codec.Decode(strings.NewReader("127.0.0.1"), &ip))
+	assert.Equal(t, net.ParseIP("127.0.0.1"), ip)
+
+	var number int
+	assert.EqualError(t, codec.Decode(strings.NewReader("5"), &number), "luci: text decode: unsupported type *int")
+}
+
+func TestBinaryCodec(t *testing.T) {
+	t.Parallel()
+
+	var (
+		buffer bytes.Buffer
+		value  codecBinary
+	)
+
+	codec := BinaryCodec{Type: "application/msgpack"}
+	assert.Equal(t, "application/msgpack", codec.ContentType())
+
+	assert.NoError(t, codec.Encode(&buffer, codecBinary{value: "luci"}))
+	assert.Equal(t, "luci", buffer.String())
+
+	assert.NoError(t, codec.Decode(&buffer, &value))
+	assert.Equal(t, codecBinary{value: "luci"}, value)
+
+	assert.EqualError(t, codec.Encode(&buffer, "luci"), "luci: binary encode: string does not implement encoding.BinaryMarshaler")
+	assert.EqualError(t, codec.Decode(&buffer, &value.value), "luci: binary decode: *string does not implement encoding.BinaryUnmarshaler")
+}
@@ -0,0 +1,408 @@
+package luci
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+var (
+	// DefaultCompressConfig is the base configuration that's used when creating a compress middleware.
+	DefaultCompressConfig = CompressConfig{
+		Level:     flate.DefaultCompression,
+		MinLength: 1024,
+		ContentTypes: []string{
+			"text/*",
+			"application/json",
+			"application/problem+json",
+			"application/xml",
+			"application/javascript",
+			"image/svg+xml",
+		},
+	}
+)
+
+type etagEncodingKey struct{}
+
+// CompressConfig defines how the compress middleware compresses responses.
+// See DefaultCompressConfig for configuration defaults.
+type CompressConfig struct {
+	// Level defines the compression level, from flate.HuffmanOnly (-2) to flate.BestCompression (9).
+	// If not set flate.DefaultCompression (-1) is used, so flate.NoCompression (0) can't be selected.
+	Level int
+	// MinLength defines the minimum response length to compress, smaller responses aren't
+	// worth the overhead of compression. Responses are buffered until the length is reached.
+	MinLength int
+	// ContentTypes defines the media types that are compressed, which may use a
+	// wildcard subtype such as text/*.
+	ContentTypes []string
+}
+
+type compressEncoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+type compressWriter struct {
+	rw          http.ResponseWriter
+	req         *http.Request
+	config      CompressConfig
+	encoding    string
+	pool        *sync.Pool
+	encoder     compressEncoder
+	buffer      []byte
+	status      int
+	wroteHeader bool
+	decided     bool
+	length      int64
+}
+
+// Compress is a middleware that compresses responses using gzip or deflate, based on the
+// q-values in the request's Accept-Encoding header. Responses that already have a Content-Encoding,
+// have a media type that isn't allowed, or are shorter than the minimum length aren't compressed.
+// Responses are compressed regardless of length once flushed, so streaming responses are sent
+// as they're written. Strong ETags of compressed responses have the encoding appended, e.g. "abc-gzip",
+// which is removed when the Conditional middleware and Preconditions of the request compare ETags.
+//
+// Compress panics if the compression level is invalid.
+func Compress(config CompressConfig) Middleware {
+	config = buildCompressConfig(config)
+
+	pools := map[string]*sync.Pool{
+		"gzip": {New: func() any {
+			encoder, _ := gzip.NewWriterLevel(io.Discard, config.Level)
+			return encoder
+		}},
+		// The deflate content coding is the zlib format, not raw deflate, see RFC 9110 section 8.4.1.2.
+		"deflate": {New: func() any {
+			encoder, _ := zlib.NewWriterLevel(io.Discard, config.Level)
+			return encoder
+		}},
+	}
+
+	_, err := gzip.NewWriterLevel(io.Discard, config.Level)
+	if err != nil {
+		panic(fmt.Errorf("luci: compress: %w", err))
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			encoding := negotiateEncoding(strings.Join(req.Header.Values("Accept-Encoding"), ","))
+			if encoding == "" || req.Method == http.MethodHead {
+				next.ServeHTTP(rw, req)
+				return
+			}
+
+			// Conditional requests compare the client's ETags, which may be of a compressed response.
+			req = req.WithContext(context.WithValue(req.Context(), etagEncodingKey{}, encoding))
+
+			cw := &compressWriter{
+				rw:       rw,
+				req:      req,
+				config:   config,
+				encoding: encoding,
+				pool:     pools[encoding],
+			}
+
+			// The response isn't completed on panic, which allows an error response to be
+			// written if nothing has been written yet.
+			next.ServeHTTP(cw, req)
+			cw.close()
+		})
+	}
+}
+
+func (cw *compressWriter) Header() http.Header {
+	return cw.rw.Header()
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.wroteHeader {
+		return
+	}
+
+	if status >= 100 && status < 200 && status != http.StatusSwitchingProtocols {
+		cw.rw.WriteHeader(status)
+		return
+	}
+
+	cw.wroteHeader = true
+	cw.status = status
+
+	if !bodyAllowed(status) {
+		_ = cw.decide(false, false)
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+
+	cw.length += int64(len(b))
+
+	if !cw.decided {
+		cw.buffer = append(cw.buffer, b...)
+		if len(cw.buffer) < cw.config.MinLength {
+			return len(b), nil
+		}
+
+		return len(b), cw.decide(true, false)
+	}
+
+	if cw.encoder != nil {
+		_, err := cw.encoder.Write(b)
+		if err != nil {
+			return 0, fmt.Errorf("luci: compress: %w", err)
+		}
+
+		return len(b), nil
+	}
+
+	n, err := cw.rw.Write(b)
+	if err != nil {
+		return n, fmt.Errorf("luci: compress: %w", err)
+	}
+
+	return n, nil
+}
+
+func (cw *compressWriter) Flush() {
+	_ = cw.FlushError()
+}
+
+func (cw *compressWriter) FlushError() error {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+
+	if !cw.decided {
+		err := cw.decide(true, true)
+		if err != nil {
+			return err
+		}
+	}
+
+	if cw.encoder != nil {
+		err := cw.encoder.Flush()
+		if err != nil {
+			return fmt.Errorf("luci: compress: %w", err)
+		}
+	}
+
+	err := http.NewResponseController(cw.rw).Flush()
+	if err != nil {
+		return fmt.Errorf("luci: compress: %w", err)
+	}
+
+	return nil
+}
+
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if cw.wroteHeader {
+		return nil, nil, fmt.Errorf("luci: compress: hijack: %w", http.ErrHijacked)
+	}
+
+	conn, buf, err := http.NewResponseController(cw.rw).Hijack()
+	if err != nil {
+		return nil, nil, fmt.Errorf("luci: compress: %w", err)
+	}
+
+	cw.wroteHeader = true
+	cw.decided = true
+
+	return conn, buf, nil
+}
+
+// Unwrap returns the wrapped ResponseWriter, see http.ResponseController.
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.rw
+}
+
+// decide determines whether the response is compressed, then writes the header and any buffered data.
+// If flushing the response is compressed regardless of the buffered length.
+func (cw *compressWriter) decide(hasBody, flushing bool) error {
+	cw.decided = true
+
+	header := cw.rw.Header()
+	compressible := hasBody && cw.compressible(header)
+
+	if compressible {
+		addVary(header, "Accept-Encoding")
+	}
+
+	if compressible && (flushing || len(cw.buffer) >= cw.config.MinLength) {
+		header.Set("Content-Encoding", cw.encoding)
+		header.Del("Content-Length")
+
+		// The compressed response isn't byte for byte identical, so strong ETags identify the encoding.
+		etag := header.Get("ETag")
+		if strings.HasPrefix(etag, `"`) && strings.HasSuffix(etag, `"`) && len(etag) > 1 {
+			header.Set("ETag", etag[:len(etag)-1]+"-"+cw.encoding+`"`)
+		}
+
+		encoder, _ := cw.pool.Get().(compressEncoder)
+		encoder.Reset(cw.rw)
+		cw.encoder = encoder
+	}
+
+	cw.rw.WriteHeader(cw.status)
+
+	if len(cw.buffer) == 0 {
+		return nil
+	}
+
+	var err error
+	if cw.encoder != nil {
+		_, err = cw.encoder.Write(cw.buffer)
+	} else {
+		_, err = cw.rw.Write(cw.buffer)
+	}
+
+	cw.buffer = nil
+
+	if err != nil {
+		return fmt.Errorf("luci: compress: %w", err)
+	}
+
+	return nil
+}
+
+func (cw *compressWriter) compressible(header http.Header) bool {
+	if header.Get("Content-Encoding") != "" || header.Get("Content-Range") != "" || cw.status == http.StatusPartialContent {
+		return false
+	}
+
+	contentType := header.Get("Content-Type")
+	if contentType == "" && len(cw.buffer) != 0 {
+		contentType = http.DetectContentType(cw.buffer)
+		header.Set("Content-Type", contentType)
+	}
+
+	mediaType := parseMediaType(contentType)
+	mainType, _, _ := strings.Cut(mediaType, "/")
+
+	for _, allowed := range cw.config.ContentTypes {
+		allowed = parseMediaType(allowed)
+		if allowed == mediaType || allowed == mainType+"/*" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (cw *compressWriter) close() {
+	if !cw.decided {
+		// Nothing was written, leave the response to the wrapped response writer.
+		if !cw.wroteHeader {
+			return
+		}
+
+		err := cw.decide(true, false)
+		if err != nil {
+			Logger(cw.req).With(slog.Any("error", err)).Error("failed to write compressed response")
+		}
+	}
+
+	if cw.encoder == nil {
+		return
+	}
+
+	err := cw.encoder.Close()
+	if err != nil {
+		Logger(cw.req).With(slog.Any("error", err)).Error("failed to write compressed response")
+	}
+
+	cw.encoder.Reset(io.Discard)
+	cw.pool.Put(cw.encoder)
+
+	resWriter := findResponseWriter(cw.rw)
+	if resWriter != nil {
+		resWriter.compressed(cw.encoding, cw.length)
+	}
+}
+
+func buildCompressConfig(config CompressConfig) CompressConfig {
+	built := DefaultCompressConfig
+
+	if config.Level != 0 {
+		built.Level = config.Level
+	}
+
+	if config.MinLength != 0 {
+		built.MinLength = config.MinLength
+	}
+
+	if len(config.ContentTypes) != 0 {
+		built.ContentTypes = config.ContentTypes
+	}
+
+	return built
+}
+
+// negotiateEncoding returns the supported encoding with the highest q-value, preferring gzip.
+func negotiateEncoding(acceptEncoding string) string {
+	ranges := parseAccept(acceptEncoding)
+
+	var (
+		best        string
+		bestQuality float64
+	)
+
+	for _, encoding := range []string{"gzip", "deflate"} {
+		quality := 0.0
+		specific := false
+
+		for _, accepted := range ranges {
+			switch {
+			case accepted.mediaType == encoding:
+				quality = accepted.quality
+				specific = true
+			case accepted.mediaType == "*" && !specific:
+				quality = accepted.quality
+			}
+		}
+
+		if quality > bestQuality {
+			best = encoding
+			bestQuality = quality
+		}
+	}
+
+	return best
+}
+
+// etagEncoding returns the encoding Compress negotiated for the request, which it appends to
+// strong ETags, or an empty string if the response isn't compressed.
+func etagEncoding(req *http.Request) string {
+	encoding, _ := req.Context().Value(etagEncodingKey{}).(string)
+	return encoding
+}
+
+// trimETagEncoding removes the encoding Compress appends to strong ETags from the opaque tag.
+// Only the encoding negotiated for the request is removed, so ETags that naturally end with an
+// encoding's name aren't changed unless the response is compressed with it.
+func trimETagEncoding(opaque, encoding string) string {
+	if encoding == "" {
+		return opaque
+	}
+
+	trimmed, _ := strings.CutSuffix(opaque, "-"+encoding)
+
+	return trimmed
+}
+
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+}
@@ -0,0 +1,323 @@
+package luci
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompress(t *testing.T) {
+	t.Parallel()
+
+	body := strings.Repeat("luci ", 500)
+
+	t.Run("compresses responses with gzip", func(t *testing.T) {
+		t.Parallel()
+
+		handler := Compress(CompressConfig{})(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+			rw.Header().Set("Content-Type", "text/plain")
+			rw.Header().Set("Content-Length", "2500")
+			rw.WriteHeader(http.StatusCreated)
+
+			_, err := io.WriteString(rw, body)
+			assert.NoError(t, err)
+		}))
+
+		recorder, wrw := serveTestRequest(handler, testRequest(t.Context(), http.MethodGet, "/status", nil, http.Header{"Accept-Encoding": []string{"deflate;q=0.5, gzip"}}, nil))
+
+		assert.Equal(t, http.StatusCreated, recorder.Code)
+		assert.Equal(t, "gzip", recorder.Header().Get("Content-Encoding"))
+		assert.Equal(t, "Accept-Encoding", recorder.Header().Get("Vary"))
+		assert.Empty(t, recorder.Header().Get("Content-Length"))
+
+		reader, err := gzip.NewReader(recorder.Body)
+		assert.NoError(t, err)
+
+		data, err := io.ReadAll(reader)
+		assert.NoError(t, err)
+		assert.Equal(t, body, string(data))
+
+		stats := wrw.stats()
+		assert.Equal(t, "gzip", stats.encoding)
+		assert.Equal(t, int64(len(body)), stats.uncompressedLength)
+		assert.Less(t, stats.length, stats.uncompressedLength)
+	})
+
+	t.Run("appends the encoding to strong ETags", func(t *testing.T) {
+		t.Parallel()
+
+		for etag, expected := range map[string]string{`"abc"`: `"abc-gzip"`, `W/"abc"`: `W/"abc"`} {
+			handler := Compress(CompressConfig{})(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+				rw.Header().Set("Content-Type", "text/plain")
+				rw.Header().Set("ETag", etag)
+
+				_, err := io.WriteString(rw, body)
+				assert.NoError(t, err)
+			}))
+
+			recorder, _ := serveTestRequest(handler, testRequest(t.Context(), http.MethodGet, "/status", nil, http.Header{"Accept-Encoding": []string{"gzip"}}, nil))
+
+			assert.Equal(t, expected, recorder.Header().Get("ETag"), etag)
+		}
+	})
+
+	t.Run("matches preconditions against the ETags of compressed responses", func(t *testing.T) {
+		t.Parallel()
+
+		handler := Middlewares{Compress(CompressConfig{}), Conditional(ConditionalConfig{})}.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			if req.Method == http.MethodPost {
+				err := CheckPreconditions(req, `"abc"`, time.Time{})
+				if err != nil {
+					rw.WriteHeader(http.StatusPreconditionFailed)
+					return
+				}
+			}
+
+			rw.Header().Set("Content-Type", "text/plain")
+			SetETag(rw.Header(), "abc", false)
+
+			_, err := io.WriteString(rw, body)
+			assert.NoError(t, err)
+		})
+
+		serve := func(method string, header http.Header) *httptest.ResponseRecorder {
+			request := testRequest(t.Context(), method, "/user/abc", nil, header, nil)
+			request.Header.Set("Accept-Encoding", "gzip")
+
+			recorder, _ := serveTestRequest(handler, request)
+
+			return recorder
+		}
+
+		recorder := serve(http.MethodGet, http.Header{})
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, `"abc-gzip"`, recorder.Header().Get("ETag"))
+
+		etag := recorder.Header().Get("ETag")
+
+		recorder = serve(http.MethodGet, http.Header{"If-None-Match": []string{etag}})
+		assert.Equal(t, http.StatusNotModified, recorder.Code)
+
+		recorder = serve(http.MethodPost, http.Header{"If-Match": []string{etag}})
+		assert.Equal(t, http.StatusOK, recorder.Code)
+
+		recorder = serve(http.MethodPost, http.Header{"If-Match": []string{`"xyz-gzip"`}})
+		assert.Equal(t, http.StatusPreconditionFailed, recorder.Code)
+	})
+
+	t.Run("matches ETags that end with an encoding", func(t *testing.T) {
+		t.Parallel()
+
+		etag := `"build"`
+
+		handler := Middlewares{Compress(CompressConfig{}), Conditional(ConditionalConfig{})}.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+			rw.Header().Set("Content-Type", "text/plain")
+			rw.Header().Set("ETag", etag)
+
+			_, err := io.WriteString(rw, body)
+			assert.NoError(t, err)
+		})
+
+		serve := func(acceptEncoding, ifNoneMatch string) *httptest.ResponseRecorder {
+			recorder, _ := serveTestRequest(handler, testRequest(t.Context(), http.MethodGet, "/build", nil, http.Header{
+				"Accept-Encoding": []string{acceptEncoding},
+				"If-None-Match":   []string{ifNoneMatch},
+			}, nil))
+
+			return recorder
+		}
+
+		// The application's ETag "build-gzip" of an uncompressed response doesn't match "build".
+		recorder := serve("identity", `"build-gzip"`)
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, `"build"`, recorder.Header().Get("ETag"))
+
+		etag = `"build-gzip"`
+
+		recorder = serve("identity", `"build-gzip"`)
+		assert.Equal(t, http.StatusNotModified, recorder.Code)
+
+		recorder = serve("gzip", `"build-gzip-gzip"`)
+		assert.Equal(t, http.StatusNotModified, recorder.Code)
+
+		recorder = serve("gzip", `"build"`)
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, `"build-gzip-gzip"`, recorder.Header().Get("ETag"))
+	})
+
+	t.Run("compresses responses with deflate", func(t *testing.T) {
+		t.Parallel()
+
+		handler := Compress(CompressConfig{})(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+			rw.Header().Set("Content-Type", "application/json")
+
+			_, err := io.WriteString(rw, body)
+			assert.NoError(t, err)
+		}))
+
+		recorder, _ := serveTestRequest(handler, testRequest(t.Context(), http.MethodGet, "/status", nil, http.Header{"Accept-Encoding": []string{"gzip;q=0.5, deflate"}}, nil))
+
+		assert.Equal(t, "deflate", recorder.Header().Get("Content-Encoding"))
+
+		reader, err := zlib.NewReader(recorder.Body)
+		assert.NoError(t, err)
+
+		data, err := io.ReadAll(reader)
+		assert.NoError(t, err)
+		assert.Equal(t, body, string(data))
+	})
+
+	t.Run("doesn't compress small responses", func(t *testing.T) {
+		t.Parallel()
+
+		handler := Compress(CompressConfig{MinLength: 10})(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+			_, err := io.WriteString(rw, "luci")
+			assert.NoError(t, err)
+		}))
+
+		recorder, wrw := serveTestRequest(handler, testRequest(t.Context(), http.MethodGet, "/status", nil, http.Header{"Accept-Encoding": []string{"gzip"}}, nil))
+
+		assert.Empty(t, recorder.Header().Get("Content-Encoding"))
+		assert.Equal(t, "Accept-Encoding", recorder.Header().Get("Vary"))
+		assert.Equal(t, "text/plain; charset=utf-8", recorder.Header().Get("Content-Type"))
+		assert.Equal(t, "luci", recorder.Body.String())
+		assert.Empty(t, wrw.stats().encoding)
+	})
+
+	t.Run("doesn't compress responses that aren't allowed", func(t *testing.T) {
+		t.Parallel()
+
+		tests := []struct {
+			acceptEncoding string
+			header         http.Header
+			status         int
+		}{
+			{acceptEncoding: "", header: http.Header{"Content-Type": []string{"text/plain"}}},
+			{acceptEncoding: "br, gzip;q=0", header: http.Header{"Content-Type": []string{"text/plain"}}},
+			{acceptEncoding: "*;q=0", header: http.Header{"Content-Type": []string{"text/plain"}}},
+			{acceptEncoding: "gzip", header: http.Header{"Content-Type": []string{"image/png"}}},
+			{acceptEncoding: "gzip", header: http.Header{"Content-Type": []string{"text/plain"}, "Content-Encoding": []string{"br"}}},
+			{acceptEncoding: "gzip", header: http.Header{"Content-Type": []string{"text/plain"}}, status: http.StatusPartialContent},
+		}
+
+		for _, test := range tests {
+			handler := Compress(CompressConfig{})(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+				for key, values := range test.header {
+					rw.Header()[key] = values
+				}
+
+				if test.status != 0 {
+					rw.WriteHeader(test.status)
+				}
+
+				_, err := io.WriteString(rw, body)
+				assert.NoError(t, err)
+			}))
+
+			recorder, _ := serveTestRequest(handler, testRequest(t.Context(), http.MethodGet, "/status", nil, http.Header{"Accept-Encoding": []string{test.acceptEncoding}}, nil))
+
+			assert.Equal(t, test.header.Get("Content-Encoding"), recorder.Header().Get("Content-Encoding"), test.acceptEncoding)
+			assert.Equal(t, body, recorder.Body.String(), test.acceptEncoding)
+		}
+	})
+
+	t.Run("compresses any length once flushed", func(t *testing.T) {
+		t.Parallel()
+
+		handler := Compress(CompressConfig{})(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+			rw.Header().Set("Content-Type", "text/event-stream")
+
+			_, err := io.WriteString(rw, "data: luci\n\n")
+			assert.NoError(t, err)
+
+			rw.(http.Flusher).Flush()
+
+			assert.True(t, rw.(*compressWriter).decided)
+		}))
+
+		recorder, _ := serveTestRequest(handler, testRequest(t.Context(), http.MethodGet, "/status", nil, http.Header{"Accept-Encoding": []string{"*"}}, nil))
+
+		assert.True(t, recorder.Flushed)
+		assert.Equal(t, "gzip", recorder.Header().Get("Content-Encoding"))
+
+		reader, err := gzip.NewReader(recorder.Body)
+		assert.NoError(t, err)
+
+		data, err := io.ReadAll(reader)
+		assert.NoError(t, err)
+		assert.Equal(t, "data: luci\n\n", string(data))
+	})
+
+	t.Run("writes responses without bodies", func(t *testing.T) {
+		t.Parallel()
+
+		handler := Compress(CompressConfig{})(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+			rw.WriteHeader(http.StatusNoContent)
+		}))
+
+		recorder, _ := serveTestRequest(handler, testRequest(t.Context(), http.MethodGet, "/status", nil, http.Header{"Accept-Encoding": []string{"gzip"}}, nil))
+
+		assert.Equal(t, http.StatusNoContent, recorder.Code)
+		assert.Empty(t, recorder.Header().Get("Content-Encoding"))
+		assert.Empty(t, recorder.Body.String())
+	})
+
+	t.Run("doesn't write a response if nothing was written", func(t *testing.T) {
+		t.Parallel()
+
+		handler := Compress(CompressConfig{})(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {}))
+
+		_, wrw := serveTestRequest(handler, testRequest(t.Context(), http.MethodGet, "/status", nil, http.Header{"Accept-Encoding": []string{"gzip"}}, nil))
+
+		assert.False(t, wrw.stats().wroteHeader)
+	})
+
+	t.Run("unwraps to the wrapped ResponseWriter", func(t *testing.T) {
+		t.Parallel()
+
+		handler := Compress(CompressConfig{})(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+			assert.IsType(t, new(compressWriter), rw)
+			assert.NotNil(t, findResponseWriter(rw))
+		}))
+
+		serveTestRequest(handler, testRequest(t.Context(), http.MethodGet, "/status", nil, http.Header{"Accept-Encoding": []string{"gzip"}}, nil))
+	})
+
+	t.Run("panics with an invalid level", func(t *testing.T) {
+		t.Parallel()
+
+		assert.PanicsWithError(t, "luci: compress: gzip: invalid compression level: 20", func() {
+			Compress(CompressConfig{Level: 20})
+		})
+	})
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		acceptEncoding string
+		expected       string
+	}{
+		{acceptEncoding: "", expected: ""},
+		{acceptEncoding: "identity", expected: ""},
+		{acceptEncoding: "gzip, deflate", expected: "gzip"},
+		{acceptEncoding: "deflate, gzip", expected: "gzip"},
+		{acceptEncoding: "gzip;q=0.1, deflate", expected: "deflate"},
+		{acceptEncoding: "*", expected: "gzip"},
+		{acceptEncoding: "gzip;q=0, *", expected: "deflate"},
+		{acceptEncoding: "*, gzip;q=0", expected: "deflate"},
+		{acceptEncoding: "GZIP", expected: "gzip"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, negotiateEncoding(test.acceptEncoding), test.acceptEncoding)
+	}
+}
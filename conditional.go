@@ -0,0 +1,460 @@
+package luci
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ETagMode defines how the conditional middleware generates ETags.
+type ETagMode int
+
+// ETag modes used by the conditional middleware.
+const (
+	// NoETag doesn't generate ETags.
+	NoETag ETagMode = iota
+	// StrongETag generates strong ETags, for responses that are byte for byte identical.
+	StrongETag
+	// WeakETag generates weak ETags, for responses that are semantically equivalent.
+	WeakETag
+)
+
+var (
+	// DefaultConditionalConfig is the base configuration that's used when creating a conditional middleware.
+	DefaultConditionalConfig = ConditionalConfig{
+		ETag:            NoETag,
+		MaxBufferLength: 1 << 20,
+	}
+)
+
+type responseHeaderKey struct{}
+
+// ConditionalConfig defines how the conditional middleware handles responses.
+// See DefaultConditionalConfig for configuration defaults.
+type ConditionalConfig struct {
+	// ETag defines the kind of ETag to generate for responses that don't set one. Generating
+	// ETags requires buffering the response to hash it.
+	ETag ETagMode
+	// MaxBufferLength defines the maximum response length to buffer when generating ETags,
+	// longer responses are written without an ETag.
+	MaxBufferLength int
+}
+
+// Preconditions are the conditional headers of a request. Preconditions may be embedded in Handle
+// input values to be bound from the request.
+type Preconditions struct {
+	IfMatch           string `header:"If-Match"`
+	IfNoneMatch       string `header:"If-None-Match"`
+	IfModifiedSince   string `header:"If-Modified-Since"`
+	IfUnmodifiedSince string `header:"If-Unmodified-Since"`
+
+	// encoding is the encoding Compress appends to strong ETags of the response.
+	encoding string
+}
+
+type conditionalWriter struct {
+	rw          http.ResponseWriter
+	req         *http.Request
+	config      ConditionalConfig
+	buffer      bytes.Buffer
+	status      int
+	wroteHeader bool
+	buffering   bool
+	notModified bool
+}
+
+// SetETag sets the ETag header to the quoted value, as a weak ETag if weak is true.
+func SetETag(header http.Header, value string, weak bool) {
+	etag := `"` + value + `"`
+	if weak {
+		etag = "W/" + etag
+	}
+
+	header.Set("ETag", etag)
+}
+
+// SetLastModified sets the Last-Modified header, zero times are ignored.
+func SetLastModified(header http.Header, modified time.Time) {
+	if modified.IsZero() {
+		return
+	}
+
+	header.Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+}
+
+// ResponseHeader returns the response header for the context of a function called by Handle,
+// which allows functions to set headers such as the ETag before the output value is responded
+// with. If the context isn't from Handle an empty header is returned.
+func ResponseHeader(ctx context.Context) http.Header {
+	header, ok := ctx.Value(responseHeaderKey{}).(http.Header)
+	if !ok {
+		return make(http.Header)
+	}
+
+	return header
+}
+
+// RequestPreconditions returns the conditional headers of the request.
+func RequestPreconditions(req *http.Request) Preconditions {
+	return Preconditions{
+		IfMatch:           strings.Join(req.Header.Values("If-Match"), ","),
+		IfNoneMatch:       strings.Join(req.Header.Values("If-None-Match"), ","),
+		IfModifiedSince:   req.Header.Get("If-Modified-Since"),
+		IfUnmodifiedSince: req.Header.Get("If-Unmodified-Since"),
+		encoding:          etagEncoding(req),
+	}
+}
+
+// CheckPreconditions evaluates the request's If-Match, If-Unmodified-Since, and If-None-Match headers
+// against the current ETag and last modified time of the resource, see Preconditions.Check. For GET
+// and HEAD requests only If-Match and If-Unmodified-Since are evaluated, Not Modified responses are
+// handled by the conditional middleware.
+func CheckPreconditions(req *http.Request, etag string, lastModified time.Time) error {
+	preconditions := RequestPreconditions(req)
+
+	if req.Method == http.MethodGet || req.Method == http.MethodHead {
+		preconditions.IfNoneMatch = ""
+	}
+
+	return preconditions.Check(etag, lastModified)
+}
+
+// Check evaluates the preconditions against the current ETag and last modified time of the resource
+// for a request that changes it, following RFC 9110 section 13.2.2. Handlers should check preconditions
+// before making any changes, and an empty ETag or zero last modified time means the value is unknown.
+// If a precondition fails a 412 Precondition Failed *HTTPError is returned.
+func (preconditions Preconditions) Check(etag string, lastModified time.Time) error {
+	if preconditions.evaluate(false, etag, lastModified) == http.StatusPreconditionFailed {
+		return PreconditionFailed(ErrPreconditionFailed)
+	}
+
+	return nil
+}
+
+// evaluate returns the status to respond with if a precondition fails, or zero if the request should continue.
+func (preconditions Preconditions) evaluate(safe bool, etag string, lastModified time.Time) int {
+	lastModified = lastModified.Truncate(time.Second)
+
+	if preconditions.IfMatch != "" {
+		if !matchETag(preconditions.IfMatch, etag, preconditions.encoding, true) {
+			return http.StatusPreconditionFailed
+		}
+	} else if preconditions.IfUnmodifiedSince != "" && !lastModified.IsZero() {
+		since, err := http.ParseTime(preconditions.IfUnmodifiedSince)
+		if err == nil && lastModified.After(since) {
+			return http.StatusPreconditionFailed
+		}
+	}
+
+	if preconditions.IfNoneMatch != "" {
+		if !matchETag(preconditions.IfNoneMatch, etag, preconditions.encoding, false) {
+			return 0
+		}
+
+		if safe {
+			return http.StatusNotModified
+		}
+
+		return http.StatusPreconditionFailed
+	}
+
+	if safe && preconditions.IfModifiedSince != "" && !lastModified.IsZero() {
+		since, err := http.ParseTime(preconditions.IfModifiedSince)
+		if err == nil && !lastModified.After(since) {
+			return http.StatusNotModified
+		}
+	}
+
+	return 0
+}
+
+// Conditional is a middleware that handles conditional GET and HEAD requests. Once a successful response
+// is written its ETag and Last-Modified headers are evaluated against the request's preconditions, responding
+// with 304 Not Modified if the client's representation is current. Failed If-Match and If-Unmodified-Since
+// preconditions are responded to with the applications Error using a 412 Precondition Failed *HTTPError.
+//
+// If configured ETags are generated for responses that don't set one, in which case Conditional should come
+// after Compress so the ETag is generated from the uncompressed response.
+func Conditional(config ConditionalConfig) Middleware {
+	config = buildConditionalConfig(config)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			if req.Method != http.MethodGet && req.Method != http.MethodHead {
+				next.ServeHTTP(rw, req)
+				return
+			}
+
+			cw := &conditionalWriter{rw: rw, req: req, config: config}
+
+			next.ServeHTTP(cw, req)
+			cw.close()
+		})
+	}
+}
+
+func (cw *conditionalWriter) Header() http.Header {
+	return cw.rw.Header()
+}
+
+func (cw *conditionalWriter) WriteHeader(status int) {
+	if cw.wroteHeader {
+		return
+	}
+
+	if status >= 100 && status < 200 && status != http.StatusSwitchingProtocols {
+		cw.rw.WriteHeader(status)
+		return
+	}
+
+	cw.wroteHeader = true
+	cw.status = status
+
+	if status != http.StatusOK {
+		cw.rw.WriteHeader(status)
+		return
+	}
+
+	if cw.config.ETag != NoETag && cw.rw.Header().Get("ETag") == "" {
+		cw.buffering = true
+		return
+	}
+
+	cw.respond()
+}
+
+func (cw *conditionalWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+
+	if cw.notModified {
+		return len(b), nil
+	}
+
+	if cw.buffering {
+		cw.buffer.Write(b)
+		if cw.buffer.Len() <= cw.config.MaxBufferLength {
+			return len(b), nil
+		}
+
+		return len(b), cw.stopBuffering()
+	}
+
+	n, err := cw.rw.Write(b)
+	if err != nil {
+		return n, fmt.Errorf("luci: conditional: %w", err)
+	}
+
+	return n, nil
+}
+
+func (cw *conditionalWriter) Flush() {
+	_ = cw.FlushError()
+}
+
+func (cw *conditionalWriter) FlushError() error {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+
+	if cw.buffering {
+		err := cw.stopBuffering()
+		if err != nil {
+			return err
+		}
+	}
+
+	if cw.notModified {
+		return nil
+	}
+
+	err := http.NewResponseController(cw.rw).Flush()
+	if err != nil {
+		return fmt.Errorf("luci: conditional: %w", err)
+	}
+
+	return nil
+}
+
+func (cw *conditionalWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if cw.wroteHeader {
+		return nil, nil, fmt.Errorf("luci: conditional: hijack: %w", http.ErrHijacked)
+	}
+
+	conn, buf, err := http.NewResponseController(cw.rw).Hijack()
+	if err != nil {
+		return nil, nil, fmt.Errorf("luci: conditional: %w", err)
+	}
+
+	cw.wroteHeader = true
+
+	return conn, buf, nil
+}
+
+// Unwrap returns the wrapped ResponseWriter, see http.ResponseController.
+func (cw *conditionalWriter) Unwrap() http.ResponseWriter {
+	return cw.rw
+}
+
+// respond evaluates the request's preconditions and writes the response header.
+func (cw *conditionalWriter) respond() {
+	header := cw.rw.Header()
+
+	var lastModified time.Time
+	if value := header.Get("Last-Modified"); value != "" {
+		lastModified, _ = http.ParseTime(value)
+	}
+
+	switch RequestPreconditions(cw.req).evaluate(true, header.Get("ETag"), lastModified) {
+	case http.StatusNotModified:
+		cw.notModified = true
+
+		for _, key := range []string{"Content-Type", "Content-Length", "Content-Encoding", "Transfer-Encoding"} {
+			header.Del(key)
+		}
+
+		cw.rw.WriteHeader(http.StatusNotModified)
+	case http.StatusPreconditionFailed:
+		cw.notModified = true
+
+		errorRespond(requestErrorHandler(cw.req), http.StatusPreconditionFailed, ErrPreconditionFailed)(cw.rw, cw.req)
+	default:
+		cw.rw.WriteHeader(cw.status)
+	}
+}
+
+// stopBuffering responds without generating an ETag.
+func (cw *conditionalWriter) stopBuffering() error {
+	cw.buffering = false
+	cw.respond()
+
+	return cw.writeBuffer()
+}
+
+func (cw *conditionalWriter) writeBuffer() error {
+	if cw.notModified || cw.buffer.Len() == 0 {
+		return nil
+	}
+
+	_, err := cw.rw.Write(cw.buffer.Bytes())
+	cw.buffer.Reset()
+
+	if err != nil {
+		return fmt.Errorf("luci: conditional: %w", err)
+	}
+
+	return nil
+}
+
+func (cw *conditionalWriter) close() {
+	if !cw.buffering {
+		return
+	}
+
+	cw.buffering = false
+
+	sum := sha256.Sum256(cw.buffer.Bytes())
+	SetETag(cw.rw.Header(), base64.RawURLEncoding.EncodeToString(sum[:16]), cw.config.ETag == WeakETag)
+
+	cw.respond()
+
+	err := cw.writeBuffer()
+	if err != nil {
+		Logger(cw.req).With(slog.Any("error", err)).Error("failed to write conditional response")
+	}
+}
+
+func buildConditionalConfig(config ConditionalConfig) ConditionalConfig {
+	built := DefaultConditionalConfig
+
+	if config.ETag != NoETag {
+		built.ETag = config.ETag
+	}
+
+	if config.MaxBufferLength != 0 {
+		built.MaxBufferLength = config.MaxBufferLength
+	}
+
+	return built
+}
+
+// requestErrorHandler returns the error handler of the request's application, or http.Error
+// if the request isn't from a server.
+func requestErrorHandler(req *http.Request) ErrorHandlerFunc {
+	app := requestApplication(req)
+	if app == nil {
+		return func(rw http.ResponseWriter, _ *http.Request, status int, err error) {
+			http.Error(rw, err.Error(), status)
+		}
+	}
+
+	return app.Error
+}
+
+// matchETag reports whether the etag matches any of the ETags in the list, using strong
+// or weak comparison as defined by RFC 9110 section 8.8.3.2. Candidates may be the ETag of
+// a response compressed with the encoding, which is removed before they're compared.
+func matchETag(list, etag, encoding string, strong bool) bool {
+	if etag == "" {
+		return false
+	}
+
+	if strings.TrimSpace(list) == "*" {
+		return true
+	}
+
+	weak, opaque, ok := parseETag(etag)
+	if !ok || (strong && weak) {
+		return false
+	}
+
+	for list != "" {
+		list = strings.TrimLeft(list, " \t,")
+
+		candidateWeak, candidateOpaque, rest, ok := scanETag(list)
+		if !ok {
+			return false
+		}
+
+		list = rest
+
+		if (candidateOpaque == opaque || trimETagEncoding(candidateOpaque, encoding) == opaque) && (!strong || !candidateWeak) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func parseETag(etag string) (bool, string, bool) {
+	weak, opaque, rest, ok := scanETag(strings.TrimSpace(etag))
+	return weak, opaque, ok && rest == ""
+}
+
+// scanETag scans a single ETag from the start of the value.
+func scanETag(value string) (bool, string, string, bool) {
+	weak := strings.HasPrefix(value, "W/")
+	if weak {
+		value = value[2:]
+	}
+
+	if !strings.HasPrefix(value, `"`) {
+		return false, "", "", false
+	}
+
+	end := strings.IndexByte(value[1:], '"')
+	if end == -1 {
+		return false, "", "", false
+	}
+
+	return weak, value[1 : end+1], value[end+2:], true
+}
@@ -0,0 +1,346 @@
+package luci
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestSetETag(t *testing.T) {
+	t.Parallel()
+
+	header := make(http.Header)
+
+	SetETag(header, "abc", false)
+	assert.Equal(t, `"abc"`, header.Get("ETag"))
+
+	SetETag(header, "abc", true)
+	assert.Equal(t, `W/"abc"`, header.Get("ETag"))
+}
+
+func TestSetLastModified(t *testing.T) {
+	t.Parallel()
+
+	header := make(http.Header)
+
+	SetLastModified(header, time.Time{})
+	assert.Empty(t, header.Get("Last-Modified"))
+
+	SetLastModified(header, time.Date(2024, 1, 2, 3, 4, 5, 0, time.FixedZone("", 3600)))
+	assert.Equal(t, "Tue, 02 Jan 2024 02:04:05 GMT", header.Get("Last-Modified"))
+}
+
+func TestResponseHeader(t *testing.T) {
+	t.Parallel()
+
+	header := http.Header{"Etag": []string{`"abc"`}}
+	ctx := context.WithValue(t.Context(), responseHeaderKey{}, header)
+
+	assert.Equal(t, header, ResponseHeader(ctx))
+	assert.Empty(t, ResponseHeader(t.Context()))
+}
+
+func TestCheckPreconditions(t *testing.T) {
+	t.Parallel()
+
+	request := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/user/abc", nil)
+	request.Header.Set("If-None-Match", `"abc"`)
+
+	assert.NoError(t, CheckPreconditions(request, `"abc"`, time.Time{}))
+
+	request.Method = http.MethodPut
+
+	err := CheckPreconditions(request, `"abc"`, time.Time{})
+	assert.ErrorIs(t, err, ErrPreconditionFailed)
+	assert.Equal(t, http.StatusPreconditionFailed, AsHTTPError(err, http.StatusInternalServerError).Status)
+}
+
+func TestPreconditionsEvaluate(t *testing.T) {
+	t.Parallel()
+
+	modified := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	before := modified.Add(-time.Hour).Format(http.TimeFormat)
+	after := modified.Add(time.Hour).Format(http.TimeFormat)
+
+	tests := []struct {
+		preconditions Preconditions
+		safe          bool
+		etag          string
+		expected      int
+	}{
+		{preconditions: Preconditions{}, safe: true, etag: `"abc"`, expected: 0},
+		{preconditions: Preconditions{IfMatch: `"abc"`}, etag: `"abc"`, expected: 0},
+		{preconditions: Preconditions{IfMatch: `"xyz", "abc"`}, etag: `"abc"`, expected: 0},
+		{preconditions: Preconditions{IfMatch: `*`}, etag: `"abc"`, expected: 0},
+		{preconditions: Preconditions{IfMatch: `*`}, etag: "", expected: http.StatusPreconditionFailed},
+		{preconditions: Preconditions{IfMatch: `"xyz"`}, etag: `"abc"`, expected: http.StatusPreconditionFailed},
+		{preconditions: Preconditions{IfMatch: `W/"abc"`}, etag: `"abc"`, expected: http.StatusPreconditionFailed},
+		{preconditions: Preconditions{IfMatch: `"abc"`}, etag: `W/"abc"`, expected: http.StatusPreconditionFailed},
+		{preconditions: Preconditions{IfUnmodifiedSince: after}, etag: `"abc"`, expected: 0},
+		{preconditions: Preconditions{IfUnmodifiedSince: before}, etag: `"abc"`, expected: http.StatusPreconditionFailed},
+		{preconditions: Preconditions{IfMatch: `"abc"`, IfUnmodifiedSince: before}, etag: `"abc"`, expected: 0},
+		{preconditions: Preconditions{IfNoneMatch: `"abc"`}, safe: true, etag: `"abc"`, expected: http.StatusNotModified},
+		{preconditions: Preconditions{IfNoneMatch: `W/"abc"`}, safe: true, etag: `"abc"`, expected: http.StatusNotModified},
+		{preconditions: Preconditions{IfNoneMatch: `"abc"`}, etag: `"abc"`, expected: http.StatusPreconditionFailed},
+		{preconditions: Preconditions{IfNoneMatch: `*`}, etag: `"abc"`, expected: http.StatusPreconditionFailed},
+		{preconditions: Preconditions{IfNoneMatch: `*`}, etag: "", expected: 0},
+		{preconditions: Preconditions{IfNoneMatch: `"xyz"`}, safe: true, etag: `"abc"`, expected: 0},
+		{preconditions: Preconditions{IfNoneMatch: `"xyz"`, IfModifiedSince: after}, safe: true, etag: `"abc"`, expected: 0},
+		{preconditions: Preconditions{IfModifiedSince: after}, safe: true, etag: `"abc"`, expected: http.StatusNotModified},
+		{preconditions: Preconditions{IfModifiedSince: before}, safe: true, etag: `"abc"`, expected: 0},
+		{preconditions: Preconditions{IfModifiedSince: after}, etag: `"abc"`, expected: 0},
+		{preconditions: Preconditions{IfModifiedSince: "invalid"}, safe: true, etag: `"abc"`, expected: 0},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, test.preconditions.evaluate(test.safe, test.etag, modified), "%+v", test.preconditions)
+	}
+}
+
+func TestConditional(t *testing.T) {
+	t.Parallel()
+
+	t.Run("responds with not modified if the ETag matches", func(t *testing.T) {
+		t.Parallel()
+
+		header := http.Header{"If-None-Match": []string{`"xyz", "abc"`}}
+
+		handler := Conditional(ConditionalConfig{})(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+			SetETag(rw.Header(), "abc", false)
+			rw.Header().Set("Content-Type", "application/json")
+
+			_, err := io.WriteString(rw, `{"key":"abc"}`)
+			assert.NoError(t, err)
+		}))
+
+		recorder, _ := serveTestRequest(handler, testRequest(t.Context(), http.MethodGet, "/user/abc", nil, header, nil))
+
+		assert.Equal(t, http.StatusNotModified, recorder.Code)
+		assert.Equal(t, `"abc"`, recorder.Header().Get("ETag"))
+		assert.Empty(t, recorder.Header().Get("Content-Type"))
+		assert.Empty(t, recorder.Body.String())
+	})
+
+	t.Run("responds with not modified if unmodified since", func(t *testing.T) {
+		t.Parallel()
+
+		modified := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+		header := http.Header{"If-Modified-Since": []string{modified.Format(http.TimeFormat)}}
+
+		handler := Conditional(ConditionalConfig{})(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+			SetLastModified(rw.Header(), modified.Add(time.Millisecond))
+
+			_, err := io.WriteString(rw, "luci")
+			assert.NoError(t, err)
+		}))
+
+		recorder, _ := serveTestRequest(handler, testRequest(t.Context(), http.MethodGet, "/user/abc", nil, header, nil))
+
+		assert.Equal(t, http.StatusNotModified, recorder.Code)
+		assert.Empty(t, recorder.Body.String())
+	})
+
+	t.Run("responds if modified", func(t *testing.T) {
+		t.Parallel()
+
+		header := http.Header{"If-None-Match": []string{`"xyz"`}}
+
+		handler := Conditional(ConditionalConfig{})(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+			SetETag(rw.Header(), "abc", false)
+
+			_, err := io.WriteString(rw, "luci")
+			assert.NoError(t, err)
+		}))
+
+		recorder, _ := serveTestRequest(handler, testRequest(t.Context(), http.MethodGet, "/user/abc", nil, header, nil))
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "luci", recorder.Body.String())
+	})
+
+	t.Run("generates ETags", func(t *testing.T) {
+		t.Parallel()
+
+		handler := http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+			_, err := io.WriteString(rw, "luci")
+			assert.NoError(t, err)
+		})
+
+		strong, _ := serveTestRequest(Conditional(ConditionalConfig{ETag: StrongETag})(handler), testRequest(t.Context(), http.MethodGet, "/user/abc", nil, nil, nil))
+		assert.Equal(t, http.StatusOK, strong.Code)
+		assert.Equal(t, `"L9y8hhXCdf--SRBs-F-6sQ"`, strong.Header().Get("ETag"))
+		assert.Equal(t, "luci", strong.Body.String())
+
+		weak, _ := serveTestRequest(Conditional(ConditionalConfig{ETag: WeakETag})(handler), testRequest(t.Context(), http.MethodGet, "/user/abc", nil, nil, nil))
+		assert.Equal(t, "W/"+strong.Header().Get("ETag"), weak.Header().Get("ETag"))
+
+		header := http.Header{"If-None-Match": []string{strong.Header().Get("ETag")}}
+		notModified, _ := serveTestRequest(Conditional(ConditionalConfig{ETag: StrongETag})(handler), testRequest(t.Context(), http.MethodGet, "/user/abc", nil, header, nil))
+		assert.Equal(t, http.StatusNotModified, notModified.Code)
+		assert.Empty(t, notModified.Body.String())
+	})
+
+	t.Run("doesn't generate ETags for long or unsuccessful responses", func(t *testing.T) {
+		t.Parallel()
+
+		handler := Conditional(ConditionalConfig{ETag: StrongETag, MaxBufferLength: 4})(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+			_, err := io.WriteString(rw, "luci")
+			assert.NoError(t, err)
+
+			_, err = io.WriteString(rw, " luci")
+			assert.NoError(t, err)
+		}))
+
+		long, _ := serveTestRequest(handler, testRequest(t.Context(), http.MethodGet, "/user/abc", nil, nil, nil))
+
+		assert.Empty(t, long.Header().Get("ETag"))
+		assert.Equal(t, "luci luci", long.Body.String())
+
+		handler = Conditional(ConditionalConfig{ETag: StrongETag})(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+			rw.WriteHeader(http.StatusCreated)
+		}))
+
+		created, _ := serveTestRequest(handler, testRequest(t.Context(), http.MethodGet, "/user/abc", nil, nil, nil))
+
+		assert.Equal(t, http.StatusCreated, created.Code)
+		assert.Empty(t, created.Header().Get("ETag"))
+	})
+
+	t.Run("doesn't generate ETags once flushed", func(t *testing.T) {
+		t.Parallel()
+
+		handler := Conditional(ConditionalConfig{ETag: StrongETag})(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+			_, err := io.WriteString(rw, "luci")
+			assert.NoError(t, err)
+
+			rw.(http.Flusher).Flush()
+		}))
+
+		recorder, _ := serveTestRequest(handler, testRequest(t.Context(), http.MethodGet, "/user/abc", nil, nil, nil))
+
+		assert.True(t, recorder.Flushed)
+		assert.Empty(t, recorder.Header().Get("ETag"))
+		assert.Equal(t, "luci", recorder.Body.String())
+	})
+
+	t.Run("responds with the applications error if a precondition fails", func(t *testing.T) {
+		t.Parallel()
+
+		var app TestApplication
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/user/abc", nil)
+		request.Header.Set("If-Match", `"xyz"`)
+		request = withState(request, &requestState{app: &app})
+
+		app.On("Error", recorder, request, http.StatusPreconditionFailed, mock.Anything).Run(func(args mock.Arguments) {
+			err, _ := args.Get(3).(error)
+			assert.ErrorIs(t, err, ErrPreconditionFailed)
+		})
+
+		Conditional(ConditionalConfig{})(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+			SetETag(rw.Header(), "abc", false)
+
+			_, err := io.WriteString(rw, "luci")
+			assert.NoError(t, err)
+		})).ServeHTTP(recorder, request)
+
+		app.AssertExpectations(t)
+		assert.Empty(t, recorder.Body.String())
+	})
+
+	t.Run("responds with an error if a precondition fails without an application", func(t *testing.T) {
+		t.Parallel()
+
+		header := http.Header{"If-Unmodified-Since": []string{time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).Format(http.TimeFormat)}}
+
+		handler := Conditional(ConditionalConfig{})(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+			SetLastModified(rw.Header(), time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC))
+
+			_, err := io.WriteString(rw, "luci")
+			assert.NoError(t, err)
+		}))
+
+		recorder, _ := serveTestRequest(handler, testRequest(t.Context(), http.MethodGet, "/user/abc", nil, header, nil))
+
+		assert.Equal(t, http.StatusPreconditionFailed, recorder.Code)
+		assert.True(t, strings.HasPrefix(recorder.Body.String(), ErrPreconditionFailed.Error()))
+	})
+
+	t.Run("ignores requests that aren't GET or HEAD", func(t *testing.T) {
+		t.Parallel()
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequestWithContext(t.Context(), http.MethodPut, "/user/abc", nil)
+		request.Header.Set("If-None-Match", "*")
+
+		Conditional(ConditionalConfig{ETag: StrongETag})(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+			assert.IsType(t, new(httptest.ResponseRecorder), rw)
+			SetETag(rw.Header(), "abc", false)
+		})).ServeHTTP(recorder, request)
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+	})
+
+	t.Run("unwraps to the wrapped ResponseWriter", func(t *testing.T) {
+		t.Parallel()
+
+		handler := Conditional(ConditionalConfig{})(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+			assert.IsType(t, new(conditionalWriter), rw)
+			assert.Equal(t, rw.(*conditionalWriter).rw, rw.(interface{ Unwrap() http.ResponseWriter }).Unwrap())
+		}))
+
+		serveTestRequest(handler, testRequest(t.Context(), http.MethodGet, "/user/abc", nil, nil, nil))
+	})
+}
+
+func TestMatchETag(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		list     string
+		etag     string
+		encoding string
+		strong   bool
+		expected bool
+	}{
+		{list: `"abc"`, etag: `"abc"`, strong: true, expected: true},
+		{list: `"xyz", "abc"`, etag: `"abc"`, strong: true, expected: true},
+		{list: `"xyz","abc"`, etag: `"abc"`, strong: true, expected: true},
+		{list: `W/"abc"`, etag: `"abc"`, strong: true, expected: false},
+		{list: `W/"abc"`, etag: `"abc"`, strong: false, expected: true},
+		{list: `"abc"`, etag: `W/"abc"`, strong: false, expected: true},
+		{list: `"abc"`, etag: `W/"abc"`, strong: true, expected: false},
+		{list: `"xyz"`, etag: `"abc"`, strong: false, expected: false},
+		{list: `"abc-gzip"`, etag: `"abc"`, encoding: "gzip", strong: true, expected: true},
+		{list: `"abc-deflate"`, etag: `"abc"`, encoding: "deflate", strong: true, expected: true},
+		{list: `"abc-gzip"`, etag: `"abc-gzip"`, encoding: "gzip", strong: true, expected: true},
+		{list: `"abc-gzip-gzip"`, etag: `"abc-gzip"`, encoding: "gzip", strong: true, expected: true},
+		{list: `"abc-deflate"`, etag: `"abc"`, encoding: "gzip", strong: true, expected: false},
+		{list: `"abc-gzip"`, etag: `"abc"`, strong: true, expected: false},
+		{list: `"abc-br"`, etag: `"abc"`, encoding: "gzip", strong: true, expected: false},
+		{list: `*`, etag: `"abc"`, strong: true, expected: true},
+		{list: `*`, etag: "", strong: true, expected: false},
+		{list: `abc`, etag: `"abc"`, strong: false, expected: false},
+		{list: `"abc"`, etag: `abc`, strong: false, expected: false},
+		{list: `"abc`, etag: `"abc"`, strong: false, expected: false},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, matchETag(test.list, test.etag, test.encoding, test.strong), "%s %s %s", test.list, test.etag, test.encoding)
+	}
+}
+
+func TestBuildConditionalConfig(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, DefaultConditionalConfig, buildConditionalConfig(ConditionalConfig{}))
+	assert.Equal(t, ConditionalConfig{ETag: WeakETag, MaxBufferLength: 10}, buildConditionalConfig(ConditionalConfig{ETag: WeakETag, MaxBufferLength: 10}))
+}
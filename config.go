@@ -2,6 +2,7 @@ package luci
 
 import (
 	"log/slog"
+	"maps"
 	"time"
 )
 
@@ -13,6 +14,9 @@ var (
 		ReadHeaderTimeout: time.Second,
 		ShutdownTimeout:   5 * time.Second,
 		Logger:            slog.Default(),
+		Codecs:            DefaultCodecs,
+		VarConverters:     DefaultVarConverters,
+		RequestID:         DefaultRequestIDConfig,
 	}
 )
 
@@ -31,6 +35,41 @@ type Config struct {
 	ShutdownTimeout time.Duration
 	// Logger defines the logger the server uses when logging startup/shutdown/requests.
 	Logger *slog.Logger
+	// Codecs defines the codecs used to encode responses and decode request bodies,
+	// in order of preference for content negotiation. Requests to routes with an Accept header
+	// that doesn't match any of the codecs are responded to with 406 Not Acceptable, and requests
+	// with a body whose Content-Type doesn't match are responded to with 415 Unsupported Media Type.
+	Codecs Codecs
+	// CORS may be optionally used to allow cross-origin requests to all routes, routes
+	// may override it using Route.CORS. If not set cross-origin requests aren't allowed.
+	CORS *CORSConfig
+	// AutoOptions defines whether OPTIONS requests to patterns without a route matching OPTIONS
+	// are responded to with 204 No Content and the Allow header, rather than 405 Method Not Allowed.
+	AutoOptions bool
+	// AutoHead defines whether HEAD requests to patterns without a route matching HEAD are
+	// handled by the GET route for the pattern.
+	AutoHead bool
+	// PathPolicy defines how requests to non-canonical paths, such as paths with a trailing slash, are
+	// handled. Routes may override it using Route.PathPolicy. If not set non-canonical paths aren't matched.
+	PathPolicy PathPolicy
+	// BaseURL may be optionally used to define the public URL of the server, e.g. https://api.example.com,
+	// which is used to build absolute URLs for routes. If not set absolute URLs use the request's scheme and host.
+	BaseURL string
+	// VarConverters defines the converters that route patterns may use by name to match and
+	// convert variables, e.g. {id:int}. Converters are added to DefaultVarConverters, replacing
+	// any default converter with the same name.
+	VarConverters map[string]VarConverter
+	// RequestID defines how request IDs are generated, and when request IDs supplied by clients are used.
+	RequestID RequestIDConfig
+	// TrustedProxies may be optionally used to define the IP addresses or CIDRs of the proxies whose
+	// request headers are trusted, e.g. 10.0.0.0/8. Requests made by trusted proxies use the Forwarded,
+	// X-Forwarded-For, X-Forwarded-Proto, X-Forwarded-Host, and X-Real-IP headers to resolve the client's
+	// IP address, and the scheme and host the client used. If not set no proxies are trusted.
+	TrustedProxies []string
+	// ProxyProtocol may be optionally used to read the client's address from PROXY protocol headers sent by
+	// load balancers, so requests RemoteAddr and ClientIP are the client's. If not set PROXY protocol headers
+	// aren't read.
+	ProxyProtocol *ProxyProtocolConfig
 }
 
 func buildConfig(config Config) Config {
@@ -56,5 +95,55 @@ func buildConfig(config Config) Config {
 		built.Logger = config.Logger
 	}
 
+	if len(config.Codecs) != 0 {
+		built.Codecs = config.Codecs
+	}
+
+	if config.CORS != nil {
+		built.CORS = config.CORS
+	}
+
+	if config.AutoOptions {
+		built.AutoOptions = true
+	}
+
+	if config.AutoHead {
+		built.AutoHead = true
+	}
+
+	if config.PathPolicy != (PathPolicy{}) {
+		built.PathPolicy = config.PathPolicy
+	}
+
+	if config.BaseURL != "" {
+		built.BaseURL = config.BaseURL
+	}
+
+	if len(config.VarConverters) != 0 {
+		built.VarConverters = config.VarConverters
+
+		// Converters that already include every default converter are used as is,
+		// so building an already built config keeps the same converters.
+		for name := range DefaultVarConverters {
+			if _, ok := config.VarConverters[name]; !ok {
+				built.VarConverters = maps.Clone(DefaultVarConverters)
+				maps.Copy(built.VarConverters, config.VarConverters)
+
+				break
+			}
+		}
+	}
+
+	built.RequestID = buildRequestIDConfig(config.RequestID)
+
+	if len(config.TrustedProxies) != 0 {
+		built.TrustedProxies = config.TrustedProxies
+	}
+
+	if config.ProxyProtocol != nil {
+		proxyProtocol := buildProxyProtocolConfig(*config.ProxyProtocol)
+		built.ProxyProtocol = &proxyProtocol
+	}
+
 	return built
 }
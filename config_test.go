@@ -16,6 +16,9 @@ func TestDefaultConfig(t *testing.T) {
 		ReadHeaderTimeout: time.Second,
 		ShutdownTimeout:   5 * time.Second,
 		Logger:            DefaultConfig.Logger,
+		Codecs:            DefaultCodecs,
+		VarConverters:     DefaultVarConverters,
+		RequestID:         DefaultRequestIDConfig,
 	}, DefaultConfig)
 	assert.NotNil(t, DefaultConfig.Logger)
 }
@@ -31,6 +34,21 @@ func TestBuildConfig(t *testing.T) {
 		assert.Equal(t, DefaultConfig, config)
 	})
 
+	t.Run("adds var converters to the default var converters", func(t *testing.T) {
+		t.Parallel()
+
+		slug := VarConverter{Pattern: "[a-z-]+"}
+		integer := VarConverter{Pattern: "[0-9]+"}
+
+		config := buildConfig(Config{VarConverters: map[string]VarConverter{"slug": slug, "int": integer}})
+
+		assert.Len(t, config.VarConverters, len(DefaultVarConverters)+1)
+		assert.Equal(t, slug.Pattern, config.VarConverters["slug"].Pattern)
+		assert.Equal(t, integer.Pattern, config.VarConverters["int"].Pattern)
+		assert.Equal(t, DefaultVarConverters["uuid"].Pattern, config.VarConverters["uuid"].Pattern)
+		assert.Equal(t, "-?[0-9]+", DefaultVarConverters["int"].Pattern)
+	})
+
 	t.Run("nonzero config fields override default config", func(t *testing.T) {
 		t.Parallel()
 
@@ -41,6 +59,9 @@ func TestBuildConfig(t *testing.T) {
 			ReadHeaderTimeout: DefaultConfig.ReadHeaderTimeout,
 			ShutdownTimeout:   DefaultConfig.ShutdownTimeout,
 			Logger:            DefaultConfig.Logger,
+			Codecs:            DefaultConfig.Codecs,
+			VarConverters:     DefaultConfig.VarConverters,
+			RequestID:         DefaultConfig.RequestID,
 		}, config)
 
 		config = buildConfig(Config{RouteTimeout: time.Hour})
@@ -50,6 +71,9 @@ func TestBuildConfig(t *testing.T) {
 			ReadHeaderTimeout: DefaultConfig.ReadHeaderTimeout,
 			ShutdownTimeout:   DefaultConfig.ShutdownTimeout,
 			Logger:            DefaultConfig.Logger,
+			Codecs:            DefaultConfig.Codecs,
+			VarConverters:     DefaultConfig.VarConverters,
+			RequestID:         DefaultConfig.RequestID,
 		}, config)
 
 		config = buildConfig(Config{ReadHeaderTimeout: time.Hour})
@@ -59,6 +83,9 @@ func TestBuildConfig(t *testing.T) {
 			ReadHeaderTimeout: time.Hour,
 			ShutdownTimeout:   DefaultConfig.ShutdownTimeout,
 			Logger:            DefaultConfig.Logger,
+			Codecs:            DefaultConfig.Codecs,
+			VarConverters:     DefaultConfig.VarConverters,
+			RequestID:         DefaultConfig.RequestID,
 		}, config)
 
 		config = buildConfig(Config{ShutdownTimeout: time.Hour})
@@ -68,6 +95,9 @@ func TestBuildConfig(t *testing.T) {
 			ReadHeaderTimeout: DefaultConfig.ReadHeaderTimeout,
 			ShutdownTimeout:   time.Hour,
 			Logger:            DefaultConfig.Logger,
+			Codecs:            DefaultConfig.Codecs,
+			VarConverters:     DefaultConfig.VarConverters,
+			RequestID:         DefaultConfig.RequestID,
 		}, config)
 
 		config = buildConfig(Config{Logger: noopLogger})
@@ -77,6 +107,113 @@ func TestBuildConfig(t *testing.T) {
 			ReadHeaderTimeout: DefaultConfig.ReadHeaderTimeout,
 			ShutdownTimeout:   DefaultConfig.ShutdownTimeout,
 			Logger:            noopLogger,
+			Codecs:            DefaultConfig.Codecs,
+			VarConverters:     DefaultConfig.VarConverters,
+			RequestID:         DefaultConfig.RequestID,
+		}, config)
+
+		config = buildConfig(Config{Codecs: Codecs{JSONCodec{}}})
+		assert.Equal(t, Config{
+			Address:           DefaultConfig.Address,
+			RouteTimeout:      DefaultConfig.RouteTimeout,
+			ReadHeaderTimeout: DefaultConfig.ReadHeaderTimeout,
+			ShutdownTimeout:   DefaultConfig.ShutdownTimeout,
+			Logger:            DefaultConfig.Logger,
+			Codecs:            Codecs{JSONCodec{}},
+			VarConverters:     DefaultConfig.VarConverters,
+			RequestID:         DefaultConfig.RequestID,
+		}, config)
+
+		cors := &CORSConfig{AllowedOrigins: []string{"*"}}
+		config = buildConfig(Config{CORS: cors})
+		assert.Equal(t, Config{
+			Address:           DefaultConfig.Address,
+			RouteTimeout:      DefaultConfig.RouteTimeout,
+			ReadHeaderTimeout: DefaultConfig.ReadHeaderTimeout,
+			ShutdownTimeout:   DefaultConfig.ShutdownTimeout,
+			Logger:            DefaultConfig.Logger,
+			Codecs:            DefaultConfig.Codecs,
+			VarConverters:     DefaultConfig.VarConverters,
+			RequestID:         DefaultConfig.RequestID,
+			CORS:              cors,
+		}, config)
+
+		config = buildConfig(Config{AutoOptions: true, AutoHead: true})
+		assert.Equal(t, Config{
+			Address:           DefaultConfig.Address,
+			RouteTimeout:      DefaultConfig.RouteTimeout,
+			ReadHeaderTimeout: DefaultConfig.ReadHeaderTimeout,
+			ShutdownTimeout:   DefaultConfig.ShutdownTimeout,
+			Logger:            DefaultConfig.Logger,
+			Codecs:            DefaultConfig.Codecs,
+			VarConverters:     DefaultConfig.VarConverters,
+			RequestID:         DefaultConfig.RequestID,
+			AutoOptions:       true,
+			AutoHead:          true,
+		}, config)
+
+		config = buildConfig(Config{PathPolicy: PathPolicy{Mode: PathRedirect}})
+		assert.Equal(t, Config{
+			Address:           DefaultConfig.Address,
+			RouteTimeout:      DefaultConfig.RouteTimeout,
+			ReadHeaderTimeout: DefaultConfig.ReadHeaderTimeout,
+			ShutdownTimeout:   DefaultConfig.ShutdownTimeout,
+			Logger:            DefaultConfig.Logger,
+			Codecs:            DefaultConfig.Codecs,
+			VarConverters:     DefaultConfig.VarConverters,
+			RequestID:         DefaultConfig.RequestID,
+			PathPolicy:        PathPolicy{Mode: PathRedirect},
+		}, config)
+
+		config = buildConfig(Config{BaseURL: "https://api.luci.dev"})
+		assert.Equal(t, Config{
+			Address:           DefaultConfig.Address,
+			RouteTimeout:      DefaultConfig.RouteTimeout,
+			ReadHeaderTimeout: DefaultConfig.ReadHeaderTimeout,
+			ShutdownTimeout:   DefaultConfig.ShutdownTimeout,
+			Logger:            DefaultConfig.Logger,
+			Codecs:            DefaultConfig.Codecs,
+			VarConverters:     DefaultConfig.VarConverters,
+			RequestID:         DefaultConfig.RequestID,
+			BaseURL:           "https://api.luci.dev",
+		}, config)
+
+		config = buildConfig(Config{
+			RequestID:      RequestIDConfig{Generator: UUIDv7Generator{}, Trust: IDTrustProxies},
+			TrustedProxies: []string{"10.0.0.0/8"},
+		})
+		assert.Equal(t, Config{
+			Address:           DefaultConfig.Address,
+			RouteTimeout:      DefaultConfig.RouteTimeout,
+			ReadHeaderTimeout: DefaultConfig.ReadHeaderTimeout,
+			ShutdownTimeout:   DefaultConfig.ShutdownTimeout,
+			Logger:            DefaultConfig.Logger,
+			Codecs:            DefaultConfig.Codecs,
+			VarConverters:     DefaultConfig.VarConverters,
+			RequestID: RequestIDConfig{
+				Generator: UUIDv7Generator{},
+				Trust:     IDTrustProxies,
+				Headers:   DefaultRequestIDConfig.Headers,
+				MaxLength: DefaultRequestIDConfig.MaxLength,
+				Charset:   DefaultRequestIDConfig.Charset,
+			},
+			TrustedProxies: []string{"10.0.0.0/8"},
+		}, config)
+
+		config = buildConfig(Config{ProxyProtocol: &ProxyProtocolConfig{TrustedSources: []string{"10.0.0.0/8"}}})
+		assert.Equal(t, Config{
+			Address:           DefaultConfig.Address,
+			RouteTimeout:      DefaultConfig.RouteTimeout,
+			ReadHeaderTimeout: DefaultConfig.ReadHeaderTimeout,
+			ShutdownTimeout:   DefaultConfig.ShutdownTimeout,
+			Logger:            DefaultConfig.Logger,
+			Codecs:            DefaultConfig.Codecs,
+			VarConverters:     DefaultConfig.VarConverters,
+			RequestID:         DefaultConfig.RequestID,
+			ProxyProtocol: &ProxyProtocolConfig{
+				TrustedSources: []string{"10.0.0.0/8"},
+				HeaderTimeout:  DefaultProxyProtocolConfig.HeaderTimeout,
+			},
 		}, config)
 	})
 }
@@ -0,0 +1,263 @@
+package luci
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// DefaultCORSConfig is the base configuration that's used for a server or route's CORS configuration.
+	DefaultCORSConfig = CORSConfig{
+		AllowedHeaders: []string{
+			"Accept",
+			"Accept-Language",
+			"Authorization",
+			"Content-Language",
+			"Content-Type",
+			"If-Match",
+			"If-Modified-Since",
+			"If-None-Match",
+			"If-Unmodified-Since",
+			"Request-Id",
+			"X-Request-Id",
+		},
+		MaxAge: 5 * time.Minute,
+	}
+
+	// corsAnyMethods are the methods allowed for patterns with a route that matches all methods.
+	corsAnyMethods = []string{
+		http.MethodGet,
+		http.MethodHead,
+		http.MethodPost,
+		http.MethodPut,
+		http.MethodPatch,
+		http.MethodDelete,
+	}
+)
+
+// CORSConfig defines how cross-origin requests to routes are allowed. Allowed methods aren't configured,
+// they're the methods of the routes defined for the requested pattern. Preflight requests that aren't
+// allowed are responded to with the applications Error using a 403 Forbidden *HTTPError.
+// See DefaultCORSConfig for configuration defaults.
+type CORSConfig struct {
+	// AllowedOrigins defines the origins that are allowed to make requests. Origins must match exactly,
+	// unless the origin is * which allows any origin, or the origin's host begins with *. which allows
+	// any subdomain, e.g. https://*.example.com.
+	AllowedOrigins []string
+	// AllowOriginFunc may be optionally used to allow origins that aren't in AllowedOrigins.
+	AllowOriginFunc func(req *http.Request, origin string) bool
+	// AllowedHeaders defines the request headers that are allowed, * allows any header.
+	AllowedHeaders []string
+	// ExposedHeaders defines the response headers that clients are allowed to read.
+	ExposedHeaders []string
+	// AllowCredentials defines whether requests may include credentials such as cookies. Credentials
+	// can't be allowed if AllowedOrigins contains *, since any site could read credentialed responses.
+	AllowCredentials bool
+	// MaxAge defines how long the result of a preflight request may be cached. If MaxAge
+	// is negative preflight results aren't cached.
+	MaxAge time.Duration
+	// AllowPrivateNetwork defines whether requests from public networks are allowed, see
+	// the Private Network Access specification.
+	AllowPrivateNetwork bool
+}
+
+// cors handles CORS for the routes of a pattern.
+type cors struct {
+	config  CORSConfig
+	methods []string
+}
+
+// newCORS creates the CORS handling for routes that allow the given methods.
+func newCORS(config CORSConfig, methods []string) *cors {
+	return &cors{config: buildCORSConfig(config), methods: methods}
+}
+
+// preflight responds to a preflight request.
+func (cors *cors) preflight(errorHandler ErrorHandlerFunc, rw http.ResponseWriter, req *http.Request) {
+	header := rw.Header()
+	addVary(header, "Origin")
+	addVary(header, "Access-Control-Request-Method")
+	addVary(header, "Access-Control-Request-Headers")
+
+	if cors.config.AllowPrivateNetwork {
+		addVary(header, "Access-Control-Request-Private-Network")
+	}
+
+	origin := req.Header.Get("Origin")
+	method := req.Header.Get("Access-Control-Request-Method")
+	requestHeaders := corsRequestHeaders(req)
+
+	if !cors.allowOrigin(req, origin) || !slices.Contains(cors.methods, method) || !cors.allowHeaders(requestHeaders) {
+		errorHandler(rw, req, http.StatusForbidden, Forbidden(ErrCORSNotAllowed))
+		return
+	}
+
+	cors.allowResponse(header, origin)
+	header.Set("Access-Control-Allow-Methods", strings.Join(cors.methods, ", "))
+
+	if len(requestHeaders) != 0 {
+		header.Set("Access-Control-Allow-Headers", strings.Join(requestHeaders, ", "))
+	}
+
+	if cors.config.MaxAge < 0 {
+		header.Set("Access-Control-Max-Age", "0")
+	} else if cors.config.MaxAge > 0 {
+		header.Set("Access-Control-Max-Age", strconv.Itoa(int(cors.config.MaxAge.Seconds())))
+	}
+
+	if cors.config.AllowPrivateNetwork && req.Header.Get("Access-Control-Request-Private-Network") == "true" {
+		header.Set("Access-Control-Allow-Private-Network", "true")
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// actual adds the CORS headers for a request that isn't a preflight request.
+func (cors *cors) actual(rw http.ResponseWriter, req *http.Request) {
+	header := rw.Header()
+	addVary(header, "Origin")
+
+	origin := req.Header.Get("Origin")
+	if origin == "" || !cors.allowOrigin(req, origin) {
+		return
+	}
+
+	cors.allowResponse(header, origin)
+
+	if len(cors.config.ExposedHeaders) != 0 {
+		header.Set("Access-Control-Expose-Headers", strings.Join(cors.config.ExposedHeaders, ", "))
+	}
+}
+
+func (cors *cors) allowResponse(header http.Header, origin string) {
+	if slices.Contains(cors.config.AllowedOrigins, "*") {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+	}
+
+	if cors.config.AllowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+func (cors *cors) allowOrigin(req *http.Request, origin string) bool {
+	if origin == "" {
+		return false
+	}
+
+	for _, allowed := range cors.config.AllowedOrigins {
+		if matchOrigin(allowed, origin) {
+			return true
+		}
+	}
+
+	return cors.config.AllowOriginFunc != nil && cors.config.AllowOriginFunc(req, origin)
+}
+
+func (cors *cors) allowHeaders(requestHeaders []string) bool {
+	if slices.Contains(cors.config.AllowedHeaders, "*") {
+		return true
+	}
+
+	for _, requestHeader := range requestHeaders {
+		allowed := slices.ContainsFunc(cors.config.AllowedHeaders, func(allowed string) bool {
+			return strings.EqualFold(allowed, requestHeader)
+		})
+		if !allowed {
+			return false
+		}
+	}
+
+	return true
+}
+
+func withCORS(errorHandler ErrorHandlerFunc, cors *cors) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			if cors == nil {
+				next.ServeHTTP(rw, req)
+				return
+			}
+
+			if isPreflight(req) {
+				cors.preflight(errorHandler, rw, req)
+				return
+			}
+
+			cors.actual(rw, req)
+			next.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func buildCORSConfig(config CORSConfig) CORSConfig {
+	if config.AllowCredentials && slices.Contains(config.AllowedOrigins, "*") {
+		panic(errors.New("luci: cors must not allow credentials for any origin"))
+	}
+
+	built := DefaultCORSConfig
+
+	built.AllowedOrigins = config.AllowedOrigins
+	built.AllowOriginFunc = config.AllowOriginFunc
+	built.ExposedHeaders = config.ExposedHeaders
+	built.AllowCredentials = config.AllowCredentials
+	built.AllowPrivateNetwork = config.AllowPrivateNetwork
+
+	if len(config.AllowedHeaders) != 0 {
+		built.AllowedHeaders = config.AllowedHeaders
+	}
+
+	if config.MaxAge != 0 {
+		built.MaxAge = config.MaxAge
+	}
+
+	return built
+}
+
+func isPreflight(req *http.Request) bool {
+	return req.Method == http.MethodOptions && req.Header.Get("Origin") != "" &&
+		req.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// corsRequestHeaders returns the headers listed in the Access-Control-Request-Headers header.
+func corsRequestHeaders(req *http.Request) []string {
+	var requestHeaders []string
+
+	for _, value := range req.Header.Values("Access-Control-Request-Headers") {
+		for name := range strings.SplitSeq(value, ",") {
+			name = strings.TrimSpace(name)
+			if name != "" {
+				requestHeaders = append(requestHeaders, name)
+			}
+		}
+	}
+
+	return requestHeaders
+}
+
+// matchOrigin reports whether the origin matches the allowed origin, which may be * or
+// have a host beginning with *. to match any subdomain.
+func matchOrigin(allowed, origin string) bool {
+	if allowed == "*" || strings.EqualFold(allowed, origin) {
+		return true
+	}
+
+	scheme, host, ok := strings.Cut(allowed, "://*.")
+	if !ok {
+		return false
+	}
+
+	prefix := scheme + "://"
+	if len(origin) <= len(prefix) || !strings.EqualFold(origin[:len(prefix)], prefix) {
+		return false
+	}
+
+	subdomain, found := strings.CutSuffix(strings.ToLower(origin[len(prefix):]), "."+strings.ToLower(host))
+
+	return found && subdomain != "" && !strings.ContainsAny(subdomain, "/:")
+}
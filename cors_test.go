@@ -0,0 +1,189 @@
+package luci
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestWithCORS(t *testing.T) {
+	t.Parallel()
+
+	methods := []string{http.MethodGet, http.MethodPost}
+
+	ok := http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	})
+
+	t.Run("responds to allowed preflight requests", func(t *testing.T) {
+		t.Parallel()
+
+		cors := newCORS(CORSConfig{
+			AllowedOrigins:      []string{"https://luci.dev"},
+			AllowCredentials:    true,
+			MaxAge:              time.Hour,
+			AllowPrivateNetwork: true,
+		}, methods)
+
+		recorder, _ := serveTestRequest(withCORS(nil, cors)(ok), testRequest(t.Context(), http.MethodOptions, "/user/abc", nil, http.Header{
+			"Origin":                                 []string{"https://luci.dev"},
+			"Access-Control-Request-Method":          []string{http.MethodPost},
+			"Access-Control-Request-Headers":         []string{"content-type, if-match"},
+			"Access-Control-Request-Private-Network": []string{"true"},
+		}, nil))
+
+		assert.Equal(t, http.StatusNoContent, recorder.Code)
+		assert.Equal(t, http.Header{
+			"Vary": []string{
+				"Origin",
+				"Access-Control-Request-Method",
+				"Access-Control-Request-Headers",
+				"Access-Control-Request-Private-Network",
+			},
+			"Access-Control-Allow-Origin":          []string{"https://luci.dev"},
+			"Access-Control-Allow-Credentials":     []string{"true"},
+			"Access-Control-Allow-Methods":         []string{"GET, POST"},
+			"Access-Control-Allow-Headers":         []string{"content-type, if-match"},
+			"Access-Control-Max-Age":               []string{"3600"},
+			"Access-Control-Allow-Private-Network": []string{"true"},
+		}, recorder.Header())
+	})
+
+	t.Run("responds with the applications error to preflight requests that aren't allowed", func(t *testing.T) {
+		t.Parallel()
+
+		tests := []http.Header{
+			{"Origin": []string{"https://other.dev"}, "Access-Control-Request-Method": []string{http.MethodGet}},
+			{"Origin": []string{"https://luci.dev"}, "Access-Control-Request-Method": []string{http.MethodDelete}},
+			{
+				"Origin":                         []string{"https://luci.dev"},
+				"Access-Control-Request-Method":  []string{http.MethodGet},
+				"Access-Control-Request-Headers": []string{"X-Other"},
+			},
+		}
+
+		for _, header := range tests {
+			var mock mock.Mock
+			mock.On("Error", http.StatusForbidden, Forbidden(ErrCORSNotAllowed))
+
+			errorHandler := func(_ http.ResponseWriter, _ *http.Request, status int, err error) {
+				mock.MethodCalled("Error", status, err)
+			}
+
+			cors := newCORS(CORSConfig{AllowedOrigins: []string{"https://luci.dev"}}, methods)
+
+			recorder, _ := serveTestRequest(withCORS(errorHandler, cors)(ok), testRequest(t.Context(), http.MethodOptions, "/user/abc", nil, header, nil))
+
+			mock.AssertExpectations(t)
+			assert.Empty(t, recorder.Header().Get("Access-Control-Allow-Origin"), "%v", header)
+		}
+	})
+
+	t.Run("adds headers to allowed requests", func(t *testing.T) {
+		t.Parallel()
+
+		cors := newCORS(CORSConfig{
+			AllowedOrigins: []string{"*"},
+			ExposedHeaders: []string{"ETag", "Request-Id"},
+		}, methods)
+
+		recorder, _ := serveTestRequest(withCORS(nil, cors)(ok), testRequest(t.Context(), http.MethodGet, "/user/abc", nil, http.Header{"Origin": []string{"https://luci.dev"}}, nil))
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, http.Header{
+			"Vary":                          []string{"Origin"},
+			"Access-Control-Allow-Origin":   []string{"*"},
+			"Access-Control-Expose-Headers": []string{"ETag, Request-Id"},
+		}, recorder.Header())
+
+		cors = newCORS(CORSConfig{AllowedOrigins: []string{"https://*.luci.dev"}, AllowCredentials: true}, methods)
+
+		recorder, _ = serveTestRequest(withCORS(nil, cors)(ok), testRequest(t.Context(), http.MethodGet, "/user/abc", nil, http.Header{
+			"Origin": []string{"https://api.luci.dev"},
+		}, nil))
+		assert.Equal(t, "https://api.luci.dev", recorder.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "true", recorder.Header().Get("Access-Control-Allow-Credentials"))
+	})
+
+	t.Run("doesn't add headers to requests that aren't allowed", func(t *testing.T) {
+		t.Parallel()
+
+		cors := newCORS(CORSConfig{AllowedOrigins: []string{"https://luci.dev"}}, methods)
+
+		recorder, _ := serveTestRequest(withCORS(nil, cors)(ok), testRequest(t.Context(), http.MethodGet, "/user/abc", nil, http.Header{"Origin": []string{"https://other.dev"}}, nil))
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, http.Header{"Vary": []string{"Origin"}}, recorder.Header())
+
+		recorder, _ = serveTestRequest(withCORS(nil, cors)(ok), testRequest(t.Context(), http.MethodOptions, "/user/abc", nil, nil, nil))
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, http.Header{"Vary": []string{"Origin"}}, recorder.Header())
+	})
+
+	t.Run("allows origins using the origin func", func(t *testing.T) {
+		t.Parallel()
+
+		cors := newCORS(CORSConfig{AllowOriginFunc: func(req *http.Request, origin string) bool {
+			return req.URL.Path == "/user/abc" && origin == "https://luci.dev"
+		}}, methods)
+
+		recorder, _ := serveTestRequest(withCORS(nil, cors)(ok), testRequest(t.Context(), http.MethodGet, "/user/abc", nil, http.Header{"Origin": []string{"https://luci.dev"}}, nil))
+		assert.Equal(t, "https://luci.dev", recorder.Header().Get("Access-Control-Allow-Origin"))
+	})
+
+	t.Run("does nothing without a configuration", func(t *testing.T) {
+		t.Parallel()
+
+		recorder, _ := serveTestRequest(withCORS(nil, nil)(ok), testRequest(t.Context(), http.MethodGet, "/user/abc", nil, http.Header{"Origin": []string{"https://luci.dev"}}, nil))
+		assert.Empty(t, recorder.Header())
+	})
+}
+
+func TestMatchOrigin(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		allowed  string
+		origin   string
+		expected bool
+	}{
+		{allowed: "*", origin: "https://luci.dev", expected: true},
+		{allowed: "https://luci.dev", origin: "https://luci.dev", expected: true},
+		{allowed: "https://luci.dev", origin: "HTTPS://LUCI.DEV", expected: true},
+		{allowed: "https://luci.dev", origin: "http://luci.dev", expected: false},
+		{allowed: "https://luci.dev", origin: "https://luci.dev:8080", expected: false},
+		{allowed: "https://*.luci.dev", origin: "https://api.luci.dev", expected: true},
+		{allowed: "https://*.luci.dev", origin: "https://a.b.luci.dev", expected: true},
+		{allowed: "https://*.luci.dev", origin: "https://luci.dev", expected: false},
+		{allowed: "https://*.luci.dev", origin: "https://.luci.dev", expected: false},
+		{allowed: "https://*.luci.dev", origin: "https://api.other.dev", expected: false},
+		{allowed: "https://*.luci.dev", origin: "http://api.luci.dev", expected: false},
+		{allowed: "https://*.luci.dev", origin: "https://evil.dev/.luci.dev", expected: false},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, matchOrigin(test.allowed, test.origin), "%s %s", test.allowed, test.origin)
+	}
+}
+
+func TestBuildCORSConfig(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, DefaultCORSConfig, buildCORSConfig(CORSConfig{}))
+	assert.Equal(t, CORSConfig{
+		AllowedOrigins:   []string{"https://luci.dev"},
+		AllowedHeaders:   []string{"*"},
+		AllowCredentials: true,
+		MaxAge:           -1,
+	}, buildCORSConfig(CORSConfig{
+		AllowedOrigins:   []string{"https://luci.dev"},
+		AllowedHeaders:   []string{"*"},
+		AllowCredentials: true,
+		MaxAge:           -1,
+	}))
+	assert.PanicsWithError(t, "luci: cors must not allow credentials for any origin", func() {
+		buildCORSConfig(CORSConfig{AllowedOrigins: []string{"*"}, AllowCredentials: true})
+	})
+}
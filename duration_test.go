@@ -1,7 +1,6 @@
 package luci
 
 import (
-	"context"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -18,7 +17,7 @@ func TestDuration(t *testing.T) {
 	<-time.After(50 * time.Millisecond)
 
 	request := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/status", nil)
-	request = request.WithContext(context.WithValue(request.Context(), durationKey{}, start))
+	request = withState(request, &requestState{start: start})
 
 	assert.GreaterOrEqual(t, Duration(request), 50*time.Millisecond)
 }
@@ -3,6 +3,7 @@ package luci
 import (
 	"errors"
 	"net/http"
+	"strings"
 )
 
 var (
@@ -10,15 +11,205 @@ var (
 	ErrMethodNotAllowed = errors.New("luci: method not allowed")
 	// ErrNotFound is used for requests with a path that doesn't match any routes.
 	ErrNotFound = errors.New("luci: not found")
+	// ErrNotAcceptable is used for requests that don't accept any of a route's content types.
+	ErrNotAcceptable = errors.New("luci: not acceptable")
+	// ErrUnsupportedMediaType is used for requests with a body content type that a route doesn't support.
+	ErrUnsupportedMediaType = errors.New("luci: unsupported media type")
+	// ErrPreconditionFailed is used for requests with a conditional header that doesn't match the current resource.
+	ErrPreconditionFailed = errors.New("luci: precondition failed")
+	// ErrCORSNotAllowed is used for CORS preflight requests with an origin, method, or headers that aren't allowed.
+	ErrCORSNotAllowed = errors.New("luci: cors not allowed")
+	// ErrRateLimited is used for requests that exceed a rate limit.
+	ErrRateLimited = errors.New("luci: rate limited")
+	// ErrInvalidUUID is used when parsing a UUID that isn't in its canonical form.
+	ErrInvalidUUID = errors.New("luci: invalid uuid")
 	// ErrForcedShutdown is used when server shutdown takes longer than the configured timeout.
 	ErrForcedShutdown = errors.New("luci: forced server shutdown")
 )
 
+type statusCoder interface {
+	StatusCode() int
+}
+
 // ErrorHandlerFunc is used to define functions that handle error specific responses.
 type ErrorHandlerFunc func(http.ResponseWriter, *http.Request, int, error)
 
+// HTTPError is an error that describes how it should be responded to. It separates the public
+// message that's safe to respond with from the internal cause that should only be logged.
+type HTTPError struct {
+	// Status is the HTTP status code to respond with.
+	Status int
+	// Code is a machine readable identifier for the error, e.g. not_found.
+	Code string
+	// Message is the public message that's safe to respond with.
+	Message string
+	// Cause is the internal error that caused the error, it should not be responded with.
+	Cause error
+	// Header contains headers to add to the response, e.g. Retry-After.
+	Header http.Header
+	// Details contains additional public information about the error.
+	Details map[string]any
+}
+
+// NewHTTPError creates an error with the given status and cause. The code and message default
+// to values derived from the status text, e.g. 404 uses not_found and Not Found.
+func NewHTTPError(status int, cause error) *HTTPError {
+	text := http.StatusText(status)
+
+	return &HTTPError{
+		Status:  status,
+		Code:    strings.ReplaceAll(strings.ToLower(text), " ", "_"),
+		Message: text,
+		Cause:   cause,
+	}
+}
+
+// AsHTTPError returns the HTTPError in the error chain of err if one exists. Otherwise an HTTPError is
+// created with err as the cause, using the status from a StatusCode() int method in the error chain if
+// one exists or the given status.
+func AsHTTPError(err error, status int) *HTTPError {
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr
+	}
+
+	var coder statusCoder
+	if errors.As(err, &coder) {
+		status = coder.StatusCode()
+	}
+
+	return NewHTTPError(status, err)
+}
+
+// BadRequest creates an error with the status 400 Bad Request.
+func BadRequest(cause error) *HTTPError {
+	return NewHTTPError(http.StatusBadRequest, cause)
+}
+
+// Unauthorized creates an error with the status 401 Unauthorized.
+func Unauthorized(cause error) *HTTPError {
+	return NewHTTPError(http.StatusUnauthorized, cause)
+}
+
+// Forbidden creates an error with the status 403 Forbidden.
+func Forbidden(cause error) *HTTPError {
+	return NewHTTPError(http.StatusForbidden, cause)
+}
+
+// NotFound creates an error with the status 404 Not Found.
+func NotFound(cause error) *HTTPError {
+	return NewHTTPError(http.StatusNotFound, cause)
+}
+
+// MethodNotAllowed creates an error with the status 405 Method Not Allowed.
+func MethodNotAllowed(cause error) *HTTPError {
+	return NewHTTPError(http.StatusMethodNotAllowed, cause)
+}
+
+// NotAcceptable creates an error with the status 406 Not Acceptable.
+func NotAcceptable(cause error) *HTTPError {
+	return NewHTTPError(http.StatusNotAcceptable, cause)
+}
+
+// Conflict creates an error with the status 409 Conflict.
+func Conflict(cause error) *HTTPError {
+	return NewHTTPError(http.StatusConflict, cause)
+}
+
+// PreconditionFailed creates an error with the status 412 Precondition Failed.
+func PreconditionFailed(cause error) *HTTPError {
+	return NewHTTPError(http.StatusPreconditionFailed, cause)
+}
+
+// UnsupportedMediaType creates an error with the status 415 Unsupported Media Type.
+func UnsupportedMediaType(cause error) *HTTPError {
+	return NewHTTPError(http.StatusUnsupportedMediaType, cause)
+}
+
+// UnprocessableEntity creates an error with the status 422 Unprocessable Entity.
+func UnprocessableEntity(cause error) *HTTPError {
+	return NewHTTPError(http.StatusUnprocessableEntity, cause)
+}
+
+// TooManyRequests creates an error with the status 429 Too Many Requests.
+func TooManyRequests(cause error) *HTTPError {
+	return NewHTTPError(http.StatusTooManyRequests, cause)
+}
+
+// InternalServerError creates an error with the status 500 Internal Server Error.
+func InternalServerError(cause error) *HTTPError {
+	return NewHTTPError(http.StatusInternalServerError, cause)
+}
+
+// ServiceUnavailable creates an error with the status 503 Service Unavailable.
+func ServiceUnavailable(cause error) *HTTPError {
+	return NewHTTPError(http.StatusServiceUnavailable, cause)
+}
+
+// Error returns the internal cause's message if it exists, otherwise the public message.
+func (err *HTTPError) Error() string {
+	if err.Cause != nil {
+		return err.Cause.Error()
+	}
+
+	return err.PublicMessage()
+}
+
+// Unwrap returns the internal cause.
+func (err *HTTPError) Unwrap() error {
+	return err.Cause
+}
+
+// StatusCode returns the HTTP status code to respond with.
+func (err *HTTPError) StatusCode() int {
+	return err.Status
+}
+
+// PublicMessage returns the public message, defaulting to the status text if no message is set.
+func (err *HTTPError) PublicMessage() string {
+	if err.Message != "" {
+		return err.Message
+	}
+
+	return http.StatusText(err.Status)
+}
+
+// WithCode sets the machine readable code and returns the error.
+func (err *HTTPError) WithCode(code string) *HTTPError {
+	err.Code = code
+	return err
+}
+
+// WithMessage sets the public message and returns the error.
+func (err *HTTPError) WithMessage(message string) *HTTPError {
+	err.Message = message
+	return err
+}
+
+// WithHeader adds a header to respond with and returns the error.
+func (err *HTTPError) WithHeader(key, value string) *HTTPError {
+	if err.Header == nil {
+		err.Header = make(http.Header)
+	}
+
+	err.Header.Add(key, value)
+
+	return err
+}
+
+// WithDetail sets a public detail and returns the error.
+func (err *HTTPError) WithDetail(key string, value any) *HTTPError {
+	if err.Details == nil {
+		err.Details = make(map[string]any)
+	}
+
+	err.Details[key] = value
+
+	return err
+}
+
 func errorRespond(errorHandler ErrorHandlerFunc, status int, err error) http.HandlerFunc {
 	return func(rw http.ResponseWriter, req *http.Request) {
-		errorHandler(rw, req, status, err)
+		errorHandler(rw, req, status, NewHTTPError(status, err))
 	}
 }
@@ -1,10 +1,13 @@
 package luci
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
 
@@ -21,10 +24,125 @@ func TestErrorRespond(t *testing.T) {
 	request := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/status", nil)
 	status := http.StatusNotFound
 	err := ErrNotFound
-	mock.On("handler", recorder, request, status, err)
+	mock.On("handler", recorder, request, status, NotFound(err))
 
 	handler := errorRespond(errorHandler, http.StatusNotFound, err)
 	handler(recorder, request)
 
 	mock.AssertExpectations(t)
 }
+
+func TestNewHTTPError(t *testing.T) {
+	t.Parallel()
+
+	cause := errors.New("user not found")
+
+	assert.Equal(t, &HTTPError{
+		Status:  http.StatusNotFound,
+		Code:    "not_found",
+		Message: "Not Found",
+		Cause:   cause,
+	}, NewHTTPError(http.StatusNotFound, cause))
+
+	assert.Equal(t, &HTTPError{
+		Status:  http.StatusTooManyRequests,
+		Code:    "too_many_requests",
+		Message: "Too Many Requests",
+	}, NewHTTPError(http.StatusTooManyRequests, nil))
+}
+
+func TestAsHTTPError(t *testing.T) {
+	t.Parallel()
+
+	t.Run("returns HTTPError in the error chain", func(t *testing.T) {
+		t.Parallel()
+
+		httpErr := Conflict(nil)
+
+		assert.Same(t, httpErr, AsHTTPError(fmt.Errorf("wrapped: %w", httpErr), http.StatusInternalServerError))
+	})
+
+	t.Run("uses status from StatusCode method in the error chain", func(t *testing.T) {
+		t.Parallel()
+
+		err := fmt.Errorf("wrapped: %w", &BindError{})
+
+		assert.Equal(t, BadRequest(err), AsHTTPError(err, http.StatusInternalServerError))
+	})
+
+	t.Run("uses given status otherwise", func(t *testing.T) {
+		t.Parallel()
+
+		err := errors.New("failure")
+
+		assert.Equal(t, InternalServerError(err), AsHTTPError(err, http.StatusInternalServerError))
+	})
+}
+
+func TestHTTPErrorHelpers(t *testing.T) {
+	t.Parallel()
+
+	cause := errors.New("cause")
+
+	tests := []struct {
+		err    *HTTPError
+		status int
+	}{
+		{err: BadRequest(cause), status: http.StatusBadRequest},
+		{err: Unauthorized(cause), status: http.StatusUnauthorized},
+		{err: Forbidden(cause), status: http.StatusForbidden},
+		{err: NotFound(cause), status: http.StatusNotFound},
+		{err: MethodNotAllowed(cause), status: http.StatusMethodNotAllowed},
+		{err: Conflict(cause), status: http.StatusConflict},
+		{err: PreconditionFailed(cause), status: http.StatusPreconditionFailed},
+		{err: UnprocessableEntity(cause), status: http.StatusUnprocessableEntity},
+		{err: TooManyRequests(cause), status: http.StatusTooManyRequests},
+		{err: InternalServerError(cause), status: http.StatusInternalServerError},
+		{err: ServiceUnavailable(cause), status: http.StatusServiceUnavailable},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, NewHTTPError(test.status, cause), test.err)
+		assert.Equal(t, test.status, test.err.StatusCode())
+	}
+}
+
+func TestHTTPError(t *testing.T) {
+	t.Parallel()
+
+	t.Run("error message uses cause if it exists", func(t *testing.T) {
+		t.Parallel()
+
+		cause := errors.New("database unavailable")
+		err := ServiceUnavailable(cause)
+
+		assert.EqualError(t, err, "database unavailable")
+		assert.ErrorIs(t, err, cause)
+		assert.Equal(t, "Service Unavailable", err.PublicMessage())
+	})
+
+	t.Run("error message uses public message without a cause", func(t *testing.T) {
+		t.Parallel()
+
+		assert.EqualError(t, NotFound(nil).WithMessage("user not found"), "user not found")
+		assert.EqualError(t, &HTTPError{Status: http.StatusNotFound}, "Not Found")
+	})
+
+	t.Run("builder methods set fields", func(t *testing.T) {
+		t.Parallel()
+
+		err := TooManyRequests(nil).
+			WithCode("rate_limited").
+			WithMessage("slow down").
+			WithHeader("Retry-After", "10").
+			WithDetail("limit", 100)
+
+		assert.Equal(t, &HTTPError{
+			Status:  http.StatusTooManyRequests,
+			Code:    "rate_limited",
+			Message: "slow down",
+			Header:  http.Header{"Retry-After": []string{"10"}},
+			Details: map[string]any{"limit": 100},
+		}, err)
+	})
+}
@@ -0,0 +1,239 @@
+package luci
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrStreamingUnsupported is used when a response writer doesn't support flushing.
+	ErrStreamingUnsupported = errors.New("luci: streaming unsupported")
+)
+
+// Event defines a server-sent event.
+type Event struct {
+	// ID sets the streams last event ID, which clients send in the
+	// Last-Event-ID header when reconnecting to resume the stream.
+	ID string
+	// Type is the event type, clients treat events without a type as message events.
+	Type string
+	// Data is the event data. Strings and byte slices are sent as is, and
+	// any other value is encoded as JSON.
+	Data any
+	// Retry is the reconnection time clients should use if greater than zero.
+	Retry time.Duration
+}
+
+// EventWriter writes server-sent events to a response.
+type EventWriter struct {
+	rw        http.ResponseWriter
+	req       *http.Request
+	flusher   http.Flusher
+	resWriter *responseWriter
+	done      chan struct{}
+	closeOnce sync.Once
+	stop      func() bool
+	mu        sync.Mutex
+}
+
+// EventStream starts a server-sent event stream response, writing the event stream headers
+// and flushing them to the client. Every event sent is flushed immediately. Events are sent
+// until the request context is done or the writer is closed, after which sends return an error.
+//
+// Since the access log is written once the handler returns, a debug log is written when the stream
+// starts, and the access log includes the number of events sent.
+//
+// The route's timeout is lifted once the stream starts, so the stream lasts until the client
+// disconnects or the writer is closed. ErrStreamingUnsupported is returned if the response writer doesn't
+// support flushing, and the request context's error if the route has already timed out.
+func EventStream(rw http.ResponseWriter, req *http.Request) (*EventWriter, error) {
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		return nil, ErrStreamingUnsupported
+	}
+
+	header := rw.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("X-Accel-Buffering", "no")
+	header.Del("Content-Length")
+
+	state := requestStateFrom(req)
+	if state != nil && state.timeout != nil && !state.timeout.lift() {
+		return nil, state.timeout.Err()
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	writer := &EventWriter{
+		rw:        rw,
+		req:       req,
+		flusher:   flusher,
+		resWriter: findResponseWriter(rw),
+		done:      make(chan struct{}),
+	}
+	writer.stop = context.AfterFunc(req.Context(), writer.Close)
+
+	logger := Logger(req)
+	if logger != nil {
+		logger.With(slog.String("last_event_id", writer.LastEventID())).Debug("event stream started")
+	}
+
+	return writer, nil
+}
+
+// LastEventID returns the ID of the last event the client received, sent in the Last-Event-ID
+// header when a client reconnects. Streams can use it to resume sending events after that event.
+func (writer *EventWriter) LastEventID() string {
+	return writer.req.Header.Get("Last-Event-ID")
+}
+
+// Done returns a channel that's closed once the stream is closed or the request context is done.
+func (writer *EventWriter) Done() <-chan struct{} {
+	return writer.done
+}
+
+// Send writes the event to the stream and flushes it to the client.
+func (writer *EventWriter) Send(event Event) error {
+	var buffer bytes.Buffer
+
+	if event.ID != "" {
+		if strings.ContainsAny(event.ID, "\r\n\x00") {
+			return errors.New("luci: event id must not contain newlines or null characters")
+		}
+
+		buffer.WriteString("id: " + event.ID + "\n")
+	}
+
+	if event.Type != "" {
+		if strings.ContainsAny(event.Type, "\r\n") {
+			return errors.New("luci: event type must not contain newlines")
+		}
+
+		buffer.WriteString("event: " + event.Type + "\n")
+	}
+
+	if event.Retry > 0 {
+		buffer.WriteString("retry: " + strconv.FormatInt(event.Retry.Milliseconds(), 10) + "\n")
+	}
+
+	if event.Data != nil {
+		data, err := eventData(event.Data)
+		if err != nil {
+			return err
+		}
+
+		writeEventLines(&buffer, "data: ", data)
+	}
+
+	buffer.WriteByte('\n')
+
+	return writer.write(buffer.Bytes(), true)
+}
+
+// Comment writes a comment to the stream, comments are ignored by clients.
+func (writer *EventWriter) Comment(text string) error {
+	var buffer bytes.Buffer
+
+	writeEventLines(&buffer, ": ", text)
+	buffer.WriteByte('\n')
+
+	return writer.write(buffer.Bytes(), false)
+}
+
+// Heartbeat writes a comment to the stream at the given interval until the stream is closed,
+// which keeps idle connections from being closed by clients and proxies.
+func (writer *EventWriter) Heartbeat(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-writer.done:
+				return
+			case <-ticker.C:
+				err := writer.Comment("heartbeat")
+				if err != nil {
+					return
+				}
+			}
+		}
+	}()
+}
+
+// Close closes the stream, stopping any heartbeat and causing further sends to return an error.
+// Close doesn't end the response, which ends once the handler returns.
+func (writer *EventWriter) Close() {
+	writer.closeOnce.Do(func() {
+		writer.mu.Lock()
+		defer writer.mu.Unlock()
+
+		writer.stop()
+		close(writer.done)
+	})
+}
+
+func (writer *EventWriter) write(data []byte, isEvent bool) error {
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+
+	select {
+	case <-writer.done:
+		err := context.Cause(writer.req.Context())
+		if err == nil {
+			err = errors.New("stream closed")
+		}
+
+		return fmt.Errorf("luci: event stream: %w", err)
+	default:
+	}
+
+	_, err := writer.rw.Write(data)
+	if err != nil {
+		return fmt.Errorf("luci: event stream: %w", err)
+	}
+
+	writer.flusher.Flush()
+
+	if isEvent && writer.resWriter != nil {
+		writer.resWriter.addEvent()
+	}
+
+	return nil
+}
+
+func eventData(data any) (string, error) {
+	switch value := data.(type) {
+	case string:
+		return value, nil
+	case []byte:
+		return string(value), nil
+	default:
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return "", fmt.Errorf("luci: event data: %w", err)
+		}
+
+		return string(encoded), nil
+	}
+}
+
+func writeEventLines(buffer *bytes.Buffer, prefix, text string) {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+
+	for line := range strings.SplitSeq(text, "\n") {
+		buffer.WriteString(prefix + line + "\n")
+	}
+}
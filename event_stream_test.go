@@ -0,0 +1,215 @@
+package luci
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type unflushableResponseWriter struct {
+	http.ResponseWriter
+}
+
+type lockedRecorder struct {
+	*httptest.ResponseRecorder
+	mu sync.Mutex
+}
+
+func (rw *lockedRecorder) Write(b []byte) (int, error) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	return rw.ResponseRecorder.Write(b)
+}
+
+func (rw *lockedRecorder) body() string {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	return rw.Body.String()
+}
+
+func TestEventStream(t *testing.T) {
+	t.Parallel()
+
+	t.Run("writes event stream headers", func(t *testing.T) {
+		t.Parallel()
+
+		recorder := httptest.NewRecorder()
+		recorder.Header().Set("Content-Length", "5")
+
+		request := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/events", nil)
+		request.Header.Set("Last-Event-ID", "5")
+
+		writer, err := EventStream(recorder, request)
+		assert.NoError(t, err)
+		defer writer.Close()
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.True(t, recorder.Flushed)
+		assert.Equal(t, "text/event-stream", recorder.Header().Get("Content-Type"))
+		assert.Equal(t, "no-cache", recorder.Header().Get("Cache-Control"))
+		assert.Equal(t, "no", recorder.Header().Get("X-Accel-Buffering"))
+		assert.Empty(t, recorder.Header().Get("Content-Length"))
+		assert.Equal(t, "5", writer.LastEventID())
+	})
+
+	t.Run("outlives the route timeout", func(t *testing.T) {
+		t.Parallel()
+
+		var called bool
+
+		errorHandler := func(_ http.ResponseWriter, _ *http.Request, _ int, _ error) {
+			called = true
+		}
+
+		timeout := time.Millisecond * 50
+		middlewares := Middlewares{
+			withResponseWriter,
+			withRequestState,
+			withLogger(noopLogger),
+			withTimeout(errorHandler, timeout),
+		}
+
+		handler := middlewares.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			writer, err := EventStream(rw, req)
+			assert.NoError(t, err)
+			defer writer.Close()
+
+			_, ok := req.Context().Deadline()
+			assert.False(t, ok)
+
+			<-time.After(timeout * 2)
+
+			assert.NoError(t, req.Context().Err())
+			assert.NoError(t, writer.Send(Event{Data: "hello"}))
+		})
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/events", nil)
+
+		handler.ServeHTTP(recorder, request)
+
+		assert.False(t, called)
+		assert.Equal(t, "data: hello\n\n", recorder.Body.String())
+	})
+
+	t.Run("returns error if response writer can't flush", func(t *testing.T) {
+		t.Parallel()
+
+		rw := unflushableResponseWriter{httptest.NewRecorder()}
+		request := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/events", nil)
+
+		writer, err := EventStream(rw, request)
+		assert.Nil(t, writer)
+		assert.Equal(t, ErrStreamingUnsupported, err)
+	})
+}
+
+func TestEventWriterSend(t *testing.T) {
+	t.Parallel()
+
+	t.Run("writes events", func(t *testing.T) {
+		t.Parallel()
+
+		recorder := httptest.NewRecorder()
+		wrw := &responseWriter{rw: recorder}
+		request := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/events", nil)
+
+		writer, err := EventStream(wrw, request)
+		assert.NoError(t, err)
+		defer writer.Close()
+
+		assert.NoError(t, writer.Send(Event{Data: "hello"}))
+		assert.NoError(t, writer.Send(Event{ID: "2", Type: "user", Data: map[string]string{"name": "luci"}}))
+		assert.NoError(t, writer.Send(Event{Data: []byte("line 1\nline 2\r\nline 3"), Retry: time.Second}))
+		assert.NoError(t, writer.Comment("ping"))
+
+		expected := "data: hello\n\n" +
+			"id: 2\nevent: user\ndata: {\"name\":\"luci\"}\n\n" +
+			"retry: 1000\ndata: line 1\ndata: line 2\ndata: line 3\n\n" +
+			": ping\n\n"
+
+		assert.Equal(t, expected, recorder.Body.String())
+		assert.Equal(t, int64(3), wrw.stats().events)
+	})
+
+	t.Run("returns error for invalid events", func(t *testing.T) {
+		t.Parallel()
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/events", nil)
+
+		writer, err := EventStream(recorder, request)
+		assert.NoError(t, err)
+		defer writer.Close()
+
+		assert.EqualError(t, writer.Send(Event{ID: "1\n2"}), "luci: event id must not contain newlines or null characters")
+		assert.EqualError(t, writer.Send(Event{Type: "a\nb"}), "luci: event type must not contain newlines")
+		assert.EqualError(t, writer.Send(Event{Data: make(chan int)}), "luci: event data: json: unsupported type: chan int")
+		assert.Empty(t, recorder.Body.String())
+	})
+
+	t.Run("returns error once request context is done", func(t *testing.T) {
+		t.Parallel()
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/events", nil)
+
+		ctx, cancel := context.WithCancel(request.Context())
+
+		writer, err := EventStream(recorder, request.WithContext(ctx))
+		assert.NoError(t, err)
+
+		cancel()
+
+		select {
+		case <-writer.Done():
+		case <-time.After(time.Second):
+			assert.Fail(t, "stream should be done")
+		}
+
+		err = writer.Send(Event{Data: "hello"})
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Empty(t, recorder.Body.String())
+	})
+
+	t.Run("returns error once closed", func(t *testing.T) {
+		t.Parallel()
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/events", nil)
+
+		writer, err := EventStream(recorder, request)
+		assert.NoError(t, err)
+
+		writer.Close()
+		writer.Close()
+
+		assert.EqualError(t, writer.Send(Event{Data: "hello"}), "luci: event stream: stream closed")
+	})
+}
+
+func TestEventWriterHeartbeat(t *testing.T) {
+	t.Parallel()
+
+	recorder := &lockedRecorder{ResponseRecorder: httptest.NewRecorder()}
+	request := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/events", nil)
+
+	writer, err := EventStream(recorder, request)
+	assert.NoError(t, err)
+
+	writer.Heartbeat(time.Millisecond * 10)
+
+	assert.Eventually(t, func() bool {
+		return strings.Count(recorder.body(), ": heartbeat\n\n") >= 2
+	}, time.Second, time.Millisecond*10)
+
+	writer.Close()
+}
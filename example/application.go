@@ -2,11 +2,11 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"time"
 
 	"github.com/larzconwell/luci"
 )
@@ -44,43 +44,51 @@ func (app *Application) ListenAndServe(ctx context.Context) error {
 
 func (app *Application) Routes() []luci.Route {
 	return []luci.Route{
-		{Name: Status, Pattern: "/status", HandlerFunc: app.Status},
-		{Name: ShowUser, Method: http.MethodGet, Pattern: "/user/{key:[0-9a-zA-Z]+}", HandlerFunc: app.ShowUser},
-		{Name: UpdateUser, Method: http.MethodPost, Pattern: "/user/{key:[0-9a-zA-Z]+}/update", HandlerFunc: app.UpdateUser},
+		{Name: Status, Pattern: "/status", ContentTypes: []string{"application/json"}, Cache: &luci.RouteCache{TTL: 5 * time.Second}, HandlerFunc: app.Status},
+		{
+			Name:        ShowUser,
+			Method:      http.MethodGet,
+			Pattern:     "/user/{key:[0-9a-zA-Z]+}",
+			Middlewares: luci.Middlewares{luci.Coalesce(luci.CoalesceConfig{})},
+			HandlerFunc: luci.Handle(app.ShowUser),
+		},
+		{
+			Name:        UpdateUser,
+			Method:      http.MethodPost,
+			Pattern:     "/user/{key:[0-9a-zA-Z]+}/update",
+			RateLimit:   &luci.RateLimitRule{Requests: 10, Window: time.Minute},
+			HandlerFunc: luci.Handle(app.UpdateUser),
+		},
 	}
 }
 
 func (app *Application) Middlewares() luci.Middlewares {
-	return nil
+	return luci.Middlewares{
+		luci.RateLimit(luci.RateLimitConfig{}),
+		luci.Compress(luci.CompressConfig{}),
+		luci.Conditional(luci.ConditionalConfig{}),
+		luci.Cache(luci.CacheConfig{}),
+	}
 }
 
 func (app *Application) Error(rw http.ResponseWriter, req *http.Request, status int, err error) {
 	statusRoute, _ := app.server.Route(Status)
-	value := map[string]any{
-		"error":  err.Error(),
-		"status": status,
-		"links": map[string]string{
-			Status: statusRoute.Pattern,
-		},
-	}
-
-	rw.Header().Set("Content-Type", "application/json")
-	rw.WriteHeader(status)
 
-	encodeErr := json.NewEncoder(rw).Encode(value)
-	if encodeErr != nil && !errors.Is(encodeErr, http.ErrHandlerTimeout) && !errors.Is(encodeErr, context.Canceled) {
-		luci.Logger(req).With(
-			slog.Any("error", encodeErr),
-			slog.Any("source_error", err),
-		).Error("failed to write response")
+	problem := luci.NewProblem(req, status, err)
+	problem.Extensions["links"] = map[string]string{
+		Status: statusRoute.Pattern,
 	}
+
+	luci.WriteProblem(rw, req, problem)
 }
 
 func (app *Application) Respond(rw http.ResponseWriter, req *http.Request, value any) {
-	rw.Header().Set("Content-Type", "application/json")
+	err := luci.Encode(rw, req, value)
 
-	err := json.NewEncoder(rw).Encode(value)
-	if err != nil && !errors.Is(err, http.ErrHandlerTimeout) && !errors.Is(err, context.Canceled) {
+	var httpErr *luci.HTTPError
+	if errors.As(err, &httpErr) {
+		app.Error(rw, req, httpErr.Status, httpErr)
+	} else if err != nil && !errors.Is(err, http.ErrHandlerTimeout) && !errors.Is(err, context.Canceled) {
 		luci.Logger(req).With(slog.Any("error", err)).Error("failed to write response")
 	}
 }
@@ -91,54 +99,76 @@ func (app *Application) Status(rw http.ResponseWriter, req *http.Request) {
 	})
 }
 
-func (app *Application) ShowUser(rw http.ResponseWriter, req *http.Request) {
-	updateUserRoute, _ := app.server.Route(UpdateUser)
-	key := luci.Var(req, "key")
+type ShowUserInput struct {
+	Key string `path:"key"`
+}
 
-	user, ok := app.db.Get(key)
+func (app *Application) ShowUser(ctx context.Context, in ShowUserInput) (map[string]any, error) {
+	user, ok := app.db.Get(in.Key)
 	if !ok {
-		app.Error(rw, req, http.StatusNotFound, errors.New("user not found"))
-		return
+		return nil, luci.NotFound(nil).WithMessage("user not found")
 	}
 
-	updateUserPath, err := updateUserRoute.Path(key)
+	luci.SetETag(luci.ResponseHeader(ctx), user.ETag(), false)
+
+	links, err := app.userLinks(user.Key)
 	if err != nil {
-		app.Error(rw, req, http.StatusInternalServerError, err)
-		return
+		return nil, err
 	}
 
-	app.Respond(rw, req, map[string]any{
-		"user": user,
-		"links": map[string]string{
-			ShowUser:   req.URL.Path,
-			UpdateUser: updateUserPath,
-		},
-	})
+	return map[string]any{
+		"user":  user,
+		"links": links,
+	}, nil
+}
+
+type UpdateUserInput struct {
+	luci.Preconditions
+
+	Key  string `path:"key"`
+	Name string `form:"name" query:"name" validate:"required,max=64"`
 }
 
-func (app *Application) UpdateUser(rw http.ResponseWriter, req *http.Request) {
-	showUserRoute, _ := app.server.Route(ShowUser)
-	key := luci.Var(req, "key")
+func (app *Application) UpdateUser(ctx context.Context, in UpdateUserInput) (map[string]any, error) {
+	var etag string
+	if user, ok := app.db.Get(in.Key); ok {
+		etag = `"` + user.ETag() + `"`
+	}
 
-	name := req.FormValue("name")
-	if name == "" {
-		app.Error(rw, req, http.StatusBadRequest, errors.New("name is required"))
-		return
+	err := in.Check(etag, time.Time{})
+	if err != nil {
+		return nil, err
 	}
 
-	user := app.db.Update(key, name)
+	user := app.db.Update(in.Key, in.Name)
+	luci.SetETag(luci.ResponseHeader(ctx), user.ETag(), false)
 
-	showUserPath, err := showUserRoute.Path(key)
+	links, err := app.userLinks(user.Key)
 	if err != nil {
-		app.Error(rw, req, http.StatusInternalServerError, err)
-		return
+		return nil, err
 	}
 
-	app.Respond(rw, req, map[string]any{
-		"user": user,
-		"links": map[string]string{
-			ShowUser:   showUserPath,
-			UpdateUser: req.URL.Path,
-		},
-	})
+	return map[string]any{
+		"user":  user,
+		"links": links,
+	}, nil
+}
+
+func (app *Application) userLinks(key string) (map[string]string, error) {
+	vars := ShowUserInput{Key: key}
+
+	showUserURL, err := app.server.URL(ShowUser, vars, nil)
+	if err != nil {
+		return nil, fmt.Errorf("show user url: %w", err)
+	}
+
+	updateUserURL, err := app.server.URL(UpdateUser, vars, nil)
+	if err != nil {
+		return nil, fmt.Errorf("update user url: %w", err)
+	}
+
+	return map[string]string{
+		ShowUser:   showUserURL.String(),
+		UpdateUser: updateUserURL.String(),
+	}, nil
 }
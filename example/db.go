@@ -1,12 +1,18 @@
 package main
 
 import (
+	"strconv"
 	"sync"
 )
 
 type User struct {
-	Key  string `json:"key"`
-	Name string `json:"name"`
+	Key     string `json:"key"`
+	Name    string `json:"name"`
+	Version int    `json:"-"`
+}
+
+func (user User) ETag() string {
+	return strconv.Itoa(user.Version)
 }
 
 type DB struct {
@@ -44,6 +50,7 @@ func (db *DB) Update(key, name string) User {
 
 	user.Key = key
 	user.Name = name
+	user.Version++
 
 	return *user
 }
@@ -28,6 +28,13 @@ func run() error {
 		Address:         ":7879",
 		ShutdownTimeout: time.Second,
 		Logger:          slog.New(slog.NewJSONHandler(os.Stdout, nil)),
+		CORS: &luci.CORSConfig{
+			AllowedOrigins: []string{"http://localhost:3000"},
+			ExposedHeaders: []string{"ETag", "Request-Id"},
+		},
+		AutoOptions: true,
+		AutoHead:    true,
+		PathPolicy:  luci.PathPolicy{Mode: luci.PathRedirect},
 	})
 
 	return app.ListenAndServe(ctx)
@@ -0,0 +1,102 @@
+package luci
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+)
+
+// HandleFunc defines a function that handles a request using typed values.
+type HandleFunc[In, Out any] func(ctx context.Context, in In) (Out, error)
+
+// Handle creates a handler function from a function that operates on typed values rather than the raw request.
+//
+// The input value is decoded from the request body using Decode, and then bound from the request using Bind.
+// Form bodies are only bound using the form struct tag. Once bound the input value is validated using Validate.
+// Decode, bind, and validation errors are responded to with the applications Error as an *HTTPError, using
+// 415 Unsupported Media Type for bodies without a matching codec and 400 Bad Request otherwise.
+//
+// The context given to fn includes the response header, see ResponseHeader. The output value is responded to
+// with the applications Respond. Errors returned from fn are responded to with the applications Error as an
+// *HTTPError, see AsHTTPError. If the error has no status of its own, errors wrapping ErrNotFound or
+// ErrMethodNotAllowed use 404 Not Found and 405 Method Not Allowed, errors wrapping context.DeadlineExceeded
+// use 503 Service Unavailable, and any other error uses 500 Internal Server Error.
+//
+// Handle must only be used for route handlers of a server.
+func Handle[In, Out any](fn HandleFunc[In, Out]) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		app := requestApplication(req)
+		if app == nil {
+			panic(errors.New("luci: Handle has not been called with an application"))
+		}
+
+		var in In
+
+		err := decodeBody(req, &in)
+		if err != nil {
+			httpErr := AsHTTPError(err, http.StatusBadRequest)
+			app.Error(rw, req, httpErr.Status, httpErr)
+
+			return
+		}
+
+		if reflect.TypeFor[In]().Kind() == reflect.Struct {
+			err = Bind(req, &in)
+			if err != nil {
+				app.Error(rw, req, http.StatusBadRequest, AsHTTPError(err, http.StatusBadRequest))
+				return
+			}
+		}
+
+		err = Validate(&in)
+		if err != nil {
+			httpErr := AsHTTPError(err, http.StatusBadRequest)
+			app.Error(rw, req, httpErr.Status, httpErr)
+
+			return
+		}
+
+		ctx := context.WithValue(req.Context(), responseHeaderKey{}, rw.Header())
+
+		out, err := fn(ctx, in)
+		if err != nil {
+			httpErr := handleError(err)
+			app.Error(rw, req, httpErr.Status, httpErr)
+
+			return
+		}
+
+		app.Respond(rw, req, out)
+	}
+}
+
+func decodeBody(req *http.Request, dst any) error {
+	contentType := req.Header.Get("Content-Type")
+	if !hasBody(req) || contentType == "" {
+		return nil
+	}
+
+	// Form bodies are bound by Bind using the form struct tag.
+	switch parseMediaType(contentType) {
+	case "application/x-www-form-urlencoded", "multipart/form-data":
+		return nil
+	}
+
+	return Decode(req, dst)
+}
+
+func handleError(err error) *HTTPError {
+	status := http.StatusInternalServerError
+
+	switch {
+	case errors.Is(err, ErrNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrMethodNotAllowed):
+		status = http.StatusMethodNotAllowed
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, http.ErrHandlerTimeout):
+		status = http.StatusServiceUnavailable
+	}
+
+	return AsHTTPError(err, status)
+}
@@ -0,0 +1,203 @@
+package luci
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type handleInput struct {
+	Key  string `path:"key"`
+	Name string `json:"name"`
+	Page int    `query:"page"`
+}
+
+func (in handleInput) Validate() error {
+	if in.Name == "invalid" {
+		return errors.New("name is invalid")
+	}
+
+	return nil
+}
+
+type handleStatusError struct{}
+
+func (handleStatusError) Error() string {
+	return "teapot"
+}
+
+func (handleStatusError) StatusCode() int {
+	return http.StatusTeapot
+}
+
+func TestHandle(t *testing.T) {
+	t.Parallel()
+
+	t.Run("decodes, binds, and responds with the output", func(t *testing.T) {
+		t.Parallel()
+
+		var app TestApplication
+
+		recorder := httptest.NewRecorder()
+		request := testRequest(t.Context(), http.MethodPost, "/user/abc?page=2", strings.NewReader(`{"name":"luci"}`), http.Header{
+			"Content-Type": []string{"application/json; charset=utf-8"},
+		}, &requestState{app: &app, vars: map[string]string{"key": "abc"}})
+		app.On("Respond", recorder, mock.Anything, "abc luci 2")
+
+		handler := Handle(func(_ context.Context, in handleInput) (string, error) {
+			return fmt.Sprintf("%s %s %d", in.Key, in.Name, in.Page), nil
+		})
+		handler(recorder, request)
+
+		app.AssertExpectations(t)
+	})
+
+	t.Run("includes the response header in the context", func(t *testing.T) {
+		t.Parallel()
+
+		var app TestApplication
+
+		recorder := httptest.NewRecorder()
+		request := testRequest(t.Context(), http.MethodPost, "/user/abc?page=2", strings.NewReader(`{"name":"luci"}`), http.Header{
+			"Content-Type": []string{"application/json; charset=utf-8"},
+		}, &requestState{app: &app, vars: map[string]string{"key": "abc"}})
+		app.On("Respond", recorder, mock.Anything, "luci")
+
+		handler := Handle(func(ctx context.Context, in handleInput) (string, error) {
+			SetETag(ResponseHeader(ctx), "abc", false)
+			return in.Name, nil
+		})
+		handler(recorder, request)
+
+		app.AssertExpectations(t)
+		assert.Equal(t, `"abc"`, recorder.Header().Get("ETag"))
+	})
+
+	t.Run("responds with bad request if the body can't be decoded", func(t *testing.T) {
+		t.Parallel()
+
+		var app TestApplication
+
+		recorder := httptest.NewRecorder()
+		request := testRequest(t.Context(), http.MethodPost, "/user/abc?page=2", strings.NewReader(`{"name":`), http.Header{
+			"Content-Type": []string{"application/json; charset=utf-8"},
+		}, &requestState{app: &app, vars: map[string]string{"key": "abc"}})
+		app.On("Error", recorder, mock.Anything, http.StatusBadRequest, mock.Anything)
+
+		handler := Handle(func(_ context.Context, _ handleInput) (string, error) {
+			assert.Fail(t, "handler should not be called")
+			return "", nil
+		})
+		handler(recorder, request)
+
+		app.AssertExpectations(t)
+	})
+
+	t.Run("responds with unsupported media type if the body has no codec", func(t *testing.T) {
+		t.Parallel()
+
+		var app TestApplication
+
+		recorder := httptest.NewRecorder()
+		request := testRequest(t.Context(), http.MethodPost, "/user/abc?page=2", strings.NewReader(`name`), http.Header{
+			"Content-Type": []string{"application/json; charset=utf-8"},
+		}, &requestState{app: &app, vars: map[string]string{"key": "abc"}})
+		request.Header.Set("Content-Type", "image/png")
+		app.On("Error", recorder, mock.Anything, http.StatusUnsupportedMediaType, UnsupportedMediaType(ErrUnsupportedMediaType))
+
+		handler := Handle(func(_ context.Context, _ handleInput) (string, error) {
+			assert.Fail(t, "handler should not be called")
+			return "", nil
+		})
+		handler(recorder, request)
+
+		app.AssertExpectations(t)
+	})
+
+	t.Run("responds with bad request if the value fails validation", func(t *testing.T) {
+		t.Parallel()
+
+		var app TestApplication
+
+		recorder := httptest.NewRecorder()
+		request := testRequest(t.Context(), http.MethodPost, "/user/abc?page=2", strings.NewReader(`{"name":"invalid"}`), http.Header{
+			"Content-Type": []string{"application/json; charset=utf-8"},
+		}, &requestState{app: &app, vars: map[string]string{"key": "abc"}})
+		app.On("Error", recorder, mock.Anything, http.StatusBadRequest, mock.Anything).Run(func(args mock.Arguments) {
+			err, _ := args.Get(3).(error)
+			assert.EqualError(t, err, "luci: validate: name is invalid")
+		})
+
+		handler := Handle(func(_ context.Context, _ handleInput) (string, error) {
+			assert.Fail(t, "handler should not be called")
+			return "", nil
+		})
+		handler(recorder, request)
+
+		app.AssertExpectations(t)
+	})
+
+	t.Run("responds with error status based on the returned error", func(t *testing.T) {
+		t.Parallel()
+
+		tests := []struct {
+			err    error
+			status int
+		}{
+			{err: handleStatusError{}, status: http.StatusTeapot},
+			{err: fmt.Errorf("wrapped: %w", handleStatusError{}), status: http.StatusTeapot},
+			{err: fmt.Errorf("user: %w", ErrNotFound), status: http.StatusNotFound},
+			{err: ErrMethodNotAllowed, status: http.StatusMethodNotAllowed},
+			{err: context.DeadlineExceeded, status: http.StatusServiceUnavailable},
+			{err: errors.New("failure"), status: http.StatusInternalServerError},
+			{err: Conflict(errors.New("conflict")), status: http.StatusConflict},
+			{err: fmt.Errorf("wrapped: %w", Forbidden(nil)), status: http.StatusForbidden},
+		}
+
+		for _, test := range tests {
+			var app TestApplication
+
+			recorder := httptest.NewRecorder()
+			request := testRequest(t.Context(), http.MethodPost, "/user/abc?page=2", strings.NewReader(`{}`), http.Header{
+				"Content-Type": []string{"application/json; charset=utf-8"},
+			}, &requestState{app: &app, vars: map[string]string{"key": "abc"}})
+			expected := NewHTTPError(test.status, test.err)
+
+			var httpErr *HTTPError
+			if errors.As(test.err, &httpErr) {
+				expected = httpErr
+			}
+
+			app.On("Error", recorder, mock.Anything, test.status, expected)
+
+			handler := Handle(func(_ context.Context, _ handleInput) (string, error) {
+				return "", test.err
+			})
+			handler(recorder, request)
+
+			app.AssertExpectations(t)
+		}
+	})
+
+	t.Run("panics if no application is associated with the request", func(t *testing.T) {
+		t.Parallel()
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/user/abc", nil)
+
+		handler := Handle(func(_ context.Context, _ handleInput) (string, error) {
+			return "", nil
+		})
+
+		assert.PanicsWithError(t, "luci: Handle has not been called with an application", func() {
+			handler(recorder, request)
+		})
+	})
+}
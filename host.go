@@ -0,0 +1,250 @@
+package luci
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// hostPattern matches request hosts against a route's host pattern, and builds hosts from it.
+type hostPattern struct {
+	regexp *regexp.Regexp
+	parts  []hostPart
+	names  []string
+}
+
+// hostPart is either a literal part of a host pattern, or a variable with its matcher.
+type hostPart struct {
+	literal string
+	name    string
+	matcher *regexp.Regexp
+}
+
+// hostRoute is a route's handler along with the host pattern it's restricted to, if any, and
+// the CORS configuration and path policy used for requests the route matches.
+type hostRoute struct {
+	host    *hostPattern
+	handler http.Handler
+	cors    *cors
+	policy  PathPolicy
+}
+
+// hostRoutes are the routes registered for each pattern and method, in the order they're defined.
+type hostRoutes map[patternMethod][]hostRoute
+
+// parseHostPattern parses a host pattern, whose variables use the same syntax as route patterns.
+// Variables without a regex match a single label.
+func parseHostPattern(pattern string) (*hostPattern, error) {
+	var (
+		host    hostPattern
+		builder strings.Builder
+	)
+
+	_, _ = builder.WriteString("^")
+
+	for pattern != "" {
+		start := strings.IndexByte(pattern, '{')
+		if start == -1 {
+			start = len(pattern)
+		}
+
+		if start != 0 {
+			literal := strings.ToLower(pattern[:start])
+			if strings.ContainsAny(literal, "}/") {
+				return nil, errors.New("luci: invalid host pattern")
+			}
+
+			host.parts = append(host.parts, hostPart{literal: literal})
+			_, _ = builder.WriteString(regexp.QuoteMeta(literal))
+			pattern = pattern[start:]
+
+			continue
+		}
+
+		end := patternVarEnd(pattern)
+		if end == -1 {
+			return nil, errors.New("luci: invalid host pattern")
+		}
+
+		name, matcherStr, _ := strings.Cut(pattern[1:end], ":")
+		if name == "" {
+			return nil, errors.New("luci: host variable must have a name")
+		}
+
+		if matcherStr == "" {
+			matcherStr = "[^.]+"
+		}
+
+		matcher, err := regexp.Compile("^(?:" + matcherStr + ")$")
+		if err != nil {
+			return nil, fmt.Errorf(`luci: host variable "%s" must have valid regex: %w`, name, err)
+		}
+
+		host.parts = append(host.parts, hostPart{name: name, matcher: matcher})
+		host.names = append(host.names, name)
+		_, _ = builder.WriteString("(?P<v" + strconv.Itoa(len(host.names)-1) + ">" + matcherStr + ")")
+		pattern = pattern[end+1:]
+	}
+
+	_, _ = builder.WriteString("$")
+
+	compiled, err := regexp.Compile(builder.String())
+	if err != nil {
+		return nil, fmt.Errorf("luci: invalid host pattern: %w", err)
+	}
+
+	host.regexp = compiled
+
+	return &host, nil
+}
+
+// patternVarEnd returns the index of the brace closing the variable at the start of the
+// pattern, allowing for braces in the variables regex, or -1 if the variable isn't closed.
+func patternVarEnd(pattern string) int {
+	var depth int
+
+	for idx := range len(pattern) {
+		switch pattern[idx] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return idx
+			}
+		}
+	}
+
+	return -1
+}
+
+// match returns the variable values for the host, and whether the host matches the pattern.
+// The host's port is ignored.
+func (host *hostPattern) match(hostname string) ([]string, bool) {
+	name, _, err := net.SplitHostPort(hostname)
+	if err == nil {
+		hostname = name
+	}
+
+	matches := host.regexp.FindStringSubmatch(strings.ToLower(hostname))
+	if matches == nil {
+		return nil, false
+	}
+
+	vals := make([]string, len(host.names))
+	for idx := range host.names {
+		vals[idx] = matches[host.regexp.SubexpIndex("v"+strconv.Itoa(idx))]
+	}
+
+	return vals, true
+}
+
+// build builds a host from the pattern using the given variable values, in the order defined by the pattern.
+func (host *hostPattern) build(vals []string) (string, error) {
+	if len(vals) != len(host.names) {
+		return "", fmt.Errorf("luci: must provide the expected number of host values (expected %d received %d)", len(host.names), len(vals))
+	}
+
+	var (
+		valIndex int
+		builder  strings.Builder
+	)
+
+	for _, part := range host.parts {
+		if part.matcher == nil {
+			_, _ = builder.WriteString(part.literal)
+			continue
+		}
+
+		val := vals[valIndex]
+		if !part.matcher.MatchString(val) {
+			return "", fmt.Errorf(`luci: value for host variable "%s" does not match regex`, part.name)
+		}
+
+		_, _ = builder.WriteString(val)
+		valIndex++
+	}
+
+	return builder.String(), nil
+}
+
+// lookup returns the route for the pattern and method that matches the host, falling back to the
+// routes for any method if there are none for the method.
+func (routes hostRoutes) lookup(pattern, method, hostname string) (hostRoute, bool) {
+	byHost, ok := routes[patternMethod{pattern: pattern, method: method}]
+	if !ok {
+		byHost = routes[patternMethod{pattern: pattern}]
+	}
+
+	route, _, ok := matchHostRoute(byHost, hostname)
+
+	return route, ok
+}
+
+// registered returns whether a route is registered for the pattern and method, for any host.
+func (routes hostRoutes) registered(pattern, method string) bool {
+	_, ok := routes[patternMethod{pattern: pattern, method: method}]
+	if !ok {
+		_, ok = routes[patternMethod{pattern: pattern}]
+	}
+
+	return ok
+}
+
+// hostHandler dispatches requests to the route matching the request's host, adding the host's
+// variables to the route context. See matchHostRoute for how the route is chosen.
+func hostHandler(notFound http.Handler, routes []hostRoute) http.Handler {
+	if len(routes) == 1 && routes[0].host == nil {
+		return routes[0].handler
+	}
+
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		route, vals, ok := matchHostRoute(routes, req.Host)
+		if !ok {
+			notFound.ServeHTTP(rw, req)
+			return
+		}
+
+		if route.host != nil {
+			rctx := chi.RouteContext(req.Context())
+			for idx, name := range route.host.names {
+				rctx.URLParams.Add(name, vals[idx])
+			}
+		}
+
+		route.handler.ServeHTTP(rw, req)
+	})
+}
+
+// matchHostRoute returns the first route whose host pattern matches the host, along with the
+// host's variable values. Routes without a host pattern match any host, but only if no route
+// with a host pattern matches.
+func matchHostRoute(routes []hostRoute, hostname string) (hostRoute, []string, bool) {
+	var (
+		fallback hostRoute
+		found    bool
+	)
+
+	for _, route := range routes {
+		if route.host == nil {
+			if !found {
+				fallback, found = route, true
+			}
+
+			continue
+		}
+
+		vals, ok := route.host.match(hostname)
+		if ok {
+			return route, vals, true
+		}
+	}
+
+	return fallback, nil, found
+}
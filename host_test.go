@@ -0,0 +1,122 @@
+package luci
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseHostPattern(t *testing.T) {
+	t.Parallel()
+
+	host, err := parseHostPattern("{tenant}.api.{domain:[a-z]+\\.(com|dev)}")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"tenant", "domain"}, host.names)
+
+	tests := []struct {
+		pattern     string
+		expectedErr string
+	}{
+		{pattern: "{tenant.api.luci.dev", expectedErr: "luci: invalid host pattern"},
+		{pattern: "tenant}.api.luci.dev", expectedErr: "luci: invalid host pattern"},
+		{pattern: "api.luci.dev/path", expectedErr: "luci: invalid host pattern"},
+		{pattern: "{}.api.luci.dev", expectedErr: "luci: host variable must have a name"},
+		{pattern: "{tenant:[a-z}.api.luci.dev", expectedErr: `luci: host variable "tenant" must have valid regex`},
+	}
+
+	for _, test := range tests {
+		_, err := parseHostPattern(test.pattern)
+		assert.ErrorContains(t, err, test.expectedErr, test.pattern)
+	}
+}
+
+func TestHostPatternMatch(t *testing.T) {
+	t.Parallel()
+
+	host, err := parseHostPattern("{tenant}.API.{domain:[a-z]+\\.(com|dev)}")
+	assert.NoError(t, err)
+
+	tests := []struct {
+		host     string
+		expected []string
+		ok       bool
+	}{
+		{host: "acme.api.luci.dev", expected: []string{"acme", "luci.dev"}, ok: true},
+		{host: "ACME.api.luci.dev:8080", expected: []string{"acme", "luci.dev"}, ok: true},
+		{host: "a.b.api.luci.dev", ok: false},
+		{host: "api.luci.dev", ok: false},
+		{host: "acme.api.luci.org", ok: false},
+	}
+
+	for _, test := range tests {
+		vals, ok := host.match(test.host)
+		assert.Equal(t, test.ok, ok, test.host)
+		assert.Equal(t, test.expected, vals, test.host)
+	}
+}
+
+func TestHostPatternBuild(t *testing.T) {
+	t.Parallel()
+
+	host, err := parseHostPattern("{tenant}.api.{domain:[a-z]+\\.dev}")
+	assert.NoError(t, err)
+
+	built, err := host.build([]string{"acme", "luci.dev"})
+	assert.NoError(t, err)
+	assert.Equal(t, "acme.api.luci.dev", built)
+
+	_, err = host.build([]string{"acme"})
+	assert.Equal(t, errors.New("luci: must provide the expected number of host values (expected 2 received 1)"), err)
+
+	_, err = host.build([]string{"a.b", "luci.dev"})
+	assert.Equal(t, errors.New(`luci: value for host variable "tenant" does not match regex`), err)
+}
+
+func TestHostHandler(t *testing.T) {
+	t.Parallel()
+
+	tenant, err := parseHostPattern("{tenant}.api.luci.dev")
+	assert.NoError(t, err)
+
+	admin, err := parseHostPattern("admin.api.luci.dev")
+	assert.NoError(t, err)
+
+	respond := func(name string) http.Handler {
+		return withRequestState(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			_, _ = rw.Write([]byte(name + " " + Var(req, "tenant")))
+		}))
+	}
+
+	notFound := http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		rw.WriteHeader(http.StatusNotFound)
+	})
+
+	request := func(handler http.Handler, host string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/status", nil)
+		request.Host = host
+
+		mux := chi.NewMux()
+		mux.Method(http.MethodGet, "/status", handler)
+		mux.ServeHTTP(recorder, request)
+
+		return recorder
+	}
+
+	handler := hostHandler(notFound, []hostRoute{
+		{handler: respond("any")},
+		{host: admin, handler: respond("admin")},
+		{host: tenant, handler: respond("tenant")},
+	})
+
+	assert.Equal(t, "admin ", request(handler, "admin.api.luci.dev").Body.String())
+	assert.Equal(t, "tenant acme", request(handler, "acme.api.luci.dev").Body.String())
+	assert.Equal(t, "any ", request(handler, "luci.dev").Body.String())
+
+	handler = hostHandler(notFound, []hostRoute{{host: tenant, handler: respond("tenant")}})
+	assert.Equal(t, http.StatusNotFound, request(handler, "luci.dev").Code)
+}
@@ -2,7 +2,6 @@ package luci
 
 import (
 	"bufio"
-	"context"
 	"crypto/rand"
 	"fmt"
 	"net/http"
@@ -15,47 +14,212 @@ var (
 	entropy = &ulid.LockedMonotonicReader{
 		MonotonicReader: ulid.Monotonic(reader, 0),
 	}
+
+	// DefaultRequestIDConfig is the base configuration that's used for request IDs when creating a server.
+	DefaultRequestIDConfig = RequestIDConfig{
+		Generator: ULIDGenerator{},
+		Trust:     IDTrustAlways,
+		Headers:   []string{"Request-Id", "X-Request-Id"},
+		MaxLength: 64,
+		Charset:   "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_.:",
+	}
 )
 
-type idKey struct{}
+// IDTrust defines whether request IDs supplied by clients are used as the request's ID.
+type IDTrust int
+
+const (
+	// IDTrustAlways uses request IDs supplied by any client.
+	IDTrustAlways IDTrust = iota
+	// IDTrustProxies only uses request IDs supplied by the servers trusted proxies.
+	IDTrustProxies
+	// IDTrustNever always generates request IDs.
+	IDTrustNever
+)
+
+// IDGenerator generates unique request IDs.
+type IDGenerator interface {
+	// NewID returns a new unique ID.
+	NewID() (string, error)
+}
+
+// IDGeneratorFunc is an adapter to allow the use of ordinary functions as an IDGenerator.
+type IDGeneratorFunc func() (string, error)
+
+// NewID calls fn().
+func (fn IDGeneratorFunc) NewID() (string, error) {
+	return fn()
+}
+
+// ULIDGenerator generates monotonic ULIDs.
+type ULIDGenerator struct{}
+
+// NewID returns a new ULID.
+func (ULIDGenerator) NewID() (string, error) {
+	id, err := ulid.New(ulid.Now(), entropy)
+	if err != nil {
+		return "", fmt.Errorf("luci: ulid: %w", err)
+	}
+
+	return id.String(), nil
+}
+
+// UUIDv4Generator generates random UUIDs.
+type UUIDv4Generator struct{}
+
+// NewID returns a new version 4 UUID.
+func (UUIDv4Generator) NewID() (string, error) {
+	id, err := NewUUIDv4()
+	if err != nil {
+		return "", err
+	}
+
+	return id.String(), nil
+}
+
+// UUIDv7Generator generates UUIDs that sort by creation time.
+type UUIDv7Generator struct{}
+
+// NewID returns a new version 7 UUID.
+func (UUIDv7Generator) NewID() (string, error) {
+	id, err := NewUUIDv7()
+	if err != nil {
+		return "", err
+	}
+
+	return id.String(), nil
+}
+
+// RequestIDConfig defines how request IDs are generated, and when request IDs supplied by clients are used.
+// See DefaultRequestIDConfig for configuration defaults.
+type RequestIDConfig struct {
+	// Generator defines how request IDs are generated if the request doesn't have a usable ID.
+	Generator IDGenerator
+	// Trust defines whether request IDs supplied by clients are used.
+	Trust IDTrust
+	// Headers defines the request headers checked in order for a request ID supplied by the client,
+	// the first valid ID is used.
+	// The request ID is set on the response using each of the headers.
+	Headers []string
+	// MaxLength defines the maximum length of request IDs supplied by clients,
+	// longer IDs aren't used.
+	MaxLength int
+	// Charset defines the characters request IDs supplied by clients may contain,
+	// IDs with any other characters aren't used.
+	Charset string
+	// PreserveClientID defines whether a valid request ID supplied by the client that isn't used
+	// as the request's ID, because the client isn't trusted, is logged as client_request_id.
+	PreserveClientID bool
+}
 
 // ID returns the unique identifier associated with the request.
 func ID(req *http.Request) string {
-	id, _ := req.Context().Value(idKey{}).(string)
-	return id
+	state := requestStateFrom(req)
+	if state == nil {
+		return ""
+	}
+
+	return state.id
 }
 
-func withID(errorHandler ErrorHandlerFunc) Middleware {
+func withID(errorHandler ErrorHandlerFunc, config RequestIDConfig, proxies trustedProxies) Middleware {
+	var charset [256]bool
+	for idx := range len(config.Charset) {
+		charset[config.Charset[idx]] = true
+	}
+
+	valid := func(id string) bool {
+		if id == "" || len(id) > config.MaxLength {
+			return false
+		}
+
+		for idx := range len(id) {
+			if !charset[id[idx]] {
+				return false
+			}
+		}
+
+		return true
+	}
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
-			xKey := "X-Request-Id"
-			key := "Request-Id"
+			var clientID string
 
-			xID := req.Header.Get(xKey)
+			// Headers with an invalid ID are skipped, so a later header's valid ID may be used.
+			for _, key := range config.Headers {
+				id := req.Header.Get(key)
+				if valid(id) {
+					clientID = id
+					break
+				}
+			}
 
-			id := req.Header.Get(key)
-			if id == "" {
-				id = xID
+			var trusted bool
+
+			switch config.Trust {
+			case IDTrustAlways:
+				trusted = true
+			case IDTrustProxies:
+				trusted = proxies.trusts(req.RemoteAddr)
+			case IDTrustNever:
+				trusted = false
 			}
 
-			if id == "" {
-				now := ulid.Now()
+			state := requestStateFrom(req)
 
-				ulid, err := ulid.New(now, entropy)
+			id := clientID
+			if !trusted || id == "" {
+				generated, err := config.Generator.NewID()
 				if err != nil {
-					errorHandler(rw, req, http.StatusInternalServerError, fmt.Errorf("luci: id generate: %w", err))
+					errorHandler(rw, req, http.StatusInternalServerError, InternalServerError(fmt.Errorf("luci: id generate: %w", err)))
 					return
 				}
 
-				id = ulid.String()
+				id = generated
+
+				if config.PreserveClientID {
+					state.clientID = clientID
+				}
 			}
 
 			header := rw.Header()
-			header.Set(xKey, id)
-			header.Set(key, id)
+			for _, key := range config.Headers {
+				header.Set(key, id)
+			}
 
-			newReq := req.WithContext(context.WithValue(req.Context(), idKey{}, id))
-			next.ServeHTTP(rw, newReq)
+			state.id = id
+			next.ServeHTTP(rw, req)
 		})
 	}
 }
+
+func buildRequestIDConfig(config RequestIDConfig) RequestIDConfig {
+	built := DefaultRequestIDConfig
+
+	if config.Generator != nil {
+		built.Generator = config.Generator
+	}
+
+	if config.Trust != IDTrustAlways {
+		built.Trust = config.Trust
+	}
+
+	if len(config.Headers) != 0 {
+		built.Headers = config.Headers
+	}
+
+	if config.MaxLength != 0 {
+		built.MaxLength = config.MaxLength
+	}
+
+	if config.Charset != "" {
+		built.Charset = config.Charset
+	}
+
+	if config.PreserveClientID {
+		built.PreserveClientID = true
+	}
+
+	return built
+}
@@ -1,13 +1,16 @@
 package luci
 
 import (
-	"context"
+	"errors"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/oklog/ulid/v2"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 )
 
 func TestID(t *testing.T) {
@@ -16,7 +19,7 @@ func TestID(t *testing.T) {
 	id := "luci"
 
 	request := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/status", nil)
-	request = request.WithContext(context.WithValue(request.Context(), idKey{}, id))
+	request = withState(request, &requestState{id: id})
 
 	assert.Equal(t, id, ID(request))
 }
@@ -27,9 +30,9 @@ func TestWithID(t *testing.T) {
 	t.Run("sets id to Request-Id in request header", func(t *testing.T) {
 		t.Parallel()
 
-		handler := withID(nil)(http.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
+		handler := withRequestState(withID(nil, DefaultRequestIDConfig, nil)(http.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
 			assert.Equal(t, "luci", ID(req))
-		}))
+		})))
 
 		recorder := httptest.NewRecorder()
 		request := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/status", nil)
@@ -41,9 +44,9 @@ func TestWithID(t *testing.T) {
 	t.Run("sets id to X-Request-Id in request header", func(t *testing.T) {
 		t.Parallel()
 
-		handler := withID(nil)(http.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
+		handler := withRequestState(withID(nil, DefaultRequestIDConfig, nil)(http.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
 			assert.Equal(t, "luci", ID(req))
-		}))
+		})))
 
 		recorder := httptest.NewRecorder()
 		request := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/status", nil)
@@ -55,9 +58,9 @@ func TestWithID(t *testing.T) {
 	t.Run("sets id to Request-Id over X-Request-Id if both exist in request header", func(t *testing.T) {
 		t.Parallel()
 
-		handler := withID(nil)(http.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
+		handler := withRequestState(withID(nil, DefaultRequestIDConfig, nil)(http.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
 			assert.Equal(t, "luci", ID(req))
-		}))
+		})))
 
 		recorder := httptest.NewRecorder()
 		request := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/status", nil)
@@ -67,16 +70,31 @@ func TestWithID(t *testing.T) {
 		handler.ServeHTTP(recorder, request)
 	})
 
+	t.Run("sets id to X-Request-Id if Request-Id is invalid", func(t *testing.T) {
+		t.Parallel()
+
+		handler := withRequestState(withID(nil, DefaultRequestIDConfig, nil)(http.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
+			assert.Equal(t, "luci", ID(req))
+		})))
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/status", nil)
+		request.Header.Set("Request-Id", "invalid id")
+		request.Header.Set("X-Request-Id", "luci")
+
+		handler.ServeHTTP(recorder, request)
+	})
+
 	t.Run("sets id to valid ULID if no id is provided", func(t *testing.T) {
 		t.Parallel()
 
-		handler := withID(nil)(http.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
+		handler := withRequestState(withID(nil, DefaultRequestIDConfig, nil)(http.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
 			id := ID(req)
 			assert.NotEmpty(t, id)
 
 			_, err := ulid.ParseStrict(id)
 			assert.NoError(t, err)
-		}))
+		})))
 
 		recorder := httptest.NewRecorder()
 		handler.ServeHTTP(recorder, httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/status", nil))
@@ -85,9 +103,9 @@ func TestWithID(t *testing.T) {
 	t.Run("adds Request-Id and X-Request-Id to response header", func(t *testing.T) {
 		t.Parallel()
 
-		handler := withID(nil)(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		handler := withRequestState(withID(nil, DefaultRequestIDConfig, nil)(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
 			rw.WriteHeader(http.StatusOK)
-		}))
+		})))
 
 		recorder := httptest.NewRecorder()
 		request := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/status", nil)
@@ -98,4 +116,162 @@ func TestWithID(t *testing.T) {
 		assert.Equal(t, "luci", recorder.Header().Get("Request-Id"))
 		assert.Equal(t, "luci", recorder.Header().Get("X-Request-Id"))
 	})
+
+	t.Run("only uses valid ids from trusted clients", func(t *testing.T) {
+		t.Parallel()
+
+		request := func(config RequestIDConfig, remoteAddr, id string) string {
+			var got string
+
+			proxies, err := parseTrustedProxies([]string{"10.0.0.0/8"})
+			assert.NoError(t, err)
+
+			handler := withRequestState(withID(nil, config, proxies)(http.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
+				got = ID(req)
+			})))
+
+			request := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/status", nil)
+			request.RemoteAddr = remoteAddr
+			request.Header.Set("Request-Id", id)
+
+			handler.ServeHTTP(httptest.NewRecorder(), request)
+
+			return got
+		}
+
+		config := buildRequestIDConfig(RequestIDConfig{})
+		assert.Equal(t, "luci", request(config, "192.0.2.1:1234", "luci"))
+		assert.NotEqual(t, "luci id", request(config, "192.0.2.1:1234", "luci id"))
+		assert.NotEqual(t, strings.Repeat("a", 65), request(config, "192.0.2.1:1234", strings.Repeat("a", 65)))
+
+		config = buildRequestIDConfig(RequestIDConfig{Trust: IDTrustProxies})
+		assert.Equal(t, "luci", request(config, "10.0.0.1:1234", "luci"))
+		assert.NotEqual(t, "luci", request(config, "192.0.2.1:1234", "luci"))
+
+		config = buildRequestIDConfig(RequestIDConfig{Trust: IDTrustNever})
+		assert.NotEqual(t, "luci", request(config, "10.0.0.1:1234", "luci"))
+
+		config = buildRequestIDConfig(RequestIDConfig{MaxLength: 3, Charset: "luci"})
+		assert.NotEqual(t, "luci", request(config, "192.0.2.1:1234", "luci"))
+		assert.Equal(t, "lui", request(config, "192.0.2.1:1234", "lui"))
+		assert.NotEqual(t, "lux", request(config, "192.0.2.1:1234", "lux"))
+	})
+
+	t.Run("uses configured headers and generator", func(t *testing.T) {
+		t.Parallel()
+
+		config := buildRequestIDConfig(RequestIDConfig{
+			Generator: IDGeneratorFunc(func() (string, error) {
+				return "generated", nil
+			}),
+			Headers: []string{"Correlation-Id"},
+		})
+
+		handler := withRequestState(withID(nil, config, nil)(http.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
+			assert.Equal(t, "generated", ID(req))
+		})))
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/status", nil)
+		request.Header.Set("Request-Id", "luci")
+
+		handler.ServeHTTP(recorder, request)
+
+		assert.Equal(t, "generated", recorder.Header().Get("Correlation-Id"))
+		assert.Empty(t, recorder.Header().Get("Request-Id"))
+	})
+
+	t.Run("preserves untrusted client id", func(t *testing.T) {
+		t.Parallel()
+
+		config := buildRequestIDConfig(RequestIDConfig{Trust: IDTrustNever, PreserveClientID: true})
+
+		handler := withRequestState(withID(nil, config, nil)(http.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
+			state := requestStateFrom(req)
+			assert.NotEqual(t, "luci", state.id)
+			assert.Equal(t, "luci", state.clientID)
+			assert.Contains(t, state.requestAttrs(state.urlParams()), slog.String("client_request_id", "luci"))
+		})))
+
+		request := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/status", nil)
+		request.Header.Set("Request-Id", "luci")
+
+		handler.ServeHTTP(httptest.NewRecorder(), request)
+	})
+
+	t.Run("responds with error if id can't be generated", func(t *testing.T) {
+		t.Parallel()
+
+		generateErr := errors.New("generate")
+		config := buildRequestIDConfig(RequestIDConfig{
+			Generator: IDGeneratorFunc(func() (string, error) {
+				return "", generateErr
+			}),
+		})
+
+		var errorMock mock.Mock
+		errorMock.On("Error", http.StatusInternalServerError, mock.Anything).Run(func(args mock.Arguments) {
+			err, _ := args.Get(1).(error)
+			assert.ErrorIs(t, err, generateErr)
+		})
+
+		errorHandler := func(_ http.ResponseWriter, _ *http.Request, status int, err error) {
+			errorMock.MethodCalled("Error", status, err)
+		}
+
+		handler := withRequestState(withID(errorHandler, config, nil)(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+			assert.Fail(t, "handler should not be called")
+		})))
+
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/status", nil))
+
+		errorMock.AssertExpectations(t)
+	})
+}
+
+func TestIDGenerators(t *testing.T) {
+	t.Parallel()
+
+	id, err := ULIDGenerator{}.NewID()
+	assert.NoError(t, err)
+
+	_, err = ulid.ParseStrict(id)
+	assert.NoError(t, err)
+
+	id, err = UUIDv4Generator{}.NewID()
+	assert.NoError(t, err)
+
+	uuid, err := ParseUUID(id)
+	assert.NoError(t, err)
+	assert.Equal(t, byte(0x40), uuid[6]&0xf0)
+
+	id, err = UUIDv7Generator{}.NewID()
+	assert.NoError(t, err)
+
+	uuid, err = ParseUUID(id)
+	assert.NoError(t, err)
+	assert.Equal(t, byte(0x70), uuid[6]&0xf0)
+}
+
+func TestBuildRequestIDConfig(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, DefaultRequestIDConfig, buildRequestIDConfig(RequestIDConfig{}))
+
+	config := buildRequestIDConfig(RequestIDConfig{
+		Generator:        UUIDv4Generator{},
+		Trust:            IDTrustNever,
+		Headers:          []string{"Correlation-Id"},
+		MaxLength:        36,
+		Charset:          "0123456789abcdef-",
+		PreserveClientID: true,
+	})
+	assert.Equal(t, RequestIDConfig{
+		Generator:        UUIDv4Generator{},
+		Trust:            IDTrustNever,
+		Headers:          []string{"Correlation-Id"},
+		MaxLength:        36,
+		Charset:          "0123456789abcdef-",
+		PreserveClientID: true,
+	}, config)
 }
@@ -0,0 +1,123 @@
+package luci
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// inFlight tracks the requests a server is handling. net/http stops tracking hijacked
+// connections, so long lived hijacked requests such as WebSockets register a close function
+// that's called on shutdown, and shutdown waits for every request and background task to finish.
+type inFlight struct {
+	count   atomic.Int64
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	closers map[*inFlightCloser]struct{}
+	closed  bool
+}
+
+type inFlightCloser struct {
+	close func()
+}
+
+func newInFlight() *inFlight {
+	return &inFlight{closers: make(map[*inFlightCloser]struct{})}
+}
+
+func (tracker *inFlight) requests() int64 {
+	return tracker.count.Load()
+}
+
+// track registers a function to close a hijacked request on shutdown, the returned function
+// must be called to stop tracking once the request has been closed. If shutdown has already
+// started the close function is called immediately.
+func (tracker *inFlight) track(closeFunc func()) func() {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	if tracker.closed {
+		go closeFunc()
+		return func() {}
+	}
+
+	closer := &inFlightCloser{close: closeFunc}
+	tracker.closers[closer] = struct{}{}
+
+	return func() {
+		tracker.mu.Lock()
+		defer tracker.mu.Unlock()
+
+		delete(tracker.closers, closer)
+	}
+}
+
+// shutdown calls the close function of every tracked hijacked request.
+func (tracker *inFlight) shutdown() {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	tracker.closed = true
+
+	for closer := range tracker.closers {
+		go closer.close()
+	}
+
+	clear(tracker.closers)
+}
+
+// background runs fn in a goroutine that shutdown waits for, the context passed to fn is
+// cancelled once shutdown starts.
+func (tracker *inFlight) background(ctx context.Context, fn func(ctx context.Context)) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	tracker.wg.Add(1)
+	untrack := tracker.track(cancel)
+
+	go func() {
+		defer func() {
+			untrack()
+			cancel()
+			tracker.wg.Done()
+		}()
+
+		fn(ctx)
+	}()
+}
+
+// wait blocks until every request and background task has finished or the context is done.
+func (tracker *inFlight) wait(ctx context.Context) error {
+	done := make(chan struct{})
+
+	go func() {
+		tracker.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+}
+
+func (tracker *inFlight) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		tracker.wg.Add(1)
+		tracker.count.Add(1)
+
+		defer func() {
+			tracker.count.Add(-1)
+			tracker.wg.Done()
+		}()
+
+		state := requestStateFrom(req)
+		if state != nil {
+			state.inFlight = tracker
+		}
+
+		next.ServeHTTP(rw, req)
+	})
+}
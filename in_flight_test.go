@@ -0,0 +1,95 @@
+package luci
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInFlightMiddleware(t *testing.T) {
+	t.Parallel()
+
+	tracker := newInFlight()
+
+	handler := tracker.middleware(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+		assert.Equal(t, int64(1), tracker.requests())
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/status", nil))
+
+	assert.Equal(t, int64(0), tracker.requests())
+	assert.NoError(t, tracker.wait(t.Context()))
+}
+
+func TestInFlightShutdown(t *testing.T) {
+	t.Parallel()
+
+	tracker := newInFlight()
+
+	closed := make(chan string, 3)
+
+	tracker.track(func() { closed <- "first" })
+	untrack := tracker.track(func() { closed <- "untracked" })
+	untrack()
+
+	tracker.shutdown()
+	assert.Equal(t, "first", <-closed)
+
+	tracker.track(func() { closed <- "after shutdown" })
+	assert.Equal(t, "after shutdown", <-closed)
+
+	select {
+	case value := <-closed:
+		assert.Fail(t, "unexpected close", value)
+	case <-time.After(time.Millisecond * 50):
+	}
+}
+
+func TestInFlightWait(t *testing.T) {
+	t.Parallel()
+
+	tracker := newInFlight()
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	handler := tracker.middleware(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+		close(started)
+		<-release
+	}))
+
+	go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/status", nil))
+	<-started
+
+	ctx, cancel := context.WithTimeout(t.Context(), time.Millisecond*50)
+	defer cancel()
+
+	assert.ErrorIs(t, tracker.wait(ctx), context.DeadlineExceeded)
+
+	close(release)
+	assert.NoError(t, tracker.wait(t.Context()))
+}
+
+func TestInFlightBackground(t *testing.T) {
+	t.Parallel()
+
+	tracker := newInFlight()
+	started := make(chan struct{})
+
+	tracker.background(t.Context(), func(ctx context.Context) {
+		close(started)
+		<-ctx.Done()
+	})
+	<-started
+
+	ctx, cancel := context.WithTimeout(t.Context(), time.Millisecond*50)
+	defer cancel()
+
+	assert.ErrorIs(t, tracker.wait(ctx), context.DeadlineExceeded)
+
+	tracker.shutdown()
+	assert.NoError(t, tracker.wait(t.Context()))
+}
@@ -1,18 +1,19 @@
 package luci
 
 import (
-	"context"
 	"errors"
 	"log/slog"
 	"net/http"
 )
 
-type loggerKey struct{}
-
 // Logger returns the logger associated with the request.
 func Logger(req *http.Request) *slog.Logger {
-	logger, _ := req.Context().Value(loggerKey{}).(*slog.Logger)
-	return logger
+	state := requestStateFrom(req)
+	if state == nil {
+		return nil
+	}
+
+	return state.requestLogger()
 }
 
 func withLogger(serverLogger *slog.Logger) Middleware {
@@ -23,33 +24,53 @@ func withLogger(serverLogger *slog.Logger) Middleware {
 				panic(errors.New("luci: withLogger has not been called with responseWriter"))
 			}
 
-			requestAttrs := []slog.Attr{slog.String("id", ID(req))}
+			state := requestStateFrom(req)
+			state.serverLogger = serverLogger
 
-			name := RequestRoute(req).Name
-			if name != "" {
-				requestAttrs = append(requestAttrs, slog.String("route", name))
+			next.ServeHTTP(wrw, req)
+
+			ctx := req.Context()
+			if !serverLogger.Enabled(ctx, slog.LevelInfo) {
+				return
 			}
 
-			requestVars := Vars(req)
-			if len(requestVars) > 0 {
-				varAttrs := make([]slog.Attr, 0, len(requestVars))
-				for key, value := range requestVars {
-					varAttrs = append(varAttrs, slog.String(key, value))
-				}
+			stats := wrw.stats()
+			responseAttrs := []slog.Attr{
+				slog.String("duration", Duration(req).String()),
+				slog.Int("status", stats.status),
+				slog.Int64("length", stats.length),
+			}
 
-				requestAttrs = append(requestAttrs, slog.GroupAttrs("vars", varAttrs...))
+			if stats.encoding != "" {
+				responseAttrs = append(
+					responseAttrs,
+					slog.String("encoding", stats.encoding),
+					slog.Int64("uncompressed_length", stats.uncompressedLength),
+				)
 			}
 
-			logger := serverLogger.With(slog.GroupAttrs("request", requestAttrs...))
+			if len(stats.informational) > 0 {
+				responseAttrs = append(responseAttrs, slog.Any("informational", stats.informational))
+			}
 
-			newReq := req.WithContext(context.WithValue(req.Context(), loggerKey{}, logger))
-			next.ServeHTTP(wrw, newReq)
+			if len(stats.trailers) > 0 {
+				responseAttrs = append(responseAttrs, slog.Any("trailers", stats.trailers))
+			}
 
-			_, status, length := wrw.stats()
-			responseAttrs := []slog.Attr{
-				slog.String("duration", Duration(req).String()),
-				slog.Int("status", status),
-				slog.Int64("length", length),
+			if stats.hijacked {
+				responseAttrs = append(responseAttrs, slog.Bool("hijacked", true))
+			}
+
+			if stats.events > 0 {
+				responseAttrs = append(responseAttrs, slog.Int64("events", stats.events))
+			}
+
+			if stats.cache != "" {
+				responseAttrs = append(responseAttrs, slog.String("cache", stats.cache))
+			}
+
+			if stats.coalesced {
+				responseAttrs = append(responseAttrs, slog.Bool("coalesced", true))
 			}
 
 			contentType := wrw.Header().Get("Content-Type")
@@ -57,10 +78,15 @@ func withLogger(serverLogger *slog.Logger) Middleware {
 				responseAttrs = append(responseAttrs, slog.String("type", contentType))
 			}
 
-			logger.With(slog.GroupAttrs(
-				"response",
-				responseAttrs...,
-			)).Info("request")
+			// The request's attributes are built from the route context rather than using the
+			// request's logger, so the logger and vars are only built if the handler uses them.
+			serverLogger.LogAttrs(
+				ctx,
+				slog.LevelInfo,
+				"request",
+				slog.GroupAttrs("request", state.requestAttrs(state.urlParams())...),
+				slog.GroupAttrs("response", responseAttrs...),
+			)
 		})
 	}
 }
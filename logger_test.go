@@ -1,7 +1,6 @@
 package luci
 
 import (
-	"context"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -13,7 +12,7 @@ func TestLogger(t *testing.T) {
 	t.Parallel()
 
 	request := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/status", nil)
-	request = request.WithContext(context.WithValue(request.Context(), loggerKey{}, noopLogger))
+	request = withState(request, &requestState{logger: noopLogger})
 
 	assert.Same(t, noopLogger, Logger(request))
 }
@@ -24,9 +23,9 @@ func TestWithLogger(t *testing.T) {
 	t.Run("adds logger to request context", func(t *testing.T) {
 		t.Parallel()
 
-		handler := withLogger(noopLogger)(http.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
+		handler := withRequestState(withLogger(noopLogger)(http.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
 			assert.NotNil(t, Logger(req))
-		}))
+		})))
 
 		rw := &responseWriter{rw: httptest.NewRecorder()}
 		req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/status", nil)
@@ -37,9 +36,9 @@ func TestWithLogger(t *testing.T) {
 	t.Run("panics if response writer has not been wrapped", func(t *testing.T) {
 		t.Parallel()
 
-		handler := withLogger(noopLogger)(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+		handler := withRequestState(withLogger(noopLogger)(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
 			assert.Fail(t, "handler should not be called")
-		}))
+		})))
 
 		rw := httptest.NewRecorder()
 		req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/status", nil)
@@ -0,0 +1,104 @@
+package luci
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+var (
+	// standardMethods are the methods checked when determining the methods allowed for a request,
+	// in the order they're listed in the Allow header.
+	standardMethods = []string{
+		http.MethodGet,
+		http.MethodHead,
+		http.MethodPost,
+		http.MethodPut,
+		http.MethodPatch,
+		http.MethodDelete,
+		http.MethodConnect,
+		http.MethodOptions,
+		http.MethodTrace,
+	}
+)
+
+// allowedMethods returns the methods that have a route matching the request's path and host.
+func allowedMethods(routes chi.Routes, hosted hostRoutes, methods []string, req *http.Request) []string {
+	path := routePath(req)
+
+	var allowed []string
+
+	for _, method := range methods {
+		pattern := routes.Find(chi.NewRouteContext(), method, path)
+		if pattern == "" {
+			continue
+		}
+
+		// Handlers registered without a route, such as automatic OPTIONS handlers, handle any host.
+		_, ok := hosted.lookup(pattern, method, req.Host)
+		if ok || !hosted.registered(pattern, method) {
+			allowed = append(allowed, method)
+		}
+	}
+
+	return allowed
+}
+
+// methodNotAllowedHandler responds with 405 Method Not Allowed, setting the Allow
+// header to the methods that have a route matching the request's path and host.
+func methodNotAllowedHandler(errorHandler ErrorHandlerFunc, routes chi.Routes, hosted hostRoutes, methods []string) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Allow", strings.Join(allowedMethods(routes, hosted, methods, req), ", "))
+		errorHandler(rw, req, http.StatusMethodNotAllowed, MethodNotAllowed(ErrMethodNotAllowed))
+	}
+}
+
+// optionsHandler handles OPTIONS requests for a pattern that doesn't have a route matching OPTIONS.
+// Preflight requests use the CORS configuration of the route for the requested method and host if any
+// route for the pattern and host allows CORS. Otherwise if auto is true the request is responded to with
+// 204 No Content and the Allow header, or 405 Method Not Allowed if not.
+func optionsHandler(
+	errorHandler ErrorHandlerFunc,
+	routes chi.Routes,
+	hosted hostRoutes,
+	methods []string,
+	pattern string,
+	auto bool,
+) http.HandlerFunc {
+	notAllowed := methodNotAllowedHandler(errorHandler, routes, hosted, methods)
+
+	return func(rw http.ResponseWriter, req *http.Request) {
+		if isPreflight(req) && hasCORS(hosted, pattern, methods, req.Host) {
+			route, ok := hosted.lookup(pattern, req.Header.Get("Access-Control-Request-Method"), req.Host)
+			if !ok || route.cors == nil {
+				errorHandler(rw, req, http.StatusForbidden, Forbidden(ErrCORSNotAllowed))
+				return
+			}
+
+			route.cors.preflight(errorHandler, rw, req)
+
+			return
+		}
+
+		if !auto {
+			notAllowed(rw, req)
+			return
+		}
+
+		rw.Header().Set("Allow", strings.Join(allowedMethods(routes, hosted, methods, req), ", "))
+		rw.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// hasCORS returns whether any route for the pattern and host allows CORS.
+func hasCORS(hosted hostRoutes, pattern string, methods []string, hostname string) bool {
+	for _, method := range methods {
+		route, ok := hosted.lookup(pattern, method, hostname)
+		if ok && route.cors != nil {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,161 @@
+package luci
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func methodsMux() *chi.Mux {
+	chi.RegisterMethod("PURGE")
+
+	mux := chi.NewMux()
+	handler := func(http.ResponseWriter, *http.Request) {}
+
+	mux.Get("/user/{id}", handler)
+	mux.Post("/user/{id}", handler)
+	mux.MethodFunc("PURGE", "/user/{id}", handler)
+	mux.Delete("/status", handler)
+
+	return mux
+}
+
+func TestAllowedMethods(t *testing.T) {
+	t.Parallel()
+
+	mux := methodsMux()
+	methods := slices.Concat(standardMethods, []string{"PURGE"})
+
+	request := httptest.NewRequestWithContext(t.Context(), http.MethodPut, "/user/abc", nil)
+	assert.Equal(t, []string{http.MethodGet, http.MethodPost, "PURGE"}, allowedMethods(mux, nil, methods, request))
+
+	request = httptest.NewRequestWithContext(t.Context(), http.MethodPut, "/status", nil)
+	assert.Equal(t, []string{http.MethodDelete}, allowedMethods(mux, nil, methods, request))
+
+	request = httptest.NewRequestWithContext(t.Context(), http.MethodPut, "/other", nil)
+	assert.Empty(t, allowedMethods(mux, nil, methods, request))
+
+	admin, err := parseHostPattern("admin.luci.dev")
+	assert.NoError(t, err)
+
+	hosted := hostRoutes{
+		{pattern: "/user/{id}", method: http.MethodGet}:  {{}},
+		{pattern: "/user/{id}", method: http.MethodPost}: {{host: admin}},
+	}
+
+	request = httptest.NewRequestWithContext(t.Context(), http.MethodPut, "http://luci.dev/user/abc", nil)
+	assert.Equal(t, []string{http.MethodGet, "PURGE"}, allowedMethods(mux, hosted, methods, request))
+
+	request = httptest.NewRequestWithContext(t.Context(), http.MethodPut, "http://admin.luci.dev/user/abc", nil)
+	assert.Equal(t, []string{http.MethodGet, http.MethodPost, "PURGE"}, allowedMethods(mux, hosted, methods, request))
+}
+
+func TestMethodNotAllowedHandler(t *testing.T) {
+	t.Parallel()
+
+	var mock mock.Mock
+	mock.On("Error", http.StatusMethodNotAllowed, MethodNotAllowed(ErrMethodNotAllowed)).Once()
+
+	errorHandler := func(_ http.ResponseWriter, _ *http.Request, status int, err error) {
+		mock.MethodCalled("Error", status, err)
+	}
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequestWithContext(t.Context(), http.MethodPut, "/user/abc", nil)
+
+	methodNotAllowedHandler(errorHandler, methodsMux(), nil, standardMethods)(recorder, request)
+
+	mock.AssertExpectations(t)
+	assert.Equal(t, "GET, POST", recorder.Header().Get("Allow"))
+}
+
+func TestOptionsHandler(t *testing.T) {
+	t.Parallel()
+
+	admin, err := parseHostPattern("admin.luci.dev")
+	assert.NoError(t, err)
+
+	hosted := hostRoutes{
+		{pattern: "/user/{id}", method: http.MethodGet}: {
+			{host: admin},
+			{cors: newCORS(CORSConfig{AllowedOrigins: []string{"*"}}, []string{http.MethodGet, http.MethodPost})},
+		},
+		{pattern: "/user/{id}", method: http.MethodPost}: {{}},
+	}
+
+	request := func(t *testing.T, handler http.HandlerFunc, host, method string) *httptest.ResponseRecorder {
+		t.Helper()
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequestWithContext(t.Context(), http.MethodOptions, "http://"+host+"/user/abc", nil)
+
+		if method != "" {
+			request.Header.Set("Origin", "https://luci.dev")
+			request.Header.Set("Access-Control-Request-Method", method)
+		}
+
+		handler(recorder, request)
+
+		return recorder
+	}
+
+	t.Run("responds to preflight requests", func(t *testing.T) {
+		t.Parallel()
+
+		var mock mock.Mock
+		mock.On("Error", http.StatusMethodNotAllowed, MethodNotAllowed(ErrMethodNotAllowed)).Once()
+		mock.On("Error", http.StatusForbidden, Forbidden(ErrCORSNotAllowed)).Once()
+
+		errorHandler := func(_ http.ResponseWriter, _ *http.Request, status int, err error) {
+			mock.MethodCalled("Error", status, err)
+		}
+
+		handler := optionsHandler(errorHandler, methodsMux(), hosted, standardMethods, "/user/{id}", false)
+
+		assert.Equal(t, http.StatusNoContent, request(t, handler, "luci.dev", http.MethodGet).Code)
+
+		recorder := request(t, handler, "luci.dev", "")
+		assert.Equal(t, "GET, POST", recorder.Header().Get("Allow"))
+
+		request(t, handler, "luci.dev", http.MethodPost)
+
+		mock.AssertExpectations(t)
+	})
+
+	t.Run("uses the CORS configuration of the route for the host", func(t *testing.T) {
+		t.Parallel()
+
+		var mock mock.Mock
+		mock.On("Error", http.StatusMethodNotAllowed, MethodNotAllowed(ErrMethodNotAllowed)).Once()
+
+		errorHandler := func(_ http.ResponseWriter, _ *http.Request, status int, err error) {
+			mock.MethodCalled("Error", status, err)
+		}
+
+		handler := optionsHandler(errorHandler, methodsMux(), hosted, standardMethods, "/user/{id}", false)
+
+		recorder := request(t, handler, "admin.luci.dev", http.MethodGet)
+		assert.Empty(t, recorder.Header().Get("Access-Control-Allow-Origin"))
+
+		mock.AssertExpectations(t)
+	})
+
+	t.Run("responds with the allowed methods", func(t *testing.T) {
+		t.Parallel()
+
+		handler := optionsHandler(nil, methodsMux(), nil, standardMethods, "/user/{id}", true)
+
+		recorder := request(t, handler, "luci.dev", "")
+		assert.Equal(t, http.StatusNoContent, recorder.Code)
+		assert.Equal(t, "GET, POST", recorder.Header().Get("Allow"))
+
+		recorder = request(t, handler, "luci.dev", http.MethodGet)
+		assert.Equal(t, http.StatusNoContent, recorder.Code)
+		assert.Empty(t, recorder.Header().Get("Access-Control-Allow-Origin"))
+	})
+}
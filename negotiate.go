@@ -0,0 +1,195 @@
+package luci
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type codecsKey struct{}
+
+type acceptRange struct {
+	mediaType string
+	quality   float64
+}
+
+// RequestCodecs returns the codecs that are allowed for the request. For server routes these are the
+// servers configured codecs, filtered by the routes ContentTypes if set. Otherwise DefaultCodecs is returned.
+func RequestCodecs(req *http.Request) Codecs {
+	codecs, ok := req.Context().Value(codecsKey{}).(Codecs)
+	if !ok {
+		return DefaultCodecs
+	}
+
+	return codecs
+}
+
+// NegotiateCodec returns the codec to respond with based on the request's Accept header. If no codec is
+// acceptable a 406 Not Acceptable *HTTPError is returned.
+func NegotiateCodec(req *http.Request) (Codec, error) {
+	codec, ok := RequestCodecs(req).Negotiate(strings.Join(req.Header.Values("Accept"), ","))
+	if !ok {
+		return nil, NotAcceptable(ErrNotAcceptable)
+	}
+
+	return codec, nil
+}
+
+// Encode responds with the value using the codec negotiated by NegotiateCodec, setting the Content-Type
+// header and adding Accept to the Vary header. If no codec is acceptable a 406 Not Acceptable *HTTPError
+// is returned without writing anything.
+func Encode(rw http.ResponseWriter, req *http.Request, value any) error {
+	codec, err := NegotiateCodec(req)
+	if err != nil {
+		return err
+	}
+
+	header := rw.Header()
+	addVary(header, "Accept")
+	header.Set("Content-Type", codec.ContentType())
+
+	err = codec.Encode(rw, value)
+	if err != nil {
+		return fmt.Errorf("luci: encode: %w", err)
+	}
+
+	return nil
+}
+
+// Decode decodes the request body into the value pointed to by dst, using the codec matching the
+// request's Content-Type header. If no codec matches a 415 Unsupported Media Type *HTTPError is
+// returned, and if decoding fails a 400 Bad Request *HTTPError is returned.
+func Decode(req *http.Request, dst any) error {
+	codec, ok := RequestCodecs(req).Lookup(req.Header.Get("Content-Type"))
+	if !ok {
+		return UnsupportedMediaType(ErrUnsupportedMediaType)
+	}
+
+	err := codec.Decode(req.Body, dst)
+	if err != nil {
+		return BadRequest(err)
+	}
+
+	return nil
+}
+
+// withCodecs adds the codecs to the request, responding with a 406 Not Acceptable error if none of
+// the codecs are acceptable and a 415 Unsupported Media Type error if the request has a body that none of the
+// codecs decode. Requests accepting event streams are allowed, since they're written by EventStream rather than a codec.
+func withCodecs(errorHandler ErrorHandlerFunc, codecs Codecs) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			accept := strings.Join(req.Header.Values("Accept"), ",")
+
+			_, ok := codecs.Negotiate(accept)
+			if !ok && acceptQuality(parseAccept(accept), "text/event-stream") == 0 {
+				errorHandler(rw, req, http.StatusNotAcceptable, NotAcceptable(ErrNotAcceptable))
+				return
+			}
+
+			if hasBody(req) {
+				_, ok = codecs.Lookup(req.Header.Get("Content-Type"))
+				if !ok {
+					errorHandler(rw, req, http.StatusUnsupportedMediaType, UnsupportedMediaType(ErrUnsupportedMediaType))
+					return
+				}
+			}
+
+			newReq := req.WithContext(context.WithValue(req.Context(), codecsKey{}, codecs))
+			next.ServeHTTP(rw, newReq)
+		})
+	}
+}
+
+func hasBody(req *http.Request) bool {
+	return req.Body != nil && req.Body != http.NoBody && req.ContentLength != 0
+}
+
+func addVary(header http.Header, value string) {
+	for _, vary := range header.Values("Vary") {
+		for field := range strings.SplitSeq(vary, ",") {
+			field = strings.TrimSpace(field)
+			if field == "*" || strings.EqualFold(field, value) {
+				return
+			}
+		}
+	}
+
+	header.Add("Vary", value)
+}
+
+func parseAccept(accept string) []acceptRange {
+	var ranges []acceptRange
+
+	for part := range strings.SplitSeq(accept, ",") {
+		mediaType, params, _ := strings.Cut(part, ";")
+
+		mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+		if mediaType == "" {
+			continue
+		}
+
+		quality := 1.0
+
+		for param := range strings.SplitSeq(params, ";") {
+			key, value, _ := strings.Cut(param, "=")
+			if !strings.EqualFold(strings.TrimSpace(key), "q") {
+				continue
+			}
+
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err == nil && parsed >= 0 && parsed <= 1 {
+				quality = parsed
+			}
+		}
+
+		ranges = append(ranges, acceptRange{mediaType: mediaType, quality: quality})
+	}
+
+	return ranges
+}
+
+// acceptQuality returns the quality of the most specific media range matching the media type.
+func acceptQuality(ranges []acceptRange, mediaType string) float64 {
+	mainType, _, _ := strings.Cut(mediaType, "/")
+
+	var (
+		quality     float64
+		specificity = -1
+	)
+
+	for _, accepted := range ranges {
+		rangeSpecificity := -1
+
+		switch {
+		case accepted.mediaType == mediaType:
+			rangeSpecificity = 2
+		case accepted.mediaType == mainType+"/*":
+			rangeSpecificity = 1
+		case accepted.mediaType == "*/*":
+			rangeSpecificity = 0
+		}
+
+		if rangeSpecificity > specificity {
+			specificity = rangeSpecificity
+			quality = accepted.quality
+		}
+	}
+
+	return quality
+}
+
+func routeCodecs(codecs Codecs, route Route) Codecs {
+	if len(route.ContentTypes) == 0 {
+		return codecs
+	}
+
+	filtered, err := codecs.Filter(route.ContentTypes...)
+	if err != nil {
+		panic(fmt.Errorf(`luci: route "%s": %w`, route.Name, err))
+	}
+
+	return filtered
+}
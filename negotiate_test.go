@@ -0,0 +1,202 @@
+package luci
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestRequestCodecs(t *testing.T) {
+	t.Parallel()
+
+	request := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/status", nil)
+	assert.Equal(t, DefaultCodecs, RequestCodecs(request))
+
+	codecs := Codecs{TextCodec{}}
+	request = request.WithContext(context.WithValue(request.Context(), codecsKey{}, codecs))
+	assert.Equal(t, codecs, RequestCodecs(request))
+}
+
+func TestEncode(t *testing.T) {
+	t.Parallel()
+
+	t.Run("encodes value with negotiated codec", func(t *testing.T) {
+		t.Parallel()
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/status", nil)
+		request.Header.Set("Accept", "text/plain")
+
+		err := Encode(recorder, request, "healthy")
+		assert.NoError(t, err)
+
+		assert.Equal(t, "text/plain; charset=utf-8", recorder.Header().Get("Content-Type"))
+		assert.Equal(t, "Accept", recorder.Header().Get("Vary"))
+		assert.Equal(t, "healthy", recorder.Body.String())
+	})
+
+	t.Run("doesn't duplicate Accept in Vary header", func(t *testing.T) {
+		t.Parallel()
+
+		recorder := httptest.NewRecorder()
+		recorder.Header().Set("Vary", "Origin, accept")
+
+		request := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/status", nil)
+
+		err := Encode(recorder, request, "healthy")
+		assert.NoError(t, err)
+
+		assert.Equal(t, []string{"Origin, accept"}, recorder.Header().Values("Vary"))
+	})
+
+	t.Run("returns not acceptable error if no codec matches", func(t *testing.T) {
+		t.Parallel()
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/status", nil)
+		request.Header.Set("Accept", "image/png")
+
+		err := Encode(recorder, request, "healthy")
+		assert.Equal(t, NotAcceptable(ErrNotAcceptable), err)
+		assert.False(t, recorder.Flushed)
+		assert.Empty(t, recorder.Body.String())
+	})
+}
+
+func TestDecode(t *testing.T) {
+	t.Parallel()
+
+	t.Run("decodes body with matching codec", func(t *testing.T) {
+		t.Parallel()
+
+		request := httptest.NewRequestWithContext(t.Context(), http.MethodPost, "/user", strings.NewReader("luci"))
+		request.Header.Set("Content-Type", "text/plain")
+
+		var value string
+
+		err := Decode(request, &value)
+		assert.NoError(t, err)
+		assert.Equal(t, "luci", value)
+	})
+
+	t.Run("returns unsupported media type error if no codec matches", func(t *testing.T) {
+		t.Parallel()
+
+		request := httptest.NewRequestWithContext(t.Context(), http.MethodPost, "/user", strings.NewReader("luci"))
+		request.Header.Set("Content-Type", "image/png")
+
+		var value string
+
+		err := Decode(request, &value)
+		assert.Equal(t, UnsupportedMediaType(ErrUnsupportedMediaType), err)
+	})
+
+	t.Run("returns bad request error if decoding fails", func(t *testing.T) {
+		t.Parallel()
+
+		request := httptest.NewRequestWithContext(t.Context(), http.MethodPost, "/user", strings.NewReader("{"))
+		request.Header.Set("Content-Type", "application/json")
+
+		var value map[string]string
+
+		err := Decode(request, &value)
+
+		var httpErr *HTTPError
+		assert.True(t, errors.As(err, &httpErr))
+		assert.Equal(t, http.StatusBadRequest, httpErr.Status)
+	})
+}
+
+func TestWithCodecs(t *testing.T) {
+	t.Parallel()
+
+	codecs := Codecs{JSONCodec{}}
+
+	t.Run("adds codecs to request context", func(t *testing.T) {
+		t.Parallel()
+
+		var called bool
+
+		handler := withCodecs(nil, codecs)(http.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
+			called = true
+
+			assert.Equal(t, codecs, RequestCodecs(req))
+		}))
+
+		request := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/status", nil)
+		request.Header.Set("Accept", "application/*")
+
+		handler.ServeHTTP(httptest.NewRecorder(), request)
+
+		assert.True(t, called)
+	})
+
+	t.Run("allows requests accepting event streams", func(t *testing.T) {
+		t.Parallel()
+
+		var called bool
+
+		handler := withCodecs(nil, codecs)(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+			called = true
+		}))
+
+		request := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/events", nil)
+		request.Header.Set("Accept", "text/event-stream")
+
+		handler.ServeHTTP(httptest.NewRecorder(), request)
+
+		assert.True(t, called)
+	})
+
+	t.Run("calls error handler if no codec is acceptable", func(t *testing.T) {
+		t.Parallel()
+
+		var mock mock.Mock
+
+		errorHandler := func(rw http.ResponseWriter, req *http.Request, status int, err error) {
+			mock.MethodCalled("handler", rw, req, status, err)
+		}
+
+		handler := withCodecs(errorHandler, codecs)(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+			assert.Fail(t, "handler should not be called")
+		}))
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/status", nil)
+		request.Header.Set("Accept", "image/png")
+		mock.On("handler", recorder, request, http.StatusNotAcceptable, NotAcceptable(ErrNotAcceptable))
+
+		handler.ServeHTTP(recorder, request)
+
+		mock.AssertExpectations(t)
+	})
+
+	t.Run("calls error handler if body has unsupported content type", func(t *testing.T) {
+		t.Parallel()
+
+		var mock mock.Mock
+
+		errorHandler := func(rw http.ResponseWriter, req *http.Request, status int, err error) {
+			mock.MethodCalled("handler", rw, req, status, err)
+		}
+
+		handler := withCodecs(errorHandler, codecs)(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+			assert.Fail(t, "handler should not be called")
+		}))
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequestWithContext(t.Context(), http.MethodPost, "/status", strings.NewReader("luci"))
+		request.Header.Set("Content-Type", "text/plain")
+		mock.On("handler", recorder, request, http.StatusUnsupportedMediaType, UnsupportedMediaType(ErrUnsupportedMediaType))
+
+		handler.ServeHTTP(recorder, request)
+
+		mock.AssertExpectations(t)
+	})
+}
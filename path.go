@@ -0,0 +1,149 @@
+package luci
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// PathMode defines how requests to a non-canonical path are handled.
+type PathMode int
+
+const (
+	// PathStrict only matches routes using the requested path, so non-canonical paths are
+	// responded to with 404 Not Found.
+	PathStrict PathMode = iota
+	// PathRedirect redirects requests to the canonical path.
+	PathRedirect
+	// PathMatch handles requests using the route for the canonical path as if it were requested.
+	PathMatch
+)
+
+// PathPolicy defines how requests to a path that doesn't match a route are handled when the
+// canonical form of the path does. The canonical path has duplicate slashes and dot segments
+// removed, and the trailing slash removed or added to match the routes pattern.
+type PathPolicy struct {
+	// Mode defines how requests to a non-canonical path are handled.
+	Mode PathMode
+	// Permanent defines whether redirects use 308 Permanent Redirect rather than 307 Temporary Redirect.
+	// Permanent redirects may be cached by clients indefinitely.
+	Permanent bool
+}
+
+// pathPolicies handles requests to non-canonical paths before they're routed.
+type pathPolicies struct {
+	mux      *chi.Mux
+	policy   PathPolicy
+	routes   hostRoutes
+	redirect Middlewares
+}
+
+// ServeHTTP routes the request, applying the path policy of the route matching the canonical path
+// if the requested path doesn't match a route.
+func (policies *pathPolicies) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	canonical, pattern := policies.canonical(req)
+	if pattern == "" {
+		policies.mux.ServeHTTP(rw, req)
+		return
+	}
+
+	policy := policies.lookup(pattern, req.Method, req.Host)
+
+	switch policy.Mode {
+	case PathRedirect:
+		status := http.StatusTemporaryRedirect
+		if policy.Permanent {
+			status = http.StatusPermanentRedirect
+		}
+
+		location := canonical
+		if req.URL.RawPath == "" {
+			location = (&url.URL{Path: canonical}).EscapedPath()
+		}
+
+		if req.URL.RawQuery != "" {
+			location += "?" + req.URL.RawQuery
+		}
+
+		policies.redirect.Handler(http.RedirectHandler(location, status)).ServeHTTP(rw, req)
+
+		return
+	case PathMatch:
+		uri := *req.URL
+
+		if uri.RawPath == "" {
+			uri.Path = canonical
+		} else {
+			uri.RawPath = canonical
+			uri.Path, _ = url.PathUnescape(canonical)
+		}
+
+		req = req.WithContext(req.Context())
+		req.URL = &uri
+	case PathStrict:
+	}
+
+	policies.mux.ServeHTTP(rw, req)
+}
+
+// canonical returns the canonical path for the request and the pattern of the route it matches,
+// if the requested path doesn't match a route. The pattern is empty if there's no such path.
+func (policies *pathPolicies) canonical(req *http.Request) (string, string) {
+	requested := routePath(req)
+	if policies.mux.Find(chi.NewRouteContext(), req.Method, requested) != "" {
+		return "", ""
+	}
+
+	for _, canonical := range canonicalPaths(requested) {
+		pattern := policies.mux.Find(chi.NewRouteContext(), req.Method, canonical)
+		if pattern != "" {
+			return canonical, pattern
+		}
+	}
+
+	return "", ""
+}
+
+// lookup returns the path policy of the route for the pattern, method, and host.
+func (policies *pathPolicies) lookup(pattern, method, hostname string) PathPolicy {
+	route, ok := policies.routes.lookup(pattern, method, hostname)
+	if ok {
+		return route.policy
+	}
+
+	return policies.policy
+}
+
+// routePath returns the path used to route the request.
+func routePath(req *http.Request) string {
+	if req.URL.RawPath != "" {
+		return req.URL.RawPath
+	}
+
+	return req.URL.Path
+}
+
+// canonicalPaths returns the canonical forms of the path that differ from it, first
+// without a trailing slash and then with one.
+func canonicalPaths(requested string) []string {
+	if !strings.HasPrefix(requested, "/") {
+		return nil
+	}
+
+	cleaned := path.Clean(requested)
+
+	var canonical []string
+
+	if cleaned != requested {
+		canonical = append(canonical, cleaned)
+	}
+
+	if cleaned != "/" && cleaned+"/" != requested {
+		canonical = append(canonical, cleaned+"/")
+	}
+
+	return canonical
+}
@@ -0,0 +1,95 @@
+package luci
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPathPolicies(t *testing.T) {
+	t.Parallel()
+
+	mux := chi.NewMux()
+	mux.Get("/user/{key}", func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte(req.URL.Path))
+	})
+	mux.Post("/user/{key}", func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte(req.URL.Path))
+	})
+	mux.Get("/users/", func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte(req.URL.Path))
+	})
+
+	policies := &pathPolicies{
+		mux:    mux,
+		policy: PathPolicy{Mode: PathRedirect},
+		routes: hostRoutes{
+			{pattern: "/user/{key}", method: http.MethodGet}:  {{policy: PathPolicy{Mode: PathMatch}}},
+			{pattern: "/user/{key}", method: http.MethodPost}: {{policy: PathPolicy{Mode: PathStrict}}},
+		},
+	}
+
+	request := func(method, target string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequestWithContext(t.Context(), method, target, nil)
+
+		policies.ServeHTTP(recorder, request)
+
+		return recorder
+	}
+
+	tests := []struct {
+		method   string
+		target   string
+		status   int
+		body     string
+		location string
+	}{
+		{method: http.MethodGet, target: "/user/abc", status: http.StatusOK, body: "/user/abc"},
+		{method: http.MethodGet, target: "/user/abc/", status: http.StatusOK, body: "/user/abc"},
+		{method: http.MethodGet, target: "//user/./abc", status: http.StatusOK, body: "/user/abc"},
+		{method: http.MethodGet, target: "/user/a%2Fb/", status: http.StatusOK, body: "/user/a/b"},
+		{method: http.MethodPost, target: "/user/abc/", status: http.StatusNotFound, body: "404 page not found\n"},
+		{method: http.MethodGet, target: "/users", status: http.StatusTemporaryRedirect, location: "/users/"},
+		{method: http.MethodGet, target: "/users//?page=2", status: http.StatusTemporaryRedirect, location: "/users/?page=2"},
+		{method: http.MethodGet, target: "/other/", status: http.StatusNotFound, body: "404 page not found\n"},
+	}
+
+	for _, test := range tests {
+		recorder := request(test.method, test.target)
+
+		assert.Equal(t, test.status, recorder.Code, test.target)
+		assert.Equal(t, test.location, recorder.Header().Get("Location"), test.target)
+
+		if test.body != "" {
+			assert.Equal(t, test.body, recorder.Body.String(), test.target)
+		}
+	}
+
+	policies.policy.Permanent = true
+	assert.Equal(t, http.StatusPermanentRedirect, request(http.MethodGet, "/users").Code)
+}
+
+func TestCanonicalPaths(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		path     string
+		expected []string
+	}{
+		{path: "/", expected: nil},
+		{path: "*", expected: nil},
+		{path: "//", expected: []string{"/"}},
+		{path: "/user", expected: []string{"/user/"}},
+		{path: "/user/", expected: []string{"/user"}},
+		{path: "/user//abc/../", expected: []string{"/user", "/user/"}},
+		{path: "/./user", expected: []string{"/user", "/user/"}},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, canonicalPaths(test.path), test.path)
+	}
+}
@@ -0,0 +1,182 @@
+package luci
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"strings"
+)
+
+const (
+	// ProblemContentType is the media type used for problem details responses.
+	ProblemContentType = "application/problem+json"
+)
+
+var (
+	problemMembers = []string{"type", "title", "status", "detail", "instance"}
+)
+
+// Problem is a problem details object as defined by RFC 9457.
+type Problem struct {
+	// Type is a URI reference that identifies the problem type.
+	Type string
+	// Title is a short summary of the problem type.
+	Title string
+	// Status is the HTTP status code for the problem.
+	Status int
+	// Detail is an explanation specific to this occurrence of the problem.
+	Detail string
+	// Instance is a URI reference that identifies this occurrence of the problem.
+	Instance string
+	// Extensions contains additional members to include in the problem. Extensions
+	// with the same name as a standard member are ignored.
+	Extensions map[string]any
+	// Err is the error the problem describes, it's used for response headers and
+	// logging but is never included in the problem itself.
+	Err *HTTPError
+}
+
+// NewProblem creates a problem describing the given error. The error is converted with AsHTTPError using
+// the given status. The type is about:blank, the title is the status text, the detail is the errors public
+// message if it differs from the title, and the instance is the request ID. The errors code and details are
+// added as extensions, as are the fields of any ValidationError or BindError as the errors extension.
+func NewProblem(req *http.Request, status int, err error) *Problem {
+	httpErr := AsHTTPError(err, status)
+	if httpErr.Status != 0 {
+		status = httpErr.Status
+	}
+
+	problem := &Problem{
+		Type:       "about:blank",
+		Title:      http.StatusText(status),
+		Status:     status,
+		Instance:   ID(req),
+		Extensions: make(map[string]any, len(httpErr.Details)+2),
+		Err:        httpErr,
+	}
+
+	message := httpErr.PublicMessage()
+	if message != problem.Title {
+		problem.Detail = message
+	}
+
+	maps.Copy(problem.Extensions, httpErr.Details)
+
+	if httpErr.Code != "" {
+		problem.Extensions["code"] = httpErr.Code
+	}
+
+	var (
+		validationErr *ValidationError
+		bindErr       *BindError
+	)
+
+	switch {
+	case errors.As(httpErr, &validationErr):
+		problem.Extensions["errors"] = validationErr.Fields
+	case errors.As(httpErr, &bindErr):
+		fields := make([]map[string]string, len(bindErr.Fields))
+		for idx, field := range bindErr.Fields {
+			fields[idx] = map[string]string{
+				"source":  field.Source,
+				"key":     field.Key,
+				"message": field.Err.Error(),
+			}
+		}
+
+		problem.Extensions["errors"] = fields
+	}
+
+	return problem
+}
+
+// MarshalJSON encodes the problem as a JSON object with its extensions as top level members.
+func (problem *Problem) MarshalJSON() ([]byte, error) {
+	object := make(map[string]any, len(problem.Extensions)+len(problemMembers))
+	maps.Copy(object, problem.Extensions)
+
+	for _, member := range problemMembers {
+		delete(object, member)
+	}
+
+	if problem.Type != "" {
+		object["type"] = problem.Type
+	}
+
+	if problem.Title != "" {
+		object["title"] = problem.Title
+	}
+
+	if problem.Status != 0 {
+		object["status"] = problem.Status
+	}
+
+	if problem.Detail != "" {
+		object["detail"] = problem.Detail
+	}
+
+	if problem.Instance != "" {
+		object["instance"] = problem.Instance
+	}
+
+	data, err := json.Marshal(object)
+	if err != nil {
+		return nil, fmt.Errorf("luci: problem marshal: %w", err)
+	}
+
+	return data, nil
+}
+
+// WriteProblem responds with the problem as application/problem+json, including any headers
+// from the problems error. Server errors have their internal cause logged with the request logger.
+func WriteProblem(rw http.ResponseWriter, req *http.Request, problem *Problem) {
+	logger := Logger(req)
+	if logger == nil {
+		logger = slog.New(slog.DiscardHandler)
+	}
+
+	header := rw.Header()
+
+	if problem.Err != nil {
+		for key, values := range problem.Err.Header {
+			header[key] = values
+		}
+
+		if problem.Err.Status >= http.StatusInternalServerError && problem.Err.Cause != nil {
+			logger.With(slog.Any("error", problem.Err.Cause)).Error("request failed")
+		}
+	}
+
+	header.Set("Content-Type", ProblemContentType)
+	header.Del("Content-Length")
+	rw.WriteHeader(problem.Status)
+
+	err := json.NewEncoder(rw).Encode(problem)
+	if err != nil && !errors.Is(err, http.ErrHandlerTimeout) && !errors.Is(err, context.Canceled) {
+		logger.With(slog.Any("error", err)).Error("failed to write problem response")
+	}
+}
+
+// ProblemResponder responds to errors with RFC 9457 problem details. It may be used as an
+// applications Error implementation directly, or embedded in an application.
+type ProblemResponder struct {
+	// TypeBaseURI is used to build problem types from error codes, e.g. a base of
+	// https://example.com/problems/ and a code of not_found results in the type
+	// https://example.com/problems/not_found. If not set about:blank is used.
+	TypeBaseURI string
+}
+
+// Error responds with problem details describing the error, see NewProblem.
+func (responder ProblemResponder) Error(rw http.ResponseWriter, req *http.Request, status int, err error) {
+	problem := NewProblem(req, status, err)
+
+	if responder.TypeBaseURI != "" && problem.Err.Code != "" {
+		problem.Type = strings.TrimSuffix(responder.TypeBaseURI, "/") + "/" + problem.Err.Code
+	}
+
+	WriteProblem(rw, req, problem)
+}
@@ -0,0 +1,176 @@
+package luci
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type problemApplication struct {
+	ProblemResponder
+}
+
+func (problemApplication) Routes() []Route {
+	return nil
+}
+
+func (problemApplication) Middlewares() Middlewares {
+	return nil
+}
+
+func (problemApplication) Respond(_ http.ResponseWriter, _ *http.Request, _ any) {}
+
+func TestNewProblem(t *testing.T) {
+	t.Parallel()
+
+	t.Run("creates problem from error", func(t *testing.T) {
+		t.Parallel()
+
+		err := errors.New("database unavailable")
+		request := testRequest(t.Context(), http.MethodGet, "/user/abc", nil, nil, &requestState{id: "request_id"})
+
+		problem := NewProblem(request, http.StatusServiceUnavailable, err)
+
+		assert.Equal(t, &Problem{
+			Type:       "about:blank",
+			Title:      "Service Unavailable",
+			Status:     http.StatusServiceUnavailable,
+			Instance:   "request_id",
+			Extensions: map[string]any{"code": "service_unavailable"},
+			Err:        ServiceUnavailable(err),
+		}, problem)
+	})
+
+	t.Run("creates problem from HTTPError", func(t *testing.T) {
+		t.Parallel()
+
+		err := NotFound(errors.New("no rows")).WithMessage("user not found").WithDetail("key", "abc")
+		request := testRequest(t.Context(), http.MethodGet, "/user/abc", nil, nil, &requestState{id: "request_id"})
+
+		problem := NewProblem(request, http.StatusInternalServerError, err)
+
+		assert.Equal(t, &Problem{
+			Type:       "about:blank",
+			Title:      "Not Found",
+			Status:     http.StatusNotFound,
+			Detail:     "user not found",
+			Instance:   "request_id",
+			Extensions: map[string]any{"code": "not_found", "key": "abc"},
+			Err:        err,
+		}, problem)
+	})
+
+	t.Run("adds validation and bind error fields", func(t *testing.T) {
+		t.Parallel()
+
+		validationErr := &ValidationError{Fields: []ValidationFieldError{{Path: "name", Rule: "required", Message: "is required"}}}
+		request := testRequest(t.Context(), http.MethodGet, "/user/abc", nil, nil, &requestState{id: "request_id"})
+
+		problem := NewProblem(request, http.StatusInternalServerError, validationErr)
+
+		assert.Equal(t, http.StatusBadRequest, problem.Status)
+		assert.Equal(t, validationErr.Fields, problem.Extensions["errors"])
+
+		bindErr := &BindError{Fields: []BindFieldError{{Field: "Page", Source: "query", Key: "page", Err: errors.New("invalid integer")}}}
+		problem = NewProblem(request, http.StatusInternalServerError, bindErr)
+
+		assert.Equal(t, http.StatusBadRequest, problem.Status)
+		assert.Equal(t, []map[string]string{
+			{"source": "query", "key": "page", "message": "invalid integer"},
+		}, problem.Extensions["errors"])
+	})
+}
+
+func TestProblemMarshalJSON(t *testing.T) {
+	t.Parallel()
+
+	problem := &Problem{
+		Type:     "about:blank",
+		Title:    "Not Found",
+		Status:   http.StatusNotFound,
+		Detail:   "user not found",
+		Instance: "request_id",
+		Extensions: map[string]any{
+			"code":   "not_found",
+			"status": "ignored",
+		},
+		Err: NotFound(nil),
+	}
+
+	data, err := problem.MarshalJSON()
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+		"type": "about:blank",
+		"title": "Not Found",
+		"status": 404,
+		"detail": "user not found",
+		"instance": "request_id",
+		"code": "not_found"
+	}`, string(data))
+}
+
+func TestProblemResponder(t *testing.T) {
+	t.Parallel()
+
+	t.Run("responds with problem details", func(t *testing.T) {
+		t.Parallel()
+
+		var responder ProblemResponder
+
+		recorder := httptest.NewRecorder()
+		err := TooManyRequests(nil).WithHeader("Retry-After", "10")
+
+		request := testRequest(t.Context(), http.MethodGet, "/user/abc", nil, nil, &requestState{id: "request_id"})
+
+		responder.Error(recorder, request, http.StatusTooManyRequests, err)
+
+		assert.Equal(t, http.StatusTooManyRequests, recorder.Code)
+		assert.Equal(t, ProblemContentType, recorder.Header().Get("Content-Type"))
+		assert.Equal(t, "10", recorder.Header().Get("Retry-After"))
+		assert.JSONEq(t, `{
+			"type": "about:blank",
+			"title": "Too Many Requests",
+			"status": 429,
+			"instance": "request_id",
+			"code": "too_many_requests"
+		}`, recorder.Body.String())
+	})
+
+	t.Run("builds type from base URI and error code", func(t *testing.T) {
+		t.Parallel()
+
+		responder := ProblemResponder{TypeBaseURI: "https://example.com/problems/"}
+
+		recorder := httptest.NewRecorder()
+		request := testRequest(t.Context(), http.MethodGet, "/user/abc", nil, nil, &requestState{id: "request_id"})
+
+		responder.Error(recorder, request, http.StatusNotFound, ErrNotFound)
+
+		assert.Equal(t, http.StatusNotFound, recorder.Code)
+		assert.JSONEq(t, `{
+			"type": "https://example.com/problems/not_found",
+			"title": "Not Found",
+			"status": 404,
+			"instance": "request_id",
+			"code": "not_found"
+		}`, recorder.Body.String())
+	})
+
+	t.Run("can be embedded as an applications Error", func(t *testing.T) {
+		t.Parallel()
+
+		server := NewServer(testConfig, problemApplication{})
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/notfound", nil)
+
+		server.server.Handler.ServeHTTP(recorder, request)
+
+		assert.Equal(t, http.StatusNotFound, recorder.Code)
+		assert.Equal(t, ProblemContentType, recorder.Header().Get("Content-Type"))
+		assert.Contains(t, recorder.Body.String(), `"instance":"`+recorder.Header().Get("Request-Id")+`"`)
+	})
+}
@@ -0,0 +1,294 @@
+package luci
+
+import (
+	"fmt"
+	"iter"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// trustedProxies are the networks of the proxies whose request headers are trusted.
+type trustedProxies []netip.Prefix
+
+// forwarded is the client's IP address, and the scheme and host the client used to make the request.
+type forwarded struct {
+	clientIP netip.Addr
+	scheme   string
+	host     string
+}
+
+// forwardedElement is an element of the Forwarded header, see RFC 7239.
+type forwardedElement struct {
+	addr  netip.Addr
+	proto string
+	host  string
+}
+
+// ClientIP returns the IP address of the client that made the request. If the request was made by one of
+// the servers trusted proxies, the client is resolved using the Forwarded, X-Forwarded-For, or X-Real-IP
+// headers, in that order of preference. Otherwise it's the address of the peer that made the request.
+func ClientIP(req *http.Request) netip.Addr {
+	state := requestStateFrom(req)
+	if state == nil {
+		return netip.Addr{}
+	}
+
+	return state.forwarded.clientIP
+}
+
+// withForwarded resolves the client's IP address, and the scheme and host the client used, for the request.
+func withForwarded(proxies trustedProxies) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			requestStateFrom(req).forwarded = proxies.resolve(req)
+			next.ServeHTTP(rw, req)
+		})
+	}
+}
+
+// parseTrustedProxies parses the proxies, each of which is either an IP address or a CIDR.
+func parseTrustedProxies(proxies []string) (trustedProxies, error) {
+	prefixes := make(trustedProxies, 0, len(proxies))
+
+	for _, proxy := range proxies {
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(proxy)
+			if addrErr != nil {
+				return nil, fmt.Errorf(`luci: trusted proxy "%s" must be an ip address or cidr: %w`, proxy, err)
+			}
+
+			prefix = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+		}
+
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	return prefixes, nil
+}
+
+// trusts returns whether the address, which may include a port, is one of the trusted proxies.
+func (proxies trustedProxies) trusts(address string) bool {
+	if len(proxies) == 0 {
+		return false
+	}
+
+	addr := parseNodeAddr(address)
+
+	return addr.IsValid() && proxies.contains(addr)
+}
+
+// contains returns whether the address is in one of the trusted proxies networks.
+func (proxies trustedProxies) contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+
+	for _, prefix := range proxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// resolve resolves the client's IP address, and the scheme and host the client used, from the
+// request's forwarding headers, if the request was made by a trusted proxy. The client is the
+// rightmost address that isn't a trusted proxy.
+func (proxies trustedProxies) resolve(req *http.Request) forwarded {
+	resolved := forwarded{clientIP: parseNodeAddr(req.RemoteAddr), scheme: "http", host: req.Host}
+	if req.TLS != nil {
+		resolved.scheme = "https"
+	}
+
+	if !resolved.clientIP.IsValid() || !proxies.contains(resolved.clientIP) {
+		return resolved
+	}
+
+	header := req.Header
+
+	if values := header.Values("Forwarded"); len(values) != 0 {
+		elements := parseForwarded(values)
+
+		addrs := make([]netip.Addr, len(elements))
+		for idx, element := range elements {
+			addrs[idx] = element.addr
+		}
+
+		idx := proxies.client(addrs)
+		if idx == -1 {
+			return resolved
+		}
+
+		element := elements[idx]
+		resolved.clientIP = element.addr
+
+		if validProto(element.proto) {
+			resolved.scheme = strings.ToLower(element.proto)
+		}
+
+		if validHost(element.host) {
+			resolved.host = element.host
+		}
+
+		return resolved
+	}
+
+	if values := header.Values("X-Forwarded-For"); len(values) != 0 {
+		var addrs []netip.Addr
+
+		for _, value := range values {
+			for node := range strings.SplitSeq(value, ",") {
+				addrs = append(addrs, parseNodeAddr(strings.TrimSpace(node)))
+			}
+		}
+
+		idx := proxies.client(addrs)
+		if idx != -1 {
+			resolved.clientIP = addrs[idx]
+		}
+
+		// Proxies that set X-Forwarded-Proto and X-Forwarded-Host may append to them
+		// rather than replacing them, so the nearest proxy's value is used.
+		proto := lastHeaderValue(header, "X-Forwarded-Proto")
+		if validProto(proto) {
+			resolved.scheme = strings.ToLower(proto)
+		}
+
+		host := lastHeaderValue(header, "X-Forwarded-Host")
+		if validHost(host) {
+			resolved.host = host
+		}
+
+		return resolved
+	}
+
+	addr := parseNodeAddr(strings.TrimSpace(header.Get("X-Real-Ip")))
+	if addr.IsValid() {
+		resolved.clientIP = addr
+	}
+
+	return resolved
+}
+
+// client returns the index of the client in the addresses of each hop, the rightmost address that isn't
+// a trusted proxy, or the leftmost address if they're all trusted. If an address is invalid the address to
+// its right is used, since the hops beyond it can't be checked, or -1 if there's no address to its right.
+func (proxies trustedProxies) client(addrs []netip.Addr) int {
+	for idx := len(addrs) - 1; idx >= 0; idx-- {
+		if !addrs[idx].IsValid() {
+			if idx == len(addrs)-1 {
+				return -1
+			}
+
+			return idx + 1
+		}
+
+		if !proxies.contains(addrs[idx]) {
+			return idx
+		}
+	}
+
+	if len(addrs) == 0 {
+		return -1
+	}
+
+	return 0
+}
+
+// parseForwarded parses the elements of the Forwarded header values.
+func parseForwarded(values []string) []forwardedElement {
+	var elements []forwardedElement
+
+	for _, value := range values {
+		for element := range splitQuoted(value, ',') {
+			var parsed forwardedElement
+
+			for pair := range splitQuoted(element, ';') {
+				key, val, _ := strings.Cut(strings.TrimSpace(pair), "=")
+				val = strings.Trim(val, `"`)
+
+				switch strings.ToLower(key) {
+				case "for":
+					parsed.addr = parseNodeAddr(val)
+				case "proto":
+					parsed.proto = val
+				case "host":
+					parsed.host = val
+				}
+			}
+
+			elements = append(elements, parsed)
+		}
+	}
+
+	return elements
+}
+
+// splitQuoted splits the value by the separator, ignoring separators in quoted strings.
+func splitQuoted(value string, separator byte) iter.Seq[string] {
+	return func(yield func(string) bool) {
+		var quoted bool
+
+		start := 0
+
+		for idx := range len(value) {
+			switch value[idx] {
+			case '"':
+				quoted = !quoted
+			case separator:
+				if quoted {
+					continue
+				}
+
+				if !yield(value[start:idx]) {
+					return
+				}
+
+				start = idx + 1
+			}
+		}
+
+		yield(value[start:])
+	}
+}
+
+// parseNodeAddr parses the IP address of a node, which may be bracketed and include a port.
+// The address is invalid if the node isn't an IP address, such as an obfuscated identifier.
+func parseNodeAddr(node string) netip.Addr {
+	host, _, err := net.SplitHostPort(node)
+	if err != nil {
+		host = strings.TrimSuffix(strings.TrimPrefix(node, "["), "]")
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}
+	}
+
+	return addr.Unmap()
+}
+
+// lastHeaderValue returns the last comma separated value of the header.
+func lastHeaderValue(header http.Header, key string) string {
+	values := header.Values(key)
+	if len(values) == 0 {
+		return ""
+	}
+
+	value := values[len(values)-1]
+	if idx := strings.LastIndexByte(value, ','); idx != -1 {
+		value = value[idx+1:]
+	}
+
+	return strings.TrimSpace(value)
+}
+
+func validProto(proto string) bool {
+	return strings.EqualFold(proto, "http") || strings.EqualFold(proto, "https")
+}
+
+func validHost(host string) bool {
+	return host != "" && !strings.ContainsAny(host, "/?#@ \t")
+}
@@ -0,0 +1,289 @@
+package luci
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// proxyV1MaxLength is the maximum length of a PROXY protocol v1 header, including the CRLF.
+	proxyV1MaxLength = 107
+	// proxyV2HeaderLength is the length of a PROXY protocol v2 header, excluding the addresses.
+	proxyV2HeaderLength = 16
+)
+
+var (
+	// DefaultProxyProtocolConfig is the base configuration that's used for the PROXY protocol when it's enabled.
+	DefaultProxyProtocolConfig = ProxyProtocolConfig{
+		HeaderTimeout: time.Second,
+	}
+
+	proxyV1Signature = []byte("PROXY ")
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+	errProxyHeader = errors.New("luci: invalid proxy protocol header")
+)
+
+// ProxyProtocolConfig defines which connections send PROXY protocol headers, see
+// https://www.haproxy.org/download/3.0/doc/proxy-protocol.txt. Both v1 and v2 headers are supported.
+// See DefaultProxyProtocolConfig for configuration defaults.
+type ProxyProtocolConfig struct {
+	// TrustedSources defines the IP addresses or CIDRs of the load balancers that send PROXY protocol
+	// headers, e.g. 10.0.0.0/8. Connections from trusted sources must begin with a header, and are
+	// closed if they don't. Connections from other sources are used as is. If not set the servers
+	// TrustedProxies are used.
+	TrustedSources []string
+	// HeaderTimeout defines the timeout to read the PROXY protocol header once a connection is accepted.
+	HeaderTimeout time.Duration
+}
+
+// proxyListener accepts connections that may begin with a PROXY protocol header.
+type proxyListener struct {
+	net.Listener
+	sources trustedProxies
+	timeout time.Duration
+}
+
+// proxyConn is a connection whose addresses are read from its PROXY protocol header, if it's from a
+// trusted source. The header is read on first use, rather than when the connection is accepted, so
+// slow connections don't prevent other connections from being accepted.
+type proxyConn struct {
+	net.Conn
+	sources    trustedProxies
+	timeout    time.Duration
+	once       sync.Once
+	reader     *bufio.Reader
+	remoteAddr net.Addr
+	localAddr  net.Addr
+	err        error
+}
+
+// Accept waits for and returns the next connection to the listener.
+func (listener *proxyListener) Accept() (net.Conn, error) {
+	conn, err := listener.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return &proxyConn{
+		Conn:       conn,
+		sources:    listener.sources,
+		timeout:    listener.timeout,
+		remoteAddr: conn.RemoteAddr(),
+		localAddr:  conn.LocalAddr(),
+	}, nil
+}
+
+// Read reads data from the connection, after the PROXY protocol header.
+func (conn *proxyConn) Read(b []byte) (int, error) {
+	conn.once.Do(conn.readHeader)
+
+	if conn.err != nil {
+		return 0, conn.err
+	}
+
+	// Once any data buffered while reading the header has been read, the connection is read directly.
+	if conn.reader != nil && conn.reader.Buffered() != 0 {
+		return conn.reader.Read(b)
+	}
+
+	return conn.Conn.Read(b)
+}
+
+// RemoteAddr returns the source address from the PROXY protocol header, or the connection's remote address.
+func (conn *proxyConn) RemoteAddr() net.Addr {
+	conn.once.Do(conn.readHeader)
+	return conn.remoteAddr
+}
+
+// LocalAddr returns the destination address from the PROXY protocol header, or the connection's local address.
+func (conn *proxyConn) LocalAddr() net.Addr {
+	conn.once.Do(conn.readHeader)
+	return conn.localAddr
+}
+
+// readHeader reads the PROXY protocol header if the connection is from a trusted source.
+func (conn *proxyConn) readHeader() {
+	if !conn.sources.trusts(conn.remoteAddr.String()) {
+		return
+	}
+
+	err := conn.SetReadDeadline(time.Now().Add(conn.timeout))
+	if err != nil {
+		conn.err = fmt.Errorf("luci: proxy protocol deadline: %w", err)
+		return
+	}
+
+	conn.reader = bufio.NewReaderSize(conn.Conn, 256)
+
+	err = conn.readProxyHeader()
+	if err != nil {
+		conn.err = err
+		return
+	}
+
+	err = conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		conn.err = fmt.Errorf("luci: proxy protocol deadline: %w", err)
+	}
+}
+
+// readProxyHeader reads a v1 or v2 PROXY protocol header, and the addresses it defines.
+func (conn *proxyConn) readProxyHeader() error {
+	// The v1 signature is shorter than the v2 signature, so it's peeked first and the full v2 signature is only
+	// waited for if the data may still be a v2 header. Otherwise short data that isn't a header stalls until the timeout.
+	signature, err := conn.reader.Peek(len(proxyV1Signature))
+	if err != nil {
+		return fmt.Errorf("luci: proxy protocol read: %w", err)
+	}
+
+	switch {
+	case bytes.Equal(signature, proxyV1Signature):
+		return conn.readProxyV1()
+	case !bytes.HasPrefix(proxyV2Signature, signature):
+		return errProxyHeader
+	}
+
+	signature, err = conn.reader.Peek(len(proxyV2Signature))
+	if err != nil {
+		return fmt.Errorf("luci: proxy protocol read: %w", err)
+	}
+
+	if !bytes.Equal(signature, proxyV2Signature) {
+		return errProxyHeader
+	}
+
+	return conn.readProxyV2()
+}
+
+// readProxyV1 reads a human readable header, e.g. "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n".
+func (conn *proxyConn) readProxyV1() error {
+	line, err := conn.reader.ReadSlice('\n')
+	if err != nil || len(line) > proxyV1MaxLength || !bytes.HasSuffix(line, []byte("\r\n")) {
+		return errProxyHeader
+	}
+
+	fields := strings.Split(string(line[len(proxyV1Signature):len(line)-2]), " ")
+
+	if fields[0] == "UNKNOWN" {
+		return nil
+	}
+
+	if len(fields) != 5 || (fields[0] != "TCP4" && fields[0] != "TCP6") {
+		return errProxyHeader
+	}
+
+	source, err := parseProxyV1Addr(fields[0], fields[1], fields[3])
+	if err != nil {
+		return err
+	}
+
+	destination, err := parseProxyV1Addr(fields[0], fields[2], fields[4])
+	if err != nil {
+		return err
+	}
+
+	conn.remoteAddr = source
+	conn.localAddr = destination
+
+	return nil
+}
+
+// readProxyV2 reads a binary header, whose addresses are only used for TCP over IPv4 or IPv6.
+func (conn *proxyConn) readProxyV2() error {
+	header := make([]byte, proxyV2HeaderLength)
+
+	_, err := io.ReadFull(conn.reader, header)
+	if err != nil {
+		return fmt.Errorf("luci: proxy protocol read: %w", err)
+	}
+
+	version, command := header[12]>>4, header[12]&0x0f
+	if version != 2 || command > 1 {
+		return errProxyHeader
+	}
+
+	family, transport := header[13]>>4, header[13]&0x0f
+	length := int(binary.BigEndian.Uint16(header[14:16]))
+
+	var addrLength int
+
+	switch family {
+	case 1:
+		addrLength = 12
+	case 2:
+		addrLength = 36
+	}
+
+	if length < addrLength {
+		return errProxyHeader
+	}
+
+	addrs := make([]byte, addrLength)
+
+	_, err = io.ReadFull(conn.reader, addrs)
+	if err != nil {
+		return fmt.Errorf("luci: proxy protocol read: %w", err)
+	}
+
+	// Type-length-values following the addresses aren't used.
+	_, err = conn.reader.Discard(length - addrLength)
+	if err != nil {
+		return fmt.Errorf("luci: proxy protocol read: %w", err)
+	}
+
+	// LOCAL commands are sent by the load balancer itself, such as for health checks,
+	// and addresses for other transports aren't used, so the connection's are kept.
+	if command == 0 || transport != 1 || addrLength == 0 {
+		return nil
+	}
+
+	ipLength := (addrLength - 4) / 2
+	source, _ := netip.AddrFromSlice(addrs[:ipLength])
+	destination, _ := netip.AddrFromSlice(addrs[ipLength : ipLength*2])
+	ports := addrs[ipLength*2:]
+
+	conn.remoteAddr = net.TCPAddrFromAddrPort(netip.AddrPortFrom(source.Unmap(), binary.BigEndian.Uint16(ports[0:2])))
+	conn.localAddr = net.TCPAddrFromAddrPort(netip.AddrPortFrom(destination.Unmap(), binary.BigEndian.Uint16(ports[2:4])))
+
+	return nil
+}
+
+// parseProxyV1Addr parses an address and port from a v1 header, the address must match the protocol's family.
+func parseProxyV1Addr(protocol, address, port string) (*net.TCPAddr, error) {
+	addr, err := netip.ParseAddr(address)
+	if err != nil || addr.Is4() != (protocol == "TCP4") {
+		return nil, errProxyHeader
+	}
+
+	parsedPort, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, errProxyHeader
+	}
+
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, uint16(parsedPort))), nil //nolint:gosec // The port is parsed as 16 bits.
+}
+
+func buildProxyProtocolConfig(config ProxyProtocolConfig) ProxyProtocolConfig {
+	built := DefaultProxyProtocolConfig
+
+	if len(config.TrustedSources) != 0 {
+		built.TrustedSources = config.TrustedSources
+	}
+
+	if config.HeaderTimeout != 0 {
+		built.HeaderTimeout = config.HeaderTimeout
+	}
+
+	return built
+}
@@ -0,0 +1,254 @@
+package luci
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type addrConn struct {
+	net.Conn
+	remoteAddr net.Addr
+}
+
+func (conn addrConn) RemoteAddr() net.Addr {
+	return conn.remoteAddr
+}
+
+type testListener struct {
+	net.Listener
+	conn net.Conn
+}
+
+func (listener *testListener) Accept() (net.Conn, error) {
+	return listener.conn, nil
+}
+
+func proxyV2Header(command, family byte, addrs []byte, tlvs []byte) []byte {
+	header := append([]byte{}, proxyV2Signature...)
+	header = append(header, 0x20|command, family)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(addrs)+len(tlvs))) //nolint:gosec // Test headers are short.
+	header = append(header, addrs...)
+
+	return append(header, tlvs...)
+}
+
+func TestProxyConn(t *testing.T) {
+	t.Parallel()
+
+	sources, err := parseTrustedProxies([]string{"10.0.0.0/8"})
+	assert.NoError(t, err)
+
+	peer := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1234}
+
+	tests := []struct {
+		name       string
+		peer       net.Addr
+		header     []byte
+		remoteAddr string
+		localAddr  string
+		invalid    bool
+	}{
+		{
+			name:       "reads v1 tcp4 header",
+			peer:       peer,
+			header:     []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"),
+			remoteAddr: "192.0.2.1:56324",
+			localAddr:  "198.51.100.1:443",
+		},
+		{
+			name:       "reads v1 tcp6 header",
+			peer:       peer,
+			header:     []byte("PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n"),
+			remoteAddr: "[2001:db8::1]:56324",
+			localAddr:  "[2001:db8::2]:443",
+		},
+		{
+			name:       "keeps connection addresses for v1 unknown header",
+			peer:       peer,
+			header:     []byte("PROXY UNKNOWN\r\n"),
+			remoteAddr: "10.0.0.1:1234",
+			localAddr:  "pipe",
+		},
+		{
+			name: "reads v2 ipv4 header",
+			peer: peer,
+			header: proxyV2Header(1, 0x11, []byte{
+				192, 0, 2, 1,
+				198, 51, 100, 1,
+				0xdc, 0x04,
+				0x01, 0xbb,
+			}, []byte{0x04, 0x00, 0x01, 0x00}),
+			remoteAddr: "192.0.2.1:56324",
+			localAddr:  "198.51.100.1:443",
+		},
+		{
+			name: "reads v2 ipv6 header",
+			peer: peer,
+			header: proxyV2Header(1, 0x21, append(append(
+				net.ParseIP("2001:db8::1").To16(),
+				net.ParseIP("2001:db8::2").To16()...),
+				0xdc, 0x04, 0x01, 0xbb,
+			), nil),
+			remoteAddr: "[2001:db8::1]:56324",
+			localAddr:  "[2001:db8::2]:443",
+		},
+		{
+			name:       "keeps connection addresses for v2 local command",
+			peer:       peer,
+			header:     proxyV2Header(0, 0x00, nil, nil),
+			remoteAddr: "10.0.0.1:1234",
+			localAddr:  "pipe",
+		},
+		{
+			name:       "uses connection as is if it's not from a trusted source",
+			peer:       &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1234},
+			remoteAddr: "192.0.2.1:1234",
+			localAddr:  "pipe",
+		},
+		{
+			name:    "errors if trusted source doesn't send a header",
+			peer:    peer,
+			header:  []byte("GET / HTTP/1.1\r\n"),
+			invalid: true,
+		},
+		{
+			name:    "errors if v1 header is invalid",
+			peer:    peer,
+			header:  []byte("PROXY TCP4 2001:db8::1 198.51.100.1 56324 443\r\n"),
+			invalid: true,
+		},
+		{
+			name:    "errors if v2 header has an unsupported version",
+			peer:    peer,
+			header:  append(append([]byte{}, proxyV2Signature...), 0x11, 0x11, 0x00, 0x00),
+			invalid: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			server, client := net.Pipe()
+
+			go func() {
+				_, _ = client.Write(append(test.header, "payload"...))
+				_ = client.Close()
+			}()
+
+			listener := &proxyListener{Listener: &testListener{conn: addrConn{Conn: server, remoteAddr: test.peer}}, sources: sources, timeout: time.Second}
+
+			conn, err := listener.Accept()
+			assert.NoError(t, err)
+
+			defer func() {
+				assert.NoError(t, conn.Close())
+			}()
+
+			body, err := io.ReadAll(conn)
+			if test.invalid {
+				assert.ErrorIs(t, err, errProxyHeader)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, "payload", string(body))
+			assert.Equal(t, test.remoteAddr, conn.RemoteAddr().String())
+			assert.Equal(t, test.localAddr, conn.LocalAddr().String())
+		})
+	}
+}
+
+func TestProxyConnHeaderTimeout(t *testing.T) {
+	t.Parallel()
+
+	sources, err := parseTrustedProxies([]string{"10.0.0.0/8"})
+	assert.NoError(t, err)
+
+	server, client := net.Pipe()
+
+	defer func() {
+		assert.NoError(t, client.Close())
+	}()
+
+	conn := &proxyConn{
+		Conn:       server,
+		sources:    sources,
+		timeout:    50 * time.Millisecond,
+		remoteAddr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1234},
+		localAddr:  server.LocalAddr(),
+	}
+
+	_, err = conn.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+}
+
+func TestProxyConnShortData(t *testing.T) {
+	t.Parallel()
+
+	sources, err := parseTrustedProxies([]string{"10.0.0.0/8"})
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		data    string
+		invalid bool
+	}{
+		{name: "reads short v1 header", data: "PROXY UNKNOWN\r\n"},
+		{name: "errors if short data isn't a header", data: "GET /\r\n", invalid: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			server, client := net.Pipe()
+
+			defer func() {
+				assert.NoError(t, client.Close())
+			}()
+
+			go func() {
+				_, _ = client.Write([]byte(test.data))
+			}()
+
+			conn := &proxyConn{
+				Conn:       server,
+				sources:    sources,
+				timeout:    time.Second,
+				remoteAddr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1234},
+				localAddr:  server.LocalAddr(),
+			}
+
+			start := time.Now()
+
+			assert.Equal(t, "10.0.0.1:1234", conn.RemoteAddr().String())
+			assert.Less(t, time.Since(start), time.Second/2)
+
+			if test.invalid {
+				assert.ErrorIs(t, conn.err, errProxyHeader)
+			} else {
+				assert.NoError(t, conn.err)
+			}
+		})
+	}
+}
+
+func TestBuildProxyProtocolConfig(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, DefaultProxyProtocolConfig, buildProxyProtocolConfig(ProxyProtocolConfig{}))
+	assert.Equal(t, ProxyProtocolConfig{
+		TrustedSources: []string{"10.0.0.0/8"},
+		HeaderTimeout:  time.Minute,
+	}, buildProxyProtocolConfig(ProxyProtocolConfig{
+		TrustedSources: []string{"10.0.0.0/8"},
+		HeaderTimeout:  time.Minute,
+	}))
+}
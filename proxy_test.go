@@ -0,0 +1,183 @@
+package luci
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	t.Parallel()
+
+	proxies, err := parseTrustedProxies([]string{"10.1.2.3/8", "192.0.2.1", "2001:db8::/32"})
+	assert.NoError(t, err)
+	assert.Len(t, proxies, 3)
+	assert.Equal(t, "10.0.0.0/8", proxies[0].String())
+	assert.Equal(t, "192.0.2.1/32", proxies[1].String())
+	assert.Equal(t, "2001:db8::/32", proxies[2].String())
+
+	_, err = parseTrustedProxies([]string{"10.0.0.0/33"})
+	assert.ErrorContains(t, err, `luci: trusted proxy "10.0.0.0/33" must be an ip address or cidr`)
+
+	_, err = parseTrustedProxies([]string{"proxy"})
+	assert.ErrorContains(t, err, `luci: trusted proxy "proxy" must be an ip address or cidr`)
+}
+
+func TestTrustedProxiesTrusts(t *testing.T) {
+	t.Parallel()
+
+	proxies, err := parseTrustedProxies([]string{"10.0.0.0/8", "2001:db8::/32"})
+	assert.NoError(t, err)
+
+	assert.True(t, proxies.trusts("10.0.0.1:1234"))
+	assert.True(t, proxies.trusts("10.0.0.1"))
+	assert.True(t, proxies.trusts("[::ffff:10.0.0.1]:1234"))
+	assert.True(t, proxies.trusts("[2001:db8::1]:1234"))
+	assert.False(t, proxies.trusts("192.0.2.1:1234"))
+	assert.False(t, proxies.trusts("[2001:db9::1]:1234"))
+	assert.False(t, proxies.trusts("pipe"))
+	assert.False(t, trustedProxies(nil).trusts("10.0.0.1:1234"))
+}
+
+func TestClientIP(t *testing.T) {
+	t.Parallel()
+
+	proxies, err := parseTrustedProxies([]string{"10.0.0.0/8"})
+	assert.NoError(t, err)
+
+	handler := Middlewares{withRequestState, withForwarded(proxies)}.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, netip.MustParseAddr("192.0.2.1"), ClientIP(req))
+	})
+
+	request := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/status", nil)
+	request.RemoteAddr = "10.0.0.1:1234"
+	request.Header.Set("X-Forwarded-For", "192.0.2.1")
+
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+
+	assert.False(t, ClientIP(request).IsValid())
+}
+
+func TestTrustedProxiesResolve(t *testing.T) {
+	t.Parallel()
+
+	proxies, err := parseTrustedProxies([]string{"10.0.0.0/8", "2001:db8::/32"})
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		tls        bool
+		header     http.Header
+		expected   forwarded
+	}{
+		{
+			name:       "uses peer if it isn't trusted",
+			remoteAddr: "192.0.2.1:1234",
+			header: http.Header{
+				"Forwarded":       {"for=198.51.100.1;proto=https;host=evil.dev"},
+				"X-Forwarded-For": {"198.51.100.1"},
+			},
+			expected: forwarded{clientIP: netip.MustParseAddr("192.0.2.1"), scheme: "http", host: "luci.dev"},
+		},
+		{
+			name:       "uses peer if trusted without headers",
+			remoteAddr: "10.0.0.1:1234",
+			tls:        true,
+			expected:   forwarded{clientIP: netip.MustParseAddr("10.0.0.1"), scheme: "https", host: "luci.dev"},
+		},
+		{
+			name:       "uses forwarded",
+			remoteAddr: "10.0.0.1:1234",
+			header: http.Header{
+				"Forwarded":       {`for=198.51.100.1;proto=https;host=api.luci.dev, for="[2001:db8::1]:4711"`, "for=10.0.0.2"},
+				"X-Forwarded-For": {"203.0.113.1"},
+			},
+			expected: forwarded{clientIP: netip.MustParseAddr("198.51.100.1"), scheme: "https", host: "api.luci.dev"},
+		},
+		{
+			name:       "uses rightmost untrusted forwarded address",
+			remoteAddr: "10.0.0.1:1234",
+			header: http.Header{
+				"Forwarded": {`for=203.0.113.1;proto=https, for="198.51.100.1:80";proto=http;host="luci.dev:8080", for=10.0.0.2`},
+			},
+			expected: forwarded{clientIP: netip.MustParseAddr("198.51.100.1"), scheme: "http", host: "luci.dev:8080"},
+		},
+		{
+			name:       "uses trusted proxy if forwarded address is obfuscated",
+			remoteAddr: "10.0.0.1:1234",
+			header: http.Header{
+				"Forwarded": {"for=_hidden, for=10.0.0.2"},
+			},
+			expected: forwarded{clientIP: netip.MustParseAddr("10.0.0.2"), scheme: "http", host: "luci.dev"},
+		},
+		{
+			name:       "uses peer if last forwarded address is unknown",
+			remoteAddr: "10.0.0.1:1234",
+			header: http.Header{
+				"Forwarded": {"for=unknown;proto=https"},
+			},
+			expected: forwarded{clientIP: netip.MustParseAddr("10.0.0.1"), scheme: "http", host: "luci.dev"},
+		},
+		{
+			name:       "uses x-forwarded-for",
+			remoteAddr: "[2001:db8::2]:1234",
+			header: http.Header{
+				"X-Forwarded-For":   {"203.0.113.1, 198.51.100.1", "10.0.0.2"},
+				"X-Forwarded-Proto": {"http, HTTPS"},
+				"X-Forwarded-Host":  {"api.luci.dev"},
+				"X-Real-Ip":         {"192.0.2.1"},
+			},
+			expected: forwarded{clientIP: netip.MustParseAddr("198.51.100.1"), scheme: "https", host: "api.luci.dev"},
+		},
+		{
+			name:       "uses leftmost x-forwarded-for address if all are trusted",
+			remoteAddr: "10.0.0.1:1234",
+			header: http.Header{
+				"X-Forwarded-For":   {"10.0.0.3, 10.0.0.2"},
+				"X-Forwarded-Proto": {"ftp"},
+				"X-Forwarded-Host":  {"luci.dev/path"},
+			},
+			expected: forwarded{clientIP: netip.MustParseAddr("10.0.0.3"), scheme: "http", host: "luci.dev"},
+		},
+		{
+			name:       "uses x-real-ip",
+			remoteAddr: "10.0.0.1:1234",
+			header: http.Header{
+				"X-Real-Ip": {"198.51.100.1"},
+			},
+			expected: forwarded{clientIP: netip.MustParseAddr("198.51.100.1"), scheme: "http", host: "luci.dev"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			request := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "http://luci.dev/status", nil)
+			request.RemoteAddr = test.remoteAddr
+			request.Header = test.header
+
+			if test.tls {
+				request.TLS = &tls.ConnectionState{}
+			}
+
+			assert.Equal(t, test.expected, proxies.resolve(request))
+		})
+	}
+}
+
+func TestParseForwarded(t *testing.T) {
+	t.Parallel()
+
+	elements := parseForwarded([]string{`For="[2001:db8:cafe::17]:4711";Proto=HTTPS;host="luci.dev;a,b", for=192.0.2.60;by=203.0.113.43`, "for=unknown"})
+	assert.Equal(t, []forwardedElement{
+		{addr: netip.MustParseAddr("2001:db8:cafe::17"), proto: "HTTPS", host: "luci.dev;a,b"},
+		{addr: netip.MustParseAddr("192.0.2.60")},
+		{},
+	}, elements)
+}
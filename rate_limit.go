@@ -0,0 +1,381 @@
+package luci
+
+import (
+	"container/list"
+	"context"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// DefaultRateLimitConfig is the base configuration that's used when creating a rate limit middleware.
+	DefaultRateLimitConfig = RateLimitConfig{
+		Key:     RateLimitByClientIP,
+		MaxKeys: 10000,
+	}
+)
+
+// RateLimitAlgorithm defines how requests are counted against a limit.
+type RateLimitAlgorithm int
+
+const (
+	// TokenBucket allows bursts of up to the limit's requests, with the requests replenished
+	// evenly over the limit's window.
+	TokenBucket RateLimitAlgorithm = iota
+	// SlidingWindow allows up to the limit's requests in any window, estimated by weighting the
+	// previous fixed window's count by how much of it overlaps the sliding window.
+	SlidingWindow
+)
+
+// RateLimitKey returns the key requests are limited by, and whether the request is limited at all.
+type RateLimitKey func(req *http.Request) (string, bool)
+
+// RateLimitConfig defines how the rate limit middleware limits requests.
+// See DefaultRateLimitConfig for configuration defaults.
+type RateLimitConfig struct {
+	// Limit may be optionally used to limit all requests, in addition to the limits of routes
+	// using Route.RateLimit. If not set only routes with a limit are limited.
+	Limit RateLimitRule
+	// Key defines the key requests are limited by for limits without a key.
+	Key RateLimitKey
+	// Store defines where the state of each key's limit is stored. If not set a
+	// MemoryRateLimitStore is created using MaxKeys.
+	Store RateLimitStore
+	// MaxKeys defines the maximum number of keys the default MemoryRateLimitStore holds.
+	MaxKeys int
+}
+
+// RateLimitRule defines the number of requests allowed for each key in a window.
+// Rules without positive Requests and Window don't limit requests.
+type RateLimitRule struct {
+	// Requests defines the number of requests allowed in the window.
+	Requests int
+	// Window defines the duration of the window.
+	Window time.Duration
+	// Algorithm defines how requests are counted, if not set TokenBucket is used.
+	Algorithm RateLimitAlgorithm
+	// Key may be optionally used to define the key requests are limited by,
+	// if not set the rate limit middlewares Key is used.
+	Key RateLimitKey
+}
+
+// RateLimitResult is the result of taking a request from a key's limit.
+type RateLimitResult struct {
+	// Allowed is whether the request is allowed.
+	Allowed bool
+	// Limit is the number of requests allowed in the window.
+	Limit int
+	// Remaining is the number of requests remaining.
+	Remaining int
+	// Reset is the duration until all of the requests are available again.
+	Reset time.Duration
+	// RetryAfter is the duration until the next request is allowed, if the request isn't allowed.
+	RetryAfter time.Duration
+}
+
+// RateLimitStore stores the state of each key's limit. Stores must take requests atomically,
+// so a store shared by many servers limits requests across all of them.
+type RateLimitStore interface {
+	// Take takes a request from the limit of the key using the rule's algorithm.
+	Take(ctx context.Context, key string, rule RateLimitRule) (RateLimitResult, error)
+}
+
+type memoryRateLimitEntry struct {
+	key      string
+	tokens   float64
+	last     time.Time
+	start    time.Time
+	count    int
+	previous int
+}
+
+// MemoryRateLimitStore is a RateLimitStore that keeps limits in memory, evicting the least
+// recently used keys once the maximum number of keys has been reached. The limit of an
+// evicted key is reset.
+type MemoryRateLimitStore struct {
+	maxKeys int
+	entries map[string]*list.Element
+	order   *list.List
+	now     func() time.Time
+	mu      sync.Mutex
+}
+
+// NewMemoryRateLimitStore creates a memory rate limit store that holds up to maxKeys keys.
+// If maxKeys is zero or less the number of keys isn't bounded.
+func NewMemoryRateLimitStore(maxKeys int) *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		maxKeys: maxKeys,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+		now:     time.Now,
+	}
+}
+
+// Take takes a request from the limit of the key using the rule's algorithm.
+func (store *MemoryRateLimitStore) Take(_ context.Context, key string, rule RateLimitRule) (RateLimitResult, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	var entry *memoryRateLimitEntry
+
+	element, ok := store.entries[key]
+	if ok {
+		entry, _ = element.Value.(*memoryRateLimitEntry)
+		store.order.MoveToFront(element)
+	} else {
+		entry = &memoryRateLimitEntry{key: key}
+		store.entries[key] = store.order.PushFront(entry)
+
+		for store.maxKeys > 0 && store.order.Len() > store.maxKeys {
+			evicted, _ := store.order.Remove(store.order.Back()).(*memoryRateLimitEntry)
+			delete(store.entries, evicted.key)
+		}
+	}
+
+	now := store.now()
+
+	if rule.Algorithm == SlidingWindow {
+		return takeSlidingWindow(entry, rule, now), nil
+	}
+
+	return takeTokenBucket(entry, rule, now), nil
+}
+
+// Len returns the number of keys in the store.
+func (store *MemoryRateLimitStore) Len() int {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	return store.order.Len()
+}
+
+// RateLimitByClientIP limits requests by their ClientIP.
+func RateLimitByClientIP(req *http.Request) (string, bool) {
+	addr := ClientIP(req)
+	if !addr.IsValid() {
+		return "", false
+	}
+
+	return "ip:" + addr.String(), true
+}
+
+// RateLimitByHeader limits requests by the value of the header, such as an API key.
+// Requests without the header are limited by their ClientIP.
+func RateLimitByHeader(name string) RateLimitKey {
+	return func(req *http.Request) (string, bool) {
+		value := req.Header.Get(name)
+		if value == "" {
+			return RateLimitByClientIP(req)
+		}
+
+		return "header:" + value, true
+	}
+}
+
+// RateLimitByPrincipal limits requests by the authenticated principal returned by principal, such
+// as a user ID set by an authentication middleware. Requests without a principal are limited by their ClientIP.
+func RateLimitByPrincipal(principal func(req *http.Request) string) RateLimitKey {
+	return func(req *http.Request) (string, bool) {
+		value := principal(req)
+		if value == "" {
+			return RateLimitByClientIP(req)
+		}
+
+		return "principal:" + value, true
+	}
+}
+
+// RateLimit is a middleware that limits the rate of requests for each key, using the configured limit
+// for all requests and the limit of routes that define one using Route.RateLimit. Requests are
+// responded to with the RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset, and RateLimit-Policy
+// headers, using the limit with the fewest remaining requests.
+//
+// Requests that exceed a limit are responded to with the applications Error using a 429 Too Many Requests
+// *HTTPError wrapping ErrRateLimited, and the Retry-After header. If the store fails the error
+// is logged and the request is allowed.
+func RateLimit(config RateLimitConfig) Middleware {
+	config = buildRateLimitConfig(config)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			route := RequestRoute(req)
+
+			var (
+				limited    bool
+				policies   []string
+				result     RateLimitResult
+				retryAfter time.Duration
+			)
+
+			take := func(name string, rule *RateLimitRule) {
+				if rule == nil || rule.Requests <= 0 || rule.Window <= 0 {
+					return
+				}
+
+				key := config.Key
+				if rule.Key != nil {
+					key = rule.Key
+				}
+
+				value, ok := key(req)
+				if !ok {
+					return
+				}
+
+				taken, err := config.Store.Take(req.Context(), name+"\n"+value, *rule)
+				if err != nil {
+					Logger(req).With(slog.Any("error", err)).Error("failed to take rate limit")
+					return
+				}
+
+				policies = append(policies, strconv.Itoa(rule.Requests)+";w="+strconv.Itoa(ceilSeconds(rule.Window)))
+
+				if len(policies) == 1 || taken.Remaining < result.Remaining {
+					result = taken
+				}
+
+				if !taken.Allowed {
+					limited = true
+					retryAfter = max(retryAfter, taken.RetryAfter)
+				}
+			}
+
+			take("*", &config.Limit)
+			take("route:"+route.Name, route.RateLimit)
+
+			if len(policies) == 0 {
+				next.ServeHTTP(rw, req)
+				return
+			}
+
+			header := rw.Header()
+			header.Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+			header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+			header.Set("RateLimit-Policy", strings.Join(policies, ", "))
+
+			if limited {
+				// Retry-After is set on the response as well as the error, for error handlers that don't write its headers.
+				retrySeconds := strconv.Itoa(ceilSeconds(retryAfter))
+				header.Set("Retry-After", retrySeconds)
+
+				err := TooManyRequests(ErrRateLimited).WithHeader("Retry-After", retrySeconds)
+				requestErrorHandler(req)(rw, req, err.Status, err)
+
+				return
+			}
+
+			next.ServeHTTP(rw, req)
+		})
+	}
+}
+
+// takeTokenBucket takes a request from a bucket holding up to the rule's requests, which is
+// refilled at the rule's requests per window.
+func takeTokenBucket(entry *memoryRateLimitEntry, rule RateLimitRule, now time.Time) RateLimitResult {
+	capacity := float64(rule.Requests)
+	rate := capacity / rule.Window.Seconds()
+
+	if entry.last.IsZero() {
+		entry.tokens = capacity
+	} else {
+		entry.tokens = min(capacity, entry.tokens+now.Sub(entry.last).Seconds()*rate)
+	}
+
+	entry.last = now
+
+	result := RateLimitResult{Limit: rule.Requests}
+
+	if entry.tokens >= 1 {
+		entry.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = seconds((1 - entry.tokens) / rate)
+	}
+
+	result.Remaining = int(entry.tokens)
+	result.Reset = seconds((capacity - entry.tokens) / rate)
+
+	return result
+}
+
+// takeSlidingWindow takes a request from the sliding window ending now, whose count is estimated
+// using the current fixed window's count and the previous fixed window's weighted count.
+func takeSlidingWindow(entry *memoryRateLimitEntry, rule RateLimitRule, now time.Time) RateLimitResult {
+	start := now.Truncate(rule.Window)
+
+	switch {
+	case entry.start.Equal(start):
+	case entry.start.Add(rule.Window).Equal(start):
+		entry.previous, entry.count = entry.count, 0
+	default:
+		entry.previous, entry.count = 0, 0
+	}
+
+	entry.start = start
+
+	window := float64(rule.Window)
+	requests := float64(rule.Requests)
+	untilEnd := start.Add(rule.Window).Sub(now)
+	weight := float64(untilEnd) / window
+	estimated := float64(entry.previous)*weight + float64(entry.count)
+
+	result := RateLimitResult{Limit: rule.Requests}
+
+	if estimated+1 <= requests {
+		entry.count++
+		estimated++
+		result.Allowed = true
+	} else if entry.count+1 > rule.Requests {
+		// The request is allowed once enough of the current window's count has left the sliding window.
+		result.RetryAfter = untilEnd + time.Duration(window*max(0, 1-(requests-1)/float64(entry.count)))
+	} else {
+		// The request is allowed once enough of the previous window's count has left the sliding window.
+		result.RetryAfter = untilEnd - time.Duration(window*(requests-float64(entry.count)-1)/float64(entry.previous))
+	}
+
+	result.Remaining = max(0, int(requests-estimated))
+
+	result.Reset = untilEnd
+	if entry.count > 0 {
+		result.Reset += rule.Window
+	}
+
+	return result
+}
+
+func buildRateLimitConfig(config RateLimitConfig) RateLimitConfig {
+	built := DefaultRateLimitConfig
+
+	built.Limit = config.Limit
+
+	if config.Key != nil {
+		built.Key = config.Key
+	}
+
+	if config.MaxKeys != 0 {
+		built.MaxKeys = config.MaxKeys
+	}
+
+	built.Store = config.Store
+	if built.Store == nil {
+		built.Store = NewMemoryRateLimitStore(built.MaxKeys)
+	}
+
+	return built
+}
+
+// seconds returns the duration of the number of seconds.
+func seconds(value float64) time.Duration {
+	return time.Duration(value * float64(time.Second))
+}
+
+// ceilSeconds returns the duration in whole seconds, rounded up.
+func ceilSeconds(duration time.Duration) int {
+	return int(math.Ceil(duration.Seconds()))
+}
@@ -0,0 +1,317 @@
+package luci
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type errorRateLimitStore struct {
+	err error
+}
+
+func (store errorRateLimitStore) Take(context.Context, string, RateLimitRule) (RateLimitResult, error) {
+	return RateLimitResult{}, store.err
+}
+
+func TestMemoryRateLimitStore(t *testing.T) {
+	t.Parallel()
+
+	start := time.Unix(1000, 0).Truncate(10 * time.Second)
+
+	t.Run("takes requests from a token bucket", func(t *testing.T) {
+		t.Parallel()
+
+		now := start
+		store := NewMemoryRateLimitStore(0)
+		store.now = func() time.Time { return now }
+		rule := RateLimitRule{Requests: 2, Window: time.Second}
+
+		result, err := store.Take(t.Context(), "a", rule)
+		assert.NoError(t, err)
+		assert.Equal(t, RateLimitResult{Allowed: true, Limit: 2, Remaining: 1, Reset: 500 * time.Millisecond}, result)
+
+		result, err = store.Take(t.Context(), "a", rule)
+		assert.NoError(t, err)
+		assert.Equal(t, RateLimitResult{Allowed: true, Limit: 2, Remaining: 0, Reset: time.Second}, result)
+
+		result, err = store.Take(t.Context(), "a", rule)
+		assert.NoError(t, err)
+		assert.Equal(t, RateLimitResult{Limit: 2, Remaining: 0, Reset: time.Second, RetryAfter: 500 * time.Millisecond}, result)
+
+		now = now.Add(500 * time.Millisecond)
+
+		result, err = store.Take(t.Context(), "a", rule)
+		assert.NoError(t, err)
+		assert.Equal(t, RateLimitResult{Allowed: true, Limit: 2, Remaining: 0, Reset: time.Second}, result)
+	})
+
+	t.Run("takes requests from a sliding window", func(t *testing.T) {
+		t.Parallel()
+
+		now := start
+		store := NewMemoryRateLimitStore(0)
+		store.now = func() time.Time { return now }
+		rule := RateLimitRule{Requests: 2, Window: 10 * time.Second, Algorithm: SlidingWindow}
+
+		result, err := store.Take(t.Context(), "a", rule)
+		assert.NoError(t, err)
+		assert.Equal(t, RateLimitResult{Allowed: true, Limit: 2, Remaining: 1, Reset: 20 * time.Second}, result)
+
+		result, err = store.Take(t.Context(), "a", rule)
+		assert.NoError(t, err)
+		assert.Equal(t, RateLimitResult{Allowed: true, Limit: 2, Remaining: 0, Reset: 20 * time.Second}, result)
+
+		result, err = store.Take(t.Context(), "a", rule)
+		assert.NoError(t, err)
+		assert.Equal(t, RateLimitResult{Limit: 2, Remaining: 0, Reset: 20 * time.Second, RetryAfter: 15 * time.Second}, result)
+
+		now = start.Add(14 * time.Second)
+
+		result, err = store.Take(t.Context(), "a", rule)
+		assert.NoError(t, err)
+		assert.Equal(t, RateLimitResult{Limit: 2, Remaining: 0, Reset: 6 * time.Second, RetryAfter: time.Second}, result)
+
+		now = start.Add(15 * time.Second)
+
+		result, err = store.Take(t.Context(), "a", rule)
+		assert.NoError(t, err)
+		assert.Equal(t, RateLimitResult{Allowed: true, Limit: 2, Remaining: 0, Reset: 15 * time.Second}, result)
+
+		now = start.Add(40 * time.Second)
+
+		result, err = store.Take(t.Context(), "a", rule)
+		assert.NoError(t, err)
+		assert.Equal(t, RateLimitResult{Allowed: true, Limit: 2, Remaining: 1, Reset: 20 * time.Second}, result)
+	})
+
+	t.Run("evicts the least recently used keys", func(t *testing.T) {
+		t.Parallel()
+
+		store := NewMemoryRateLimitStore(2)
+		rule := RateLimitRule{Requests: 1, Window: time.Hour}
+
+		for _, key := range []string{"a", "b", "a", "c"} {
+			_, err := store.Take(t.Context(), key, rule)
+			assert.NoError(t, err)
+		}
+
+		assert.Equal(t, 2, store.Len())
+
+		result, err := store.Take(t.Context(), "a", rule)
+		assert.NoError(t, err)
+		assert.False(t, result.Allowed)
+
+		result, err = store.Take(t.Context(), "b", rule)
+		assert.NoError(t, err)
+		assert.True(t, result.Allowed)
+	})
+}
+
+func TestRateLimit(t *testing.T) {
+	t.Parallel()
+
+	serve := func(t *testing.T, middleware Middleware, route Route, clientIP string, header http.Header) (*httptest.ResponseRecorder, bool) {
+		var called bool
+
+		handler := middleware(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+			called = true
+
+			rw.WriteHeader(http.StatusOK)
+		}))
+
+		recorder, _ := serveTestRequest(handler, testRequest(t.Context(), http.MethodGet, "/status", nil, header, &requestState{
+			route:        &route,
+			forwarded:    forwarded{clientIP: netip.MustParseAddr(clientIP)},
+			serverLogger: slog.New(slog.DiscardHandler),
+		}))
+
+		return recorder, called
+	}
+
+	t.Run("doesn't limit requests without a limit", func(t *testing.T) {
+		t.Parallel()
+
+		middleware := RateLimit(RateLimitConfig{})
+
+		for range 3 {
+			recorder, called := serve(t, middleware, Route{Name: "status"}, "192.0.2.1", nil)
+			assert.True(t, called)
+			assert.Empty(t, recorder.Header().Get("RateLimit-Limit"))
+		}
+	})
+
+	t.Run("limits all requests", func(t *testing.T) {
+		t.Parallel()
+
+		middleware := RateLimit(RateLimitConfig{Limit: RateLimitRule{Requests: 1, Window: time.Minute}})
+
+		recorder, called := serve(t, middleware, Route{Name: "status"}, "192.0.2.1", nil)
+		assert.True(t, called)
+		assert.Equal(t, http.Header{
+			"Ratelimit-Limit":     []string{"1"},
+			"Ratelimit-Remaining": []string{"0"},
+			"Ratelimit-Reset":     []string{"60"},
+			"Ratelimit-Policy":    []string{"1;w=60"},
+		}, recorder.Header())
+
+		recorder, called = serve(t, middleware, Route{Name: "user"}, "192.0.2.1", nil)
+		assert.False(t, called)
+		assert.Equal(t, http.StatusTooManyRequests, recorder.Code)
+		assert.Equal(t, "60", recorder.Header().Get("Retry-After"))
+
+		_, called = serve(t, middleware, Route{Name: "status"}, "192.0.2.2", nil)
+		assert.True(t, called)
+	})
+
+	t.Run("limits routes in addition to all requests", func(t *testing.T) {
+		t.Parallel()
+
+		middleware := RateLimit(RateLimitConfig{Limit: RateLimitRule{Requests: 10, Window: time.Minute}})
+		route := Route{Name: "status", RateLimit: &RateLimitRule{Requests: 1, Window: time.Second}}
+
+		recorder, called := serve(t, middleware, route, "192.0.2.1", nil)
+		assert.True(t, called)
+		assert.Equal(t, "1", recorder.Header().Get("RateLimit-Limit"))
+		assert.Equal(t, "0", recorder.Header().Get("RateLimit-Remaining"))
+		assert.Equal(t, "10;w=60, 1;w=1", recorder.Header().Get("RateLimit-Policy"))
+
+		recorder, called = serve(t, middleware, route, "192.0.2.1", nil)
+		assert.False(t, called)
+		assert.Equal(t, "1", recorder.Header().Get("Retry-After"))
+
+		recorder, called = serve(t, middleware, Route{Name: "user"}, "192.0.2.1", nil)
+		assert.True(t, called)
+		assert.Equal(t, "10", recorder.Header().Get("RateLimit-Limit"))
+		assert.Equal(t, "7", recorder.Header().Get("RateLimit-Remaining"))
+	})
+
+	t.Run("responds with the applications error if a limit is exceeded", func(t *testing.T) {
+		t.Parallel()
+
+		var app TestApplication
+
+		middleware := RateLimit(RateLimitConfig{Limit: RateLimitRule{Requests: 1, Window: time.Minute}})
+
+		serve(t, middleware, Route{}, "192.0.2.1", nil)
+
+		recorder := httptest.NewRecorder()
+		request := testRequest(t.Context(), http.MethodGet, "/status", nil, nil, &requestState{app: &app, forwarded: forwarded{clientIP: netip.MustParseAddr("192.0.2.1")}})
+
+		app.On("Error", recorder, request, http.StatusTooManyRequests, mock.Anything).Run(func(args mock.Arguments) {
+			err, _ := args.Get(3).(error)
+			assert.ErrorIs(t, err, ErrRateLimited)
+
+			var httpErr *HTTPError
+			assert.ErrorAs(t, err, &httpErr)
+			assert.Equal(t, "60", httpErr.Header.Get("Retry-After"))
+		})
+
+		middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+			assert.Fail(t, "handler called")
+		})).ServeHTTP(recorder, request)
+
+		app.AssertExpectations(t)
+	})
+
+	t.Run("limits requests by key", func(t *testing.T) {
+		t.Parallel()
+
+		principal := func(req *http.Request) string {
+			return req.Header.Get("User")
+		}
+
+		middleware := RateLimit(RateLimitConfig{
+			Limit: RateLimitRule{Requests: 1, Window: time.Minute, Key: RateLimitByPrincipal(principal)},
+		})
+		route := Route{Name: "status", RateLimit: &RateLimitRule{Requests: 5, Window: time.Minute, Key: RateLimitByHeader("Api-Key")}}
+
+		_, called := serve(t, middleware, route, "192.0.2.1", http.Header{"User": []string{"a"}, "Api-Key": []string{"a"}})
+		assert.True(t, called)
+
+		_, called = serve(t, middleware, route, "192.0.2.2", http.Header{"User": []string{"a"}})
+		assert.False(t, called)
+
+		recorder, called := serve(t, middleware, route, "192.0.2.2", http.Header{"User": []string{"b"}, "Api-Key": []string{"a"}})
+		assert.True(t, called)
+		assert.Equal(t, "0", recorder.Header().Get("RateLimit-Remaining"))
+		assert.Equal(t, "1;w=60, 5;w=60", recorder.Header().Get("RateLimit-Policy"))
+
+		_, called = serve(t, middleware, Route{}, "192.0.2.1", nil)
+		assert.True(t, called)
+
+		_, called = serve(t, middleware, Route{}, "192.0.2.1", nil)
+		assert.False(t, called)
+	})
+
+	t.Run("doesn't limit requests without a key", func(t *testing.T) {
+		t.Parallel()
+
+		middleware := RateLimit(RateLimitConfig{
+			Limit: RateLimitRule{Requests: 1, Window: time.Minute},
+			Key: func(*http.Request) (string, bool) {
+				return "", false
+			},
+		})
+
+		for range 3 {
+			_, called := serve(t, middleware, Route{}, "192.0.2.1", nil)
+			assert.True(t, called)
+		}
+	})
+
+	t.Run("allows requests if the store fails", func(t *testing.T) {
+		t.Parallel()
+
+		middleware := RateLimit(RateLimitConfig{
+			Limit: RateLimitRule{Requests: 1, Window: time.Minute},
+			Store: errorRateLimitStore{err: errors.New("store failed")},
+		})
+
+		for range 3 {
+			recorder, called := serve(t, middleware, Route{}, "192.0.2.1", nil)
+			assert.True(t, called)
+			assert.Empty(t, recorder.Header().Get("RateLimit-Limit"))
+		}
+	})
+}
+
+func TestRateLimitByClientIP(t *testing.T) {
+	t.Parallel()
+
+	request := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/status", nil)
+
+	_, ok := RateLimitByClientIP(request)
+	assert.False(t, ok)
+
+	request = withState(request, &requestState{forwarded: forwarded{clientIP: netip.MustParseAddr("2001:db8::1")}})
+
+	key, ok := RateLimitByClientIP(request)
+	assert.True(t, ok)
+	assert.Equal(t, "ip:2001:db8::1", key)
+}
+
+func TestBuildRateLimitConfig(t *testing.T) {
+	t.Parallel()
+
+	config := buildRateLimitConfig(RateLimitConfig{})
+	assert.IsType(t, new(MemoryRateLimitStore), config.Store)
+	assert.NotNil(t, config.Key)
+	assert.Equal(t, DefaultRateLimitConfig.MaxKeys, config.MaxKeys)
+	assert.Equal(t, RateLimitRule{}, config.Limit)
+
+	store := NewMemoryRateLimitStore(1)
+	limit := RateLimitRule{Requests: 1, Window: time.Second, Algorithm: SlidingWindow}
+	config = buildRateLimitConfig(RateLimitConfig{Store: store, MaxKeys: 5, Limit: limit})
+	assert.Equal(t, store, config.Store)
+	assert.Equal(t, 5, config.MaxKeys)
+	assert.Equal(t, limit, config.Limit)
+}
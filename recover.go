@@ -16,37 +16,31 @@ func withRecover(errorHandler ErrorHandlerFunc) Middleware {
 					return
 				}
 
-				var err error
-
-				switch v := val.(type) {
-				case error:
-					err = v
-				default:
-					err = fmt.Errorf("%+v", v)
-				}
-
+				err := recoveredError(val)
 				if errors.Is(err, http.ErrAbortHandler) {
 					return
 				}
 
-				var wroteHeader bool
-
-				switch resWriter := rw.(type) {
-				case *responseWriter:
-					wroteHeader, _, _ = resWriter.stats()
-				case *timeoutResponseWriter:
-					wroteHeader, _, _ = resWriter.stats()
-				}
-
-				if wroteHeader {
+				resWriter := findResponseWriter(rw)
+				if resWriter != nil && resWriter.stats().wroteHeader {
 					Logger(req).With(slog.Any("error", err)).Error("unable to write recovered error response, response already written")
 					return
 				}
 
-				errorHandler(rw, req, http.StatusInternalServerError, err)
+				errorHandler(rw, req, http.StatusInternalServerError, InternalServerError(err))
 			}()
 
 			next.ServeHTTP(rw, req)
 		})
 	}
 }
+
+// recoveredError returns the error for a value recovered from a panic.
+func recoveredError(val any) error {
+	err, ok := val.(error)
+	if !ok {
+		err = fmt.Errorf("%+v", val)
+	}
+
+	return err
+}
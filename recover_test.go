@@ -77,6 +77,7 @@ func TestWithRecover(t *testing.T) {
 
 		middlewares := Middlewares{
 			withResponseWriter,
+			withRequestState,
 			withLogger(noopLogger),
 			withRecover(errorHandler),
 		}
@@ -103,7 +104,7 @@ func TestWithRecover(t *testing.T) {
 			called = true
 
 			assert.Equal(t, http.StatusInternalServerError, status)
-			assert.Equal(t, io.ErrUnexpectedEOF, err)
+			assert.Equal(t, InternalServerError(io.ErrUnexpectedEOF), err)
 		}
 
 		middlewares := Middlewares{
@@ -132,7 +133,7 @@ func TestWithRecover(t *testing.T) {
 			called = true
 
 			assert.Equal(t, http.StatusInternalServerError, status)
-			assert.Equal(t, errors.New("5"), err)
+			assert.Equal(t, InternalServerError(errors.New("5")), err)
 		}
 
 		middlewares := Middlewares{
@@ -1,18 +1,58 @@
 package luci
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"slices"
+	"strings"
 	"sync"
+	"time"
 )
 
+type responseStats struct {
+	wroteHeader        bool
+	hijacked           bool
+	status             int
+	informational      []int
+	length             int64
+	encoding           string
+	uncompressedLength int64
+	events             int64
+	cache              string
+	coalesced          bool
+	trailers           []string
+}
+
 type responseWriter struct {
+	rw                 http.ResponseWriter
+	wroteHeader        bool
+	hijacked           bool
+	status             int
+	informational      []int
+	length             int64
+	encoding           string
+	uncompressedLength int64
+	events             int64
+	cache              string
+	coalesced          bool
+	mu                 sync.Mutex
+}
+
+// recordWriter records a copy of the response while writing it, so the response
+// can be replayed for other requests.
+type recordWriter struct {
 	rw          http.ResponseWriter
-	wroteHeader bool
+	before      http.Header
+	header      http.Header
+	maxLength   int
 	status      int
-	length      int64
-	mu          sync.Mutex
+	body        []byte
+	wroteHeader bool
+	recorded    bool
 }
 
 func (rw *responseWriter) Header() http.Header {
@@ -27,6 +67,15 @@ func (rw *responseWriter) WriteHeader(status int) {
 		return
 	}
 
+	// Informational responses such as 103 Early Hints may be written any number
+	// of times before the final status, 101 Switching Protocols is final.
+	if status >= 100 && status < 200 && status != http.StatusSwitchingProtocols {
+		rw.informational = append(rw.informational, status)
+		rw.rw.WriteHeader(status)
+
+		return
+	}
+
 	rw.lockedWriteHeader(status)
 }
 
@@ -45,10 +94,88 @@ func (rw *responseWriter) ReadFrom(r io.Reader) (int64, error) {
 }
 
 func (rw *responseWriter) Flush() {
-	flusher, ok := rw.rw.(http.Flusher)
-	if ok {
-		flusher.Flush()
+	_ = rw.FlushError()
+}
+
+func (rw *responseWriter) FlushError() error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	return rw.lockedFlush()
+}
+
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	return rw.lockedHijack()
+}
+
+func (rw *responseWriter) SetReadDeadline(deadline time.Time) error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	return rw.lockedSetReadDeadline(deadline)
+}
+
+func (rw *responseWriter) SetWriteDeadline(deadline time.Time) error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	return rw.lockedSetWriteDeadline(deadline)
+}
+
+func (rw *responseWriter) EnableFullDuplex() error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	return rw.lockedEnableFullDuplex()
+}
+
+// Unwrap returns the wrapped ResponseWriter, which allows http.ResponseController
+// to find optional interfaces that aren't implemented by responseWriter.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.rw
+}
+
+func (rw *responseWriter) lockedFlush() error {
+	if !rw.wroteHeader {
+		rw.lockedWriteHeader(http.StatusOK)
 	}
+
+	err := http.NewResponseController(rw.rw).Flush()
+	if err != nil {
+		return fmt.Errorf("luci: flush: %w", err)
+	}
+
+	return nil
+}
+
+func (rw *responseWriter) lockedSetReadDeadline(deadline time.Time) error {
+	err := http.NewResponseController(rw.rw).SetReadDeadline(deadline)
+	if err != nil {
+		return fmt.Errorf("luci: set read deadline: %w", err)
+	}
+
+	return nil
+}
+
+func (rw *responseWriter) lockedSetWriteDeadline(deadline time.Time) error {
+	err := http.NewResponseController(rw.rw).SetWriteDeadline(deadline)
+	if err != nil {
+		return fmt.Errorf("luci: set write deadline: %w", err)
+	}
+
+	return nil
+}
+
+func (rw *responseWriter) lockedEnableFullDuplex() error {
+	err := http.NewResponseController(rw.rw).EnableFullDuplex()
+	if err != nil {
+		return fmt.Errorf("luci: enable full duplex: %w", err)
+	}
+
+	return nil
 }
 
 func (rw *responseWriter) lockedWriteHeader(status int) {
@@ -89,11 +216,238 @@ func (rw *responseWriter) lockedReadFrom(r io.Reader) (int64, error) {
 	return n, nil
 }
 
-func (rw *responseWriter) stats() (bool, int, int64) {
+func (rw *responseWriter) lockedHijack() (net.Conn, *bufio.ReadWriter, error) {
+	if rw.wroteHeader {
+		return nil, nil, errors.New("luci: hijack: response already written")
+	}
+
+	conn, buf, err := http.NewResponseController(rw.rw).Hijack()
+	if err != nil {
+		return nil, nil, fmt.Errorf("luci: hijack: %w", err)
+	}
+
+	// The hijacked connection is written to directly, so treat the header as written
+	// to prevent writes to the original response.
+	rw.wroteHeader = true
+	rw.hijacked = true
+
+	return conn, buf, nil
+}
+
+// hijackedStatus records the status written directly to a hijacked connection.
+func (rw *responseWriter) hijackedStatus(status int) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	rw.status = status
+}
+
+// compressed records the encoding and uncompressed length of a compressed response.
+func (rw *responseWriter) compressed(encoding string, uncompressedLength int64) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	rw.encoding = encoding
+	rw.uncompressedLength = uncompressedLength
+}
+
+func (rw *responseWriter) addEvent() {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	rw.events++
+}
+
+// cached records whether the response was served from the cache.
+func (rw *responseWriter) cached(status string) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	rw.cache = status
+}
+
+// coalesce records that the response was copied from a coalesced request.
+func (rw *responseWriter) coalesce() {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	rw.coalesced = true
+}
+
+func (rw *responseWriter) stats() responseStats {
 	rw.mu.Lock()
 	defer rw.mu.Unlock()
 
-	return rw.wroteHeader, rw.status, rw.length
+	return responseStats{
+		wroteHeader:        rw.wroteHeader,
+		hijacked:           rw.hijacked,
+		status:             rw.status,
+		informational:      slices.Clone(rw.informational),
+		length:             rw.length,
+		encoding:           rw.encoding,
+		uncompressedLength: rw.uncompressedLength,
+		events:             rw.events,
+		cache:              rw.cache,
+		coalesced:          rw.coalesced,
+		trailers:           responseTrailers(rw.rw.Header()),
+	}
+}
+
+// newRecordWriter creates a record writer that records responses up to maxLength.
+func newRecordWriter(rw http.ResponseWriter, maxLength int) *recordWriter {
+	return &recordWriter{rw: rw, before: rw.Header().Clone(), maxLength: maxLength}
+}
+
+func (rw *recordWriter) Header() http.Header {
+	return rw.rw.Header()
+}
+
+func (rw *recordWriter) WriteHeader(status int) {
+	if rw.wroteHeader {
+		return
+	}
+
+	if status >= 100 && status < 200 && status != http.StatusSwitchingProtocols {
+		rw.rw.WriteHeader(status)
+		return
+	}
+
+	rw.wroteHeader = true
+	rw.recorded = true
+	rw.status = status
+	rw.header = rw.rw.Header().Clone()
+
+	rw.rw.WriteHeader(status)
+}
+
+func (rw *recordWriter) Write(b []byte) (int, error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	if rw.recorded {
+		rw.body = append(rw.body, b...)
+		if len(rw.body) > rw.maxLength {
+			rw.recorded = false
+			rw.body = nil
+		}
+	}
+
+	n, err := rw.rw.Write(b)
+	if err != nil {
+		rw.recorded = false
+		return n, fmt.Errorf("luci: record: %w", err)
+	}
+
+	return n, nil
+}
+
+func (rw *recordWriter) Flush() {
+	_ = rw.FlushError()
+}
+
+func (rw *recordWriter) FlushError() error {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	err := http.NewResponseController(rw.rw).Flush()
+	if err != nil {
+		return fmt.Errorf("luci: record: %w", err)
+	}
+
+	return nil
+}
+
+func (rw *recordWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if rw.wroteHeader {
+		return nil, nil, fmt.Errorf("luci: record: hijack: %w", http.ErrHijacked)
+	}
+
+	conn, buf, err := http.NewResponseController(rw.rw).Hijack()
+	if err != nil {
+		return nil, nil, fmt.Errorf("luci: record: %w", err)
+	}
+
+	rw.wroteHeader = true
+	rw.recorded = false
+
+	return conn, buf, nil
+}
+
+// Unwrap returns the wrapped ResponseWriter, see http.ResponseController.
+func (rw *recordWriter) Unwrap() http.ResponseWriter {
+	return rw.rw
+}
+
+// handlerHeader returns the recorded headers that were set after the record writer was created.
+// Headers set by earlier middlewares such as the request id are specific to the request.
+func (rw *recordWriter) handlerHeader() http.Header {
+	header := make(http.Header)
+	for name, values := range rw.header {
+		if !slices.Equal(values, rw.before[name]) {
+			header[name] = values
+		}
+	}
+
+	return header
+}
+
+// writeRecorded writes a recorded response, replacing any headers with the recorded headers.
+func writeRecorded(rw http.ResponseWriter, status int, recorded http.Header, body []byte) {
+	header := rw.Header()
+	for name, values := range recorded {
+		header[name] = slices.Clone(values)
+	}
+
+	rw.WriteHeader(status)
+
+	if len(body) != 0 {
+		_, _ = rw.Write(body)
+	}
+}
+
+// findResponseWriter returns the responseWriter wrapped by rw if one exists,
+// following Unwrap for response writers wrapped by other middlewares.
+func findResponseWriter(rw http.ResponseWriter) *responseWriter {
+	for {
+		switch resWriter := rw.(type) {
+		case *responseWriter:
+			return resWriter
+		case *timeoutResponseWriter:
+			return resWriter.responseWriter
+		case interface{ Unwrap() http.ResponseWriter }:
+			rw = resWriter.Unwrap()
+		default:
+			return nil
+		}
+	}
+}
+
+// responseTrailers returns the names of the trailers that have been set, either
+// declared in the Trailer header or set using the http.TrailerPrefix.
+func responseTrailers(header http.Header) []string {
+	var trailers []string
+
+	for _, value := range header.Values("Trailer") {
+		for name := range strings.SplitSeq(value, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name != "" && len(header.Values(name)) != 0 {
+				trailers = append(trailers, name)
+			}
+		}
+	}
+
+	for key := range header {
+		name, ok := strings.CutPrefix(key, http.TrailerPrefix)
+		if ok && name != "" {
+			trailers = append(trailers, http.CanonicalHeaderKey(name))
+		}
+	}
+
+	slices.Sort(trailers)
+
+	return slices.Compact(trailers)
 }
 
 func withResponseWriter(next http.Handler) http.Handler {
@@ -1,21 +1,107 @@
 package luci
 
 import (
+	"bufio"
 	"bytes"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
+type hijackableRecorder struct {
+	*httptest.ResponseRecorder
+	conn net.Conn
+}
+
+func (rw *hijackableRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return rw.conn, bufio.NewReadWriter(bufio.NewReader(rw.conn), bufio.NewWriter(rw.conn)), nil
+}
+
+type statusRecorder struct {
+	*httptest.ResponseRecorder
+	statuses []int
+}
+
+func (rw *statusRecorder) WriteHeader(status int) {
+	rw.statuses = append(rw.statuses, status)
+	rw.ResponseRecorder.WriteHeader(status)
+}
+
+type unwrappingResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (rw unwrappingResponseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 func TestResponseWriter(t *testing.T) {
 	t.Parallel()
 
 	rw := new(responseWriter)
 	assert.Implements(t, (*io.ReaderFrom)(nil), rw)
 	assert.Implements(t, (*http.Flusher)(nil), rw)
+	assert.Implements(t, (*http.Hijacker)(nil), rw)
+	assert.Implements(t, (*interface{ FlushError() error })(nil), rw)
+	assert.Implements(t, (*interface{ SetReadDeadline(time.Time) error })(nil), rw)
+	assert.Implements(t, (*interface{ SetWriteDeadline(time.Time) error })(nil), rw)
+	assert.Implements(t, (*interface{ EnableFullDuplex() error })(nil), rw)
+	assert.Implements(t, (*interface{ Unwrap() http.ResponseWriter })(nil), rw)
+}
+
+func TestResponseWriterUnwrap(t *testing.T) {
+	t.Parallel()
+
+	rw := httptest.NewRecorder()
+	wrw := &responseWriter{rw: rw}
+
+	assert.Equal(t, rw, wrw.Unwrap())
+}
+
+func TestResponseWriterResponseController(t *testing.T) {
+	t.Parallel()
+
+	t.Run("supports response controller through wrapped ResponseWriter", func(t *testing.T) {
+		t.Parallel()
+
+		handler := withResponseWriter(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+			controller := http.NewResponseController(unwrappingResponseWriter{rw})
+
+			assert.NoError(t, controller.SetReadDeadline(time.Now().Add(time.Second)))
+			assert.NoError(t, controller.SetWriteDeadline(time.Now().Add(time.Second)))
+			assert.NoError(t, controller.EnableFullDuplex())
+			assert.NoError(t, controller.Flush())
+
+			assert.True(t, findResponseWriter(rw).stats().wroteHeader)
+		}))
+
+		server := httptest.NewServer(handler)
+		defer server.Close()
+
+		req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, server.URL, nil)
+		assert.NoError(t, err)
+
+		res, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		assert.NoError(t, res.Body.Close())
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+	})
+
+	t.Run("returns not supported errors", func(t *testing.T) {
+		t.Parallel()
+
+		wrw := &responseWriter{rw: httptest.NewRecorder()}
+		controller := http.NewResponseController(wrw)
+
+		assert.ErrorIs(t, controller.SetReadDeadline(time.Now()), http.ErrNotSupported)
+		assert.ErrorIs(t, controller.SetWriteDeadline(time.Now()), http.ErrNotSupported)
+		assert.ErrorIs(t, controller.EnableFullDuplex(), http.ErrNotSupported)
+	})
 }
 
 func TestResponseWriterHeader(t *testing.T) {
@@ -61,6 +147,50 @@ func TestResponseWriterWriteHeader(t *testing.T) {
 	})
 }
 
+func TestResponseWriterInformational(t *testing.T) {
+	t.Parallel()
+
+	rw := &statusRecorder{ResponseRecorder: httptest.NewRecorder()}
+	wrw := &responseWriter{rw: rw}
+
+	wrw.WriteHeader(http.StatusEarlyHints)
+	wrw.WriteHeader(http.StatusEarlyHints)
+	assert.False(t, wrw.stats().wroteHeader)
+
+	wrw.WriteHeader(http.StatusCreated)
+	wrw.WriteHeader(http.StatusContinue)
+
+	stats := wrw.stats()
+	assert.Equal(t, []int{http.StatusEarlyHints, http.StatusEarlyHints, http.StatusCreated}, rw.statuses)
+	assert.Equal(t, []int{http.StatusEarlyHints, http.StatusEarlyHints}, stats.informational)
+	assert.Equal(t, http.StatusCreated, stats.status)
+	assert.True(t, stats.wroteHeader)
+
+	wrw = &responseWriter{rw: httptest.NewRecorder()}
+	wrw.WriteHeader(http.StatusSwitchingProtocols)
+
+	stats = wrw.stats()
+	assert.Empty(t, stats.informational)
+	assert.Equal(t, http.StatusSwitchingProtocols, stats.status)
+}
+
+func TestResponseWriterTrailers(t *testing.T) {
+	t.Parallel()
+
+	wrw := &responseWriter{rw: httptest.NewRecorder()}
+
+	header := wrw.Header()
+	header.Set("Trailer", "x-checksum, X-Unset")
+	header.Set(http.TrailerPrefix+"X-Other", "value")
+
+	_, err := wrw.Write([]byte("abc"))
+	assert.NoError(t, err)
+
+	header.Set("X-Checksum", "abc")
+
+	assert.Equal(t, []string{"X-Checksum", "X-Other"}, wrw.stats().trailers)
+}
+
 func TestResponseWriterWrite(t *testing.T) {
 	t.Parallel()
 
@@ -172,6 +302,90 @@ func TestResponseWriterFlush(t *testing.T) {
 	wrw.Flush()
 
 	assert.True(t, rw.Flushed)
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.True(t, wrw.stats().wroteHeader)
+}
+
+func TestResponseWriterHijack(t *testing.T) {
+	t.Parallel()
+
+	t.Run("hijacks wrapped ResponseWriter", func(t *testing.T) {
+		t.Parallel()
+
+		server, client := net.Pipe()
+		defer server.Close()
+		defer client.Close()
+
+		wrw := &responseWriter{rw: &hijackableRecorder{ResponseRecorder: httptest.NewRecorder(), conn: server}}
+
+		conn, buf, err := wrw.Hijack()
+		assert.NoError(t, err)
+		assert.Equal(t, server, conn)
+		assert.NotNil(t, buf)
+
+		wrw.hijackedStatus(http.StatusSwitchingProtocols)
+
+		stats := wrw.stats()
+		assert.True(t, stats.hijacked)
+		assert.True(t, stats.wroteHeader)
+		assert.Equal(t, http.StatusSwitchingProtocols, stats.status)
+	})
+
+	t.Run("returns error if wrapped ResponseWriter isn't a Hijacker", func(t *testing.T) {
+		t.Parallel()
+
+		wrw := &responseWriter{rw: httptest.NewRecorder()}
+
+		_, _, err := wrw.Hijack()
+		assert.ErrorIs(t, err, http.ErrNotSupported)
+		assert.False(t, wrw.stats().hijacked)
+	})
+
+	t.Run("returns error if response has been written", func(t *testing.T) {
+		t.Parallel()
+
+		wrw := &responseWriter{rw: &hijackableRecorder{ResponseRecorder: httptest.NewRecorder()}}
+		wrw.WriteHeader(http.StatusOK)
+
+		_, _, err := wrw.Hijack()
+		assert.EqualError(t, err, "luci: hijack: response already written")
+	})
+}
+
+func TestResponseWriterStats(t *testing.T) {
+	t.Parallel()
+
+	wrw := &responseWriter{rw: httptest.NewRecorder()}
+	wrw.WriteHeader(http.StatusAccepted)
+
+	_, err := wrw.Write([]byte("abc"))
+	assert.NoError(t, err)
+
+	wrw.addEvent()
+	wrw.addEvent()
+	wrw.cached("hit")
+	wrw.coalesce()
+
+	assert.Equal(t, responseStats{
+		wroteHeader: true,
+		status:      http.StatusAccepted,
+		length:      3,
+		events:      2,
+		cache:       "hit",
+		coalesced:   true,
+	}, wrw.stats())
+}
+
+func TestFindResponseWriter(t *testing.T) {
+	t.Parallel()
+
+	wrw := &responseWriter{rw: httptest.NewRecorder()}
+
+	assert.Equal(t, wrw, findResponseWriter(wrw))
+	assert.Equal(t, wrw, findResponseWriter(&timeoutResponseWriter{responseWriter: wrw}))
+	assert.Equal(t, wrw, findResponseWriter(unwrappingResponseWriter{unwrappingResponseWriter{wrw}}))
+	assert.Nil(t, findResponseWriter(httptest.NewRecorder()))
+	assert.Nil(t, findResponseWriter(unwrappingResponseWriter{httptest.NewRecorder()}))
 }
 
 func TestResponseWithResponseWriter(t *testing.T) {
@@ -191,3 +405,68 @@ func TestResponseWithResponseWriter(t *testing.T) {
 
 	assert.Equal(t, http.StatusOK, rw.Code)
 }
+
+func TestRecordWriter(t *testing.T) {
+	t.Parallel()
+
+	t.Run("records the response", func(t *testing.T) {
+		t.Parallel()
+
+		recorder := httptest.NewRecorder()
+		recorder.Header().Set("X-Request-Id", "abc")
+
+		rw := newRecordWriter(recorder, 10)
+		rw.Header().Set("Content-Type", "text/plain")
+		rw.WriteHeader(http.StatusCreated)
+
+		_, err := rw.Write([]byte("luci"))
+		assert.NoError(t, err)
+
+		assert.True(t, rw.recorded)
+		assert.Equal(t, http.StatusCreated, rw.status)
+		assert.Equal(t, []byte("luci"), rw.body)
+		assert.Equal(t, http.Header{"Content-Type": []string{"text/plain"}}, rw.handlerHeader())
+		assert.Equal(t, "luci", recorder.Body.String())
+	})
+
+	t.Run("doesn't record long responses", func(t *testing.T) {
+		t.Parallel()
+
+		recorder := httptest.NewRecorder()
+
+		rw := newRecordWriter(recorder, 4)
+
+		_, err := rw.Write([]byte("luci luci"))
+		assert.NoError(t, err)
+
+		assert.False(t, rw.recorded)
+		assert.Nil(t, rw.body)
+		assert.Equal(t, "luci luci", recorder.Body.String())
+	})
+
+	t.Run("doesn't record hijacked responses", func(t *testing.T) {
+		t.Parallel()
+
+		rw := newRecordWriter(&hijackableRecorder{ResponseRecorder: httptest.NewRecorder()}, 4)
+
+		_, _, err := rw.Hijack()
+		assert.NoError(t, err)
+		assert.False(t, rw.recorded)
+
+		_, _, err = rw.Hijack()
+		assert.ErrorIs(t, err, http.ErrHijacked)
+	})
+}
+
+func TestWriteRecorded(t *testing.T) {
+	t.Parallel()
+
+	recorder := httptest.NewRecorder()
+	recorder.Header().Set("Content-Type", "application/json")
+
+	writeRecorded(recorder, http.StatusAccepted, http.Header{"Content-Type": []string{"text/plain"}}, []byte("luci"))
+
+	assert.Equal(t, http.StatusAccepted, recorder.Code)
+	assert.Equal(t, "text/plain", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, "luci", recorder.Body.String())
+}
@@ -1,13 +1,10 @@
 package luci
 
 import (
-	"bytes"
-	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
 	"regexp"
-	"strings"
 	"time"
 )
 
@@ -15,8 +12,6 @@ var (
 	varMatcher = regexp.MustCompile("^{([^:]*):?(.*)}$")
 )
 
-type requestRouteKey struct{}
-
 // Route defines an endpoint an application supports.
 type Route struct {
 	// Name is used to uniquely identify a route by name.
@@ -25,23 +20,59 @@ type Route struct {
 	// Once the timeout has been reached a timeout response is
 	// sent and the request context is cancelled. If Timeout is
 	// not set the default RouteTimeout will be used instead.
+	// If Timeout is negative the route has no timeout. The timeout
+	// is lifted once an event stream is started with EventStream,
+	// and is ignored for WebSocket routes.
 	Timeout time.Duration
 	// Method may be optionally used to specify the method a route supports.
 	// If not set the route will be used for all methods.
 	Method string
 	// Defines the pattern that the route should match on.
 	// Refer to github.com/go-chi/chi/v5 for details on defining patterns.
+	// Variables may use the name of one of the servers VarConverters in
+	// place of a regex, e.g. {id:int}.
 	Pattern string
+	// Host may be optionally used to restrict the route to requests whose host, excluding the port,
+	// matches. Host may define variables the same way as Pattern, e.g. {tenant}.api.example.com,
+	// whose values are included in the request's Vars. Variables without a regex match a single
+	// label. Routes with a Host take precedence over routes without one for the same pattern and method.
+	Host string
+	// ContentTypes may be optionally used to further restrict the content types a route accepts and responds
+	// with. Each content type must match one of the servers configured codecs. Requests with an Accept header
+	// that doesn't match any of the content types are responded to with 406 Not Acceptable, and requests with a
+	// body whose Content-Type doesn't match are responded to with 415 Unsupported Media Type.
+	// If not set all of the servers configured codecs are allowed.
+	ContentTypes []string
+	// CORS may be optionally used to override the servers CORS configuration for the route.
+	CORS *CORSConfig
+	// PathPolicy may be optionally used to override the servers path policy for the route.
+	PathPolicy *PathPolicy
 	// Middlewares define the route specific middlewares to run after the application middlewares.
 	Middlewares Middlewares
 	// HandlerFunc defines the handler function to call to handle the request.
 	HandlerFunc http.HandlerFunc
+	// Cache may be optionally used to cache the routes GET responses when the application uses
+	// the Cache middleware. If not set the routes responses aren't cached.
+	Cache *RouteCache
+	// RateLimit may be optionally used to limit the rate of the routes requests when the application uses
+	// the RateLimit middleware, in addition to its limit for all requests. If not set only that limit applies.
+	RateLimit *RateLimitRule
+	// WebSocket may be used instead of HandlerFunc to upgrade requests to WebSocket connections.
+	// WebSocket routes only match GET requests and have no timeout.
+	WebSocket *WebSocket
+
+	template *routeTemplate
+	host     *hostPattern
 }
 
 // RequestRoute retrieves the route that's associated with the given request.
 func RequestRoute(req *http.Request) Route {
-	route, _ := req.Context().Value(requestRouteKey{}).(Route)
-	return route
+	state := requestStateFrom(req)
+	if state == nil || state.route == nil {
+		return Route{}
+	}
+
+	return *state.route
 }
 
 // String returns the routes name, method, and pattern.
@@ -49,107 +80,88 @@ func (route Route) String() string {
 	return fmt.Sprintf("%s %s %s", route.Name, route.Method, route.Pattern)
 }
 
-// Path builds an absolute path from the routes pattern using the given variable values.
-// Variable values must be in the order defined by the routes pattern, and are validated
-// against the associated regex matcher if one exists.
-func (route Route) Path(vals ...string) (string, error) {
-	if route.Pattern == "" {
-		return "", errors.New("luci: route pattern must not be empty")
-	}
-
-	if route.Pattern[0] != '/' {
-		return "", errors.New("luci: route pattern must begin with /")
+// URL builds a URL from the routes host and pattern using the given variables, and appends the query
+// parameters. vars may be a map[string]string of variables, or a struct whose fields use the path and
+// query tags the same way as Bind, with query fields that have zero values omitted. If the route has a
+// host the URL has no scheme, otherwise the URL only has a path and query.
+func (route Route) URL(vars any, query url.Values) (*url.URL, error) {
+	values, urlQuery, err := urlValues(vars)
+	if err != nil {
+		return nil, err
 	}
 
-	var (
-		valIndex int
-		varCount int
-		builder  strings.Builder
-	)
-
-	pattern := []byte(route.Pattern)[1:]
-	parts := bytes.SplitSeq(pattern, []byte{'/'})
+	var host string
 
-	for part := range parts {
-		var value string
-
-		_ = builder.WriteByte('/')
-
-		for len(part) != 0 {
-			start := bytes.IndexAny(part, "{*")
-			if start == -1 {
-				value = string(part)
-				break
+	if route.Host != "" {
+		pattern := route.host
+		if pattern == nil {
+			pattern, err = parseHostPattern(route.Host)
+			if err != nil {
+				return nil, err
 			}
+		}
 
-			isWildcard := part[start] == '*'
-			end := start
-
-			var (
-				name       string
-				matcherStr string
-			)
-
-			if !isWildcard {
-				end = bytes.IndexByte(part, '}')
-				if end == -1 {
-					return "", errors.New("luci: invalid route pattern")
-				}
-
-				matches := varMatcher.FindStringSubmatch(string(part[start : end+1]))
-				if len(matches) >= 2 {
-					name = matches[1]
-				}
-
-				if len(matches) == 3 && matches[2] != "" {
-					matcherStr = matches[2]
-				}
-			}
+		vals, err := namedValues(pattern.names, values)
+		if err != nil {
+			return nil, err
+		}
 
-			// If there's no vals left, keep count of vars so an
-			// accurate count can be returned in the final error
-			if len(vals)-1 < valIndex {
-				valIndex++
-				varCount++
-				part = part[end+1:]
+		host, err = pattern.build(vals)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-				continue
-			}
+	template, err := route.compiled()
+	if err != nil {
+		return nil, err
+	}
 
-			val := vals[valIndex]
-			if !isWildcard && strings.Contains(val, "/") {
-				return "", fmt.Errorf(`luci: value for non-wildcard variable "%s" must not contain /`, name)
-			}
+	vals, err := namedValues(template.names, values)
+	if err != nil {
+		return nil, err
+	}
 
-			if matcherStr != "" {
-				matcher, err := regexp.Compile(matcherStr)
-				if err != nil {
-					return "", fmt.Errorf(`luci: variable "%s" must have valid regex: %w`, name, err)
-				}
+	path, err := template.path(vals)
+	if err != nil {
+		return nil, err
+	}
 
-				if !matcher.MatchString(val) {
-					return "", fmt.Errorf(`luci: value for variable "%s" does not match regex`, name)
-				}
-			}
+	uri, err := url.Parse(path)
+	if err != nil {
+		return nil, fmt.Errorf("luci: parse path: %w", err)
+	}
 
-			value += string(part[:start]) + val
+	for key, vals := range query {
+		urlQuery[key] = append(urlQuery[key], vals...)
+	}
 
-			valIndex++
-			varCount++
-			part = part[end+1:]
-		}
+	uri.Host = host
+	uri.RawQuery = urlQuery.Encode()
 
-		split := strings.Split(value, "/")
-		for idx, val := range split {
-			split[idx] = url.PathEscape(val)
-		}
+	return uri, nil
+}
 
-		_, _ = builder.WriteString(strings.Join(split, "/"))
+// Path builds an absolute path from the routes pattern using the given variable values.
+// Variable values must be in the order defined by the routes pattern, and are validated
+// against the associated regex matcher if one exists. Literal text following a variable in
+// a segment is kept, e.g. {file}.jpg builds image.jpg. Routes retrieved from a server have
+// their pattern compiled ahead of time, other routes compile it on each call.
+func (route Route) Path(vals ...string) (string, error) {
+	template, err := route.compiled()
+	if err != nil {
+		return "", err
 	}
 
-	if varCount != len(vals) {
-		return "", fmt.Errorf("luci: must provide the expected number of values (expected %d received %d)", varCount, len(vals))
+	return template.path(vals)
+}
+
+// compiled returns the routes compiled pattern, compiling it using the default variable converters
+// if the route wasn't compiled by a server.
+func (route Route) compiled() (*routeTemplate, error) {
+	if route.template != nil {
+		return route.template, nil
 	}
 
-	return builder.String(), nil
+	return compileRouteTemplate(route.Pattern, DefaultVarConverters)
 }
@@ -0,0 +1,184 @@
+package luci
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// routeTemplate is a routes pattern compiled once so paths can be built from it without
+// parsing the pattern or compiling variable regexes.
+type routeTemplate struct {
+	parts  []templatePart
+	names  []string
+	length int
+}
+
+// templatePart is either an escaped literal part of a pattern, or a variable with its matcher.
+type templatePart struct {
+	literal  string
+	variable bool
+	wildcard bool
+	name     string
+	matcher  *regexp.Regexp
+}
+
+// compileRouteTemplate compiles the pattern into a template. Variables using a converter's name in
+// place of a regex are matched using the converter's pattern.
+func compileRouteTemplate(pattern string, converters map[string]VarConverter) (*routeTemplate, error) {
+	if pattern == "" {
+		return nil, errors.New("luci: route pattern must not be empty")
+	}
+
+	if pattern[0] != '/' {
+		return nil, errors.New("luci: route pattern must begin with /")
+	}
+
+	var (
+		template routeTemplate
+		literal  strings.Builder
+	)
+
+	addLiteral := func(value string) {
+		_, _ = literal.WriteString(url.PathEscape(value))
+	}
+
+	flushLiteral := func() {
+		if literal.Len() != 0 {
+			template.parts = append(template.parts, templatePart{literal: literal.String()})
+			template.length += literal.Len()
+			literal.Reset()
+		}
+	}
+
+	for part := range strings.SplitSeq(pattern[1:], "/") {
+		_ = literal.WriteByte('/')
+
+		for part != "" {
+			start := strings.IndexAny(part, "{*")
+			if start == -1 {
+				addLiteral(part)
+				break
+			}
+
+			addLiteral(part[:start])
+			flushLiteral()
+
+			if part[start] == '*' {
+				template.parts = append(template.parts, templatePart{variable: true, wildcard: true, name: "*"})
+				template.names = append(template.names, "*")
+				part = part[start+1:]
+
+				continue
+			}
+
+			end := strings.IndexByte(part, '}')
+			if end == -1 {
+				return nil, errors.New("luci: invalid route pattern")
+			}
+
+			variable := templatePart{variable: true}
+
+			var matcherStr string
+
+			matches := varMatcher.FindStringSubmatch(part[start : end+1])
+			if len(matches) >= 2 {
+				variable.name = matches[1]
+			}
+
+			if len(matches) == 3 {
+				matcherStr = matches[2]
+			}
+
+			converter, converted := converters[matcherStr]
+
+			switch {
+			case converted && converter.Pattern != "":
+				matcher, err := regexp.Compile("^(?:" + converter.Pattern + ")$")
+				if err != nil {
+					return nil, fmt.Errorf(`luci: variable "%s" must have valid converter regex: %w`, variable.name, err)
+				}
+
+				variable.matcher = matcher
+			case !converted && matcherStr != "":
+				matcher, err := regexp.Compile(matcherStr)
+				if err != nil {
+					return nil, fmt.Errorf(`luci: variable "%s" must have valid regex: %w`, variable.name, err)
+				}
+
+				variable.matcher = matcher
+			}
+
+			template.parts = append(template.parts, variable)
+			template.names = append(template.names, variable.name)
+			part = part[end+1:]
+		}
+	}
+
+	flushLiteral()
+
+	return &template, nil
+}
+
+// path builds a path from the template using the given variable values, in the order they're defined.
+func (template *routeTemplate) path(vals []string) (string, error) {
+	var (
+		valIndex int
+		builder  strings.Builder
+	)
+
+	length := template.length
+	for _, val := range vals {
+		length += len(val)
+	}
+
+	builder.Grow(length)
+
+	for _, part := range template.parts {
+		if !part.variable {
+			_, _ = builder.WriteString(part.literal)
+			continue
+		}
+
+		// If there's no vals left, keep going so an accurate
+		// count can be returned in the final error
+		if valIndex >= len(vals) {
+			continue
+		}
+
+		val := vals[valIndex]
+		valIndex++
+
+		if !part.wildcard && strings.Contains(val, "/") {
+			return "", fmt.Errorf(`luci: value for non-wildcard variable "%s" must not contain /`, part.name)
+		}
+
+		if part.matcher != nil && !part.matcher.MatchString(val) {
+			return "", fmt.Errorf(`luci: value for variable "%s" does not match regex`, part.name)
+		}
+
+		if !part.wildcard {
+			_, _ = builder.WriteString(url.PathEscape(val))
+			continue
+		}
+
+		separator := false
+
+		for segment := range strings.SplitSeq(val, "/") {
+			if separator {
+				_ = builder.WriteByte('/')
+			}
+
+			_, _ = builder.WriteString(url.PathEscape(segment))
+			separator = true
+		}
+	}
+
+	if len(template.names) != len(vals) {
+		return "", fmt.Errorf("luci: must provide the expected number of values (expected %d received %d)", len(template.names), len(vals))
+	}
+
+	return builder.String(), nil
+}
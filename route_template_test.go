@@ -0,0 +1,70 @@
+package luci
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompileRouteTemplate(t *testing.T) {
+	t.Parallel()
+
+	template, err := compileRouteTemplate("/user/{user}/media files/{file:[a-z]+}.jpg/*", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"user", "file", "*"}, template.names)
+	assert.Len(t, template.parts, 6)
+	assert.Equal(t, "/user/", template.parts[0].literal)
+	assert.Equal(t, "/media%20files/", template.parts[2].literal)
+	assert.Equal(t, ".jpg/", template.parts[4].literal)
+
+	path, err := template.path([]string{"luci", "image", "a b/c"})
+	assert.NoError(t, err)
+	assert.Equal(t, "/user/luci/media%20files/image.jpg/a%20b/c", path)
+
+	_, err = compileRouteTemplate("/user/{user", nil)
+	assert.Equal(t, errors.New("luci: invalid route pattern"), err)
+
+	template, err = compileRouteTemplate("/user/{id:int}/{slug:slug}", map[string]VarConverter{
+		"int":  {Pattern: "[0-9]+"},
+		"slug": {},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"id", "slug"}, template.names)
+
+	path, err = template.path([]string{"123", "a-slug"})
+	assert.NoError(t, err)
+	assert.Equal(t, "/user/123/a-slug", path)
+
+	_, err = template.path([]string{"123abc", "a-slug"})
+	assert.Equal(t, errors.New(`luci: value for variable "id" does not match regex`), err)
+
+	_, err = compileRouteTemplate("/user/{id:int}", map[string]VarConverter{"int": {Pattern: "[0-9"}})
+	assert.ErrorContains(t, err, `luci: variable "id" must have valid converter regex`)
+}
+
+func TestRouteTemplateTrailingLiterals(t *testing.T) {
+	t.Parallel()
+
+	// Literal text following the last variable in a segment used to replace the segment's
+	// values, e.g. /media/{file}.jpg built /media/.jpg. It's now kept after the values.
+	tests := []struct {
+		pattern  string
+		vals     []string
+		expected string
+	}{
+		{pattern: "/media/{file}.jpg", vals: []string{"image"}, expected: "/media/image.jpg"},
+		{pattern: "/media/x{width}-{height}.jpg", vals: []string{"640", "480"}, expected: "/media/x640-480.jpg"},
+		{pattern: "/media/{file}.jpg/download", vals: []string{"image"}, expected: "/media/image.jpg/download"},
+		{pattern: "/media/{file} copy", vals: []string{"image"}, expected: "/media/image%20copy"},
+	}
+
+	for _, test := range tests {
+		template, err := compileRouteTemplate(test.pattern, nil)
+		assert.NoError(t, err)
+
+		path, err := template.path(test.vals)
+		assert.NoError(t, err)
+		assert.Equal(t, test.expected, path, test.pattern)
+	}
+}
@@ -1,10 +1,10 @@
 package luci
 
 import (
-	"context"
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -20,7 +20,7 @@ func TestRequestRoute(t *testing.T) {
 	}
 
 	request := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/status", nil)
-	request = request.WithContext(context.WithValue(request.Context(), requestRouteKey{}, route))
+	request = withState(request, &requestState{route: &route})
 
 	assert.Equal(t, route, RequestRoute(request))
 }
@@ -37,140 +37,222 @@ func TestRouteString(t *testing.T) {
 	assert.Equal(t, "status GET /status", route.String())
 }
 
-func TestRoutePath(t *testing.T) {
+func TestRouteURL(t *testing.T) {
 	t.Parallel()
 
-	tests := []struct {
-		route        Route
-		vals         []string
-		expectedPath string
-		expectedErr  error
-	}{
-		{
-			route:        Route{Pattern: "/health"},
-			expectedPath: "/health",
-		},
-		{
-			route:        Route{Pattern: "/static/*"},
-			vals:         []string{"css/index.css"},
-			expectedPath: "/static/css/index.css",
-		},
-		{
-			route:        Route{Pattern: "/media/{}"},
-			vals:         []string{"image_123.jpg"},
-			expectedPath: "/media/image_123.jpg",
-		},
-		{
-			route:        Route{Pattern: "/{resource}"},
-			vals:         []string{"admin"},
-			expectedPath: "/admin",
-		},
-		{
-			route:        Route{Pattern: "/media/random files/{file}"},
-			vals:         []string{"luci 123.jpg"},
-			expectedPath: "/media/random%20files/luci%20123.jpg",
-		},
-		{
-			route:        Route{Pattern: "/user/{user}"},
-			vals:         []string{"luci"},
-			expectedPath: "/user/luci",
-		},
-		{
-			route:        Route{Pattern: "/user/{user}/edit"},
-			vals:         []string{"luci"},
-			expectedPath: "/user/luci/edit",
-		},
-		{
-			route:        Route{Pattern: "/user/{user}/post/{post}"},
-			vals:         []string{"luci", "123"},
-			expectedPath: "/user/luci/post/123",
-		},
-		{
-			route:        Route{Pattern: "/user/{user}/static/*"},
-			vals:         []string{"luci", "js/luci.js"},
-			expectedPath: "/user/luci/static/js/luci.js",
-		},
-		{
-			route:        Route{Pattern: "/user/{user}/post/{post}/static/*"},
-			vals:         []string{"luci", "123", "js/luci.js"},
-			expectedPath: "/user/luci/post/123/static/js/luci.js",
-		},
-		{
-			route:        Route{Pattern: "/search/user/{first}_{last}"},
-			vals:         []string{"luci", "last"},
-			expectedPath: "/search/user/luci_last",
-		},
-		{
-			route:        Route{Pattern: "/search/user/{first}_*"},
-			vals:         []string{"luci", "last"},
-			expectedPath: "/search/user/luci_last",
-		},
-		{
-			route:        Route{Pattern: `/search/date/{date:\d\d\d\d-\d\d-\d\d}`},
-			vals:         []string{"2023-10-28"},
-			expectedPath: "/search/date/2023-10-28",
-		},
-		{
-			route:        Route{Pattern: "/anon/{:[a-z]+[0-9]*}"},
-			vals:         []string{"abc123"},
-			expectedPath: "/anon/abc123",
-		},
-		{
-			expectedErr: errors.New("luci: route pattern must not be empty"),
-		},
-		{
-			route:       Route{Pattern: "pattern"},
-			expectedErr: errors.New("luci: route pattern must begin with /"),
-		},
-		{
-			route:       Route{Pattern: "/user/{user"},
-			vals:        []string{"luci"},
-			expectedErr: errors.New("luci: invalid route pattern"),
-		},
-		{
-			route:       Route{Pattern: "/user/{user}"},
-			expectedErr: errors.New("luci: must provide the expected number of values (expected 1 received 0)"),
-		},
-		{
-			route:       Route{Pattern: "/user/{user}"},
-			vals:        []string{"luci", "last"},
-			expectedErr: errors.New("luci: must provide the expected number of values (expected 1 received 2)"),
-		},
-		{
-			route:       Route{Pattern: "/user/{user}/static/*"},
-			vals:        []string{"luci", "last", "js/luci.js"},
-			expectedErr: errors.New("luci: must provide the expected number of values (expected 2 received 3)"),
-		},
-		{
-			route:       Route{Pattern: "/user/{user}"},
-			vals:        []string{"luci/last"},
-			expectedErr: errors.New(`luci: value for non-wildcard variable "user" must not contain /`),
-		},
-		{
-			route:       Route{Pattern: "/invalid_regex/{:[}"},
-			vals:        []string{"abc"},
-			expectedErr: errors.New("luci: variable \"\" must have valid regex: error parsing regexp: missing closing ]: `[`"),
-		},
-		{
-			route:       Route{Pattern: `/date/{date:\d\d\d\d-\d\d-\d\d}`},
-			vals:        []string{"does_not_match"},
-			expectedErr: errors.New(`luci: value for variable "date" does not match regex`),
-		},
+	type input struct {
+		Tenant string   `path:"tenant"`
+		Key    string   `path:"key"`
+		Fields []string `query:"fields"`
+		Page   int      `query:"page"`
 	}
 
-	for idx, test := range tests {
-		path, err := test.route.Path(test.vals...)
+	route := Route{Host: "{tenant}.api.luci.dev", Pattern: "/user/{key}"}
 
-		if test.expectedErr != nil {
-			assert.Error(t, err, idx)
+	uri, err := route.URL(map[string]string{"tenant": "acme", "key": "abc 123"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "//acme.api.luci.dev/user/abc%20123", uri.String())
+
+	uri, err = route.URL(&input{Tenant: "acme", Key: "abc", Fields: []string{"name", "email"}}, url.Values{"sort": {"name"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "//acme.api.luci.dev/user/abc?fields=name&fields=email&sort=name", uri.String())
+
+	uri, err = Route{Pattern: "/static/*"}.URL(map[string]string{"*": "css/index.css"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "/static/css/index.css", uri.String())
+
+	_, err = route.URL(nil, nil)
+	assert.Equal(t, errors.New(`luci: must provide a value for variable "tenant"`), err)
+
+	_, err = route.URL(map[string]string{"tenant": "acme"}, nil)
+	assert.Equal(t, errors.New(`luci: must provide a value for variable "key"`), err)
+
+	_, err = route.URL(map[string]string{"tenant": "a.b", "key": "abc"}, nil)
+	assert.Equal(t, errors.New(`luci: value for host variable "tenant" does not match regex`), err)
+
+	_, err = Route{Host: "{}.luci.dev", Pattern: "/"}.URL(nil, nil)
+	assert.Equal(t, errors.New("luci: host variable must have a name"), err)
+}
+
+var routePathTests = []struct {
+	route        Route
+	vals         []string
+	expectedPath string
+	expectedErr  error
+}{
+	{
+		route:        Route{Pattern: "/health"},
+		expectedPath: "/health",
+	},
+	{
+		route:        Route{Pattern: "/static/*"},
+		vals:         []string{"css/index.css"},
+		expectedPath: "/static/css/index.css",
+	},
+	{
+		route:        Route{Pattern: "/media/{}"},
+		vals:         []string{"image_123.jpg"},
+		expectedPath: "/media/image_123.jpg",
+	},
+	{
+		route:        Route{Pattern: "/{resource}"},
+		vals:         []string{"admin"},
+		expectedPath: "/admin",
+	},
+	{
+		route:        Route{Pattern: "/media/random files/{file}"},
+		vals:         []string{"luci 123.jpg"},
+		expectedPath: "/media/random%20files/luci%20123.jpg",
+	},
+	{
+		route:        Route{Pattern: "/user/{user}"},
+		vals:         []string{"luci"},
+		expectedPath: "/user/luci",
+	},
+	{
+		route:        Route{Pattern: "/user/{user}/edit"},
+		vals:         []string{"luci"},
+		expectedPath: "/user/luci/edit",
+	},
+	{
+		route:        Route{Pattern: "/user/{user}/post/{post}"},
+		vals:         []string{"luci", "123"},
+		expectedPath: "/user/luci/post/123",
+	},
+	{
+		route:        Route{Pattern: "/user/{user}/static/*"},
+		vals:         []string{"luci", "js/luci.js"},
+		expectedPath: "/user/luci/static/js/luci.js",
+	},
+	{
+		route:        Route{Pattern: "/user/{user}/post/{post}/static/*"},
+		vals:         []string{"luci", "123", "js/luci.js"},
+		expectedPath: "/user/luci/post/123/static/js/luci.js",
+	},
+	{
+		route:        Route{Pattern: "/search/user/{first}_{last}"},
+		vals:         []string{"luci", "last"},
+		expectedPath: "/search/user/luci_last",
+	},
+	{
+		route:        Route{Pattern: "/search/user/{first}_*"},
+		vals:         []string{"luci", "last"},
+		expectedPath: "/search/user/luci_last",
+	},
+	{
+		route:        Route{Pattern: `/search/date/{date:\d\d\d\d-\d\d-\d\d}`},
+		vals:         []string{"2023-10-28"},
+		expectedPath: "/search/date/2023-10-28",
+	},
+	{
+		route:        Route{Pattern: "/anon/{:[a-z]+[0-9]*}"},
+		vals:         []string{"abc123"},
+		expectedPath: "/anon/abc123",
+	},
+	{
+		expectedErr: errors.New("luci: route pattern must not be empty"),
+	},
+	{
+		route:       Route{Pattern: "pattern"},
+		expectedErr: errors.New("luci: route pattern must begin with /"),
+	},
+	{
+		route:       Route{Pattern: "/user/{user"},
+		vals:        []string{"luci"},
+		expectedErr: errors.New("luci: invalid route pattern"),
+	},
+	{
+		route:       Route{Pattern: "/user/{user}"},
+		expectedErr: errors.New("luci: must provide the expected number of values (expected 1 received 0)"),
+	},
+	{
+		route:       Route{Pattern: "/user/{user}"},
+		vals:        []string{"luci", "last"},
+		expectedErr: errors.New("luci: must provide the expected number of values (expected 1 received 2)"),
+	},
+	{
+		route:       Route{Pattern: "/user/{user}/static/*"},
+		vals:        []string{"luci", "last", "js/luci.js"},
+		expectedErr: errors.New("luci: must provide the expected number of values (expected 2 received 3)"),
+	},
+	{
+		route:       Route{Pattern: "/user/{user}"},
+		vals:        []string{"luci/last"},
+		expectedErr: errors.New(`luci: value for non-wildcard variable "user" must not contain /`),
+	},
+	{
+		route:       Route{Pattern: "/invalid_regex/{:[}"},
+		vals:        []string{"abc"},
+		expectedErr: errors.New("luci: variable \"\" must have valid regex: error parsing regexp: missing closing ]: `[`"),
+	},
+	{
+		route:       Route{Pattern: `/date/{date:\d\d\d\d-\d\d-\d\d}`},
+		vals:        []string{"does_not_match"},
+		expectedErr: errors.New(`luci: value for variable "date" does not match regex`),
+	},
+}
+
+func TestRoutePath(t *testing.T) {
+	t.Parallel()
 
-			if err != nil {
-				assert.Equal(t, test.expectedErr.Error(), err.Error(), idx)
+	for idx, test := range routePathTests {
+		routes := []Route{test.route}
+
+		template, err := compileRouteTemplate(test.route.Pattern, DefaultVarConverters)
+		if err == nil {
+			compiled := test.route
+			compiled.template = template
+			routes = append(routes, compiled)
+		}
+
+		for _, route := range routes {
+			path, err := route.Path(test.vals...)
+
+			if test.expectedErr != nil {
+				assert.Error(t, err, idx)
+
+				if err != nil {
+					assert.Equal(t, test.expectedErr.Error(), err.Error(), idx)
+				}
+			} else {
+				assert.NoError(t, err)
 			}
-		} else {
-			assert.NoError(t, err)
+
+			assert.Equal(t, test.expectedPath, path, idx)
 		}
+	}
+}
+
+func BenchmarkRoutePath(b *testing.B) {
+	for _, test := range routePathTests {
+		if test.expectedErr != nil {
+			continue
+		}
+
+		template, err := compileRouteTemplate(test.route.Pattern, DefaultVarConverters)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		compiled := test.route
+		compiled.template = template
+
+		b.Run(test.route.Pattern, func(b *testing.B) {
+			b.Run("uncompiled", func(b *testing.B) {
+				b.ReportAllocs()
+
+				for b.Loop() {
+					_, _ = test.route.Path(test.vals...)
+				}
+			})
+
+			b.Run("compiled", func(b *testing.B) {
+				b.ReportAllocs()
 
-		assert.Equal(t, test.expectedPath, path, idx)
+				for b.Loop() {
+					_, _ = compiled.Path(test.vals...)
+				}
+			})
+		})
 	}
 }
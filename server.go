@@ -1,53 +1,118 @@
 package luci
 
 import (
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
 	"log/slog"
 	"net"
 	"net/http"
+	"net/url"
+	"slices"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 )
 
 // Server maintains the running state of an application.
 type Server struct {
-	config  Config
-	app     Application
-	logger  *slog.Logger
-	server  *http.Server
-	routes  map[string]Route
-	started chan struct{}
-	address string
+	config   Config
+	app      Application
+	logger   *slog.Logger
+	server   *http.Server
+	routes   map[string]Route
+	baseURL  *url.URL
+	sources  trustedProxies
+	inFlight *inFlight
+	started  chan struct{}
+	address  string
 }
 
 // NewServer creates a server for the given application using the given configuration.
-// NewServer panics if any route does not have a name, the name is not unique, or if the
-// route doesn't have a handler defined.
+// NewServer panics if any route does not have a name, the name is not unique, if the
+// route doesn't have exactly one of a handler or WebSocket defined, if a WebSocket route
+// has a method other than GET, if the route has a content type without a codec, if the
+// route has an invalid host, if routes have the same pattern, method, and host, or if the
+// CORS configuration allows credentials for any origin.
+//
+// Requests using a method that no route for the path and host matches are responded to with the
+// applications Error using a 405 Method Not Allowed *HTTPError, and the Allow header set
+// to the methods that do match.
 func NewServer(config Config, app Application) *Server {
 	config = buildConfig(config)
 
+	var baseURL *url.URL
+
+	if config.BaseURL != "" {
+		parsed, err := url.Parse(config.BaseURL)
+		if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+			panic(fmt.Errorf(`luci: base url "%s" must be an absolute url`, config.BaseURL))
+		}
+
+		baseURL = parsed
+	}
+
+	proxies, err := parseTrustedProxies(config.TrustedProxies)
+	if err != nil {
+		panic(err)
+	}
+
+	var sources trustedProxies
+
+	if config.ProxyProtocol != nil {
+		trustedSources := config.ProxyProtocol.TrustedSources
+		if len(trustedSources) == 0 {
+			trustedSources = config.TrustedProxies
+		}
+
+		sources, err = parseTrustedProxies(trustedSources)
+		if err != nil {
+			panic(err)
+		}
+
+		if len(sources) == 0 {
+			panic(errors.New("luci: proxy protocol must have trusted sources"))
+		}
+	}
+
 	mux := chi.NewMux()
+	tracker := newInFlight()
 
 	appMiddlewares := app.Middlewares()
-	badRequestMiddlewares := append(Middlewares{
+	baseMiddlewares := Middlewares{
 		withResponseWriter,
-		withDuration,
-		withID(app.Error),
+		withRequestState,
+		tracker.middleware,
+		withForwarded(proxies),
+		withID(app.Error, config.RequestID, proxies),
 		withLogger(config.Logger),
 		withRecover(app.Error),
-	}, appMiddlewares...)
-
-	mux.MethodNotAllowed(badRequestMiddlewares.Handler(
-		errorRespond(app.Error, http.StatusMethodNotAllowed, ErrMethodNotAllowed),
-	).ServeHTTP)
-	mux.NotFound(badRequestMiddlewares.Handler(
-		errorRespond(app.Error, http.StatusNotFound, ErrNotFound),
-	).ServeHTTP)
+	}
+	badRequestMiddlewares := slices.Concat(baseMiddlewares, appMiddlewares)
 
 	routes := app.Routes()
 	routesByName := make(map[string]Route, len(routes))
+	definedMethods := routePatternMethods(routes, false)
+	patternMethods := routePatternMethods(routes, config.AutoHead)
+	canonicalize := config.PathPolicy.Mode != PathStrict
+	hosted := make(hostRoutes)
+	routeNames := make(map[hostPatternMethod]string)
+
+	var hostRouteKeys []patternMethod
+
+	methods := slices.Clone(standardMethods)
+	for _, route := range routes {
+		if route.Method != "" && !slices.Contains(methods, route.Method) {
+			methods = append(methods, route.Method)
+		}
+	}
+
+	mux.MethodNotAllowed(badRequestMiddlewares.Handler(
+		methodNotAllowedHandler(app.Error, mux, hosted, methods),
+	).ServeHTTP)
+	notFound := badRequestMiddlewares.Handler(errorRespond(app.Error, http.StatusNotFound, ErrNotFound))
+	mux.NotFound(notFound.ServeHTTP)
 
 	for _, route := range routes {
 		if route.Name == "" {
@@ -59,56 +124,177 @@ func NewServer(config Config, app Application) *Server {
 			panic(fmt.Errorf(`luci: route "%s" already exists`, route.Name))
 		}
 
-		if route.HandlerFunc == nil {
-			panic(fmt.Errorf(`luci: route "%s" must have a handler`, route.Name))
+		// Patterns are compiled once so building paths for the route is cheap, routes with
+		// invalid patterns return the compile error when building paths instead.
+		template, err := compileRouteTemplate(route.Pattern, config.VarConverters)
+		if err == nil {
+			route.template = template
 		}
 
-		timeout := route.Timeout
+		pattern, converters := convertPattern(route.Pattern, config.VarConverters)
+
+		if route.Host != "" {
+			host, err := parseHostPattern(route.Host)
+			if err != nil {
+				panic(fmt.Errorf(`luci: route "%s": %w`, route.Name, err))
+			}
+
+			route.host = host
+		}
+
+		handlerFunc, method, timeout := routeHandler(app.Error, tracker, route)
 		if timeout == 0 {
 			timeout = config.RouteTimeout
 		}
 
-		router := mux.With(
+		registered := hostPatternMethod{host: route.Host, pattern: pattern, method: method}
+		if name, ok := routeNames[registered]; ok {
+			panic(fmt.Errorf(`luci: route "%s" has the same pattern, method, and host as route "%s"`, route.Name, name))
+		}
+
+		routeNames[registered] = route.Name
+
+		var routeCORS *cors
+
+		corsConfig := cmp.Or(route.CORS, config.CORS)
+		if corsConfig != nil {
+			routeCORS = newCORS(*corsConfig, patternMethods[route.Pattern])
+		}
+
+		policy := config.PathPolicy
+		if route.PathPolicy != nil {
+			policy = *route.PathPolicy
+		}
+
+		canonicalize = canonicalize || policy.Mode != PathStrict
+
+		autoHead := config.AutoHead && method == http.MethodGet && route.WebSocket == nil &&
+			!slices.Contains(definedMethods[route.Pattern], http.MethodHead)
+
+		routeMiddlewares := Middlewares{
 			withResponseWriter,
-			withDuration,
-			withID(app.Error),
-			withVars,
-			WithValue(requestRouteKey{}, route),
+			withRequestState,
+			tracker.middleware,
+			withForwarded(proxies),
+			withID(app.Error, config.RequestID, proxies),
+			withRoute(&route, app),
 			withLogger(config.Logger),
-			withTimeout(app.Error, timeout),
-			withRecover(app.Error),
-		)
+			withCORS(app.Error, routeCORS),
+		}
 
-		for _, middleware := range appMiddlewares {
-			router.Use(middleware)
+		if converters != nil {
+			routeMiddlewares = append(routeMiddlewares, withVarConverters(app.Error, converters))
 		}
 
-		for _, middleware := range route.Middlewares {
-			router.Use(middleware)
+		// WebSocket routes don't encode or decode messages using codecs.
+		if route.WebSocket == nil {
+			routeMiddlewares = append(routeMiddlewares, withCodecs(app.Error, routeCodecs(config.Codecs, route)))
 		}
 
-		if route.Method == "" {
-			router.HandleFunc(route.Pattern, route.HandlerFunc)
-		} else {
-			router.MethodFunc(route.Method, route.Pattern, route.HandlerFunc)
+		if timeout > 0 {
+			routeMiddlewares = append(routeMiddlewares, withTimeout(app.Error, timeout))
+		}
+
+		routeMiddlewares = append(routeMiddlewares, withRecover(app.Error))
+		routeMiddlewares = append(routeMiddlewares, appMiddlewares...)
+		routeMiddlewares = append(routeMiddlewares, route.Middlewares...)
+
+		hostedRoute := hostRoute{
+			host:    route.host,
+			handler: routeMiddlewares.Handler(handlerFunc),
+			cors:    routeCORS,
+			policy:  policy,
+		}
+
+		routeMethods := []string{method}
+
+		// net/http discards the body of HEAD responses, while the response writer still
+		// records the length of the body that would've been written.
+		if autoHead {
+			routeMethods = append(routeMethods, http.MethodHead)
+		}
+
+		for _, routeMethod := range routeMethods {
+			key := patternMethod{pattern: pattern, method: routeMethod}
+			if _, ok := hosted[key]; !ok {
+				hostRouteKeys = append(hostRouteKeys, key)
+			}
+
+			hosted[key] = append(hosted[key], hostedRoute)
 		}
 
 		routesByName[route.Name] = route
 	}
 
+	// Routes with the same pattern and method are registered together, and dispatched using their hosts.
+	for _, key := range hostRouteKeys {
+		handler := hostHandler(notFound, hosted[key])
+
+		if key.method == "" {
+			mux.Handle(key.pattern, handler)
+		} else {
+			mux.Method(key.method, key.pattern, handler)
+		}
+	}
+
+	// OPTIONS requests for patterns without a route matching OPTIONS would otherwise be
+	// responded to with 405 Method Not Allowed, including preflight requests.
+	var optionsPatterns []string
+
+	patternOptions := make(map[string]bool)
+	patternCORS := make(map[string]bool)
+
+	for _, key := range hostRouteKeys {
+		if _, ok := patternOptions[key.pattern]; !ok {
+			optionsPatterns = append(optionsPatterns, key.pattern)
+		}
+
+		patternOptions[key.pattern] = patternOptions[key.pattern] || key.method == "" || key.method == http.MethodOptions
+
+		for _, route := range hosted[key] {
+			patternCORS[key.pattern] = patternCORS[key.pattern] || route.cors != nil
+		}
+	}
+
+	for _, pattern := range optionsPatterns {
+		if patternOptions[pattern] || (!config.AutoOptions && !patternCORS[pattern]) {
+			continue
+		}
+
+		mux.Options(pattern, baseMiddlewares.Handler(
+			optionsHandler(app.Error, mux, hosted, methods, pattern, config.AutoOptions),
+		).ServeHTTP)
+	}
+
+	var handler http.Handler = mux
+
+	// Requests to non-canonical paths are only checked if a route may handle them,
+	// since it requires routing the request multiple times.
+	if canonicalize {
+		handler = &pathPolicies{
+			mux:      mux,
+			policy:   config.PathPolicy,
+			routes:   hosted,
+			redirect: badRequestMiddlewares,
+		}
+	}
+
 	server := &http.Server{
 		Addr:              config.Address,
-		Handler:           mux,
+		Handler:           handler,
 		ReadHeaderTimeout: config.ReadHeaderTimeout,
 	}
 
 	return &Server{
-		config:  config,
-		app:     app,
-		logger:  config.Logger,
-		server:  server,
-		routes:  routesByName,
-		started: make(chan struct{}),
+		config:   config,
+		app:      app,
+		logger:   config.Logger,
+		server:   server,
+		routes:   routesByName,
+		baseURL:  baseURL,
+		sources:  sources,
+		inFlight: tracker,
+		started:  make(chan struct{}),
 	}
 }
 
@@ -124,6 +310,14 @@ func (server *Server) ListenAndServe(ctx context.Context) error {
 	}
 	// listener closed via (*http.Server).Serve
 
+	if server.config.ProxyProtocol != nil {
+		listener = &proxyListener{
+			Listener: listener,
+			sources:  server.sources,
+			timeout:  server.config.ProxyProtocol.HeaderTimeout,
+		}
+	}
+
 	server.address = listener.Addr().String()
 	close(server.started)
 
@@ -151,7 +345,15 @@ func (server *Server) ListenAndServe(ctx context.Context) error {
 		ctx, cancel := context.WithTimeout(context.Background(), server.config.ShutdownTimeout)
 		defer cancel()
 
-		done <- server.server.Shutdown(ctx)
+		// Shutdown doesn't close or wait for hijacked connections such as WebSockets.
+		server.inFlight.shutdown()
+
+		err := server.server.Shutdown(ctx)
+		if err == nil {
+			err = server.inFlight.wait(ctx)
+		}
+
+		done <- err
 	}()
 
 	err = server.server.Serve(listener)
@@ -180,8 +382,117 @@ func (server *Server) Address() string {
 	return server.address
 }
 
+// InFlight returns the number of requests currently being handled, including upgraded WebSocket connections.
+func (server *Server) InFlight() int64 {
+	return server.inFlight.requests()
+}
+
 // Route retrieves a defined route by name, and whether a route was found with the given name.
 func (server *Server) Route(name string) (Route, bool) {
 	route, ok := server.routes[name]
 	return route, ok
 }
+
+// patternMethod identifies the routes registered for a pattern and method.
+type patternMethod struct {
+	pattern string
+	method  string
+}
+
+// hostPatternMethod identifies the route registered for a host, pattern, and method.
+type hostPatternMethod struct {
+	host    string
+	pattern string
+	method  string
+}
+
+// URL builds a URL for the route with the given name, see Route.URL for details on the variables and
+// query. If the server has a base URL the URL is absolute, otherwise it's the URL built by the route.
+func (server *Server) URL(name string, vars any, query url.Values) (*url.URL, error) {
+	route, ok := server.routes[name]
+	if !ok {
+		return nil, fmt.Errorf(`luci: route "%s" doesn't exist`, name)
+	}
+
+	uri, err := route.URL(vars, query)
+	if err != nil || server.baseURL == nil {
+		return uri, err
+	}
+
+	return absoluteURL(server.baseURL, uri)
+}
+
+// RequestURL builds an absolute URL for the route with the given name, see Route.URL for details on
+// the variables and query. The server's base URL is used if it has one, otherwise the scheme and host the
+// client used are, which are resolved from the headers of trusted proxies the same way as ClientIP. Routes
+// with a host use their host rather than the base URL or request's host.
+func (server *Server) RequestURL(req *http.Request, name string, vars any, query url.Values) (*url.URL, error) {
+	route, ok := server.routes[name]
+	if !ok {
+		return nil, fmt.Errorf(`luci: route "%s" doesn't exist`, name)
+	}
+
+	uri, err := route.URL(vars, query)
+	if err != nil {
+		return nil, err
+	}
+
+	base := server.baseURL
+	if base == nil {
+		base = requestBaseURL(req)
+	}
+
+	return absoluteURL(base, uri)
+}
+
+// routePatternMethods returns the methods of the routes defined for each pattern, in the order the routes are defined.
+// If autoHead is true HEAD is included for patterns with a GET route.
+func routePatternMethods(routes []Route, autoHead bool) map[string][]string {
+	patternMethods := make(map[string][]string)
+
+	for _, route := range routes {
+		methods := []string{route.Method}
+
+		switch {
+		case route.WebSocket != nil:
+			methods = []string{http.MethodGet}
+		case route.Method == "":
+			methods = corsAnyMethods
+		case route.Method == http.MethodGet && autoHead:
+			methods = []string{http.MethodGet, http.MethodHead}
+		}
+
+		for _, method := range methods {
+			if !slices.Contains(patternMethods[route.Pattern], method) {
+				patternMethods[route.Pattern] = append(patternMethods[route.Pattern], method)
+			}
+		}
+	}
+
+	return patternMethods
+}
+
+// routeHandler returns the handler, method, and timeout for the route.
+func routeHandler(errorHandler ErrorHandlerFunc, tracker *inFlight, route Route) (http.HandlerFunc, string, time.Duration) {
+	if route.HandlerFunc != nil && route.WebSocket != nil {
+		panic(fmt.Errorf(`luci: route "%s" must not have both a handler and websocket`, route.Name))
+	}
+
+	if route.WebSocket == nil {
+		if route.HandlerFunc == nil {
+			panic(fmt.Errorf(`luci: route "%s" must have a handler`, route.Name))
+		}
+
+		return route.HandlerFunc, route.Method, route.Timeout
+	}
+
+	if route.WebSocket.HandlerFunc == nil {
+		panic(fmt.Errorf(`luci: route "%s" must have a websocket handler`, route.Name))
+	}
+
+	if route.Method != "" && route.Method != http.MethodGet {
+		panic(fmt.Errorf(`luci: route "%s" websocket must use the GET method`, route.Name))
+	}
+
+	return websocketHandler(errorHandler, tracker, *route.WebSocket), http.MethodGet, -1
+}
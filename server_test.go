@@ -1,15 +1,23 @@
 package luci
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -18,6 +26,32 @@ func contextHasKey(ctx context.Context, key any) bool {
 	return ctx.Value(key) != nil
 }
 
+// testRequest returns a request with the header values added, and the state if it isn't nil.
+func testRequest(ctx context.Context, method, target string, body io.Reader, header http.Header, state *requestState) *http.Request {
+	request := httptest.NewRequestWithContext(ctx, method, target, body)
+
+	for key, values := range header {
+		request.Header[key] = values
+	}
+
+	if state != nil {
+		request = withState(request, state)
+	}
+
+	return request
+}
+
+// serveTestRequest serves the request with the handler, returning the recorder and the
+// responseWriter wrapping it, which middlewares such as Compress and Cache write through.
+func serveTestRequest(handler http.Handler, req *http.Request) (*httptest.ResponseRecorder, *responseWriter) {
+	recorder := httptest.NewRecorder()
+	wrw := &responseWriter{rw: recorder}
+
+	handler.ServeHTTP(wrw, req)
+
+	return recorder, wrw
+}
+
 type TestApplication struct {
 	mock.Mock
 }
@@ -64,6 +98,7 @@ func TestNewServer(t *testing.T) {
 		assert.NotNil(t, server.server.Handler)
 		assert.NotNil(t, server.routes)
 		assert.NotNil(t, server.started)
+		assert.NotNil(t, server.inFlight)
 	})
 
 	t.Run("panics if route has no name", func(t *testing.T) {
@@ -133,6 +168,161 @@ func TestNewServer(t *testing.T) {
 		app.AssertExpectations(t)
 	})
 
+	t.Run("panics if websocket route is invalid", func(t *testing.T) {
+		t.Parallel()
+
+		handler := func(_ http.ResponseWriter, _ *http.Request) {}
+		websocketHandler := func(_ *WebSocketConn, _ *http.Request) {}
+
+		tests := []struct {
+			route    Route
+			expected string
+		}{
+			{
+				route:    Route{Name: "ws", Pattern: "/ws", HandlerFunc: handler, WebSocket: &WebSocket{HandlerFunc: websocketHandler}},
+				expected: `luci: route "ws" must not have both a handler and websocket`,
+			},
+			{
+				route:    Route{Name: "ws", Pattern: "/ws", WebSocket: &WebSocket{}},
+				expected: `luci: route "ws" must have a websocket handler`,
+			},
+			{
+				route:    Route{Name: "ws", Method: http.MethodPost, Pattern: "/ws", WebSocket: &WebSocket{HandlerFunc: websocketHandler}},
+				expected: `luci: route "ws" websocket must use the GET method`,
+			},
+		}
+
+		for _, test := range tests {
+			var app TestApplication
+			app.On("Middlewares").Return(nil)
+			app.On("Routes").Return([]Route{test.route})
+
+			assert.PanicsWithError(t, test.expected, func() {
+				NewServer(testConfig, &app)
+			})
+
+			app.AssertExpectations(t)
+		}
+	})
+
+	t.Run("panics if route has a content type without a codec", func(t *testing.T) {
+		t.Parallel()
+
+		var app TestApplication
+		app.On("Middlewares").Return(nil)
+		app.On("Routes").Return([]Route{
+			{
+				Name:         "status",
+				Method:       http.MethodGet,
+				Pattern:      "/status",
+				ContentTypes: []string{"image/png"},
+				HandlerFunc:  func(_ http.ResponseWriter, _ *http.Request) {},
+			},
+		})
+
+		assert.PanicsWithError(t, `luci: route "status": luci: no codec for content type "image/png"`, func() {
+			NewServer(testConfig, &app)
+		})
+
+		app.AssertExpectations(t)
+	})
+
+	t.Run("resolves client from trusted proxies", func(t *testing.T) {
+		t.Parallel()
+
+		var (
+			app    TestApplication
+			server *Server
+			buf    bytes.Buffer
+		)
+
+		app.On("Middlewares").Return(nil)
+		app.On("Routes").Return([]Route{
+			{
+				Name:    "status",
+				Method:  http.MethodGet,
+				Pattern: "/status",
+				HandlerFunc: func(rw http.ResponseWriter, req *http.Request) {
+					uri, err := server.RequestURL(req, "status", nil, nil)
+					assert.NoError(t, err)
+
+					_, _ = rw.Write([]byte(ClientIP(req).String() + " " + uri.String()))
+				},
+			},
+		})
+
+		config := testConfig
+		config.Logger = slog.New(slog.NewJSONHandler(&buf, nil))
+		config.TrustedProxies = []string{"10.0.0.0/8"}
+
+		server = NewServer(config, &app)
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "http://10.0.0.2/status", nil)
+		request.RemoteAddr = "10.0.0.1:1234"
+		request.Header.Set("X-Forwarded-For", "192.0.2.1")
+		request.Header.Set("X-Forwarded-Proto", "https")
+		request.Header.Set("X-Forwarded-Host", "api.luci.dev")
+
+		server.server.Handler.ServeHTTP(recorder, request)
+		assert.Equal(t, "192.0.2.1 https://api.luci.dev/status", recorder.Body.String())
+		assert.Contains(t, buf.String(), `"client_ip":"192.0.2.1"`)
+
+		app.AssertExpectations(t)
+	})
+
+	t.Run("panics if proxy protocol doesn't have trusted sources", func(t *testing.T) {
+		t.Parallel()
+
+		var app TestApplication
+
+		config := testConfig
+		config.ProxyProtocol = &ProxyProtocolConfig{}
+
+		assert.PanicsWithError(t, "luci: proxy protocol must have trusted sources", func() {
+			NewServer(config, &app)
+		})
+
+		app.AssertExpectations(t)
+	})
+
+	t.Run("panics if trusted proxy is invalid", func(t *testing.T) {
+		t.Parallel()
+
+		var app TestApplication
+
+		config := testConfig
+		config.TrustedProxies = []string{"proxy"}
+
+		assert.PanicsWithError(t, `luci: trusted proxy "proxy" must be an ip address or cidr: netip.ParsePrefix("proxy"): no '/'`, func() {
+			NewServer(config, &app)
+		})
+
+		app.AssertExpectations(t)
+	})
+
+	t.Run("panics if route has an invalid host", func(t *testing.T) {
+		t.Parallel()
+
+		var app TestApplication
+		app.On("Middlewares").Return(nil)
+		app.On("Routes").Return([]Route{
+			{
+				Name:        "status",
+				Method:      http.MethodGet,
+				Host:        "{tenant.luci.dev",
+				Pattern:     "/status",
+				HandlerFunc: func(_ http.ResponseWriter, _ *http.Request) {},
+			},
+		})
+
+		assert.PanicsWithError(t, `luci: route "status": luci: invalid host pattern`, func() {
+			NewServer(testConfig, &app)
+		})
+
+		app.AssertExpectations(t)
+	})
+
 	t.Run("add routes", func(t *testing.T) {
 		t.Parallel()
 
@@ -140,17 +330,19 @@ func TestNewServer(t *testing.T) {
 			t.Helper()
 
 			assert.IsType(t, new(timeoutResponseWriter), rw)
-			assert.True(t, contextHasKey(req.Context(), durationKey{}), "duration key")
-			assert.True(t, contextHasKey(req.Context(), idKey{}), "id key")
-			assert.True(t, contextHasKey(req.Context(), varsKey{}), "vars key")
-			assert.True(t, contextHasKey(req.Context(), requestRouteKey{}), "request route key")
-			assert.True(t, contextHasKey(req.Context(), loggerKey{}), "logger key")
+			assert.NotZero(t, requestStateFrom(req).start, "start")
+			assert.NotEmpty(t, ID(req), "id")
+			assert.NotNil(t, Vars(req), "vars")
+			assert.NotEmpty(t, RequestRoute(req).Name, "request route")
+			assert.NotNil(t, requestApplication(req), "application")
+			assert.True(t, contextHasKey(req.Context(), codecsKey{}), "codecs key")
+			assert.NotNil(t, Logger(req), "logger")
 			assert.True(t, contextHasKey(req.Context(), "app_middleware"), "app key")
 			assert.True(t, contextHasKey(req.Context(), "route_middleware"), "route key")
 		}
 
 		var app TestApplication
-		app.On("Error", mock.Anything, mock.Anything, http.StatusServiceUnavailable, http.ErrHandlerTimeout)
+		app.On("Error", mock.Anything, mock.Anything, http.StatusServiceUnavailable, ServiceUnavailable(http.ErrHandlerTimeout))
 		app.On("Middlewares").Return(Middlewares{
 			WithValue("app_middleware", true),
 		})
@@ -205,6 +397,19 @@ func TestNewServer(t *testing.T) {
 					assert.Less(t, duration, time.Millisecond*200)
 				},
 			},
+			{
+				Name:    "timeout_disabled",
+				Timeout: -1,
+				Pattern: "/stream",
+				HandlerFunc: func(rw http.ResponseWriter, req *http.Request) {
+					assert.IsType(t, new(responseWriter), rw)
+
+					_, ok := req.Context().Deadline()
+					assert.False(t, ok)
+
+					rw.WriteHeader(http.StatusOK)
+				},
+			},
 		})
 
 		config := testConfig
@@ -246,6 +451,12 @@ func TestNewServer(t *testing.T) {
 
 		server.server.Handler.ServeHTTP(recorder, request)
 
+		recorder = httptest.NewRecorder()
+		request = httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/stream", nil)
+
+		server.server.Handler.ServeHTTP(recorder, request)
+		assert.Equal(t, http.StatusOK, recorder.Code)
+
 		app.AssertExpectations(t)
 	})
 
@@ -264,7 +475,7 @@ func TestNewServer(t *testing.T) {
 				HandlerFunc: func(_ http.ResponseWriter, _ *http.Request) {},
 			},
 		})
-		app.On("Error", mock.Anything, mock.Anything, http.StatusMethodNotAllowed, ErrMethodNotAllowed).Run(func(args mock.Arguments) {
+		app.On("Error", mock.Anything, mock.Anything, http.StatusMethodNotAllowed, MethodNotAllowed(ErrMethodNotAllowed)).Run(func(args mock.Arguments) {
 			rw, ok := args.Get(0).(http.ResponseWriter)
 			assert.True(t, ok, "1st argument should be a response writer")
 			req, ok := args.Get(1).(*http.Request)
@@ -272,9 +483,9 @@ func TestNewServer(t *testing.T) {
 
 			assert.IsType(t, new(responseWriter), rw)
 
-			assert.True(t, contextHasKey(req.Context(), durationKey{}), "duration key")
-			assert.True(t, contextHasKey(req.Context(), idKey{}), "id key")
-			assert.True(t, contextHasKey(req.Context(), loggerKey{}), "logger key")
+			assert.NotZero(t, requestStateFrom(req).start, "start")
+			assert.NotEmpty(t, ID(req), "id")
+			assert.NotNil(t, Logger(req), "logger")
 			assert.True(t, contextHasKey(req.Context(), "app_middleware"), "app key")
 		})
 
@@ -283,6 +494,465 @@ func TestNewServer(t *testing.T) {
 		recorder := httptest.NewRecorder()
 		request := httptest.NewRequestWithContext(t.Context(), http.MethodPost, "/status", nil)
 
+		server.server.Handler.ServeHTTP(recorder, request)
+		assert.Equal(t, "GET", recorder.Header().Get("Allow"))
+
+		app.AssertExpectations(t)
+	})
+
+	t.Run("negotiates codecs for every route", func(t *testing.T) {
+		t.Parallel()
+
+		var app TestApplication
+		app.On("Middlewares").Return(nil)
+		app.On("Routes").Return([]Route{
+			{
+				Name:    "update_status",
+				Method:  http.MethodPut,
+				Pattern: "/status",
+				HandlerFunc: func(_ http.ResponseWriter, _ *http.Request) {
+					assert.Fail(t, "handler should not be called")
+				},
+			},
+		})
+		app.On("Error", mock.Anything, mock.Anything, http.StatusNotAcceptable, NotAcceptable(ErrNotAcceptable)).Once()
+		app.On("Error", mock.Anything, mock.Anything, http.StatusUnsupportedMediaType, UnsupportedMediaType(ErrUnsupportedMediaType)).Once()
+
+		server := NewServer(testConfig, &app)
+
+		request := httptest.NewRequestWithContext(t.Context(), http.MethodPut, "/status", nil)
+		request.Header.Set("Accept", "image/png")
+
+		server.server.Handler.ServeHTTP(httptest.NewRecorder(), request)
+
+		request = httptest.NewRequestWithContext(t.Context(), http.MethodPut, "/status", strings.NewReader("a,b"))
+		request.Header.Set("Content-Type", "text/csv")
+
+		server.server.Handler.ServeHTTP(httptest.NewRecorder(), request)
+
+		app.AssertExpectations(t)
+	})
+
+	t.Run("handles options requests automatically", func(t *testing.T) {
+		t.Parallel()
+
+		chi.RegisterMethod("PURGE")
+
+		var app TestApplication
+		app.On("Middlewares").Return(Middlewares{})
+		app.On("Routes").Return([]Route{
+			{
+				Name:        "show_user",
+				Method:      http.MethodGet,
+				Pattern:     "/user/{key}",
+				HandlerFunc: func(_ http.ResponseWriter, _ *http.Request) {},
+			},
+			{
+				Name:        "purge_user",
+				Method:      "PURGE",
+				Pattern:     "/user/{key}",
+				HandlerFunc: func(_ http.ResponseWriter, _ *http.Request) {},
+			},
+			{
+				Name:    "options_status",
+				Method:  http.MethodOptions,
+				Pattern: "/status",
+				HandlerFunc: func(rw http.ResponseWriter, _ *http.Request) {
+					rw.WriteHeader(http.StatusOK)
+				},
+			},
+		})
+
+		config := testConfig
+		config.AutoOptions = true
+
+		server := NewServer(config, &app)
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequestWithContext(t.Context(), http.MethodOptions, "/user/abc", nil)
+
+		server.server.Handler.ServeHTTP(recorder, request)
+		assert.Equal(t, http.StatusNoContent, recorder.Code)
+		assert.Equal(t, "GET, OPTIONS, PURGE", recorder.Header().Get("Allow"))
+
+		recorder = httptest.NewRecorder()
+		request = httptest.NewRequestWithContext(t.Context(), http.MethodOptions, "/status", nil)
+
+		server.server.Handler.ServeHTTP(recorder, request)
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Empty(t, recorder.Header().Get("Allow"))
+
+		app.AssertExpectations(t)
+	})
+
+	t.Run("handles head requests automatically", func(t *testing.T) {
+		t.Parallel()
+
+		var app TestApplication
+		app.On("Middlewares").Return(Middlewares{})
+		app.On("Routes").Return([]Route{
+			{
+				Name:    "show_user",
+				Method:  http.MethodGet,
+				Pattern: "/user/{key}",
+				HandlerFunc: func(rw http.ResponseWriter, req *http.Request) {
+					rw.Header().Set("Request-Method", req.Method)
+					rw.WriteHeader(http.StatusOK)
+				},
+			},
+			{
+				Name:    "head_status",
+				Method:  http.MethodHead,
+				Pattern: "/status",
+				HandlerFunc: func(rw http.ResponseWriter, _ *http.Request) {
+					rw.WriteHeader(http.StatusAccepted)
+				},
+			},
+			{
+				Name:    "show_status",
+				Method:  http.MethodGet,
+				Pattern: "/status",
+				HandlerFunc: func(rw http.ResponseWriter, _ *http.Request) {
+					rw.WriteHeader(http.StatusOK)
+				},
+			},
+		})
+
+		config := testConfig
+		config.AutoHead = true
+
+		server := NewServer(config, &app)
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequestWithContext(t.Context(), http.MethodHead, "/user/abc", nil)
+
+		server.server.Handler.ServeHTTP(recorder, request)
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, http.MethodHead, recorder.Header().Get("Request-Method"))
+
+		recorder = httptest.NewRecorder()
+		request = httptest.NewRequestWithContext(t.Context(), http.MethodHead, "/status", nil)
+
+		server.server.Handler.ServeHTTP(recorder, request)
+		assert.Equal(t, http.StatusAccepted, recorder.Code)
+
+		app.AssertExpectations(t)
+	})
+
+	t.Run("handles non-canonical paths", func(t *testing.T) {
+		t.Parallel()
+
+		var app TestApplication
+		app.On("Middlewares").Return(Middlewares{})
+		app.On("Routes").Return([]Route{
+			{
+				Name:    "show_user",
+				Method:  http.MethodGet,
+				Pattern: "/user/{key}",
+				HandlerFunc: func(rw http.ResponseWriter, req *http.Request) {
+					_, _ = rw.Write([]byte(Vars(req)["key"]))
+				},
+			},
+			{
+				Name:        "status",
+				Method:      http.MethodGet,
+				Pattern:     "/status",
+				PathPolicy:  &PathPolicy{Mode: PathMatch},
+				HandlerFunc: func(_ http.ResponseWriter, _ *http.Request) {},
+			},
+		})
+
+		config := testConfig
+		config.PathPolicy = PathPolicy{Mode: PathRedirect, Permanent: true}
+
+		server := NewServer(config, &app)
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/user/abc/?fields=name", nil)
+
+		server.server.Handler.ServeHTTP(recorder, request)
+		assert.Equal(t, http.StatusPermanentRedirect, recorder.Code)
+		assert.Equal(t, "/user/abc?fields=name", recorder.Header().Get("Location"))
+		assert.NotEmpty(t, recorder.Header().Get("Request-Id"))
+
+		recorder = httptest.NewRecorder()
+		request = httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/user/abc", nil)
+
+		server.server.Handler.ServeHTTP(recorder, request)
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "abc", recorder.Body.String())
+
+		recorder = httptest.NewRecorder()
+		request = httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/status/", nil)
+
+		server.server.Handler.ServeHTTP(recorder, request)
+		assert.Equal(t, http.StatusOK, recorder.Code)
+
+		app.AssertExpectations(t)
+	})
+
+	t.Run("handles routes with hosts", func(t *testing.T) {
+		t.Parallel()
+
+		var app TestApplication
+		app.On("Middlewares").Return(Middlewares{})
+		app.On("Routes").Return([]Route{
+			{
+				Name:    "show_tenant_user",
+				Method:  http.MethodGet,
+				Host:    "{tenant}.api.luci.dev",
+				Pattern: "/user/{key}",
+				HandlerFunc: func(rw http.ResponseWriter, req *http.Request) {
+					_, _ = rw.Write([]byte(Var(req, "tenant") + " " + Var(req, "key")))
+				},
+			},
+			{
+				Name:    "show_user",
+				Method:  http.MethodGet,
+				Pattern: "/user/{key}",
+				HandlerFunc: func(rw http.ResponseWriter, req *http.Request) {
+					_, _ = rw.Write([]byte(Var(req, "key")))
+				},
+			},
+		})
+
+		server := NewServer(testConfig, &app)
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/user/abc", nil)
+		request.Host = "acme.api.luci.dev"
+
+		server.server.Handler.ServeHTTP(recorder, request)
+		assert.Equal(t, "acme abc", recorder.Body.String())
+
+		recorder = httptest.NewRecorder()
+		request = httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/user/abc", nil)
+
+		server.server.Handler.ServeHTTP(recorder, request)
+		assert.Equal(t, "abc", recorder.Body.String())
+
+		app.AssertExpectations(t)
+	})
+
+	t.Run("handles routes with hosts sharing a pattern", func(t *testing.T) {
+		t.Parallel()
+
+		handler := func(rw http.ResponseWriter, req *http.Request) {
+			_, _ = rw.Write([]byte(RequestRoute(req).Name))
+		}
+
+		var app TestApplication
+		app.On("Middlewares").Return(Middlewares{})
+		app.On("Routes").Return([]Route{
+			{
+				Name:        "show_admin_user",
+				Method:      http.MethodGet,
+				Host:        "admin.luci.dev",
+				Pattern:     "/user/{key}",
+				CORS:        &CORSConfig{AllowedOrigins: []string{"https://admin.luci.dev"}},
+				PathPolicy:  &PathPolicy{Mode: PathRedirect},
+				HandlerFunc: handler,
+			},
+			{
+				Name:        "update_admin_user",
+				Method:      http.MethodPost,
+				Host:        "admin.luci.dev",
+				Pattern:     "/user/{key}",
+				HandlerFunc: handler,
+			},
+			{
+				Name:        "show_user",
+				Method:      http.MethodGet,
+				Host:        "api.luci.dev",
+				Pattern:     "/user/{key}",
+				CORS:        &CORSConfig{AllowedOrigins: []string{"https://luci.dev"}},
+				PathPolicy:  &PathPolicy{Mode: PathMatch},
+				HandlerFunc: handler,
+			},
+		})
+		app.On("Error", mock.Anything, mock.Anything, http.StatusForbidden, Forbidden(ErrCORSNotAllowed)).Once()
+		app.On("Error", mock.Anything, mock.Anything, http.StatusMethodNotAllowed, MethodNotAllowed(ErrMethodNotAllowed)).Twice()
+
+		server := NewServer(testConfig, &app)
+
+		request := func(method, host, target string, header http.Header) *httptest.ResponseRecorder {
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequestWithContext(t.Context(), method, target, nil)
+			request.Host = host
+
+			for key, values := range header {
+				request.Header[key] = values
+			}
+
+			server.server.Handler.ServeHTTP(recorder, request)
+
+			return recorder
+		}
+
+		preflight := http.Header{
+			"Origin":                        []string{"https://admin.luci.dev"},
+			"Access-Control-Request-Method": []string{http.MethodGet},
+		}
+
+		recorder := request(http.MethodOptions, "admin.luci.dev", "/user/abc", preflight)
+		assert.Equal(t, "https://admin.luci.dev", recorder.Header().Get("Access-Control-Allow-Origin"))
+
+		recorder = request(http.MethodOptions, "api.luci.dev", "/user/abc", preflight)
+		assert.Empty(t, recorder.Header().Get("Access-Control-Allow-Origin"))
+
+		recorder = request(http.MethodGet, "admin.luci.dev", "/user/abc/", nil)
+		assert.Equal(t, http.StatusTemporaryRedirect, recorder.Code)
+		assert.Equal(t, "/user/abc", recorder.Header().Get("Location"))
+
+		recorder = request(http.MethodGet, "api.luci.dev", "/user/abc/", nil)
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "show_user", recorder.Body.String())
+
+		recorder = request(http.MethodPut, "admin.luci.dev", "/user/abc", nil)
+		assert.Equal(t, "GET, POST, OPTIONS", recorder.Header().Get("Allow"))
+
+		recorder = request(http.MethodPut, "api.luci.dev", "/user/abc", nil)
+		assert.Equal(t, "GET, OPTIONS", recorder.Header().Get("Allow"))
+
+		app.AssertExpectations(t)
+	})
+
+	t.Run("panics if routes have the same pattern, method, and host", func(t *testing.T) {
+		t.Parallel()
+
+		tests := []struct {
+			host     string
+			expected string
+		}{
+			{host: "", expected: `luci: route "list_users" has the same pattern, method, and host as route "show_users"`},
+			{host: "api.luci.dev", expected: `luci: route "list_users" has the same pattern, method, and host as route "show_users"`},
+		}
+
+		for _, test := range tests {
+			var app TestApplication
+			app.On("Middlewares").Return(nil)
+			app.On("Routes").Return([]Route{
+				{Name: "show_users", Method: http.MethodGet, Host: test.host, Pattern: "/users", HandlerFunc: func(http.ResponseWriter, *http.Request) {}},
+				{Name: "list_users", Method: http.MethodGet, Host: test.host, Pattern: "/users", HandlerFunc: func(http.ResponseWriter, *http.Request) {}},
+			})
+
+			assert.PanicsWithError(t, test.expected, func() {
+				NewServer(testConfig, &app)
+			})
+		}
+	})
+
+	t.Run("converts route variables", func(t *testing.T) {
+		t.Parallel()
+
+		var app TestApplication
+		app.On("Middlewares").Return(Middlewares{})
+		app.On("Routes").Return([]Route{
+			{
+				Name:    "show_user",
+				Method:  http.MethodGet,
+				Pattern: "/user/{id:int}",
+				HandlerFunc: func(rw http.ResponseWriter, req *http.Request) {
+					id, err := VarInt(req, "id")
+					assert.NoError(t, err)
+
+					_, _ = rw.Write([]byte(strconv.Itoa(id + 1)))
+				},
+			},
+		})
+		app.On("Error", mock.Anything, mock.Anything, http.StatusNotFound, NotFound(ErrNotFound)).Once()
+		app.On("Error", mock.Anything, mock.Anything, http.StatusBadRequest, mock.Anything).Once()
+
+		server := NewServer(testConfig, &app)
+
+		request := func(target string) *httptest.ResponseRecorder {
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequestWithContext(t.Context(), http.MethodGet, target, nil)
+
+			server.server.Handler.ServeHTTP(recorder, request)
+
+			return recorder
+		}
+
+		assert.Equal(t, "124", request("/user/123").Body.String())
+		assert.Empty(t, request("/user/abc").Body.String())
+		assert.Empty(t, request("/user/99999999999999999999").Body.String())
+
+		route, ok := server.Route("show_user")
+		assert.True(t, ok)
+
+		path, err := route.Path("123")
+		assert.NoError(t, err)
+		assert.Equal(t, "/user/123", path)
+
+		app.AssertExpectations(t)
+	})
+
+	t.Run("handles cors preflight requests", func(t *testing.T) {
+		t.Parallel()
+
+		var app TestApplication
+		app.On("Middlewares").Return(Middlewares{
+			func(http.Handler) http.Handler {
+				return http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+					assert.Fail(t, "preflight requests shouldn't call application middlewares")
+				})
+			},
+		})
+		app.On("Routes").Return([]Route{
+			{
+				Name:        "show_user",
+				Method:      http.MethodGet,
+				Pattern:     "/user/{key}",
+				HandlerFunc: func(_ http.ResponseWriter, _ *http.Request) {},
+			},
+			{
+				Name:        "update_user",
+				Method:      http.MethodPost,
+				Pattern:     "/user/{key}",
+				CORS:        &CORSConfig{AllowedOrigins: []string{"https://admin.luci.dev"}},
+				HandlerFunc: func(_ http.ResponseWriter, _ *http.Request) {},
+			},
+			{
+				Name:        "status",
+				Method:      http.MethodGet,
+				Pattern:     "/status",
+				HandlerFunc: func(_ http.ResponseWriter, _ *http.Request) {},
+			},
+		})
+		app.On("Error", mock.Anything, mock.Anything, http.StatusForbidden, Forbidden(ErrCORSNotAllowed)).Once()
+		app.On("Error", mock.Anything, mock.Anything, http.StatusMethodNotAllowed, MethodNotAllowed(ErrMethodNotAllowed)).Once()
+
+		config := testConfig
+		config.CORS = &CORSConfig{AllowedOrigins: []string{"https://luci.dev"}}
+
+		server := NewServer(config, &app)
+
+		preflight := func(origin, method string) *httptest.ResponseRecorder {
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequestWithContext(t.Context(), http.MethodOptions, "/user/abc", nil)
+			request.Header.Set("Origin", origin)
+			request.Header.Set("Access-Control-Request-Method", method)
+
+			server.server.Handler.ServeHTTP(recorder, request)
+
+			return recorder
+		}
+
+		recorder := preflight("https://luci.dev", http.MethodGet)
+		assert.Equal(t, http.StatusNoContent, recorder.Code)
+		assert.Equal(t, "https://luci.dev", recorder.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "GET, POST", recorder.Header().Get("Access-Control-Allow-Methods"))
+
+		recorder = preflight("https://admin.luci.dev", http.MethodPost)
+		assert.Equal(t, http.StatusNoContent, recorder.Code)
+		assert.Equal(t, "https://admin.luci.dev", recorder.Header().Get("Access-Control-Allow-Origin"))
+
+		recorder = preflight("https://luci.dev", http.MethodPost)
+		assert.Empty(t, recorder.Header().Get("Access-Control-Allow-Origin"))
+
+		recorder = httptest.NewRecorder()
+		request := httptest.NewRequestWithContext(t.Context(), http.MethodOptions, "/user/abc", nil)
 		server.server.Handler.ServeHTTP(recorder, request)
 
 		app.AssertExpectations(t)
@@ -303,7 +973,7 @@ func TestNewServer(t *testing.T) {
 				HandlerFunc: func(_ http.ResponseWriter, _ *http.Request) {},
 			},
 		})
-		app.On("Error", mock.Anything, mock.Anything, http.StatusNotFound, ErrNotFound).Run(func(args mock.Arguments) {
+		app.On("Error", mock.Anything, mock.Anything, http.StatusNotFound, NotFound(ErrNotFound)).Run(func(args mock.Arguments) {
 			rw, ok := args.Get(0).(http.ResponseWriter)
 			assert.True(t, ok, "1st argument should be a response writer")
 			req, ok := args.Get(1).(*http.Request)
@@ -311,9 +981,9 @@ func TestNewServer(t *testing.T) {
 
 			assert.IsType(t, new(responseWriter), rw)
 
-			assert.True(t, contextHasKey(req.Context(), durationKey{}), "duration key")
-			assert.True(t, contextHasKey(req.Context(), idKey{}), "id key")
-			assert.True(t, contextHasKey(req.Context(), loggerKey{}), "logger key")
+			assert.NotZero(t, requestStateFrom(req).start, "start")
+			assert.NotEmpty(t, ID(req), "id")
+			assert.NotNil(t, Logger(req), "logger")
 			assert.True(t, contextHasKey(req.Context(), "app_middleware"), "app key")
 		})
 
@@ -390,6 +1060,59 @@ func TestServerListenAndServe(t *testing.T) {
 		app.AssertExpectations(t)
 	})
 
+	t.Run("reads proxy protocol headers from trusted sources", func(t *testing.T) {
+		t.Parallel()
+
+		var app TestApplication
+		app.On("Middlewares").Return(nil)
+		app.On("Routes").Return([]Route{
+			{
+				Name:    "status",
+				Method:  http.MethodGet,
+				Pattern: "/status",
+				HandlerFunc: func(rw http.ResponseWriter, req *http.Request) {
+					_, _ = rw.Write([]byte(req.RemoteAddr + " " + ClientIP(req).String()))
+				},
+			},
+		})
+
+		server := NewServer(Config{
+			Address:        "127.0.0.1:0",
+			Logger:         noopLogger,
+			TrustedProxies: []string{"127.0.0.1"},
+			ProxyProtocol:  &ProxyProtocolConfig{},
+		}, &app)
+		ctx, cancel := context.WithCancel(context.Background())
+		listenErr := make(chan error, 1)
+
+		go func() {
+			listenErr <- server.ListenAndServe(ctx)
+		}()
+
+		var dialer net.Dialer
+
+		conn, err := dialer.DialContext(t.Context(), "tcp", server.Address())
+		assert.NoError(t, err)
+
+		_, err = conn.Write([]byte("PROXY TCP4 192.0.2.1 127.0.0.1 56324 80\r\nGET /status HTTP/1.1\r\nHost: luci.dev\r\nConnection: close\r\n\r\n"))
+		assert.NoError(t, err)
+
+		res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		assert.NoError(t, err)
+
+		body, err := io.ReadAll(res.Body)
+		assert.NoError(t, err)
+		assert.NoError(t, res.Body.Close())
+		assert.NoError(t, conn.Close())
+
+		assert.Equal(t, "192.0.2.1:56324 192.0.2.1", string(body))
+
+		cancel()
+		assert.NoError(t, <-listenErr)
+
+		app.AssertExpectations(t)
+	})
+
 	t.Run("returns error when server shutdown takes longer than the timeout allows", func(t *testing.T) {
 		t.Parallel()
 
@@ -475,7 +1198,129 @@ func TestServerRoute(t *testing.T) {
 	assert.True(t, ok)
 	assert.Equal(t, "get_status", route.Name)
 	assert.Equal(t, http.MethodGet, route.Method)
+	assert.NotNil(t, route.template, "route pattern should be compiled")
 
 	_, ok = server.Route("nonexistent_route")
 	assert.False(t, ok)
 }
+
+func TestServerURL(t *testing.T) {
+	t.Parallel()
+
+	var app TestApplication
+	app.On("Middlewares").Return(nil)
+	app.On("Routes").Return([]Route{
+		{
+			Name:        "show_user",
+			Method:      http.MethodGet,
+			Pattern:     "/user/{key}",
+			HandlerFunc: func(_ http.ResponseWriter, _ *http.Request) {},
+		},
+	})
+
+	server := NewServer(testConfig, &app)
+
+	uri, err := server.URL("show_user", map[string]string{"key": "abc"}, url.Values{"fields": {"name"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "/user/abc?fields=name", uri.String())
+
+	request := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "http://luci.dev/status", nil)
+
+	uri, err = server.RequestURL(request, "show_user", map[string]string{"key": "abc"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "http://luci.dev/user/abc", uri.String())
+
+	_, err = server.URL("nonexistent_route", nil, nil)
+	assert.Equal(t, errors.New(`luci: route "nonexistent_route" doesn't exist`), err)
+
+	_, err = server.RequestURL(request, "nonexistent_route", nil, nil)
+	assert.Equal(t, errors.New(`luci: route "nonexistent_route" doesn't exist`), err)
+
+	config := testConfig
+	config.BaseURL = "https://api.luci.dev/v1"
+
+	server = NewServer(config, &app)
+
+	uri, err = server.URL("show_user", map[string]string{"key": "abc"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://api.luci.dev/v1/user/abc", uri.String())
+
+	uri, err = server.RequestURL(request, "show_user", map[string]string{"key": "abc"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://api.luci.dev/v1/user/abc", uri.String())
+
+	config.BaseURL = "/v1"
+
+	assert.PanicsWithError(t, `luci: base url "/v1" must be an absolute url`, func() {
+		NewServer(config, &app)
+	})
+
+	app.AssertExpectations(t)
+}
+
+func TestRoutePatternMethods(t *testing.T) {
+	t.Parallel()
+
+	routes := []Route{
+		{Method: http.MethodGet, Pattern: "/user/{key}"},
+		{Method: http.MethodPost, Pattern: "/user/{key}"},
+		{Method: http.MethodGet, Pattern: "/user/{key}"},
+		{Pattern: "/ws", WebSocket: &WebSocket{}},
+		{Pattern: "/any"},
+	}
+
+	assert.Equal(t, map[string][]string{
+		"/user/{key}": {http.MethodGet, http.MethodPost},
+		"/ws":         {http.MethodGet},
+		"/any":        corsAnyMethods,
+	}, routePatternMethods(routes, false))
+	assert.Equal(t, map[string][]string{
+		"/user/{key}": {http.MethodGet, http.MethodHead, http.MethodPost},
+		"/ws":         {http.MethodGet},
+		"/any":        corsAnyMethods,
+	}, routePatternMethods(routes, true))
+}
+
+func BenchmarkServer(b *testing.B) {
+	var app TestApplication
+	app.On("Middlewares").Return(nil)
+	app.On("Routes").Return([]Route{
+		{
+			Name:        "get_status",
+			Method:      http.MethodGet,
+			Pattern:     "/status",
+			HandlerFunc: func(_ http.ResponseWriter, _ *http.Request) {},
+		},
+		{
+			Name:        "show_user",
+			Method:      http.MethodGet,
+			Pattern:     "/user/{key}",
+			HandlerFunc: func(_ http.ResponseWriter, _ *http.Request) {},
+		},
+		{
+			Name:    "show_user_vars",
+			Method:  http.MethodGet,
+			Pattern: "/user/{key}/vars",
+			HandlerFunc: func(_ http.ResponseWriter, req *http.Request) {
+				_ = Var(req, "key")
+			},
+		},
+	})
+
+	config := testConfig
+	config.RouteTimeout = -1
+
+	server := NewServer(config, &app)
+
+	for _, path := range []string{"/status", "/user/abc", "/user/abc/vars"} {
+		b.Run(path, func(b *testing.B) {
+			request := httptest.NewRequestWithContext(b.Context(), http.MethodGet, path, nil)
+
+			b.ReportAllocs()
+
+			for b.Loop() {
+				server.server.Handler.ServeHTTP(httptest.NewRecorder(), request)
+			}
+		})
+	}
+}
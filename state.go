@@ -0,0 +1,145 @@
+package luci
+
+import (
+	"context"
+	"iter"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type requestStateKey struct{}
+
+// requestState is the state luci associates with a request. It's stored in the request
+// context once, and then filled in by each middleware, rather than each middleware copying
+// the request to add its own value. Vars and the logger are only built if they're used.
+type requestState struct {
+	start        time.Time
+	id           string
+	clientID     string
+	forwarded    forwarded
+	route        *Route
+	app          Application
+	inFlight     *inFlight
+	timeout      *timeoutContext
+	routeParams  chi.RouteParams
+	values       map[string]any
+	serverLogger *slog.Logger
+	varsOnce     sync.Once
+	vars         map[string]string
+	loggerOnce   sync.Once
+	logger       *slog.Logger
+}
+
+// requestStateFrom returns the state associated with the request, or nil if there isn't any.
+func requestStateFrom(req *http.Request) *requestState {
+	state, _ := req.Context().Value(requestStateKey{}).(*requestState)
+	return state
+}
+
+// withState returns a shallow copy of the request with the state added to its context.
+func withState(req *http.Request, state *requestState) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), requestStateKey{}, state))
+}
+
+// withRequestState adds the state for the request, and starts the request's duration. The route
+// context's variables are copied, since the route context is reused once the request returns while
+// goroutines started by the handler may still use the request.
+func withRequestState(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		state := &requestState{start: time.Now()}
+
+		routeCtx := chi.RouteContext(req.Context())
+		if routeCtx != nil {
+			state.routeParams.Keys = slices.Clone(routeCtx.URLParams.Keys)
+			state.routeParams.Values = slices.Clone(routeCtx.URLParams.Values)
+		}
+
+		next.ServeHTTP(rw, withState(req, state))
+	})
+}
+
+// withRoute associates the route and application with the request.
+func withRoute(route *Route, app Application) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			state := requestStateFrom(req)
+			state.route = route
+			state.app = app
+
+			next.ServeHTTP(rw, req)
+		})
+	}
+}
+
+// requestVars returns the request's variables, building them from the route's variables the first time.
+func (state *requestState) requestVars() map[string]string {
+	state.varsOnce.Do(func() {
+		if state.vars != nil {
+			return
+		}
+
+		state.vars = make(map[string]string, len(state.routeParams.Keys))
+		for key, value := range state.urlParams() {
+			state.vars[key] = value
+		}
+	})
+
+	return state.vars
+}
+
+// requestLogger returns the request's logger, building it from the server's logger the first time.
+func (state *requestState) requestLogger() *slog.Logger {
+	if state.serverLogger != nil {
+		state.loggerOnce.Do(func() {
+			requestAttrs := state.requestAttrs(maps.All(state.requestVars()))
+			state.logger = state.serverLogger.With(slog.GroupAttrs("request", requestAttrs...))
+		})
+	}
+
+	return state.logger
+}
+
+// requestAttrs returns the attributes logged to describe the request, using the given variables.
+func (state *requestState) requestAttrs(vars iter.Seq2[string, string]) []slog.Attr {
+	attrs := []slog.Attr{slog.String("id", state.id)}
+
+	if state.clientID != "" {
+		attrs = append(attrs, slog.String("client_request_id", state.clientID))
+	}
+
+	if state.forwarded.clientIP.IsValid() {
+		attrs = append(attrs, slog.String("client_ip", state.forwarded.clientIP.String()))
+	}
+
+	if state.route != nil && state.route.Name != "" {
+		attrs = append(attrs, slog.String("route", state.route.Name))
+	}
+
+	var varAttrs []slog.Attr
+	for key, value := range vars {
+		varAttrs = append(varAttrs, slog.String(key, value))
+	}
+
+	if len(varAttrs) > 0 {
+		attrs = append(attrs, slog.GroupAttrs("vars", varAttrs...))
+	}
+
+	return attrs
+}
+
+// urlParams returns the route's variables, without building the request's variables.
+func (state *requestState) urlParams() iter.Seq2[string, string] {
+	return func(yield func(string, string) bool) {
+		for idx, key := range state.routeParams.Keys {
+			if !yield(key, state.routeParams.Values[idx]) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package luci
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithRequestState(t *testing.T) {
+	t.Parallel()
+
+	var ctx chi.Context
+	ctx.URLParams.Add("key", "abc")
+
+	middlewares := Middlewares{
+		WithValue(chi.RouteCtxKey, &ctx),
+		withRequestState,
+	}
+
+	handler := middlewares.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
+		state := requestStateFrom(req)
+		assert.NotZero(t, state.start)
+		assert.Nil(t, state.vars, "vars should only be built when used")
+
+		assert.Equal(t, map[string]string{"key": "abc"}, Vars(req))
+		assert.Equal(t, map[string]string{"key": "abc"}, state.vars)
+	})
+
+	handler.ServeHTTP(nil, httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/user/abc", nil))
+
+	t.Run("keeps variables once the route context is reused", func(t *testing.T) {
+		t.Parallel()
+
+		var reused chi.Context
+		reused.URLParams.Add("key", "abc")
+
+		var request *http.Request
+
+		handler := Middlewares{WithValue(chi.RouteCtxKey, &reused), withRequestState}.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
+			request = req
+		})
+
+		handler.ServeHTTP(nil, httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/user/abc", nil))
+
+		// Chi resets and reuses the route context for later requests, while a goroutine
+		// started by the handler may still use the request.
+		reused.Reset()
+		reused.URLParams.Add("key", "xyz")
+
+		assert.Equal(t, map[string]string{"key": "abc"}, Vars(request))
+	})
+}
+
+func TestWithRoute(t *testing.T) {
+	t.Parallel()
+
+	var app TestApplication
+
+	route := Route{Name: "show_user", Method: http.MethodGet, Pattern: "/user/{key}"}
+
+	handler := Middlewares{withRequestState, withRoute(&route, &app)}.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, route, RequestRoute(req))
+		assert.Same(t, &app, requestApplication(req))
+	})
+
+	handler.ServeHTTP(nil, httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/user/abc", nil))
+}
+
+func TestRequestStateLogger(t *testing.T) {
+	t.Parallel()
+
+	t.Run("returns set logger if there's no server logger", func(t *testing.T) {
+		t.Parallel()
+
+		state := requestState{logger: noopLogger}
+		assert.Same(t, noopLogger, state.requestLogger())
+		assert.Nil(t, (&requestState{}).requestLogger())
+	})
+
+	t.Run("builds logger with request attributes", func(t *testing.T) {
+		t.Parallel()
+
+		var (
+			buf bytes.Buffer
+			ctx chi.Context
+		)
+
+		ctx.URLParams.Add("key", "abc")
+
+		state := requestState{
+			id:           "request_id",
+			route:        &Route{Name: "show_user"},
+			routeParams:  ctx.URLParams,
+			serverLogger: slog.New(slog.NewJSONHandler(&buf, nil)),
+		}
+
+		logger := state.requestLogger()
+		assert.Same(t, logger, state.requestLogger())
+
+		logger.Info("message")
+
+		var record struct {
+			Request map[string]any `json:"request"`
+		}
+
+		assert.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+		assert.Equal(t, map[string]any{
+			"id":    "request_id",
+			"route": "show_user",
+			"vars":  map[string]any{"key": "abc"},
+		}, record.Request)
+	})
+}
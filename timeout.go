@@ -1,19 +1,124 @@
 package luci
 
 import (
+	"bufio"
 	"context"
 	"errors"
 	"io"
 	"log/slog"
+	"net"
 	"net/http"
+	"sync"
 	"time"
 )
 
+// timeoutContext is a context that's cancelled once its timeout is reached, unless the timeout
+// has been lifted for long lived responses such as event streams. Once lifted the context is only
+// cancelled with its parent, and reports its parent's deadline.
+type timeoutContext struct {
+	context.Context
+	deadline time.Time
+	done     chan struct{}
+	timer    *time.Timer
+	stop     func() bool
+	mu       sync.Mutex
+	err      error
+	lifted   bool
+}
+
 type timeoutResponseWriter struct {
 	*responseWriter
 	err error
 }
 
+func newTimeoutContext(parent context.Context, timeout time.Duration) *timeoutContext {
+	ctx := &timeoutContext{
+		Context:  parent,
+		deadline: time.Now().Add(timeout),
+		done:     make(chan struct{}),
+	}
+
+	parentDeadline, ok := parent.Deadline()
+	if ok && parentDeadline.Before(ctx.deadline) {
+		ctx.deadline = parentDeadline
+	}
+
+	// The timer and parent may cancel the context before they've been set.
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+
+	ctx.timer = time.AfterFunc(timeout, func() {
+		ctx.cancel(context.DeadlineExceeded, false)
+	})
+	ctx.stop = context.AfterFunc(parent, func() {
+		ctx.cancel(parent.Err(), true)
+	})
+
+	return ctx
+}
+
+func (ctx *timeoutContext) Deadline() (time.Time, bool) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+
+	if ctx.lifted {
+		return ctx.Context.Deadline()
+	}
+
+	return ctx.deadline, true
+}
+
+func (ctx *timeoutContext) Done() <-chan struct{} {
+	return ctx.done
+}
+
+func (ctx *timeoutContext) Err() error {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+
+	return ctx.err
+}
+
+// lift stops the timeout, it returns false if the context has already been cancelled.
+func (ctx *timeoutContext) lift() bool {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+
+	if ctx.err != nil {
+		return false
+	}
+
+	ctx.lifted = true
+	ctx.timer.Stop()
+
+	return true
+}
+
+func (ctx *timeoutContext) isLifted() bool {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+
+	return ctx.lifted
+}
+
+// cancel cancels the context with the error, a lifted context is only cancelled if force is set.
+func (ctx *timeoutContext) cancel(err error, force bool) {
+	ctx.mu.Lock()
+
+	if ctx.err != nil || (ctx.lifted && !force) {
+		ctx.mu.Unlock()
+		return
+	}
+
+	ctx.err = err
+	close(ctx.done)
+	timer, stop := ctx.timer, ctx.stop
+	ctx.mu.Unlock()
+
+	timer.Stop()
+	stop()
+}
+
 func (rw *timeoutResponseWriter) Write(b []byte) (int, error) {
 	rw.mu.Lock()
 	defer rw.mu.Unlock()
@@ -36,6 +141,65 @@ func (rw *timeoutResponseWriter) ReadFrom(r io.Reader) (int64, error) {
 	return rw.lockedReadFrom(r)
 }
 
+func (rw *timeoutResponseWriter) Flush() {
+	_ = rw.FlushError()
+}
+
+func (rw *timeoutResponseWriter) FlushError() error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	if rw.err != nil {
+		return rw.err
+	}
+
+	return rw.lockedFlush()
+}
+
+func (rw *timeoutResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	if rw.err != nil {
+		return nil, nil, rw.err
+	}
+
+	return rw.lockedHijack()
+}
+
+func (rw *timeoutResponseWriter) SetReadDeadline(deadline time.Time) error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	if rw.err != nil {
+		return rw.err
+	}
+
+	return rw.lockedSetReadDeadline(deadline)
+}
+
+func (rw *timeoutResponseWriter) SetWriteDeadline(deadline time.Time) error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	if rw.err != nil {
+		return rw.err
+	}
+
+	return rw.lockedSetWriteDeadline(deadline)
+}
+
+func (rw *timeoutResponseWriter) EnableFullDuplex() error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	if rw.err != nil {
+		return rw.err
+	}
+
+	return rw.lockedEnableFullDuplex()
+}
+
 func (rw *timeoutResponseWriter) error(err error) {
 	rw.mu.Lock()
 	defer rw.mu.Unlock()
@@ -46,8 +210,13 @@ func (rw *timeoutResponseWriter) error(err error) {
 func withTimeout(errorHandler ErrorHandlerFunc, timeout time.Duration) Middleware {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
-			ctx, cancel := context.WithTimeout(req.Context(), timeout)
-			defer cancel()
+			ctx := newTimeoutContext(req.Context(), timeout)
+			defer ctx.cancel(context.Canceled, true)
+
+			state := requestStateFrom(req)
+			if state != nil {
+				state.timeout = ctx
+			}
 
 			req = req.WithContext(ctx)
 
@@ -72,17 +241,24 @@ func withTimeout(errorHandler ErrorHandlerFunc, timeout time.Duration) Middlewar
 				close(done)
 			}()
 
-			select {
-			case val := <-panicChan:
-				err, ok := val.(error)
-				if ok && errors.Is(err, http.ErrAbortHandler) {
-					return
-				}
+			var val any
 
-				panic(val)
+			select {
+			case val = <-panicChan:
 			case <-done:
 				return
 			case <-ctx.Done():
+				// Once the timeout has been lifted the handler is waited for like routes without a timeout.
+				if ctx.isLifted() {
+					select {
+					case val = <-panicChan:
+					case <-done:
+						return
+					}
+
+					break
+				}
+
 				err := ctx.Err()
 				if errors.Is(err, context.DeadlineExceeded) {
 					err = http.ErrHandlerTimeout
@@ -90,14 +266,22 @@ func withTimeout(errorHandler ErrorHandlerFunc, timeout time.Duration) Middlewar
 
 				trw.error(err)
 
-				wroteHeader, _, _ := trw.stats()
-				if wroteHeader {
+				if trw.stats().wroteHeader {
 					Logger(req).With(slog.Any("error", err)).Error("unable to write timeout error response, response already written")
 					return
 				}
 
-				errorHandler(wrw, req, http.StatusServiceUnavailable, err)
+				errorHandler(wrw, req, http.StatusServiceUnavailable, ServiceUnavailable(err))
+
+				return
 			}
+
+			err, ok := val.(error)
+			if ok && errors.Is(err, http.ErrAbortHandler) {
+				return
+			}
+
+			panic(val)
 		})
 	}
 }
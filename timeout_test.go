@@ -132,6 +132,7 @@ func TestWithTimeout(t *testing.T) {
 		timeout := time.Millisecond * 100
 		middlewares := Middlewares{
 			withResponseWriter,
+			withRequestState,
 			withLogger(noopLogger),
 			withTimeout(errorHandler, timeout),
 		}
@@ -158,7 +159,7 @@ func TestWithTimeout(t *testing.T) {
 			called = true
 
 			assert.Equal(t, http.StatusServiceUnavailable, status)
-			assert.Equal(t, http.ErrHandlerTimeout, err)
+			assert.Equal(t, ServiceUnavailable(http.ErrHandlerTimeout), err)
 		}
 
 		timeout := time.Millisecond * 100
@@ -272,4 +273,127 @@ func TestWithTimeout(t *testing.T) {
 
 		assert.True(t, called)
 	})
+
+	t.Run("flush does nothing when timeout occurs", func(t *testing.T) {
+		t.Parallel()
+
+		errorHandler := func(rw http.ResponseWriter, _ *http.Request, status int, _ error) {
+			rw.WriteHeader(status)
+		}
+
+		middlewares := Middlewares{
+			withResponseWriter,
+			withTimeout(errorHandler, time.Millisecond*100),
+		}
+
+		handler := middlewares.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			<-req.Context().Done()
+			<-time.After(time.Millisecond * 200)
+
+			rw.(http.Flusher).Flush()
+		})
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/status", nil)
+
+		handler.ServeHTTP(recorder, request)
+
+		assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+		assert.False(t, recorder.Flushed)
+	})
+
+	t.Run("hijack returns http.ErrHandlerTimeout when timeout occurs", func(t *testing.T) {
+		t.Parallel()
+
+		errorHandler := func(rw http.ResponseWriter, _ *http.Request, status int, _ error) {
+			rw.WriteHeader(status)
+		}
+
+		middlewares := Middlewares{
+			withResponseWriter,
+			withTimeout(errorHandler, time.Millisecond*100),
+		}
+
+		handler := middlewares.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			<-req.Context().Done()
+			<-time.After(time.Millisecond * 200)
+
+			_, _, err := rw.(http.Hijacker).Hijack()
+			assert.Equal(t, http.ErrHandlerTimeout, err)
+		})
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/status", nil)
+
+		handler.ServeHTTP(recorder, request)
+	})
+
+	t.Run("response controller returns http.ErrHandlerTimeout when timeout occurs", func(t *testing.T) {
+		t.Parallel()
+
+		errorHandler := func(rw http.ResponseWriter, _ *http.Request, status int, _ error) {
+			rw.WriteHeader(status)
+		}
+
+		middlewares := Middlewares{
+			withResponseWriter,
+			withTimeout(errorHandler, time.Millisecond*100),
+		}
+
+		handler := middlewares.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			<-req.Context().Done()
+			<-time.After(time.Millisecond * 200)
+
+			controller := http.NewResponseController(rw)
+			assert.Equal(t, http.ErrHandlerTimeout, controller.Flush())
+			assert.Equal(t, http.ErrHandlerTimeout, controller.SetReadDeadline(time.Now()))
+			assert.Equal(t, http.ErrHandlerTimeout, controller.SetWriteDeadline(time.Now()))
+			assert.Equal(t, http.ErrHandlerTimeout, controller.EnableFullDuplex())
+		})
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/status", nil)
+
+		handler.ServeHTTP(recorder, request)
+	})
+}
+
+func TestTimeoutContext(t *testing.T) {
+	t.Parallel()
+
+	t.Run("is cancelled once the timeout is reached", func(t *testing.T) {
+		t.Parallel()
+
+		ctx := newTimeoutContext(t.Context(), time.Millisecond*10)
+
+		deadline, ok := ctx.Deadline()
+		assert.True(t, ok)
+		assert.WithinDuration(t, time.Now().Add(time.Millisecond*10), deadline, time.Millisecond*10)
+
+		<-ctx.Done()
+		assert.Equal(t, context.DeadlineExceeded, ctx.Err())
+		assert.False(t, ctx.lift())
+	})
+
+	t.Run("is only cancelled with its parent once lifted", func(t *testing.T) {
+		t.Parallel()
+
+		parent, cancel := context.WithCancel(t.Context())
+		ctx := newTimeoutContext(parent, time.Millisecond*10)
+
+		assert.True(t, ctx.lift())
+
+		_, ok := ctx.Deadline()
+		assert.False(t, ok)
+
+		select {
+		case <-ctx.Done():
+			assert.Fail(t, "context was cancelled after the timeout was lifted")
+		case <-time.After(time.Millisecond * 50):
+		}
+
+		cancel()
+		<-ctx.Done()
+		assert.Equal(t, context.Canceled, ctx.Err())
+	})
 }
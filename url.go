@@ -0,0 +1,219 @@
+package luci
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	textMarshalerType = reflect.TypeFor[encoding.TextMarshaler]()
+)
+
+// urlValues returns the variables and query parameters used to build a URL. vars may be nil,
+// a map[string]string of variables, or a struct or pointer to a struct whose fields use the
+// path and query tags the same way as Bind.
+func urlValues(vars any) (map[string]string, url.Values, error) {
+	query := url.Values{}
+
+	switch vars := vars.(type) {
+	case nil:
+		return nil, query, nil
+	case map[string]string:
+		return vars, query, nil
+	}
+
+	value := reflect.ValueOf(vars)
+	if value.Kind() == reflect.Pointer && !value.IsNil() {
+		value = value.Elem()
+	}
+
+	if value.Kind() != reflect.Struct {
+		return nil, nil, errors.New("luci: url variables must be a map[string]string or struct")
+	}
+
+	values := make(map[string]string)
+
+	err := urlStructValues(value, values, query)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return values, query, nil
+}
+
+func urlStructValues(value reflect.Value, vars map[string]string, query url.Values) error {
+	valueType := value.Type()
+
+	for idx := range valueType.NumField() {
+		field := valueType.Field(idx)
+
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			err := urlStructValues(value.Field(idx), vars, query)
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if !field.IsExported() {
+			continue
+		}
+
+		key, ok := field.Tag.Lookup("path")
+		if ok && key != "" && key != "-" {
+			formatted, err := formatURLValues(value.Field(idx))
+			if err != nil {
+				return fmt.Errorf(`luci: url variable "%s": %w`, key, err)
+			}
+
+			if len(formatted) != 0 {
+				vars[key] = formatted[0]
+			}
+		}
+
+		key, ok = field.Tag.Lookup("query")
+		if ok && key != "" && key != "-" && !value.Field(idx).IsZero() {
+			formatted, err := formatURLValues(value.Field(idx))
+			if err != nil {
+				return fmt.Errorf(`luci: url query "%s": %w`, key, err)
+			}
+
+			query[key] = append(query[key], formatted...)
+		}
+	}
+
+	return nil
+}
+
+// formatURLValues formats the value the same way setValues parses it.
+func formatURLValues(value reflect.Value) ([]string, error) {
+	if value.Kind() == reflect.Slice && !value.Type().Implements(textMarshalerType) {
+		formatted := make([]string, 0, value.Len())
+
+		for idx := range value.Len() {
+			item, ok, err := formatURLValue(value.Index(idx))
+			if err != nil {
+				return nil, err
+			}
+
+			if ok {
+				formatted = append(formatted, item)
+			}
+		}
+
+		return formatted, nil
+	}
+
+	formatted, ok, err := formatURLValue(value)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	return []string{formatted}, nil
+}
+
+// formatURLValue formats the value, and whether it has a value to format.
+func formatURLValue(value reflect.Value) (string, bool, error) {
+	if value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return "", false, nil
+		}
+
+		value = value.Elem()
+	}
+
+	if value.Type() == durationType {
+		return time.Duration(value.Int()).String(), true, nil
+	}
+
+	if !value.Type().Implements(textMarshalerType) && value.CanAddr() && value.Addr().Type().Implements(textMarshalerType) {
+		value = value.Addr()
+	}
+
+	if value.Type().Implements(textMarshalerType) {
+		marshaler, _ := value.Interface().(encoding.TextMarshaler)
+
+		text, err := marshaler.MarshalText()
+		if err != nil {
+			return "", false, fmt.Errorf("invalid %s: %w", value.Type(), err)
+		}
+
+		return string(text), true, nil
+	}
+
+	switch value.Kind() {
+	case reflect.String:
+		return value.String(), true, nil
+	case reflect.Bool:
+		return strconv.FormatBool(value.Bool()), true, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(value.Int(), 10), true, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(value.Uint(), 10), true, nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(value.Float(), 'f', -1, value.Type().Bits()), true, nil
+	default:
+		return "", false, fmt.Errorf("unsupported type %s", value.Type())
+	}
+}
+
+// namedValues returns the values for the given variable names.
+func namedValues(names []string, vars map[string]string) ([]string, error) {
+	vals := make([]string, len(names))
+
+	for idx, name := range names {
+		val, ok := vars[name]
+		if !ok {
+			return nil, fmt.Errorf(`luci: must provide a value for variable "%s"`, name)
+		}
+
+		vals[idx] = val
+	}
+
+	return vals, nil
+}
+
+// absoluteURL resolves the URL built for a route against the base URL, keeping the URL's
+// host if it has one. The base URL's path is used as a prefix to the URL's path.
+func absoluteURL(base *url.URL, uri *url.URL) (*url.URL, error) {
+	host := uri.Host
+	if host == "" {
+		host = base.Host
+	}
+
+	absolute := base.Scheme + "://" + host + strings.TrimSuffix(base.EscapedPath(), "/") + uri.EscapedPath()
+	if uri.RawQuery != "" {
+		absolute += "?" + uri.RawQuery
+	}
+
+	resolved, err := url.Parse(absolute)
+	if err != nil {
+		return nil, fmt.Errorf("luci: parse url: %w", err)
+	}
+
+	return resolved, nil
+}
+
+// requestBaseURL returns the base URL of the request using the scheme and host the client used,
+// which are resolved from the headers of trusted proxies, see ClientIP for details.
+func requestBaseURL(req *http.Request) *url.URL {
+	state := requestStateFrom(req)
+	if state != nil && state.forwarded.scheme != "" {
+		return &url.URL{Scheme: state.forwarded.scheme, Host: state.forwarded.host}
+	}
+
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+
+	return &url.URL{Scheme: scheme, Host: req.Host}
+}
@@ -0,0 +1,126 @@
+package luci
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestURLValues(t *testing.T) {
+	t.Parallel()
+
+	type embedded struct {
+		Sort string `query:"sort"`
+	}
+
+	type input struct {
+		embedded
+
+		Key     string        `path:"key"`
+		Limit   *int          `query:"limit"`
+		Page    int           `query:"page"`
+		Since   time.Time     `query:"since"`
+		Timeout time.Duration `query:"timeout"`
+		IP      net.IP        `query:"ip"`
+		Ignored string        `query:"-"`
+	}
+
+	limit := 10
+	since := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	vars, query, err := urlValues(input{
+		embedded: embedded{Sort: "name"},
+		Key:      "abc",
+		Limit:    &limit,
+		Since:    since,
+		Timeout:  time.Second,
+		IP:       net.IPv4(127, 0, 0, 1),
+		Ignored:  "ignored",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"key": "abc"}, vars)
+	assert.Equal(t, url.Values{
+		"sort":    {"name"},
+		"limit":   {"10"},
+		"since":   {"2026-01-02T03:04:05Z"},
+		"timeout": {"1s"},
+		"ip":      {"127.0.0.1"},
+	}, query)
+
+	vars, query, err = urlValues(map[string]string{"key": "abc"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"key": "abc"}, vars)
+	assert.Empty(t, query)
+
+	vars, query, err = urlValues(nil)
+	assert.NoError(t, err)
+	assert.Nil(t, vars)
+	assert.Empty(t, query)
+
+	_, _, err = urlValues("abc")
+	assert.Equal(t, errors.New("luci: url variables must be a map[string]string or struct"), err)
+
+	_, _, err = urlValues(struct {
+		Key chan int `path:"key"`
+	}{})
+	assert.EqualError(t, err, `luci: url variable "key": unsupported type chan int`)
+}
+
+func TestFormatURLValues(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		value    any
+		expected []string
+	}{
+		{value: "abc", expected: []string{"abc"}},
+		{value: true, expected: []string{"true"}},
+		{value: int8(-8), expected: []string{"-8"}},
+		{value: uint(8), expected: []string{"8"}},
+		{value: 1.5, expected: []string{"1.5"}},
+		{value: []int{1, 2}, expected: []string{"1", "2"}},
+		{value: (*string)(nil), expected: nil},
+	}
+
+	for _, test := range tests {
+		formatted, err := formatURLValues(reflect.ValueOf(test.value))
+		assert.NoError(t, err)
+		assert.Equal(t, test.expected, formatted, "%v", test.value)
+	}
+}
+
+func TestAbsoluteURL(t *testing.T) {
+	t.Parallel()
+
+	base, err := url.Parse("https://luci.dev/api/")
+	assert.NoError(t, err)
+
+	absolute, err := absoluteURL(base, &url.URL{Path: "/user/a b", RawQuery: "page=2"})
+	assert.NoError(t, err)
+	assert.Equal(t, "https://luci.dev/api/user/a%20b?page=2", absolute.String())
+
+	absolute, err = absoluteURL(base, &url.URL{Host: "acme.luci.dev", Path: "/status"})
+	assert.NoError(t, err)
+	assert.Equal(t, "https://acme.luci.dev/api/status", absolute.String())
+}
+
+func TestRequestBaseURL(t *testing.T) {
+	t.Parallel()
+
+	request := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "http://luci.dev:8080/status", nil)
+	assert.Equal(t, &url.URL{Scheme: "http", Host: "luci.dev:8080"}, requestBaseURL(request))
+
+	request.TLS = &tls.ConnectionState{}
+	assert.Equal(t, &url.URL{Scheme: "https", Host: "luci.dev:8080"}, requestBaseURL(request))
+
+	request = withState(request, &requestState{forwarded: forwarded{scheme: "https", host: "api.luci.dev"}})
+	assert.Equal(t, &url.URL{Scheme: "https", Host: "api.luci.dev"}, requestBaseURL(request))
+}
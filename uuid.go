@@ -0,0 +1,109 @@
+package luci
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// UUID is a universally unique identifier, see RFC 9562.
+type UUID [16]byte
+
+// ParseUUID parses a UUID in its canonical form, e.g. 6ba7b810-9dad-11d1-80b4-00c04fd430c8.
+// Hex digits may be upper or lower case.
+func ParseUUID(value string) (UUID, error) {
+	var uuid UUID
+
+	err := uuid.UnmarshalText([]byte(value))
+
+	return uuid, err
+}
+
+// NewUUIDv4 returns a new random UUID, see RFC 9562 section 5.4.
+func NewUUIDv4() (UUID, error) {
+	var uuid UUID
+
+	_, err := rand.Read(uuid[:])
+	if err != nil {
+		return uuid, fmt.Errorf("luci: uuid random: %w", err)
+	}
+
+	uuid.setVersion(4)
+
+	return uuid, nil
+}
+
+// NewUUIDv7 returns a new UUID whose first 48 bits are the current Unix time in
+// milliseconds and the rest are random, so UUIDs sort by creation time, see RFC 9562 section 5.7.
+func NewUUIDv7() (UUID, error) {
+	var uuid UUID
+
+	_, err := rand.Read(uuid[6:])
+	if err != nil {
+		return uuid, fmt.Errorf("luci: uuid random: %w", err)
+	}
+
+	var millis [8]byte
+
+	binary.BigEndian.PutUint64(millis[:], uint64(time.Now().UnixMilli())) //nolint:gosec // The time is after the Unix epoch.
+	copy(uuid[:6], millis[2:])
+
+	uuid.setVersion(7)
+
+	return uuid, nil
+}
+
+// String returns the UUID in its canonical lower case form.
+func (uuid UUID) String() string {
+	text, _ := uuid.MarshalText()
+	return string(text)
+}
+
+// MarshalText implements encoding.TextMarshaler using the UUID's canonical form.
+func (uuid UUID) MarshalText() ([]byte, error) {
+	text := make([]byte, 36)
+
+	hex.Encode(text[0:8], uuid[0:4])
+	text[8] = '-'
+	hex.Encode(text[9:13], uuid[4:6])
+	text[13] = '-'
+	hex.Encode(text[14:18], uuid[6:8])
+	text[18] = '-'
+	hex.Encode(text[19:23], uuid[8:10])
+	text[23] = '-'
+	hex.Encode(text[24:36], uuid[10:16])
+
+	return text, nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler by parsing the UUID's canonical form.
+func (uuid *UUID) UnmarshalText(text []byte) error {
+	if len(text) != 36 || text[8] != '-' || text[13] != '-' || text[18] != '-' || text[23] != '-' {
+		return ErrInvalidUUID
+	}
+
+	var parsed UUID
+
+	offset := 0
+
+	for _, group := range [][2]int{{0, 8}, {9, 13}, {14, 18}, {19, 23}, {24, 36}} {
+		size, err := hex.Decode(parsed[offset:], text[group[0]:group[1]])
+		if err != nil {
+			return ErrInvalidUUID
+		}
+
+		offset += size
+	}
+
+	*uuid = parsed
+
+	return nil
+}
+
+// setVersion sets the UUID's version, and its variant to the RFC 9562 variant.
+func (uuid *UUID) setVersion(version byte) {
+	uuid[6] = uuid[6]&0x0f | version<<4
+	uuid[8] = uuid[8]&0x3f | 0x80
+}
@@ -0,0 +1,72 @@
+package luci
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseUUID(t *testing.T) {
+	t.Parallel()
+
+	uuid, err := ParseUUID("6BA7B810-9dad-11d1-80b4-00c04fd430c8")
+	assert.NoError(t, err)
+	assert.Equal(t, UUID{0x6b, 0xa7, 0xb8, 0x10, 0x9d, 0xad, 0x11, 0xd1, 0x80, 0xb4, 0x00, 0xc0, 0x4f, 0xd4, 0x30, 0xc8}, uuid)
+	assert.Equal(t, "6ba7b810-9dad-11d1-80b4-00c04fd430c8", uuid.String())
+
+	tests := []string{
+		"",
+		"6ba7b8109dad11d180b400c04fd430c8",
+		"6ba7b810-9dad-11d1-80b4-00c04fd430c",
+		"6ba7b810_9dad_11d1_80b4_00c04fd430c8",
+		"6ba7b810-9dad-11d1-80b4-00c04fd430cz",
+		"{6ba7b810-9dad-11d1-80b4-00c04fd430c}",
+	}
+
+	for _, test := range tests {
+		_, err := ParseUUID(test)
+		assert.ErrorIs(t, err, ErrInvalidUUID, test)
+	}
+}
+
+func TestUUIDText(t *testing.T) {
+	t.Parallel()
+
+	uuid := UUID{0x6b, 0xa7, 0xb8, 0x10, 0x9d, 0xad, 0x11, 0xd1, 0x80, 0xb4, 0x00, 0xc0, 0x4f, 0xd4, 0x30, 0xc8}
+
+	text, err := uuid.MarshalText()
+	assert.NoError(t, err)
+	assert.Equal(t, "6ba7b810-9dad-11d1-80b4-00c04fd430c8", string(text))
+
+	var unmarshaled UUID
+
+	err = unmarshaled.UnmarshalText(text)
+	assert.NoError(t, err)
+	assert.Equal(t, uuid, unmarshaled)
+}
+
+func TestNewUUID(t *testing.T) {
+	t.Parallel()
+
+	uuid, err := NewUUIDv4()
+	assert.NoError(t, err)
+	assert.Equal(t, byte(0x40), uuid[6]&0xf0, "version")
+	assert.Equal(t, byte(0x80), uuid[8]&0xc0, "variant")
+
+	other, err := NewUUIDv4()
+	assert.NoError(t, err)
+	assert.NotEqual(t, uuid, other)
+
+	before := time.Now().UnixMilli()
+
+	uuid, err = NewUUIDv7()
+	assert.NoError(t, err)
+	assert.Equal(t, byte(0x70), uuid[6]&0xf0, "version")
+	assert.Equal(t, byte(0x80), uuid[8]&0xc0, "variant")
+
+	var millis [8]byte
+	copy(millis[2:], uuid[:6])
+	assert.GreaterOrEqual(t, int64(binary.BigEndian.Uint64(millis[:])), before) //nolint:gosec // The time is 48 bits.
+}
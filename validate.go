@@ -0,0 +1,440 @@
+package luci
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+var (
+	validateTypes sync.Map
+
+	numericKinds = map[reflect.Kind]bool{
+		reflect.Int: true, reflect.Int8: true, reflect.Int16: true, reflect.Int32: true, reflect.Int64: true,
+		reflect.Uint: true, reflect.Uint8: true, reflect.Uint16: true, reflect.Uint32: true, reflect.Uint64: true,
+		reflect.Uintptr: true, reflect.Float32: true, reflect.Float64: true,
+	}
+	lengthKinds = map[reflect.Kind]bool{
+		reflect.String: true, reflect.Slice: true, reflect.Array: true, reflect.Map: true,
+	}
+)
+
+// Validator is implemented by values that can validate themselves.
+type Validator interface {
+	// Validate reports whether the value is valid.
+	Validate() error
+}
+
+// validateField is a struct field and its parsed validate rules.
+type validateField struct {
+	index     int
+	name      string
+	embedded  bool
+	omitempty bool
+	rules     []validateRule
+}
+
+// validateRule is a parsed validate rule, with its parameter parsed for the rules that use one.
+type validateRule struct {
+	name     string
+	param    string
+	bound    float64
+	isLength bool
+	options  []string
+	regexp   *regexp.Regexp
+}
+
+// ValidationFieldError describes a field that failed validation.
+type ValidationFieldError struct {
+	// Path is the location of the field within the validated value, e.g. address.city or tags[1].
+	// Fields are named by their json tag if one exists, then by their Bind tags, otherwise by their struct field name.
+	Path string `json:"path"`
+	// Rule is the validation rule that failed, e.g. required or max.
+	Rule string `json:"rule"`
+	// Message describes why the field is invalid.
+	Message string `json:"message"`
+}
+
+// Error returns the path and message for the field.
+func (err ValidationFieldError) Error() string {
+	if err.Path == "" {
+		return err.Message
+	}
+
+	return err.Path + ": " + err.Message
+}
+
+// ValidationError is returned from Validate when one or more fields are invalid.
+type ValidationError struct {
+	// Fields contains every field that failed validation.
+	Fields []ValidationFieldError
+}
+
+// Error returns a description of every field that failed validation.
+func (err *ValidationError) Error() string {
+	fields := make([]string, len(err.Fields))
+	for idx, field := range err.Fields {
+		fields[idx] = field.Error()
+	}
+
+	return "luci: validation: " + strings.Join(fields, "; ")
+}
+
+// StatusCode returns 400 Bad Request, the status to respond with for validation errors.
+func (err *ValidationError) StatusCode() int {
+	return http.StatusBadRequest
+}
+
+// Validate validates the given struct, or pointer to a struct, using the validate struct tag on its fields.
+// Rules in the tag are separated by commas. Rules other than required are skipped for nil pointers, and for
+// zero values if the tag includes omitempty. The supported rules are:
+//   - required: the value must not be the zero value
+//   - omitempty: other rules are skipped if the value is the zero value
+//   - min=N: numbers must be at least N, strings, slices, and maps must have a length of at least N
+//   - max=N: numbers must be at most N, strings, slices, and maps must have a length of at most N
+//   - len=N: strings, slices, and maps must have a length of exactly N
+//   - oneof=A B C: the value must be one of the space separated values
+//   - regex=EXPR: strings must match the regular expression, regex must be the last rule in the tag
+//   - email: strings must be a valid email address
+//   - url: strings must be an absolute URL
+//
+// Nested structs, pointers to structs, and slices of structs are validated as well. If any fields are
+// invalid a *ValidationError is returned describing every invalid field. Otherwise if the value implements
+// Validator, its Validate method is called and any error it returns is returned.
+//
+// The rules of each struct type are parsed once and cached. Validate panics the first time a struct type
+// is validated if a validate tag contains an unknown or malformed rule.
+func Validate(value any) error {
+	reflectValue := reflect.ValueOf(value)
+	for reflectValue.Kind() == reflect.Pointer && !reflectValue.IsNil() {
+		reflectValue = reflectValue.Elem()
+	}
+
+	var fields []ValidationFieldError
+
+	if reflectValue.Kind() == reflect.Struct {
+		fields = validateStruct(reflectValue, "", fields)
+	}
+
+	if len(fields) > 0 {
+		return &ValidationError{Fields: fields}
+	}
+
+	validator, ok := value.(Validator)
+	if !ok {
+		return nil
+	}
+
+	err := validator.Validate()
+	if err == nil {
+		return nil
+	}
+
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		return validationErr
+	}
+
+	return fmt.Errorf("luci: validate: %w", err)
+}
+
+func validateStruct(value reflect.Value, prefix string, fields []ValidationFieldError) []ValidationFieldError {
+	for _, field := range validateFieldsOf(value.Type()) {
+		fieldValue := value.Field(field.index)
+
+		if field.embedded {
+			fields = validateStruct(fieldValue, prefix, fields)
+			continue
+		}
+
+		path := joinPath(prefix, field.name)
+
+		fieldErr, ok := field.validate(fieldValue, path)
+		if !ok {
+			fields = append(fields, fieldErr)
+			continue
+		}
+
+		fields = validateNested(fieldValue, path, fields)
+	}
+
+	return fields
+}
+
+func validateNested(value reflect.Value, path string, fields []ValidationFieldError) []ValidationFieldError {
+	for value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return fields
+		}
+
+		value = value.Elem()
+	}
+
+	switch value.Kind() {
+	case reflect.Struct:
+		if value.Type() == timeType {
+			return fields
+		}
+
+		return validateStruct(value, path, fields)
+	case reflect.Slice, reflect.Array:
+		for idx := range value.Len() {
+			fields = validateNested(value.Index(idx), fmt.Sprintf("%s[%d]", path, idx), fields)
+		}
+	default:
+	}
+
+	return fields
+}
+
+func fieldName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name != "" && name != "-" {
+		return name
+	}
+
+	for _, tag := range bindTags {
+		name = field.Tag.Get(tag)
+		if name != "" && name != "-" {
+			return name
+		}
+	}
+
+	return field.Name
+}
+
+func joinPath(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+
+	return prefix + "." + name
+}
+
+// validateFieldsOf returns the parsed fields of the struct type, parsing and caching them on first use.
+func validateFieldsOf(structType reflect.Type) []validateField {
+	cached, ok := validateTypes.Load(structType)
+	if ok {
+		fields, _ := cached.([]validateField)
+		return fields
+	}
+
+	var fields []validateField
+
+	for idx := range structType.NumField() {
+		field := structType.Field(idx)
+
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			fields = append(fields, validateField{index: idx, embedded: true})
+			continue
+		}
+
+		if !field.IsExported() {
+			continue
+		}
+
+		parsed := validateField{index: idx, name: fieldName(field)}
+
+		tag := field.Tag.Get("validate")
+		if tag != "" && tag != "-" {
+			err := parsed.parseRules(tag, field.Type)
+			if err != nil {
+				panic(fmt.Errorf("luci: validate field %s of %s: %w", field.Name, structType, err))
+			}
+		}
+
+		fields = append(fields, parsed)
+	}
+
+	validateTypes.Store(structType, fields)
+
+	return fields
+}
+
+// parseRules parses the rules in the tag, checking each is supported for the fields type.
+func (field *validateField) parseRules(tag string, fieldType reflect.Type) error {
+	for fieldType.Kind() == reflect.Pointer {
+		fieldType = fieldType.Elem()
+	}
+
+	for tag != "" {
+		var rule string
+
+		if strings.HasPrefix(tag, "regex=") {
+			rule, tag = tag, ""
+		} else {
+			rule, tag, _ = strings.Cut(tag, ",")
+		}
+
+		name, param, _ := strings.Cut(rule, "=")
+		parsed := validateRule{name: name, param: param}
+
+		switch name {
+		case "required":
+		case "omitempty":
+			field.omitempty = true
+			continue
+		case "min", "max", "len":
+			bound, err := strconv.ParseFloat(param, 64)
+			if err != nil {
+				return fmt.Errorf(`rule "%s" must have a numeric parameter: %w`, name, err)
+			}
+
+			isLength := lengthKinds[fieldType.Kind()]
+			if !isLength && (!numericKinds[fieldType.Kind()] || name == "len") {
+				return fmt.Errorf(`rule "%s" is not supported for type %s`, name, fieldType)
+			}
+
+			parsed.bound = bound
+			parsed.isLength = isLength
+		case "oneof":
+			parsed.options = strings.Fields(param)
+		case "regex", "email", "url":
+			if fieldType.Kind() != reflect.String {
+				return fmt.Errorf(`rule "%s" is not supported for type %s`, name, fieldType)
+			}
+
+			if name == "regex" {
+				matcher, err := regexp.Compile(param)
+				if err != nil {
+					return fmt.Errorf(`rule "regex" must have valid regex: %w`, err)
+				}
+
+				parsed.regexp = matcher
+			}
+		default:
+			return fmt.Errorf(`unknown rule "%s"`, name)
+		}
+
+		field.rules = append(field.rules, parsed)
+	}
+
+	return nil
+}
+
+// validate applies the fields rules to the value, returning the first rule that fails.
+func (field validateField) validate(value reflect.Value, path string) (ValidationFieldError, bool) {
+	empty := isEmpty(value)
+
+	for value.Kind() == reflect.Pointer && !value.IsNil() {
+		value = value.Elem()
+	}
+
+	isNil := (value.Kind() == reflect.Pointer || value.Kind() == reflect.Interface) && value.IsNil()
+
+	for _, rule := range field.rules {
+		var message string
+
+		switch {
+		case rule.name == "required":
+			if empty {
+				message = "is required"
+			}
+		case isNil || (empty && field.omitempty):
+		default:
+			message = rule.validate(value)
+		}
+
+		if message != "" {
+			return ValidationFieldError{Path: path, Rule: rule.name, Message: message}, false
+		}
+	}
+
+	return ValidationFieldError{}, true
+}
+
+// validate applies the rule to the value, returning why the value is invalid.
+func (rule validateRule) validate(value reflect.Value) string {
+	switch rule.name {
+	case "min", "max", "len":
+		return rule.validateBound(value)
+	case "oneof":
+		actual := fmt.Sprint(value.Interface())
+
+		for _, option := range rule.options {
+			if option == actual {
+				return ""
+			}
+		}
+
+		return "must be one of " + strings.Join(rule.options, ", ")
+	case "regex":
+		if !rule.regexp.MatchString(value.String()) {
+			return "must match " + rule.param
+		}
+	case "email":
+		str := value.String()
+
+		address, err := mail.ParseAddress(str)
+		if err != nil || address.Address != str {
+			return "must be a valid email address"
+		}
+	case "url":
+		parsed, err := url.Parse(value.String())
+		if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+			return "must be a valid URL"
+		}
+	}
+
+	return ""
+}
+
+func (rule validateRule) validateBound(value reflect.Value) string {
+	var actual float64
+
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		actual = float64(value.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		actual = float64(value.Uint())
+	case reflect.Float32, reflect.Float64:
+		actual = value.Float()
+	case reflect.String:
+		actual = float64(utf8.RuneCountInString(value.String()))
+	default:
+		actual = float64(value.Len())
+	}
+
+	var (
+		failed      bool
+		description string
+	)
+
+	switch rule.name {
+	case "min":
+		failed, description = actual < rule.bound, "at least "+rule.param
+	case "max":
+		failed, description = actual > rule.bound, "at most "+rule.param
+	default:
+		failed, description = actual != rule.bound, rule.param
+	}
+
+	if !failed {
+		return ""
+	}
+
+	if rule.isLength {
+		return "must have a length of " + description
+	}
+
+	return "must be " + description
+}
+
+func isEmpty(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		return value.IsNil()
+	case reflect.Slice, reflect.Map, reflect.String:
+		return value.Len() == 0
+	default:
+		return value.IsZero()
+	}
+}
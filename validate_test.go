@@ -0,0 +1,193 @@
+package luci
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type validateAddress struct {
+	City string `json:"city" validate:"required"`
+}
+
+type validateUser struct {
+	Name     string            `json:"name"     validate:"required,min=2,max=8"`
+	Age      int               `json:"age"      validate:"min=18,max=130"`
+	Code     string            `json:"code"     validate:"len=3"`
+	Role     string            `json:"role"     validate:"oneof=admin user"`
+	Handle   string            `json:"handle"   validate:"regex=^[a-z]{1,3}$"`
+	Email    string            `json:"email"    validate:"email"`
+	Website  string            `json:"website"  validate:"url"`
+	Tags     []string          `json:"tags"     validate:"max=2"`
+	Address  *validateAddress  `json:"address"`
+	Previous []validateAddress `json:"previous"`
+	Nickname *string           `json:"nickname" validate:"required"`
+}
+
+type validateZero struct {
+	Age  int    `json:"age"  validate:"min=18"`
+	Role string `json:"role" validate:"oneof=admin user"`
+}
+
+type validateOptional struct {
+	Age  *int     `validate:"min=18"`
+	Role string   `validate:"omitempty,oneof=admin user"`
+	Tags []string `validate:"omitempty,min=1"`
+}
+
+type validateUnknown struct {
+	Value int `validate:"positive"`
+}
+
+type validateBadBound struct {
+	Value int `validate:"min=abc"`
+}
+
+type validateBadType struct {
+	Value int `validate:"email"`
+}
+
+type validateBadLen struct {
+	Value int `validate:"len=1"`
+}
+
+type validateSelf struct {
+	Name string `validate:"required"`
+}
+
+func (value validateSelf) Validate() error {
+	if value.Name == "error" {
+		return errors.New("name is reserved")
+	}
+
+	if value.Name == "field" {
+		return &ValidationError{Fields: []ValidationFieldError{{Path: "Name", Rule: "reserved", Message: "is reserved"}}}
+	}
+
+	return nil
+}
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	t.Run("returns nil for valid values", func(t *testing.T) {
+		t.Parallel()
+
+		nickname := "lu"
+		user := validateUser{
+			Name:     "luci",
+			Age:      30,
+			Code:     "abc",
+			Role:     "admin",
+			Handle:   "abc",
+			Email:    "luci@example.com",
+			Website:  "https://example.com",
+			Tags:     []string{"a", "b"},
+			Address:  &validateAddress{City: "Denver"},
+			Previous: []validateAddress{{City: "Boulder"}},
+			Nickname: &nickname,
+		}
+
+		assert.NoError(t, Validate(user))
+		assert.NoError(t, Validate(&user))
+	})
+
+	t.Run("applies rules to zero values", func(t *testing.T) {
+		t.Parallel()
+
+		err := Validate(validateZero{})
+		assert.EqualError(t, err, "luci: validation: age: must be at least 18; role: must be one of admin, user")
+	})
+
+	t.Run("skips rules for nil pointers and zero values with omitempty", func(t *testing.T) {
+		t.Parallel()
+
+		assert.NoError(t, Validate(validateOptional{}))
+
+		age := 12
+		err := Validate(validateOptional{Age: &age, Role: "owner"})
+		assert.EqualError(t, err, "luci: validation: Age: must be at least 18; Role: must be one of admin, user")
+	})
+
+	t.Run("returns validation error describing every invalid field", func(t *testing.T) {
+		t.Parallel()
+
+		user := validateUser{
+			Name:     "l",
+			Age:      12,
+			Code:     "abcd",
+			Role:     "owner",
+			Handle:   "ABC",
+			Email:    "Luci <luci@example.com>",
+			Website:  "/relative",
+			Tags:     []string{"a", "b", "c"},
+			Address:  &validateAddress{},
+			Previous: []validateAddress{{City: "Boulder"}, {}},
+		}
+
+		err := Validate(user)
+
+		var validationErr *ValidationError
+		assert.True(t, errors.As(err, &validationErr))
+		assert.Equal(t, http.StatusBadRequest, validationErr.StatusCode())
+		assert.Equal(t, []ValidationFieldError{
+			{Path: "name", Rule: "min", Message: "must have a length of at least 2"},
+			{Path: "age", Rule: "min", Message: "must be at least 18"},
+			{Path: "code", Rule: "len", Message: "must have a length of 3"},
+			{Path: "role", Rule: "oneof", Message: "must be one of admin, user"},
+			{Path: "handle", Rule: "regex", Message: "must match ^[a-z]{1,3}$"},
+			{Path: "email", Rule: "email", Message: "must be a valid email address"},
+			{Path: "website", Rule: "url", Message: "must be a valid URL"},
+			{Path: "tags", Rule: "max", Message: "must have a length of at most 2"},
+			{Path: "address.city", Rule: "required", Message: "is required"},
+			{Path: "previous[1].city", Rule: "required", Message: "is required"},
+			{Path: "nickname", Rule: "required", Message: "is required"},
+		}, validationErr.Fields)
+		assert.Equal(t, "luci: validation: name: must have a length of at least 2; age: must be at least 18; "+
+			"code: must have a length of 3; role: must be one of admin, user; handle: must match ^[a-z]{1,3}$; "+
+			"email: must be a valid email address; website: must be a valid URL; tags: must have a length of at most 2; "+
+			"address.city: is required; previous[1].city: is required; nickname: is required", err.Error())
+	})
+
+	t.Run("calls Validate if fields are valid", func(t *testing.T) {
+		t.Parallel()
+
+		assert.NoError(t, Validate(validateSelf{Name: "luci"}))
+		assert.EqualError(t, Validate(validateSelf{Name: "error"}), "luci: validate: name is reserved")
+
+		err := Validate(validateSelf{Name: "field"})
+
+		var validationErr *ValidationError
+		assert.True(t, errors.As(err, &validationErr))
+		assert.EqualError(t, err, "luci: validation: Name: is reserved")
+
+		err = Validate(validateSelf{})
+		assert.EqualError(t, err, "luci: validation: Name: is required")
+	})
+
+	t.Run("panics if rule is unknown or malformed", func(t *testing.T) {
+		t.Parallel()
+
+		assert.PanicsWithError(t, `luci: validate field Value of luci.validateUnknown: unknown rule "positive"`, func() {
+			_ = Validate(validateUnknown{Value: 1})
+		})
+
+		assert.PanicsWithError(t, `luci: validate field Value of luci.validateUnknown: unknown rule "positive"`, func() {
+			_ = Validate(validateUnknown{Value: 1})
+		})
+
+		assert.Panics(t, func() {
+			_ = Validate(validateBadBound{})
+		})
+
+		assert.PanicsWithError(t, `luci: validate field Value of luci.validateBadType: rule "email" is not supported for type int`, func() {
+			_ = Validate(validateBadType{})
+		})
+
+		assert.PanicsWithError(t, `luci: validate field Value of luci.validateBadLen: rule "len" is not supported for type int`, func() {
+			_ = Validate(validateBadLen{})
+		})
+	})
+}
@@ -1,18 +1,66 @@
 package luci
 
 import (
-	"context"
+	"fmt"
 	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
 
-	"github.com/go-chi/chi/v5"
+	"github.com/oklog/ulid/v2"
 )
 
-type varsKey struct{}
+var (
+	// DefaultVarConverters are the variable converters that are used when creating a server.
+	DefaultVarConverters = map[string]VarConverter{
+		"int": {
+			Pattern: "-?[0-9]+",
+			Convert: func(value string) (any, error) {
+				return strconv.Atoi(value)
+			},
+		},
+		"uint": {
+			Pattern: "[0-9]+",
+			Convert: func(value string) (any, error) {
+				parsed, err := strconv.ParseUint(value, 10, 0)
+				return uint(parsed), err
+			},
+		},
+		"ulid": {
+			Pattern: "[0-9A-HJKMNP-TV-Za-hjkmnp-tv-z]{26}",
+			Convert: func(value string) (any, error) {
+				return ulid.ParseStrict(value)
+			},
+		},
+		"uuid": {
+			Pattern: "[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}",
+			Convert: func(value string) (any, error) {
+				return ParseUUID(value)
+			},
+		},
+	}
+)
+
+// VarConverter defines how a route variable is matched and converted when a route's pattern
+// uses the converter's name in place of a regex, e.g. {id:int}.
+type VarConverter struct {
+	// Pattern may be optionally used to define the regex a variable's value must match for the
+	// route to match. Requests whose value doesn't match are responded to as if no route matched.
+	Pattern string
+	// Convert may be optionally used to convert a variable's value once the route has matched, the
+	// converted value is returned by VarAs. If Convert fails the request is responded to with the
+	// applications Error using a 400 Bad Request *HTTPError, unless the error is an *HTTPError.
+	Convert func(value string) (any, error)
+}
 
 // Vars returns the request variables that are defined by the associated routes pattern.
 func Vars(req *http.Request) map[string]string {
-	vars, _ := req.Context().Value(varsKey{}).(map[string]string)
-	return vars
+	state := requestStateFrom(req)
+	if state == nil {
+		return nil
+	}
+
+	return state.requestVars()
 }
 
 // Var returns the request variable with the given key that is defined by the associated routes pattern.
@@ -20,16 +68,129 @@ func Var(req *http.Request, key string) string {
 	return Vars(req)[key]
 }
 
-func withVars(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
-		urlParams := chi.RouteContext(req.Context()).URLParams
+// VarAs returns the request variable with the given key as type T. If the variable was converted by
+// a VarConverter whose value is a T the converted value is returned, otherwise the variable is parsed
+// the same way as Bind parses path fields. If the variable doesn't exist a 500 Internal Server Error
+// *HTTPError is returned, and if it can't be parsed a 400 Bad Request *HTTPError is returned.
+func VarAs[T any](req *http.Request, key string) (T, error) {
+	var value T
+
+	var values map[string]any
+
+	state := requestStateFrom(req)
+	if state != nil {
+		values = state.values
+	}
+
+	converted, ok := values[key].(T)
+	if ok {
+		return converted, nil
+	}
+
+	raw, ok := Vars(req)[key]
+	if !ok {
+		return value, InternalServerError(fmt.Errorf(`luci: variable "%s" doesn't exist`, key))
+	}
+
+	err := setValue(reflect.ValueOf(&value).Elem(), raw)
+	if err != nil {
+		return value, BadRequest(fmt.Errorf(`luci: variable "%s": %w`, key, err))
+	}
+
+	return value, nil
+}
+
+// VarInt returns the request variable with the given key as an int, see VarAs for details.
+func VarInt(req *http.Request, key string) (int, error) {
+	return VarAs[int](req, key)
+}
+
+// VarUUID returns the request variable with the given key as a UUID, see VarAs for details.
+func VarUUID(req *http.Request, key string) (UUID, error) {
+	return VarAs[UUID](req, key)
+}
+
+// VarULID returns the request variable with the given key as a ULID, see VarAs for details.
+func VarULID(req *http.Request, key string) (ulid.ULID, error) {
+	return VarAs[ulid.ULID](req, key)
+}
+
+// withVarConverters converts the request variables using the converters for each variable name.
+func withVarConverters(errorHandler ErrorHandlerFunc, converters map[string]VarConverter) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			vars := Vars(req)
+			values := make(map[string]any, len(converters))
+
+			for name, converter := range converters {
+				if converter.Convert == nil {
+					continue
+				}
+
+				value, err := converter.Convert(vars[name])
+				if err != nil {
+					httpErr := AsHTTPError(fmt.Errorf(`luci: variable "%s": %w`, name, err), http.StatusBadRequest)
+					errorHandler(rw, req, httpErr.Status, httpErr)
 
-		vars := make(map[string]string, len(urlParams.Keys))
-		for idx, key := range urlParams.Keys {
-			vars[key] = urlParams.Values[idx]
+					return
+				}
+
+				values[name] = value
+			}
+
+			requestStateFrom(req).values = values
+			next.ServeHTTP(rw, req)
+		})
+	}
+}
+
+// convertPattern replaces the converter names used by the patterns variables with the converters
+// pattern, and returns the converters for each variable name.
+func convertPattern(pattern string, converters map[string]VarConverter) (string, map[string]VarConverter) {
+	var (
+		builder   strings.Builder
+		converted map[string]VarConverter
+	)
+
+	for pattern != "" {
+		start := strings.IndexByte(pattern, '{')
+		if start == -1 {
+			break
 		}
 
-		newReq := req.WithContext(context.WithValue(req.Context(), varsKey{}, vars))
-		next.ServeHTTP(rw, newReq)
-	})
+		end := patternVarEnd(pattern[start:])
+		if end == -1 {
+			break
+		}
+
+		end += start
+
+		name, converterName, _ := strings.Cut(pattern[start+1:end], ":")
+
+		converter, ok := converters[converterName]
+		if !ok {
+			_, _ = builder.WriteString(pattern[:end+1])
+			pattern = pattern[end+1:]
+
+			continue
+		}
+
+		if converted == nil {
+			converted = make(map[string]VarConverter)
+		}
+
+		converted[name] = converter
+
+		_, _ = builder.WriteString(pattern[:start+1] + name)
+		if converter.Pattern != "" {
+			_, _ = builder.WriteString(":" + converter.Pattern)
+		}
+
+		_ = builder.WriteByte('}')
+		pattern = pattern[end+1:]
+	}
+
+	_, _ = builder.WriteString(pattern)
+
+	return builder.String(), converted
 }
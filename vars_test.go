@@ -1,12 +1,15 @@
 package luci
 
 import (
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"testing"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 )
 
 func TestVars(t *testing.T) {
@@ -18,7 +21,7 @@ func TestVars(t *testing.T) {
 
 	middlewares := Middlewares{
 		WithValue(chi.RouteCtxKey, &ctx),
-		withVars,
+		withRequestState,
 	}
 
 	handler := middlewares.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
@@ -39,7 +42,7 @@ func TestVar(t *testing.T) {
 
 	middlewares := Middlewares{
 		WithValue(chi.RouteCtxKey, &ctx),
-		withVars,
+		withRequestState,
 	}
 
 	handler := middlewares.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
@@ -49,3 +52,122 @@ func TestVar(t *testing.T) {
 
 	handler.ServeHTTP(nil, httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/status", nil))
 }
+
+func TestVarAs(t *testing.T) {
+	t.Parallel()
+
+	var ctx chi.Context
+	ctx.URLParams.Add("id", "123")
+	ctx.URLParams.Add("uuid", "6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+	ctx.URLParams.Add("ulid", "01ARZ3NDEKTSV4RRFFQ69G5FAV")
+	ctx.URLParams.Add("name", "luci")
+
+	middlewares := Middlewares{
+		WithValue(chi.RouteCtxKey, &ctx),
+		withRequestState,
+		func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				requestStateFrom(req).values = map[string]any{"id": 456}
+				next.ServeHTTP(rw, req)
+			})
+		},
+	}
+
+	handler := middlewares.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
+		id, err := VarInt(req, "id")
+		assert.NoError(t, err)
+		assert.Equal(t, 456, id, "converted values should be used")
+
+		id64, err := VarAs[int64](req, "id")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(123), id64)
+
+		uuid, err := VarUUID(req, "uuid")
+		assert.NoError(t, err)
+		assert.Equal(t, "6ba7b810-9dad-11d1-80b4-00c04fd430c8", uuid.String())
+
+		ulid, err := VarULID(req, "ulid")
+		assert.NoError(t, err)
+		assert.Equal(t, "01ARZ3NDEKTSV4RRFFQ69G5FAV", ulid.String())
+
+		_, err = VarInt(req, "name")
+		assert.Equal(t, http.StatusBadRequest, AsHTTPError(err, 0).Status)
+		assert.ErrorContains(t, err, `luci: variable "name": invalid integer`)
+
+		_, err = VarInt(req, "nonexistent_key")
+		assert.Equal(t, InternalServerError(errors.New(`luci: variable "nonexistent_key" doesn't exist`)), err)
+	})
+
+	handler.ServeHTTP(nil, httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/status", nil))
+}
+
+func TestWithVarConverters(t *testing.T) {
+	t.Parallel()
+
+	converters := map[string]VarConverter{
+		"id":   DefaultVarConverters["int"],
+		"slug": {Pattern: "[a-z-]+"},
+		"key": {Convert: func(value string) (any, error) {
+			if value == "missing" {
+				return nil, NotFound(errors.New("missing key"))
+			}
+
+			return value, nil
+		}},
+	}
+
+	request := func(id, key string, status int) *httptest.ResponseRecorder {
+		var errorMock mock.Mock
+		if status != 0 {
+			errorMock.On("Error", status, mock.Anything).Once()
+		}
+
+		var ctx chi.Context
+		ctx.URLParams.Add("id", id)
+		ctx.URLParams.Add("slug", "a-slug")
+		ctx.URLParams.Add("key", key)
+
+		errorHandler := func(_ http.ResponseWriter, _ *http.Request, status int, err error) {
+			errorMock.MethodCalled("Error", status, err)
+		}
+
+		middlewares := Middlewares{
+			WithValue(chi.RouteCtxKey, &ctx),
+			withRequestState,
+			withVarConverters(errorHandler, converters),
+		}
+
+		recorder := httptest.NewRecorder()
+		handler := middlewares.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			id, err := VarInt(req, "id")
+			assert.NoError(t, err)
+
+			_, _ = rw.Write([]byte(strconv.Itoa(id) + " " + Var(req, "slug")))
+		})
+
+		handler.ServeHTTP(recorder, httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/status", nil))
+
+		errorMock.AssertExpectations(t)
+
+		return recorder
+	}
+
+	assert.Equal(t, "123 a-slug", request("123", "abc", 0).Body.String())
+	assert.Empty(t, request("99999999999999999999", "abc", http.StatusBadRequest).Body.String())
+	assert.Empty(t, request("123", "missing", http.StatusNotFound).Body.String())
+}
+
+func TestConvertPattern(t *testing.T) {
+	t.Parallel()
+
+	pattern, converters := convertPattern("/user/{id:int}/key/{key:[a-z]{3}}/{slug:slug}/*", map[string]VarConverter{
+		"int":  {Pattern: "[0-9]+"},
+		"slug": {},
+	})
+	assert.Equal(t, "/user/{id:[0-9]+}/key/{key:[a-z]{3}}/{slug}/*", pattern)
+	assert.Equal(t, map[string]VarConverter{"id": {Pattern: "[0-9]+"}, "slug": {}}, converters)
+
+	pattern, converters = convertPattern("/user/{id}", DefaultVarConverters)
+	assert.Equal(t, "/user/{id}", pattern)
+	assert.Nil(t, converters)
+}
@@ -0,0 +1,641 @@
+package luci
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1" //nolint:gosec // SHA-1 is required by the WebSocket handshake.
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// WebSocket close codes as defined by RFC 6455.
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	CloseNoStatus        = 1005
+	CloseAbnormal        = 1006
+	CloseInvalidPayload  = 1007
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+	CloseInternalError   = 1011
+)
+
+const (
+	// DefaultWebSocketMaxMessageSize is the maximum message size used when a WebSocket doesn't set one.
+	DefaultWebSocketMaxMessageSize = 1 << 20
+
+	websocketGUID    = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	websocketVersion = "13"
+	websocketTimeout = 5 * time.Second
+)
+
+// MessageType is the type of a WebSocket data message.
+type MessageType int
+
+// WebSocket message types.
+const (
+	TextMessage   MessageType = 1
+	BinaryMessage MessageType = 2
+)
+
+// String returns the message type's name.
+func (messageType MessageType) String() string {
+	switch messageType {
+	case TextMessage:
+		return "text"
+	case BinaryMessage:
+		return "binary"
+	default:
+		return "unknown(" + strconv.Itoa(int(messageType)) + ")"
+	}
+}
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// WebSocketHandlerFunc handles an upgraded WebSocket connection. The request context is canceled
+// once the connection is closed, and the connection is closed once the handler returns.
+type WebSocketHandlerFunc func(conn *WebSocketConn, req *http.Request)
+
+// WebSocket defines a WebSocket route, see Route.WebSocket.
+type WebSocket struct {
+	// Subprotocols are the subprotocols the WebSocket supports in order of preference.
+	// The first supported subprotocol the client requests is selected.
+	Subprotocols []string
+	// CheckOrigin may be optionally used to check the requests Origin header, upgrades are
+	// responded to with 403 Forbidden if it returns false. If not set requests with an
+	// Origin header must have the same host as the request.
+	CheckOrigin func(req *http.Request) bool
+	// MaxMessageSize is the maximum size of a received message, connections receiving larger messages
+	// are closed with CloseMessageTooBig. If not set DefaultWebSocketMaxMessageSize is used.
+	MaxMessageSize int64
+	// PingInterval may be optionally used to send pings at the given interval, connections that
+	// don't receive any frames within twice the interval are closed.
+	PingInterval time.Duration
+	// HandlerFunc defines the handler function to call once the connection is upgraded.
+	HandlerFunc WebSocketHandlerFunc
+}
+
+// WebSocketConn is an upgraded WebSocket connection. Reads must not be done concurrently,
+// but writes may be done concurrently with each other and with reads.
+type WebSocketConn struct {
+	conn           net.Conn
+	reader         *bufio.Reader
+	writer         *bufio.Writer
+	subprotocol    string
+	maxMessageSize int64
+	pingInterval   time.Duration
+	cancel         context.CancelFunc
+	writeMu        sync.Mutex
+	closeErr       *CloseError
+	closeOnce      sync.Once
+}
+
+// CloseError is returned when a WebSocket connection is closed, either by a close frame from the
+// client or by the server failing the connection.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+// Error returns the close code and reason.
+func (err *CloseError) Error() string {
+	if err.Reason == "" {
+		return fmt.Sprintf("luci: websocket closed (%d)", err.Code)
+	}
+
+	return fmt.Sprintf("luci: websocket closed (%d): %s", err.Code, err.Reason)
+}
+
+// Subprotocol returns the negotiated subprotocol, or an empty string if none was negotiated.
+func (conn *WebSocketConn) Subprotocol() string {
+	return conn.subprotocol
+}
+
+// ReadMessage reads the next data message, pings are responded to and pongs are discarded.
+// If the client closes the connection or sends an invalid message the connection is closed
+// and a *CloseError is returned.
+func (conn *WebSocketConn) ReadMessage() (MessageType, []byte, error) {
+	var (
+		messageType MessageType
+		message     []byte
+	)
+
+	for {
+		fin, opcode, payload, err := conn.readFrame(int64(len(message)))
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch opcode {
+		case opPing:
+			err = conn.writeFrame(opPong, payload)
+			if err != nil {
+				return 0, nil, err
+			}
+
+			continue
+		case opPong:
+			continue
+		case opClose:
+			return 0, nil, conn.receiveClose(payload)
+		case opText, opBinary:
+			if messageType != 0 {
+				return 0, nil, conn.fail(CloseProtocolError, "expected continuation frame")
+			}
+
+			messageType = MessageType(opcode)
+		case opContinuation:
+			if messageType == 0 {
+				return 0, nil, conn.fail(CloseProtocolError, "unexpected continuation frame")
+			}
+		}
+
+		message = append(message, payload...)
+
+		if !fin {
+			continue
+		}
+
+		if messageType == TextMessage && !utf8.Valid(message) {
+			return 0, nil, conn.fail(CloseInvalidPayload, "invalid utf-8")
+		}
+
+		return messageType, message, nil
+	}
+}
+
+// WriteMessage writes a data message to the connection.
+func (conn *WebSocketConn) WriteMessage(messageType MessageType, data []byte) error {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return fmt.Errorf("luci: websocket write: invalid message type %d", messageType)
+	}
+
+	return conn.writeFrame(byte(messageType), data)
+}
+
+// Ping writes a ping to the connection, the client responds with a pong containing the same data.
+func (conn *WebSocketConn) Ping(data []byte) error {
+	return conn.writeFrame(opPing, data)
+}
+
+// Close sends a close frame with the given code and reason, then closes the connection.
+func (conn *WebSocketConn) Close(code int, reason string) error {
+	var err error
+
+	conn.closeOnce.Do(func() {
+		err = conn.writeClose(code, reason)
+		if errors.Is(err, net.ErrClosed) {
+			err = nil
+		}
+
+		closeErr := conn.close()
+		if err == nil {
+			err = closeErr
+		}
+	})
+
+	return err
+}
+
+func (conn *WebSocketConn) readFrame(messageLength int64) (bool, byte, []byte, error) {
+	if conn.pingInterval > 0 {
+		err := conn.conn.SetReadDeadline(time.Now().Add(conn.pingInterval * 2))
+		if err != nil {
+			return false, 0, nil, conn.readError(err)
+		}
+	}
+
+	var header [2]byte
+
+	_, err := io.ReadFull(conn.reader, header[:])
+	if err != nil {
+		return false, 0, nil, conn.readError(err)
+	}
+
+	fin := header[0]&0x80 != 0
+	opcode := header[0] & 0x0f
+	masked := header[1]&0x80 != 0
+	length := int64(header[1] & 0x7f)
+
+	if header[0]&0x70 != 0 {
+		return false, 0, nil, conn.fail(CloseProtocolError, "reserved bits must not be set")
+	}
+
+	if !masked {
+		return false, 0, nil, conn.fail(CloseProtocolError, "client frames must be masked")
+	}
+
+	switch opcode {
+	case opContinuation, opText, opBinary:
+	case opClose, opPing, opPong:
+		if !fin || length > 125 {
+			return false, 0, nil, conn.fail(CloseProtocolError, "invalid control frame")
+		}
+	default:
+		return false, 0, nil, conn.fail(CloseProtocolError, "unknown opcode")
+	}
+
+	switch length {
+	case 126:
+		var extended [2]byte
+
+		_, err = io.ReadFull(conn.reader, extended[:])
+		length = int64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+
+		_, err = io.ReadFull(conn.reader, extended[:])
+
+		extendedLength := binary.BigEndian.Uint64(extended[:])
+		if extendedLength > 1<<63-1 {
+			return false, 0, nil, conn.fail(CloseProtocolError, "invalid frame length")
+		}
+
+		length = int64(extendedLength)
+	}
+
+	if err != nil {
+		return false, 0, nil, conn.readError(err)
+	}
+
+	if opcode < opClose && length > conn.maxMessageSize-messageLength {
+		return false, 0, nil, conn.fail(CloseMessageTooBig, "message too big")
+	}
+
+	var mask [4]byte
+
+	_, err = io.ReadFull(conn.reader, mask[:])
+	if err != nil {
+		return false, 0, nil, conn.readError(err)
+	}
+
+	payload := make([]byte, length)
+
+	_, err = io.ReadFull(conn.reader, payload)
+	if err != nil {
+		return false, 0, nil, conn.readError(err)
+	}
+
+	for idx := range payload {
+		payload[idx] ^= mask[idx%4]
+	}
+
+	return fin, opcode, payload, nil
+}
+
+func (conn *WebSocketConn) writeFrame(opcode byte, payload []byte) error {
+	conn.writeMu.Lock()
+	defer conn.writeMu.Unlock()
+
+	if conn.closeErr != nil {
+		return fmt.Errorf("luci: websocket write: %w", net.ErrClosed)
+	}
+
+	return conn.lockedWriteFrame(opcode, payload)
+}
+
+func (conn *WebSocketConn) lockedWriteFrame(opcode byte, payload []byte) error {
+	header := []byte{0x80 | opcode}
+	length := len(payload)
+
+	switch {
+	case length <= 125:
+		header = append(header, byte(length))
+	case length <= 0xffff:
+		header = append(header, 126)
+		header = binary.BigEndian.AppendUint16(header, uint16(length))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(length))
+	}
+
+	err := conn.conn.SetWriteDeadline(time.Now().Add(websocketTimeout))
+	if err == nil {
+		_, err = conn.writer.Write(header)
+	}
+
+	if err == nil {
+		_, err = conn.writer.Write(payload)
+	}
+
+	if err == nil {
+		err = conn.writer.Flush()
+	}
+
+	if err != nil {
+		return fmt.Errorf("luci: websocket write: %w", err)
+	}
+
+	return nil
+}
+
+func (conn *WebSocketConn) writeClose(code int, reason string) error {
+	conn.writeMu.Lock()
+	defer conn.writeMu.Unlock()
+
+	if conn.closeErr != nil {
+		return nil
+	}
+
+	conn.closeErr = &CloseError{Code: code, Reason: reason}
+
+	var payload []byte
+	if code != CloseNoStatus {
+		payload = binary.BigEndian.AppendUint16(nil, uint16(code)) //nolint:gosec // Close codes are validated.
+		payload = append(payload, reason...)
+	}
+
+	if len(payload) > 125 {
+		payload = payload[:125]
+	}
+
+	return conn.lockedWriteFrame(opClose, payload)
+}
+
+// receiveClose responds to a close frame from the client and closes the connection.
+func (conn *WebSocketConn) receiveClose(payload []byte) error {
+	closeErr := &CloseError{Code: CloseNoStatus}
+
+	switch {
+	case len(payload) == 1:
+		return conn.fail(CloseProtocolError, "invalid close frame")
+	case len(payload) >= 2:
+		closeErr.Code = int(binary.BigEndian.Uint16(payload))
+		closeErr.Reason = string(payload[2:])
+
+		if !validCloseCode(closeErr.Code) {
+			return conn.fail(CloseProtocolError, "invalid close code")
+		}
+
+		if !utf8.ValidString(closeErr.Reason) {
+			return conn.fail(CloseInvalidPayload, "invalid utf-8")
+		}
+	}
+
+	code := closeErr.Code
+	if code == CloseNoStatus {
+		code = CloseNormal
+	}
+
+	_ = conn.Close(code, "")
+
+	return closeErr
+}
+
+// fail closes the connection with the given close code because of an invalid frame.
+func (conn *WebSocketConn) fail(code int, reason string) error {
+	_ = conn.Close(code, reason)
+
+	return &CloseError{Code: code, Reason: reason}
+}
+
+// readError closes the connection because of a read error. If the connection was closed by
+// the server the close is returned instead, since closing causes reads to fail.
+func (conn *WebSocketConn) readError(err error) error {
+	_ = conn.close()
+
+	conn.writeMu.Lock()
+	defer conn.writeMu.Unlock()
+
+	if conn.closeErr != nil {
+		return conn.closeErr
+	}
+
+	return fmt.Errorf("luci: websocket read: %w", err)
+}
+
+func (conn *WebSocketConn) close() error {
+	conn.cancel()
+
+	err := conn.conn.Close()
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		return fmt.Errorf("luci: websocket close: %w", err)
+	}
+
+	return nil
+}
+
+func (conn *WebSocketConn) pingLoop(ctx context.Context) {
+	ticker := time.NewTicker(conn.pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := conn.Ping(nil)
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+func websocketHandler(errorHandler ErrorHandlerFunc, tracker *inFlight, websocket WebSocket) http.HandlerFunc {
+	maxMessageSize := websocket.MaxMessageSize
+	if maxMessageSize <= 0 {
+		maxMessageSize = DefaultWebSocketMaxMessageSize
+	}
+
+	checkOrigin := websocket.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+
+	return func(rw http.ResponseWriter, req *http.Request) {
+		if !headerContainsToken(req.Header, "Connection", "upgrade") || !headerContainsToken(req.Header, "Upgrade", "websocket") {
+			errorHandler(rw, req, http.StatusUpgradeRequired, NewHTTPError(http.StatusUpgradeRequired, nil).
+				WithMessage("websocket upgrade required").
+				WithHeader("Connection", "Upgrade").
+				WithHeader("Upgrade", "websocket"))
+
+			return
+		}
+
+		if req.Header.Get("Sec-WebSocket-Version") != websocketVersion {
+			errorHandler(rw, req, http.StatusUpgradeRequired, NewHTTPError(http.StatusUpgradeRequired, nil).
+				WithMessage("unsupported websocket version").
+				WithHeader("Sec-WebSocket-Version", websocketVersion))
+
+			return
+		}
+
+		key := req.Header.Get("Sec-WebSocket-Key")
+
+		decoded, err := base64.StdEncoding.DecodeString(key)
+		if err != nil || len(decoded) != 16 {
+			errorHandler(rw, req, http.StatusBadRequest, BadRequest(nil).WithMessage("invalid websocket key"))
+			return
+		}
+
+		if !checkOrigin(req) {
+			errorHandler(rw, req, http.StatusForbidden, Forbidden(nil).WithMessage("websocket origin not allowed"))
+			return
+		}
+
+		subprotocol := selectSubprotocol(websocket.Subprotocols, req.Header)
+
+		netConn, buf, err := http.NewResponseController(rw).Hijack()
+		if err != nil {
+			errorHandler(rw, req, http.StatusInternalServerError, InternalServerError(err))
+			return
+		}
+
+		ctx, cancel := context.WithCancel(req.Context())
+		defer cancel()
+
+		conn := &WebSocketConn{
+			conn:           netConn,
+			reader:         buf.Reader,
+			writer:         buf.Writer,
+			subprotocol:    subprotocol,
+			maxMessageSize: maxMessageSize,
+			pingInterval:   websocket.PingInterval,
+			cancel:         cancel,
+		}
+
+		err = writeHandshake(conn, rw.Header(), key)
+		if err != nil {
+			_ = conn.close()
+
+			Logger(req).With(slog.Any("error", err)).Error("unable to write websocket handshake")
+
+			return
+		}
+
+		resWriter := findResponseWriter(rw)
+		if resWriter != nil {
+			resWriter.hijackedStatus(http.StatusSwitchingProtocols)
+		}
+
+		untrack := tracker.track(func() {
+			_ = conn.Close(CloseGoingAway, "server shutting down")
+		})
+		defer untrack()
+
+		if conn.pingInterval > 0 {
+			go conn.pingLoop(ctx)
+		}
+
+		defer func() {
+			_ = conn.Close(CloseNormal, "")
+		}()
+
+		websocket.HandlerFunc(conn, req.WithContext(ctx))
+	}
+}
+
+func writeHandshake(conn *WebSocketConn, header http.Header, key string) error {
+	//nolint:gosec // SHA-1 is required by the WebSocket handshake.
+	accept := sha1.Sum([]byte(key + websocketGUID))
+
+	header = header.Clone()
+	header.Set("Upgrade", "websocket")
+	header.Set("Connection", "Upgrade")
+	header.Set("Sec-WebSocket-Accept", base64.StdEncoding.EncodeToString(accept[:]))
+
+	if conn.subprotocol != "" {
+		header.Set("Sec-WebSocket-Protocol", conn.subprotocol)
+	}
+
+	err := conn.conn.SetWriteDeadline(time.Now().Add(websocketTimeout))
+	if err == nil {
+		_, err = conn.writer.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	}
+
+	if err == nil {
+		err = header.Write(conn.writer)
+	}
+
+	if err == nil {
+		_, err = conn.writer.WriteString("\r\n")
+	}
+
+	if err == nil {
+		err = conn.writer.Flush()
+	}
+
+	if err != nil {
+		return fmt.Errorf("luci: websocket handshake: %w", err)
+	}
+
+	return nil
+}
+
+func selectSubprotocol(subprotocols []string, header http.Header) string {
+	var requested []string
+
+	for _, value := range header.Values("Sec-WebSocket-Protocol") {
+		for protocol := range strings.SplitSeq(value, ",") {
+			requested = append(requested, strings.TrimSpace(protocol))
+		}
+	}
+
+	for _, subprotocol := range subprotocols {
+		if slices.Contains(requested, subprotocol) {
+			return subprotocol
+		}
+	}
+
+	return ""
+}
+
+func sameOrigin(req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	parsed, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	return strings.EqualFold(parsed.Host, req.Host)
+}
+
+func headerContainsToken(header http.Header, key, token string) bool {
+	for _, value := range header.Values(key) {
+		for field := range strings.SplitSeq(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(field), token) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 3000 && code <= 4999:
+		return true
+	case code < 1000 || code > 1014:
+		return false
+	default:
+		return code != 1004 && code != CloseNoStatus && code != CloseAbnormal
+	}
+}
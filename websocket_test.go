@@ -0,0 +1,487 @@
+package luci
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testWebSocketClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dialWebSocket(t *testing.T, addr string, header http.Header) (*testWebSocketClient, *http.Response) {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	assert.NoError(t, err)
+
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, "http://"+addr+"/ws", nil)
+	assert.NoError(t, err)
+
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	assert.NoError(t, req.Write(conn))
+
+	reader := bufio.NewReader(conn)
+
+	res, err := http.ReadResponse(reader, req)
+	assert.NoError(t, err)
+
+	return &testWebSocketClient{conn: conn, reader: reader}, res
+}
+
+func (client *testWebSocketClient) writeFrame(t *testing.T, fin bool, opcode byte, payload []byte, masked bool) {
+	t.Helper()
+
+	first := opcode
+	if fin {
+		first |= 0x80
+	}
+
+	frame := []byte{first}
+	maskBit := byte(0)
+
+	if masked {
+		maskBit = 0x80
+	}
+
+	switch {
+	case len(payload) <= 125:
+		frame = append(frame, maskBit|byte(len(payload)))
+	case len(payload) <= 0xffff:
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+
+	data := append([]byte(nil), payload...)
+
+	if masked {
+		mask := []byte{1, 2, 3, 4}
+		frame = append(frame, mask...)
+
+		for idx := range data {
+			data[idx] ^= mask[idx%4]
+		}
+	}
+
+	_, err := client.conn.Write(append(frame, data...))
+	assert.NoError(t, err)
+}
+
+func (client *testWebSocketClient) readFrame(t *testing.T) (byte, []byte) {
+	t.Helper()
+
+	assert.NoError(t, client.conn.SetReadDeadline(time.Now().Add(time.Second)))
+
+	header := make([]byte, 2)
+	_, err := io.ReadFull(client.reader, header)
+	assert.NoError(t, err)
+
+	length := int(header[1] & 0x7f)
+
+	switch length {
+	case 126:
+		extended := make([]byte, 2)
+		_, err = io.ReadFull(client.reader, extended)
+		assert.NoError(t, err)
+
+		length = int(binary.BigEndian.Uint16(extended))
+	case 127:
+		assert.Fail(t, "unexpected frame length")
+	}
+
+	payload := make([]byte, length)
+	_, err = io.ReadFull(client.reader, payload)
+	assert.NoError(t, err)
+
+	return header[0] & 0x0f, payload
+}
+
+func (client *testWebSocketClient) readClose(t *testing.T) (int, string) {
+	t.Helper()
+
+	opcode, payload := client.readFrame(t)
+	assert.Equal(t, byte(opClose), opcode)
+
+	if len(payload) < 2 {
+		return CloseNoStatus, ""
+	}
+
+	return int(binary.BigEndian.Uint16(payload)), string(payload[2:])
+}
+
+func websocketServer(t *testing.T, websocket *WebSocket) (*Server, string) {
+	t.Helper()
+
+	var app TestApplication
+	app.On("Middlewares").Return(nil)
+	app.On("Routes").Return([]Route{
+		{
+			Name:      "ws",
+			Pattern:   "/ws",
+			WebSocket: websocket,
+		},
+	})
+
+	server := NewServer(testConfig, &app)
+
+	httpServer := httptest.NewServer(server.server.Handler)
+	t.Cleanup(httpServer.Close)
+
+	return server, strings.TrimPrefix(httpServer.URL, "http://")
+}
+
+func echoWebSocket(conn *WebSocketConn, _ *http.Request) {
+	for {
+		messageType, message, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		err = conn.WriteMessage(messageType, message)
+		if err != nil {
+			return
+		}
+	}
+}
+
+func TestWebSocket(t *testing.T) {
+	t.Parallel()
+
+	t.Run("upgrades and echos messages", func(t *testing.T) {
+		t.Parallel()
+
+		connected := make(chan *http.Request, 1)
+
+		server, addr := websocketServer(t, &WebSocket{
+			Subprotocols: []string{"chat.v2", "chat.v1"},
+			HandlerFunc: func(conn *WebSocketConn, req *http.Request) {
+				assert.Equal(t, "chat.v1", conn.Subprotocol())
+
+				connected <- req
+
+				echoWebSocket(conn, req)
+			},
+		})
+
+		client, res := dialWebSocket(t, addr, http.Header{"Sec-Websocket-Protocol": []string{"chat.v0, chat.v1"}})
+		assert.Equal(t, http.StatusSwitchingProtocols, res.StatusCode)
+		assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", res.Header.Get("Sec-WebSocket-Accept"))
+		assert.Equal(t, "chat.v1", res.Header.Get("Sec-WebSocket-Protocol"))
+		assert.NotEmpty(t, res.Header.Get("X-Request-Id"))
+
+		req := <-connected
+		assert.Equal(t, res.Header.Get("X-Request-Id"), ID(req))
+		assert.NotNil(t, Logger(req))
+		assert.Equal(t, int64(1), server.InFlight())
+
+		client.writeFrame(t, true, opText, []byte("hello"), true)
+		opcode, payload := client.readFrame(t)
+		assert.Equal(t, byte(opText), opcode)
+		assert.Equal(t, "hello", string(payload))
+
+		client.writeFrame(t, false, opBinary, []byte{1, 2}, true)
+		client.writeFrame(t, true, opPing, []byte("ping"), true)
+		client.writeFrame(t, true, opContinuation, []byte{3}, true)
+
+		opcode, payload = client.readFrame(t)
+		assert.Equal(t, byte(opPong), opcode)
+		assert.Equal(t, "ping", string(payload))
+
+		opcode, payload = client.readFrame(t)
+		assert.Equal(t, byte(opBinary), opcode)
+		assert.Equal(t, []byte{1, 2, 3}, payload)
+
+		large := strings.Repeat("a", 1000)
+		client.writeFrame(t, true, opText, []byte(large), true)
+		_, payload = client.readFrame(t)
+		assert.Equal(t, large, string(payload))
+
+		client.writeFrame(t, true, opClose, binary.BigEndian.AppendUint16(nil, CloseNormal), true)
+		code, _ := client.readClose(t)
+		assert.Equal(t, CloseNormal, code)
+
+		<-req.Context().Done()
+
+		assert.Eventually(t, func() bool {
+			return server.InFlight() == 0
+		}, time.Second, time.Millisecond*10)
+	})
+
+	t.Run("returns close error from ReadMessage when client closes", func(t *testing.T) {
+		t.Parallel()
+
+		readErr := make(chan error, 1)
+
+		_, addr := websocketServer(t, &WebSocket{
+			HandlerFunc: func(conn *WebSocketConn, _ *http.Request) {
+				_, _, err := conn.ReadMessage()
+				readErr <- err
+
+				assert.Error(t, conn.WriteMessage(TextMessage, []byte("closed")))
+			},
+		})
+
+		client, _ := dialWebSocket(t, addr, nil)
+
+		client.writeFrame(t, true, opClose, append(binary.BigEndian.AppendUint16(nil, CloseGoingAway), "bye"...), true)
+
+		var closeErr *CloseError
+		assert.True(t, errors.As(<-readErr, &closeErr))
+		assert.Equal(t, &CloseError{Code: CloseGoingAway, Reason: "bye"}, closeErr)
+		assert.EqualError(t, closeErr, "luci: websocket closed (1001): bye")
+
+		code, _ := client.readClose(t)
+		assert.Equal(t, CloseGoingAway, code)
+	})
+
+	t.Run("fails connection on invalid frames", func(t *testing.T) {
+		t.Parallel()
+
+		_, addr := websocketServer(t, &WebSocket{
+			MaxMessageSize: 10,
+			HandlerFunc:    echoWebSocket,
+		})
+
+		tests := []struct {
+			fin      bool
+			opcode   byte
+			payload  []byte
+			masked   bool
+			expected int
+		}{
+			{fin: true, opcode: opText, payload: []byte("hello"), masked: false, expected: CloseProtocolError},
+			{fin: true, opcode: 0x3, payload: []byte("hello"), masked: true, expected: CloseProtocolError},
+			{fin: false, opcode: opPing, payload: []byte("ping"), masked: true, expected: CloseProtocolError},
+			{fin: true, opcode: opContinuation, payload: []byte("hello"), masked: true, expected: CloseProtocolError},
+			{fin: true, opcode: opText, payload: []byte{0xff, 0xfe}, masked: true, expected: CloseInvalidPayload},
+			{fin: true, opcode: opText, payload: []byte("hello world"), masked: true, expected: CloseMessageTooBig},
+			{fin: true, opcode: opClose, payload: binary.BigEndian.AppendUint16(nil, 999), masked: true, expected: CloseProtocolError},
+		}
+
+		for _, test := range tests {
+			client, _ := dialWebSocket(t, addr, nil)
+			client.writeFrame(t, test.fin, test.opcode, test.payload, test.masked)
+
+			code, _ := client.readClose(t)
+			assert.Equal(t, test.expected, code)
+		}
+	})
+
+	t.Run("fails connection on oversized continuation frames", func(t *testing.T) {
+		t.Parallel()
+
+		_, addr := websocketServer(t, &WebSocket{
+			MaxMessageSize: 10,
+			HandlerFunc:    echoWebSocket,
+		})
+
+		client, _ := dialWebSocket(t, addr, nil)
+		client.writeFrame(t, false, opText, []byte("hello"), true)
+
+		frame := []byte{0x80 | opContinuation, 0x80 | 127}
+		frame = binary.BigEndian.AppendUint64(frame, 1<<63-1)
+
+		_, err := client.conn.Write(frame)
+		assert.NoError(t, err)
+
+		code, _ := client.readClose(t)
+		assert.Equal(t, CloseMessageTooBig, code)
+	})
+
+	t.Run("sends pings at the ping interval", func(t *testing.T) {
+		t.Parallel()
+
+		_, addr := websocketServer(t, &WebSocket{
+			PingInterval: time.Millisecond * 50,
+			HandlerFunc:  echoWebSocket,
+		})
+
+		client, _ := dialWebSocket(t, addr, nil)
+
+		opcode, _ := client.readFrame(t)
+		assert.Equal(t, byte(opPing), opcode)
+	})
+
+	t.Run("closes connection once handler returns", func(t *testing.T) {
+		t.Parallel()
+
+		_, addr := websocketServer(t, &WebSocket{
+			HandlerFunc: func(conn *WebSocketConn, _ *http.Request) {
+				assert.NoError(t, conn.WriteMessage(TextMessage, []byte("bye")))
+			},
+		})
+
+		client, _ := dialWebSocket(t, addr, nil)
+
+		_, payload := client.readFrame(t)
+		assert.Equal(t, "bye", string(payload))
+
+		code, _ := client.readClose(t)
+		assert.Equal(t, CloseNormal, code)
+	})
+
+	t.Run("closes connections on server shutdown", func(t *testing.T) {
+		t.Parallel()
+
+		var app TestApplication
+		app.On("Middlewares").Return(nil)
+		app.On("Routes").Return([]Route{
+			{
+				Name:    "ws",
+				Pattern: "/ws",
+				WebSocket: &WebSocket{
+					HandlerFunc: echoWebSocket,
+				},
+			},
+		})
+
+		server := NewServer(Config{Address: "127.0.0.1:0", Logger: noopLogger}, &app)
+		ctx, cancel := context.WithCancel(context.Background())
+		listenErr := make(chan error, 1)
+
+		go func() {
+			listenErr <- server.ListenAndServe(ctx)
+		}()
+
+		client, res := dialWebSocket(t, server.Address(), nil)
+		assert.Equal(t, http.StatusSwitchingProtocols, res.StatusCode)
+
+		cancel()
+
+		code, reason := client.readClose(t)
+		assert.Equal(t, CloseGoingAway, code)
+		assert.Equal(t, "server shutting down", reason)
+
+		assert.NoError(t, <-listenErr)
+		assert.Equal(t, int64(0), server.InFlight())
+	})
+}
+
+func TestWebSocketHandlerErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		header   http.Header
+		origin   func(req *http.Request) bool
+		expected *HTTPError
+	}{
+		{
+			name:   "requires upgrade headers",
+			header: http.Header{},
+			expected: NewHTTPError(http.StatusUpgradeRequired, nil).
+				WithMessage("websocket upgrade required").
+				WithHeader("Connection", "Upgrade").
+				WithHeader("Upgrade", "websocket"),
+		},
+		{
+			name: "requires supported version",
+			header: http.Header{
+				"Connection":            []string{"keep-alive, Upgrade"},
+				"Upgrade":               []string{"WebSocket"},
+				"Sec-Websocket-Version": []string{"8"},
+			},
+			expected: NewHTTPError(http.StatusUpgradeRequired, nil).
+				WithMessage("unsupported websocket version").
+				WithHeader("Sec-WebSocket-Version", "13"),
+		},
+		{
+			name: "requires valid key",
+			header: http.Header{
+				"Connection":            []string{"Upgrade"},
+				"Upgrade":               []string{"websocket"},
+				"Sec-Websocket-Version": []string{"13"},
+				"Sec-Websocket-Key":     []string{"invalid"},
+			},
+			expected: BadRequest(nil).WithMessage("invalid websocket key"),
+		},
+		{
+			name: "requires same origin by default",
+			header: http.Header{
+				"Connection":            []string{"Upgrade"},
+				"Upgrade":               []string{"websocket"},
+				"Sec-Websocket-Version": []string{"13"},
+				"Sec-Websocket-Key":     []string{"dGhlIHNhbXBsZSBub25jZQ=="},
+				"Origin":                []string{"https://example.org"},
+			},
+			expected: Forbidden(nil).WithMessage("websocket origin not allowed"),
+		},
+		{
+			name: "uses check origin",
+			header: http.Header{
+				"Connection":            []string{"Upgrade"},
+				"Upgrade":               []string{"websocket"},
+				"Sec-Websocket-Version": []string{"13"},
+				"Sec-Websocket-Key":     []string{"dGhlIHNhbXBsZSBub25jZQ=="},
+			},
+			origin: func(_ *http.Request) bool {
+				return false
+			},
+			expected: Forbidden(nil).WithMessage("websocket origin not allowed"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			var called bool
+
+			errorHandler := func(_ http.ResponseWriter, _ *http.Request, status int, err error) {
+				called = true
+
+				assert.Equal(t, test.expected.Status, status)
+				assert.Equal(t, test.expected, err)
+			}
+
+			handler := websocketHandler(errorHandler, newInFlight(), WebSocket{
+				CheckOrigin: test.origin,
+				HandlerFunc: func(_ *WebSocketConn, _ *http.Request) {
+					assert.Fail(t, "handler should not be called")
+				},
+			})
+
+			request := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/ws", nil)
+			request.Header = test.header
+
+			handler.ServeHTTP(httptest.NewRecorder(), request)
+
+			assert.True(t, called)
+		})
+	}
+}
+
+func TestMessageTypeString(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "text", TextMessage.String())
+	assert.Equal(t, "binary", BinaryMessage.String())
+	assert.Equal(t, "unknown(5)", MessageType(5).String())
+}